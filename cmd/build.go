@@ -1,26 +1,121 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 
 	"github.com/deref/uni/internal"
 	"github.com/spf13/cobra"
 )
 
 var buildOpts internal.BuildOptions
+var buildAll bool
+var buildDefines []string
+var buildSince string
+var buildSourceMap string
+var buildNoDaemon bool
+var buildInteractive bool
+var buildPreset string
+var buildDocker string
 
 func init() {
 	rootCmd.AddCommand(buildCmd)
+	buildCmd.Flags().DurationVar(&buildOpts.Poll, "poll", 0, "poll for changes at this interval in watch mode, instead of using filesystem notifications")
+	buildCmd.Flags().Lookup("poll").NoOptDefVal = "1s"
+	buildCmd.Flags().BoolVar(&buildAll, "all", false, "build all packages (the default when no package is given)")
+	buildCmd.Flags().BoolVarP(&buildInteractive, "interactive", "i", false, "prompt for the package to build")
+	buildCmd.Flags().IntVarP(&buildOpts.Jobs, "jobs", "j", runtime.NumCPU(), "maximum number of packages to build concurrently")
 	buildCmd.Flags().StringVar(&buildOpts.Version, "version", "", "version to put in package.json")
 	buildCmd.Flags().BoolVar(&buildOpts.Watch, "watch", false, "rebuilds each time source files change")
 	buildCmd.Flags().BoolVar(&buildOpts.Types, "types", false, "also build a .d.ts file")
+	buildCmd.Flags().BoolVar(&buildOpts.NoCache, "no-cache", false, "rebuild even if inputs are unchanged")
+	buildCmd.Flags().BoolVar(&buildOpts.NoRemoteCache, "no-remote-cache", false, "neither download nor upload builds with the remote cache")
+	buildCmd.Flags().StringSliceVar(&buildOpts.WatchIgnore, "watch-ignore", nil, "glob pattern of paths to ignore in watch mode (repeatable)")
+	buildCmd.Flags().StringVar(&buildSince, "since", "", "only build packages affected by changes since a git ref")
+	buildCmd.Flags().BoolVar(&buildOpts.Minify, "minify", false, "minify all packages")
+	buildCmd.Flags().BoolVar(&buildOpts.Production, "production", false, "minify, hide source maps, define NODE_ENV as production, and remove unused console.debug calls")
+	buildCmd.Flags().BoolVar(&buildOpts.UploadSourceMaps, "upload-sourcemaps", false, "upload source maps as configured by sourcemaps.upload")
+	buildCmd.Flags().BoolVar(&buildOpts.Analyze, "analyze", false, "print bundle sizes and their largest contributors")
+	buildCmd.Flags().BoolVar(&buildOpts.FailOnCycles, "fail-on-cycles", false, "fail if source files have import cycles, instead of warning")
+	buildCmd.Flags().BoolVar(&buildOpts.StrictVersions, "strict-versions", false, "fail if dependency versions conflict, instead of warning")
+	buildCmd.Flags().StringVar(&buildDocker, "docker", "", "after building, write a Dockerfile for each service package and build its image, or given =dockerfile, only write the Dockerfile")
+	buildCmd.Flags().Lookup("docker").NoOptDefVal = "image"
+	buildCmd.Flags().StringVar(&buildPreset, "preset", "", "build deployment artifacts instead of npm packages: lambda or executable")
+	buildCmd.Flags().StringVar(&buildOpts.Target, "target", "", "language and engine versions to compile all packages for, such as es2019 or node14 (default from config)")
+	buildCmd.Flags().StringVar(&buildSourceMap, "sourcemap", "", "source map strategy: linked, external, hidden, inline, or none")
+	buildCmd.Flags().StringArrayVar(&buildDefines, "define", nil, "replace a global identifier with a JavaScript expression, as KEY=VALUE (repeatable)")
+	buildCmd.Flags().BoolVar(&buildOpts.TypeCheck, "check", false, "type check in the background after each rebuild (requires --watch)")
+	buildCmd.Flags().BoolVar(&buildOpts.DryRun, "dry-run", false, "report the files and package.json dependencies that would be built, without writing them")
+	buildCmd.Flags().BoolVar(&buildNoDaemon, "no-daemon", false, "build in this process, even if a daemon is running")
+	buildCmd.Flags().StringVar(&buildOpts.Metafile, "metafile", "", "write JSON metadata describing the inputs and outputs of each built package to a file")
+	buildCmd.Flags().StringSliceVar(&buildOpts.Externals.Bundle, "bundle", nil, "bundle modules matching a pattern, even if they are dependencies (repeatable)")
+	buildCmd.Flags().StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics of rebuilds and watched files at http://<addr>/metrics in watch mode")
+	buildCmd.Flags().StringSliceVar(&buildOpts.Externals.External, "external", nil, "leave modules matching a pattern external, loaded from node_modules at runtime (repeatable)")
 }
 
 var buildCmd = &cobra.Command{
 	Use:   "build [package]",
-	Short: "Builds packages targeting Node.",
-	Long: `Builds packages targeting Node.
-Given no arguments, builds all packages. Otherwise, builds only the specified package.`,
+	Short: "Builds packages.",
+	Long: `Builds packages for their configured platform (Node by default).
+Given no arguments, builds all packages. Otherwise, builds only the specified package.
+Given --interactive, prompts for the package to build, chosen by typing to search.
+
+When building multiple packages, packages are built in dependency order, where
+one package depends on another if it imports that package's index module.
+
+Given --since, only packages that load files changed since the given git ref
+are built. Uncommitted and untracked files count as changed, and changing
+uni.yml affects all packages.
+
+Given --production, packages are minified, source maps default to hidden (see
+--sourcemap), process.env.NODE_ENV is defined as
+"production" (unless defined otherwise), and console.debug calls are removed.
+Only calls used as statements are removed; calls whose results are used, as in
+"const x = console.debug(...)", are kept. Debugger statements are not removed.
+
+Import cycles between source files are reported as warnings, or as errors given
+--fail-on-cycles. Dependency cycles between packages are always errors.
+
+Dependency version skew is reported as warnings, or as errors given
+--strict-versions: packages bundled in more than one version, along with the
+imports that led to each, and dependencies whose installed versions are not
+in the ranges configured for them, as dependencies or peer dependencies.
+
+Given --dry-run, each package that is out of date is built into a temporary
+directory instead, and the files that would be written to out/dist are
+reported with their sizes, along with the dependencies of package.json. Code
+generators, hooks, and the remote cache are skipped, and caches are left as
+is.
+
+Given --metafile, a JSON file is written with the esbuild metafile of each
+built package, along with its inputs, externalized dependencies, and the sizes
+and hashes of its output files.
+
+Given --preset=lambda, each package is built as an AWS Lambda deployment
+package instead: a zip file in out/lambda whose index.js exports the handler of
+the package's index module, so that the function's handler is "index.handler".
+The handler is bundled with all of its dependencies, except the AWS SDK
+provided by the configured runtime and modules given with --external, and
+compiled for the runtime's version of node. Without a package, builds the
+packages that configure lambda settings.
+
+Given --preset=executable, each executable of a package is built as a single
+executable application in out/executables: a copy of the node binary that runs
+the executable bundled with all of its dependencies, and so runs without node
+on machines of the same platform. Requires node 20 or later, and postject,
+installed as a dependency or on the PATH. Without a package, builds the packages
+that have executables.
+
+Given --docker, after building, a Dockerfile is written to out/docker for each
+package that configures docker settings, or for the given package, and its
+image is built with docker, tagged with the version and as latest. The image
+installs only the package's runtime dependencies, those left external by the
+bundle, and runs one of its executables with source maps enabled. Given
+--docker=dockerfile, only the Dockerfiles are written, to build with other
+tools from the package's build output in out/dist.`,
 	Args: cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repo := mustLoadRepository()
@@ -28,11 +123,53 @@ Given no arguments, builds all packages. Otherwise, builds only the specified pa
 			return err
 		}
 
+		if buildInteractive {
+			if buildAll || len(args) > 0 {
+				return errors.New("cannot specify --interactive with --all or a package")
+			}
+			if !internal.CanPick() {
+				return errors.New("--interactive requires a terminal")
+			}
+			picked, err := internal.PickPackage(repo)
+			if err != nil {
+				return err
+			}
+			args = []string{picked}
+		}
+
+		var err error
+		buildOpts.Preset, err = internal.ParseBuildPreset(buildPreset)
+		if err != nil {
+			return err
+		}
+
 		var packages map[string]*internal.Package
-		switch len(args) {
-		case 0:
+		switch {
+		case buildAll && len(args) > 0:
+			return errors.New("cannot specify both --all and a package")
+		case len(args) == 0 && buildOpts.Preset == internal.PresetLambda:
+			packages = make(map[string]*internal.Package)
+			for name, pkg := range repo.Packages {
+				if pkg.Lambda != nil {
+					packages[name] = pkg
+				}
+			}
+			if len(packages) == 0 {
+				return errors.New("no packages configure lambda settings; specify a package to build")
+			}
+		case len(args) == 0 && buildOpts.Preset == internal.PresetExecutable:
+			packages = make(map[string]*internal.Package)
+			for name, pkg := range repo.Packages {
+				if len(pkg.Executables) > 0 {
+					packages[name] = pkg
+				}
+			}
+			if len(packages) == 0 {
+				return errors.New("no packages have executables")
+			}
+		case len(args) == 0:
 			packages = repo.Packages
-		case 1:
+		default:
 			pkgName := args[0]
 			pkg, ok := repo.Packages[pkgName]
 			if !ok {
@@ -41,17 +178,89 @@ Given no arguments, builds all packages. Otherwise, builds only the specified pa
 			packages = map[string]*internal.Package{
 				pkgName: pkg,
 			}
+		}
+
+		if buildSince != "" {
+			packages, err = internal.AffectedPackages(repo, packages, buildSince)
+			if err != nil {
+				return err
+			}
+			if len(packages) == 0 {
+				fmt.Fprintf(os.Stderr, "no packages affected since %s\n", buildSince)
+				return nil
+			}
+		}
+
+		if err := internal.ValidateTarget(buildOpts.Target); err != nil {
+			return err
+		}
+		buildOpts.SourceMap, err = internal.ParseSourceMap(buildSourceMap)
+		if err != nil {
+			return err
+		}
+		buildOpts.Define, err = internal.ParseDefines(buildDefines)
+		if err != nil {
+			return err
+		}
+
+		if err := buildOpts.Externals.Validate(); err != nil {
+			return err
+		}
+
+		if buildOpts.Metafile != "" {
+			buildOpts.Metafile, err = filepath.Abs(buildOpts.Metafile)
+			if err != nil {
+				return err
+			}
+		}
+
+		if buildOpts.DryRun && (buildOpts.Watch || buildOpts.Preset != "" || buildDocker != "") {
+			return errors.New("--dry-run cannot be used with --watch, --preset, or --docker")
+		}
+		if buildOpts.UploadSourceMaps && buildOpts.Watch {
+			return errors.New("--upload-sourcemaps cannot be used with --watch")
+		}
+		if buildOpts.TypeCheck && !buildOpts.Watch {
+			return errors.New("--check requires --watch")
+		}
+
+		var dockerPackages map[string]*internal.Package
+		switch buildDocker {
+		case "":
+		case "image", "dockerfile":
+			if buildOpts.Watch || buildOpts.Preset != "" {
+				return errors.New("--docker cannot be used with --watch or --preset")
+			}
+			dockerPackages = packages
+			if len(args) == 0 {
+				dockerPackages = make(map[string]*internal.Package)
+				for name, pkg := range packages {
+					if pkg.Docker != nil {
+						dockerPackages[name] = pkg
+					}
+				}
+				if len(dockerPackages) == 0 {
+					return errors.New("no packages configure docker settings; specify a package to build")
+				}
+			}
 		default:
-			panic("unreachable")
+			return fmt.Errorf("invalid --docker: %q, expected image or dockerfile", buildDocker)
 		}
 
-		// TODO: Parallelism.
-		for _, pkg := range packages {
-			buildOpts.Package = pkg
-			if err := internal.Build(repo, buildOpts); err != nil {
+		buildOpts.UseDaemon = !buildNoDaemon
+		if buildOpts.Watch {
+			if err := serveMetrics(); err != nil {
 				return err
 			}
 		}
+		if err := internal.BuildPackages(repo, packages, buildOpts); err != nil {
+			return err
+		}
+		if dockerPackages != nil {
+			return internal.BuildDockerImages(repo, dockerPackages, internal.DockerImagesOptions{
+				DockerfileOnly: buildDocker == "dockerfile",
+			})
+		}
 		return nil
 	},
 }
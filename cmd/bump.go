@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var bumpOpts internal.BumpOptions
+
+func init() {
+	rootCmd.AddCommand(bumpCmd)
+	bumpCmd.Flags().StringVar(&bumpOpts.Preid, "preid", "", "identifier for prerelease versions, such as beta")
+	bumpCmd.Flags().BoolVar(&bumpOpts.NoTag, "no-tag", false, "print the next version without creating a git tag")
+	bumpCmd.Flags().BoolVar(&bumpOpts.DryRun, "dry-run", false, "report the git tag that would be created without creating it")
+}
+
+var bumpCmd = &cobra.Command{
+	Use:   "bump <major|minor|patch|prerelease> [package]",
+	Short: "Increments package versions.",
+	Long: `Increments the version of a package, or of every package if none is given.
+
+Package versions are tracked with git tags of the form <package-name>@<version>.
+This command tags HEAD with the next version after the greatest tagged version,
+starting from 0.0.0. Subsequent builds use the tagged version unless given
+--version.
+
+Packages with a version configured in uni.yml cannot be bumped with this
+command.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		bumpOpts.Level = args[0]
+
+		packages := repo.Packages
+		if len(args) > 1 {
+			pkgName := args[1]
+			pkg, ok := repo.Packages[pkgName]
+			if !ok {
+				return fmt.Errorf("no such package: %q", pkgName)
+			}
+			packages = map[string]*internal.Package{
+				pkgName: pkg,
+			}
+		}
+
+		for _, pkg := range packages {
+			version, err := internal.Bump(repo, pkg, bumpOpts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s@%s\n", pkg.Name, version)
+		}
+		return nil
+	},
+}
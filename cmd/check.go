@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check [flags] [-- tsc-args...]",
+	Short: "Type checks the repository.",
+	Long: `Type checks the repository with the TypeScript compiler.
+
+If a tsconfig.json file exists in the repository root, it determines which files
+are checked and with what compiler options. Otherwise, all package entrypoints
+are checked.
+
+Any additional arguments are passed through to tsc.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+		return internal.Check(repo, internal.CheckOptions{
+			Args: args,
+		})
+	},
+}
@@ -5,16 +5,40 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var cleanOpts internal.CleanOptions
+
 func init() {
 	rootCmd.AddCommand(cleanCmd)
+	cleanCmd.Flags().BoolVar(&cleanOpts.Tmp, "tmp", false, "remove temporary directories of runs, tests, and builds")
+	cleanCmd.Flags().BoolVar(&cleanOpts.Cache, "cache", false, "remove build, codegen, task, and type checking caches")
+	cleanCmd.Flags().BoolVar(&cleanOpts.Dist, "dist", false, "remove built packages")
+	cleanCmd.Flags().BoolVar(&cleanOpts.Artifacts, "artifacts", false, "remove packed packages and deployment artifacts")
+	cleanCmd.Flags().BoolVar(&cleanOpts.DryRun, "dry-run", false, "report what would be removed without removing anything")
 }
 
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "Removes build output.",
-	Long:  `Removes build output.`,
+	Long: `Removes build output.
+
+By default, the entire output directory is removed. Given any of --tmp,
+--cache, --dist, or --artifacts, only the selected kinds of output are:
+
+  --tmp        temporary directories of runs, tests, and builds, including
+               those left behind by killed processes
+  --cache      caches of build results, code generators, tasks, type checking,
+               and engine versions, so that everything is rebuilt
+  --dist       built packages in out/dist
+  --artifacts  packed packages, the lambda zip files, Dockerfiles, and
+               executables built by presets and --docker, and the output of
+               "uni run --build-only"
+
+Removing temporary directories while uni is running may cause it to fail.
+
+Given --dry-run, the paths that would be removed are reported instead.`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repo := mustLoadRepository()
-		return internal.Clean(repo)
+		return internal.Clean(repo, cleanOpts)
 	},
 }
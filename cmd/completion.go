@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+
+	for _, cmd := range []*cobra.Command{buildCmd, packCmd, publishCmd, execCmd, transpileCmd} {
+		cmd.ValidArgsFunction = completeArgs(completePackages)
+	}
+	bumpCmd.ValidArgsFunction = completeArgs(completeWords("major", "minor", "patch", "prerelease"), completePackages)
+	taskCmd.ValidArgsFunction = completeArgs(completeTasks, completePackages)
+	runCmd.ValidArgsFunction = completeRunArgs
+	upgradeCmd.ValidArgsFunction = completeDependencies
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: "Generates shell completion scripts.",
+	Long: `Writes a completion script for the given shell to stdout. Package names, task
+names, run targets, and entrypoints are completed from the repository config.
+
+To load completions in the current shell:
+
+  bash:       source <(uni completion bash)
+  zsh:        source <(uni completion zsh)
+  fish:       uni completion fish | source
+  powershell: uni completion powershell | Out-String | Invoke-Expression
+
+To load them in every session, write the script to your shell's completions
+directory instead, such as /etc/bash_completion.d/uni for bash,
+a directory on $fpath as _uni for zsh, or ~/.config/fish/completions/uni.fish
+for fish.`,
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			return rootCmd.GenPowerShellCompletion(os.Stdout)
+		default:
+			return fmt.Errorf("unsupported shell: %q", args[0])
+		}
+	},
+}
+
+type completer func(repo *internal.Repository, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeArgs completes each positional argument with the corresponding
+// completer. Arguments beyond the last completer are not completed.
+func completeArgs(completers ...completer) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= len(completers) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		repo, ok := loadRepositoryForCompletion()
+		if !ok {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completers[len(args)](repo, toComplete)
+	}
+}
+
+// loadRepositoryForCompletion loads the repository without reporting errors,
+// which would corrupt completion output.
+func loadRepositoryForCompletion() (*internal.Repository, bool) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, false
+	}
+	repo, err := internal.LoadRepository(cwd)
+	if err != nil {
+		return nil, false
+	}
+	return repo, true
+}
+
+func completePackages(repo *internal.Repository, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return filterPrefix(internal.PackageNames(repo), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeDependencies completes every argument with dependency names.
+func completeDependencies(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo, ok := loadRepositoryForCompletion()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return filterPrefix(internal.DependencyNames(repo), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeTasks(repo *internal.Repository, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return filterPrefix(internal.TaskNames(repo), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeWords(words ...string) completer {
+	return func(repo *internal.Repository, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return filterPrefix(words, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeRunArgs completes run targets and configured entrypoints, relative
+// to the working directory, as well as files. Once a script is given, its
+// arguments are completed as files.
+func completeRunArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo, ok := loadRepositoryForCompletion()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	if len(args) > 0 && !internal.IsRunTarget(repo, args[0]) {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	candidates := internal.RunTargetNames(repo)
+	if len(args) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		for _, entrypoint := range internal.EntrypointPaths(repo) {
+			if rel, err := filepath.Rel(cwd, entrypoint); err == nil {
+				candidates = append(candidates, rel)
+			}
+		}
+	}
+	return filterPrefix(candidates, toComplete), cobra.ShellCompDirectiveDefault
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var daemonStop bool
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics of rebuilds, restarts, and watched files at http://<addr>/metrics")
+	daemonCmd.Flags().BoolVar(&daemonStop, "stop", false, "stop the running daemon")
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps programs bundled for other commands.",
+	Long: `Runs in the foreground, serving other uni commands in the same project over a
+unix socket at out/daemon.sock, until interrupted or stopped with --stop.
+
+While a daemon is running, "uni build" and "uni run" (without --watch) delegate
+bundling to it, unless given --no-daemon. The daemon keeps the configuration and
+package graph loaded, and keeps each program that has been run bundled and
+watched, so that subsequent runs only wait for an incremental rebuild. Builds
+are not incremental: each "uni build" bundles its packages from scratch, as it
+would without a daemon, skipping only unchanged packages in the build cache.
+
+The daemon reloads uni.yml when it changes. Commands run by a different version
+of uni than the daemon's do not delegate to it, and warn instead, since either
+may not know of options of the other, such as --target.
+
+Given --metrics <addr>, such as localhost:9464, the daemon serves metrics of its
+watched builds in the Prometheus text format at /metrics: rebuild counts and
+durations, process restarts, and the number of watched files, each labeled by
+package.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if daemonStop {
+			return internal.StopDaemon(repo)
+		}
+		if err := serveMetrics(); err != nil {
+			return err
+		}
+		return internal.Daemon(repo)
+	},
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/deref/uni/internal"
 	"github.com/spf13/cobra"
 )
@@ -9,13 +11,14 @@ var depsOpts internal.InstallDependenciesOptions
 
 func init() {
 	rootCmd.AddCommand(depsCmd)
+	depsCmd.AddCommand(depsCheckCmd)
 	depsCmd.Flags().BoolVar(&depsOpts.Frozen, "frozen", false, "(unstable) prevents modification of dependency lock file")
 }
 
 var depsCmd = &cobra.Command{
 	Use:   "deps",
 	Short: "Install dependencies",
-	Long:  `Generates a root package.json file and runs your package manager.`,
+	Long:  `Same as install. See uni install --help.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repo := mustLoadRepository()
 		if err := internal.CheckEngines(repo); err != nil {
@@ -24,3 +27,22 @@ var depsCmd = &cobra.Command{
 		return internal.InstallDependencies(repo, depsOpts)
 	},
 }
+
+var depsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Report unused and undeclared dependencies",
+	Long: `Reports configured dependencies that no source file imports, and installed
+packages that source files import without configuring them as dependencies,
+which are missing from generated package.json files. Fails if any are found.
+
+Source files are those imported by the entrypoints of packages and run
+targets, and by test files. Dependencies that uni requires, type declaration
+packages, peer and optional dependencies of packages, and packages that
+install executables are never reported as unused. Since imports of types only
+are erased, dependencies used only for their types are reported as unused.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		return internal.CheckDependencies(repo, os.Stderr)
+	},
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose common environment and dependency problems.",
+	Long: `Checks engine versions, installed dependencies, the package lock file,
+external modules, duplicate dependencies, and file watching limits, printing
+suggested fixes for any problems found.
+
+The lock file is compared with the configured and installed dependencies only
+for npm. For yarn and pnpm, it is only checked to exist.
+
+Returns a non-zero status code if any check fails. Warnings do not fail.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repo := mustLoadRepository()
+		diags := internal.Diagnose(repo)
+		if !internal.DumpDiagnostics(os.Stdout, diags) {
+			exit(1)
+		}
+	},
+}
@@ -25,11 +25,11 @@ not (yet?) intended to be parsed.`,
 		env, err := internal.AnalyzeEnvironment(repo)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			exit(1)
 		}
 		internal.DumpEnvironment(env)
 		if !env.OK {
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
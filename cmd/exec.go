@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var execOpts internal.ExecOptions
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().BoolVar(&execOpts.NoBuild, "no-build", false, "use existing build output, rather than building first")
+	execCmd.Flags().StringSliceVar(&execOpts.EnvFiles, "env-file", nil, "load environment variables from a file, after .env and .env.local (repeatable)")
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] <package> -- <command> [args...]",
+	Short: "Runs a command in the context of a built package.",
+	Long: `Builds the given package, unless it is up to date, then runs a command with:
+
+- The package's executables, and those of installed dependencies, on PATH.
+- Built packages resolvable by name via NODE_PATH.
+- UNI_PACKAGE and UNI_PACKAGE_DIR set to the package name and build directory.
+- Environment variables loaded from env files, as for uni run.
+
+This is useful for generators and one-off scripts that are not TypeScript
+entrypoints. The command is not run with a shell; use "sh -c" for pipelines
+and the like.`,
+	Args:                  cobra.MinimumNArgs(2),
+	DisableFlagsInUseLine: true,
+	SilenceErrors:         true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+
+		pkgName := args[0]
+		pkg, ok := repo.Packages[pkgName]
+		if !ok {
+			return fmt.Errorf("no such package: %q", pkgName)
+		}
+		execOpts.Package = pkg
+		execOpts.Command = args[1:]
+
+		var err error
+		for i, envFile := range execOpts.EnvFiles {
+			execOpts.EnvFiles[i], err = filepath.Abs(envFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = internal.Exec(repo, execOpts)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exit(exitErr.ExitCode())
+		}
+		return err
+	},
+}
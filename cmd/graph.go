@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	graphCmd.Flags().StringVar(&graphFormat, "format", internal.GraphFormatText, "output format: text, json, or dot")
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Prints the package dependency graph.",
+	Long: `Prints which packages depend on which other packages.
+
+One package depends on another if it imports that package's index module.
+
+Exits with an error if the graph contains a dependency cycle.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		graph, err := internal.LoadPackageGraph(repo)
+		if err != nil {
+			return err
+		}
+		if err := graph.Write(os.Stdout, graphFormat); err != nil {
+			return err
+		}
+		_, err = graph.Order(repo.Packages)
+		return err
+	},
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Creates a new repository.",
+	Long: `Creates a uni.yml config file in the current directory, with engine versions
+pinned to those installed, along with a tsconfig.json file (unless one exists),
+the out directory, and .gitignore entries for build outputs and dependencies.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if err := internal.Init(os.Stdout, cwd); err != nil {
+			return err
+		}
+		fmt.Println("next, run `uni install` to install dependencies, and `uni new package <name>` to add packages")
+		return nil
+	},
+}
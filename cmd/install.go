@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var installOpts internal.InstallDependenciesOptions
+
+func init() {
+	rootCmd.AddCommand(installCmd)
+	installCmd.Flags().BoolVar(&installOpts.Frozen, "frozen", false, "install exactly the versions in the lock file, failing if it does not match the configured dependencies")
+}
+
+var installCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Installs dependencies.",
+	Long: `Installs the dependencies configured in uni.yml into node_modules.
+
+A root package.json is generated from the configured dependencies, which are
+then installed by the package manager configured by packageManager: npm (the
+default), yarn, or pnpm. The generated package.json should not be edited, since
+the config file is the only source of dependencies.
+
+After installing, the lock file of the package manager is checked to exist, and
+the installed version of each dependency is checked to be within its configured
+range. Installing fails if any dependency does not match. The contents of the
+lock file are not checked by uni; uni doctor compares package-lock.json with
+the configured and installed dependencies, but only checks that yarn.lock and
+pnpm-lock.yaml exist.
+
+Given --frozen, installs exactly the versions in the lock file, as with npm ci,
+failing if the lock file is missing or does not match the configured
+dependencies, as in CI. Whether the lock file matches is decided by the package
+manager.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+		return internal.InstallDependencies(repo, installOpts)
+	},
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var newPackageOpts internal.NewPackageOptions
+
+func init() {
+	rootCmd.AddCommand(newCmd)
+	newCmd.AddCommand(newPackageCmd)
+	newPackageCmd.Flags().StringVar(&newPackageOpts.Dir, "dir", "", "directory of the package, relative to the repository root (default packages/<name>)")
+}
+
+var newCmd = &cobra.Command{
+	Use:   "new",
+	Short: "Scaffolds new code.",
+}
+
+var newPackageCmd = &cobra.Command{
+	Use:   "package <name>",
+	Short: "Creates a new package.",
+	Long: `Creates a package directory with an index module and a test, and adds the
+package to uni.yml. Comments and formatting of uni.yml are preserved.
+
+With a uni.config.ts file, the configuration to add is printed instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		return internal.NewPackage(os.Stdout, repo, args[0], newPackageOpts)
+	},
+}
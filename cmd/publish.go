@@ -7,15 +7,32 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var publishOpts internal.PublishOptions
+
 func init() {
 	rootCmd.AddCommand(publishCmd)
+	publishCmd.Flags().StringVar(&publishOpts.Version, "version", "", "build and pack with this version before publishing")
+	publishCmd.Flags().BoolVar(&publishOpts.Types, "types", false, "also build a .d.ts file (requires --version)")
+	publishCmd.Flags().StringVar(&publishOpts.Tag, "tag", "", "distribution tag to publish under (npm default: latest)")
+	publishCmd.Flags().StringVar(&publishOpts.Access, "access", "", "public or restricted (default from package config)")
+	publishCmd.Flags().StringVar(&publishOpts.OTP, "otp", "", "one-time password for two-factor authentication")
+	publishCmd.Flags().BoolVar(&publishOpts.DryRun, "dry-run", false, "report what would be built and published, without writing or publishing anything")
 }
 
 var publishCmd = &cobra.Command{
 	Use:   "publish [package]",
-	Short: "Publishes pre-packed tgz files.",
-	Long: `Publishes pre-packed tgz files.
-The package must already be packed. Use the pack command.`,
+	Short: "Publishes packages.",
+	Long: `Publishes packages to the configured registry.
+
+Given --version, each package is built and packed with that version first.
+Otherwise, the package must already be packed. Use the pack command.
+
+Packages are validated before publishing.
+
+Given --dry-run, reports the registry, access, and tag that each package would
+be published with. Given --version too, reports what would be built, as for
+"uni build --dry-run", instead of building and packing. Otherwise, the packed
+package is checked with "npm publish --dry-run".`,
 	Args: cobra.RangeArgs(0, 1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repo := mustLoadRepository()
@@ -27,7 +44,7 @@ The package must already be packed. Use the pack command.`,
 			// TODO: Parallelism.
 			for pkgName, pkg := range repo.Packages {
 				fmt.Println("publishing", pkgName)
-				if err := internal.Publish(repo, pkg); err != nil {
+				if err := internal.Publish(repo, pkg, publishOpts); err != nil {
 					return err
 				}
 			}
@@ -38,7 +55,7 @@ The package must already be packed. Use the pack command.`,
 			if !ok {
 				return fmt.Errorf("no such package: %q", pkgName)
 			}
-			return internal.Publish(repo, pkg)
+			return internal.Publish(repo, pkg, publishOpts)
 		default:
 			panic("unreachable")
 		}
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"errors"
-	"os"
 	"os/exec"
 
 	"github.com/deref/uni/internal"
@@ -16,23 +15,28 @@ func init() {
 var replCmd = &cobra.Command{
 	Use:   "repl",
 	Short: "Start a Read Evaluate Print Loop.",
-	Long:  "Start a Read Evaluate Print Loop.",
+	Long: `Start a Read Evaluate Print Loop.
+
+Inputs are TypeScript, and imports are resolved from the current directory
+as they are in programs run by uni, including aliases and plugins. Each imported module is bundled when it is imported,
+so importing it again picks up changes to it. For example:
+
+  > import { foo } from '@myrepo/utils'
+  > foo()
+
+Only import statements are bundled; require calls and dynamic imports are
+evaluated by node directly.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		repo := mustLoadRepository()
 		if err := internal.CheckEngines(repo); err != nil {
 			return err
 		}
 
-		node := exec.Command("node", "--interactive")
-		node.Stdin = os.Stdin
-		node.Stdout = os.Stdout
-		node.Stderr = os.Stderr
-
-		err := node.Run()
+		err := internal.Repl(repo)
 
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			os.Exit(exitErr.ExitCode())
+			exit(exitErr.ExitCode())
 		}
 		return err
 	},
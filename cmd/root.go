@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/deref/uni/internal"
 	"github.com/spf13/cobra"
@@ -10,33 +11,105 @@ import (
 
 const rootDescription = "Unirepo is a tool for managing uniform TypeScript monorepos."
 
+var logFormat string
+var noColor bool
+var timestamps bool
+var logVerbose bool
+var logTrace bool
+var traceFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "format of diagnostics and lifecycle messages: text or json")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "do not color output labels (also disabled by NO_COLOR, or when stderr is not a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&timestamps, "timestamps", false, "prefix lines of output with the time of day")
+	rootCmd.PersistentFlags().BoolVarP(&logVerbose, "verbose", "v", false, "report how long loading config, bundling, starting processes, and handling changes take")
+	rootCmd.PersistentFlags().BoolVar(&logTrace, "trace", false, "like --verbose, and also report each file change and the time uni's plugins spend on each file")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "write timings of all phases to a file in Chrome trace format, viewable with chrome://tracing or ui.perfetto.dev")
+}
+
 var rootCmd = &cobra.Command{
 	Use:          "uni",
 	Short:        rootDescription,
 	Long:         rootDescription,
 	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := internal.SetLogFormat(logFormat); err != nil {
+			return err
+		}
+		internal.SetLogColor(!noColor && internal.ColorSupported(os.Stderr))
+		internal.SetLogTimestamps(timestamps)
+		switch {
+		case logTrace:
+			internal.SetLogLevel(internal.LogLevelTrace)
+		case logVerbose:
+			internal.SetLogLevel(internal.LogLevelVerbose)
+		}
+		if traceFile != "" {
+			path, err := filepath.Abs(traceFile)
+			if err != nil {
+				return err
+			}
+			internal.SetTraceFile(path)
+		}
+		internal.ConfigureOTel(cmd.CommandPath())
+		if internal.LogFormat(logFormat) == internal.LogFormatJSON {
+			// Errors are reported by Execute instead.
+			cmd.Root().SilenceErrors = true
+		}
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return cmd.Help()
 	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		internal.LogError(err)
+		// Run distinguishes its own failures from those of the program.
+		if cmd == runCmd {
+			exit(internal.RunExitCode(err))
+		}
+		exit(1)
 	}
+	exit(0)
 }
 
-func mustLoadRepository() *internal.Repository {
+// Address to serve metrics of watch mode on, if any. See serveMetrics.
+var metricsAddr string
+
+// serveMetrics serves metrics in the background if --metrics was given.
+func serveMetrics() error {
+	if metricsAddr == "" {
+		return nil
+	}
+	return internal.ServeMetrics(metricsAddr, os.Stderr)
+}
+
+// exit exits with the given status, after writing the trace file and
+// exporting spans, if enabled.
+func exit(code int) {
+	internal.ShutdownOTel(code)
+	if err := internal.WriteTraceFile(); err != nil {
+		internal.LogError(fmt.Errorf("writing trace file: %w", err))
+	}
+	os.Exit(code)
+}
+
+func loadRepository() (*internal.Repository, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return nil, err
 	}
-	repo, err := internal.LoadRepository(cwd)
+	return internal.LoadRepository(cwd)
+}
+
+func mustLoadRepository() *internal.Repository {
+	repo, err := loadRepository()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		internal.LogError(err)
+		exit(1)
 	}
 	return repo
 }
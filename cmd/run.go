@@ -3,24 +3,65 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/deref/uni/internal"
 	"github.com/spf13/cobra"
 )
 
 var runOpts = internal.RunOptions{}
+var inspectBrk string
+var shutdownSignal string
+var shutdownTimeout time.Duration
+var runDefines []string
+var runSourceMap string
+var runNoDaemon bool
+var runRestart string
+var runRestartOnCrash bool
+var runMaxRestarts int
+var runPortConflict string
+var runRuntime string
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().DurationVar(&runOpts.Poll, "poll", 0, "poll for changes at this interval in watch mode, instead of using filesystem notifications")
+	runCmd.Flags().Lookup("poll").NoOptDefVal = "1s"
 	runCmd.Flags().BoolVar(&runOpts.Watch, "watch", false, "re-runs command when source files change")
-	runCmd.Flags().BoolVar(&runOpts.BuildOnly, "build-only", false, "(internal) exit before running, skip temporary file cleanup, and print path to build output")
+	runCmd.Flags().BoolVar(&runOpts.Hot, "hot", false, "in watch mode, reload changed code into the running process instead of restarting it")
+	runCmd.Flags().StringVarP(&runOpts.Eval, "eval", "e", "", "run TypeScript code instead of an entrypoint, passing all arguments to it")
+	runCmd.Flags().BoolVar(&runOpts.BuildOnly, "build-only", false, "bundle without running, and print JSON describing the build output")
+	runCmd.Flags().StringSliceVar(&runOpts.WatchIgnore, "watch-ignore", nil, "glob pattern of paths to ignore in watch mode (repeatable)")
+	runCmd.Flags().StringVar(&runOpts.Dir, "cwd", "", "working directory of the program (default is the directory of the entrypoint's package)")
+	runCmd.Flags().StringSliceVar(&runOpts.EnvFiles, "env-file", nil, "load environment variables from a file, after .env and .env.local (repeatable)")
+	runCmd.Flags().StringSliceVar(&runOpts.Workers, "worker", nil, "also bundle an entrypoint alongside the program, such as a child process script (repeatable)")
+	runCmd.Flags().StringVar(&runRuntime, "runtime", "", "runtime that runs the program: node, deno, or bun (default from config, or node)")
+	runCmd.Flags().StringVar(&runOpts.Target, "target", "", "language and engine versions to compile for, such as es2019 or node14 (default from config)")
+	runCmd.Flags().StringVar(&runSourceMap, "sourcemap", "", "source map strategy: linked, external, hidden, inline, or none")
+	runCmd.Flags().StringArrayVar(&runDefines, "define", nil, "replace a global identifier with a JavaScript expression, as KEY=VALUE (repeatable)")
+	runCmd.Flags().BoolVar(&runOpts.TypeCheck, "check", false, "type check in the background after each rebuild (requires --watch)")
+	runCmd.Flags().StringVar(&runRestart, "restart", "", "how to restart in watch mode: stop (stop, then start) or prestart (start, then stop once ready) (default from config, or stop)")
+	runCmd.Flags().BoolVar(&runRestartOnCrash, "restart-on-crash", false, "in watch mode, restart the program with increasing delays when it fails, instead of waiting for changes (default from config)")
+	runCmd.Flags().IntVar(&runMaxRestarts, "max-restarts", 0, "number of consecutive failures to restart after with --restart-on-crash (default from config, or 5)")
+	runCmd.Flags().IntSliceVar(&runOpts.Ports, "port", nil, "TCP port that the script listens on, to be freed before it starts in watch mode (repeatable)")
+	runCmd.Flags().StringVar(&runPortConflict, "port-conflict", "", "in watch mode, what to do when a port is in use as the program starts: wait (for it to be released) or kill (its holder, if uni started it) (default from config, or wait)")
+	runCmd.Flags().StringVar(&shutdownSignal, "shutdown-signal", "", "signal sent to stop the process (default from config, or SIGTERM)")
+	runCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 0, "time to wait after the shutdown signal before killing (default from config, or 5s)")
+	runCmd.Flags().StringVar(&runOpts.Inspect, "inspect", "", "activate node inspector on [host:]port")
+	runCmd.Flags().Lookup("inspect").NoOptDefVal = internal.DefaultInspectAddress
+	runCmd.Flags().StringVar(&inspectBrk, "inspect-brk", "", "like --inspect, but break before user code starts")
+	runCmd.Flags().Lookup("inspect-brk").NoOptDefVal = internal.DefaultInspectAddress
+	runCmd.Flags().BoolVar(&runNoDaemon, "no-daemon", false, "bundle in this process, even if a daemon is running")
+	runCmd.Flags().StringVar(&runOpts.Metafile, "metafile", "", "write JSON metadata describing the inputs and outputs of the bundle to a file after each build")
+	runCmd.Flags().StringSliceVar(&runOpts.Externals.Bundle, "bundle", nil, "bundle modules matching a pattern, even if they are dependencies (repeatable)")
+	runCmd.Flags().StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics of rebuilds, restarts, and watched files at http://<addr>/metrics in watch mode")
+	runCmd.Flags().StringSliceVar(&runOpts.Externals.External, "external", nil, "leave modules matching a pattern external, loaded from node_modules at runtime (repeatable)")
 }
 
 var runCmd = &cobra.Command{
-	Use:   "run [flags] <script> [args...]",
+	Use:   "run [flags] (<script> [args...] | <target>... | -e <code> [args...])",
 	Short: "Build and run an entrypoint.",
 	Long: `Builds and runs the given entrypoint file.
 
@@ -36,6 +77,114 @@ pending events will not be executed; main is responsible for graceful shutdown.
 Unhandled exceptions and promise rejections will be logged to stderr and the
 process will immediately exit with status code 1.
 
+The program runs in its own process group. Signals sent to uni (SIGINT, SIGTERM,
+SIGHUP, SIGQUIT, SIGUSR1, and SIGUSR2) are forwarded to that process group. In
+watch mode, SIGINT and SIGTERM instead stop the program and exit.
+
+The program runs in the directory of its entrypoint's package: the nearest
+directory with a package.json file, or else the repository root. Targets may
+configure their own directory, and --cwd overrides either. Relative paths in
+arguments are resolved by the program, so relative to that directory.
+
+Environment variables are loaded from .env and .env.local files in the project
+root, if present, followed by any files given with --env-file. Variables that
+are already set take precedence. In watch mode, the program is restarted when
+these files change.
+
+In watch mode, the program is restarted after a rebuild only if the bundle or
+env files changed, so edits that do not affect the output, such as to
+comments or types, leave it running.
+
+Given --restart=prestart in watch mode, the replacement of a running program
+is started before the program is stopped, to minimize downtime. The
+replacement loads its bundle and awaits the "prepare" function exported by the
+entrypoint, if any, with the same arguments as main. Once it is ready, the
+running program is stopped and the replacement's main function is called.
+Preparation must not acquire resources held by the running program, such as
+listening ports. If the replacement fails before it is ready, the running
+program is kept.
+
+With ports given by --port or configured for the target, uni listens on them
+itself and passes the sockets to each process, whose servers listen on them
+instead. Then the replacement's main function is called as soon as it is
+ready, while the running program still serves, and connections wait to be
+accepted rather than being refused while one process stops and the other
+starts. Since uni holds the ports, a "port" readiness probe passes at once.
+
+Given --restart-on-crash in watch mode, a program that fails is restarted
+automatically after a delay, rather than waiting for a change. The delay
+starts at one second and doubles after each consecutive failure, up to 30
+seconds, and uni gives up after --max-restarts failures in a row. Failures
+are no longer consecutive once the program has run for a minute, or after a
+change restarts it. Programs that exit successfully are not restarted.
+
+In watch mode, ports that the program listens on, given with --port or
+configured for targets, are checked before each start. If a port is still in
+use, such as by an orphaned child process of the previous program, uni waits
+for it to be released, or given --port-conflict=kill, stops the process
+holding it. Either way, uni waits up to the shutdown timeout before starting
+the program anyway.
+
+Given --metrics <addr> in watch mode, such as localhost:9464, uni serves
+metrics in the Prometheus text format at /metrics: rebuild counts and
+durations, restarts of the program, and the number of watched files.
+
+Given --hot in watch mode, changed code is reloaded into the running process
+instead of restarting it, so that state such as connection pools survives.
+The entrypoint may export a "dispose" function, which is awaited before
+reloading, such as to close servers. Then main is called again with the
+reloaded modules. Dependencies loaded from node_modules are not reloaded, and
+values stored on globalThis persist. The process keeps running after main
+returns until it has nothing left to do. If the build fails, the last good
+build keeps running. Changes to env files still restart the process.
+
+Programs run with node by default. Given --runtime=deno or --runtime=bun, or
+configured with run.runtime, they run with "deno run --allow-all" or bun
+instead, found on the PATH. For deno, the program is bundled as an ES module
+that imports node's builtin modules by their node: specifiers, with require,
+__filename, and __dirname defined for compatibility. Unless a target is
+configured, syntax is not transformed for these runtimes. Hot reloading and the
+prestart restart strategy require node.
+
+Workers referenced as "new Worker(new URL('./worker.ts', import.meta.url))" are
+bundled separately, alongside the program, and the URL is rewritten to refer to
+the bundled worker. Other scripts, such as those run in child processes, may be
+bundled with --worker and located relative to __dirname, with a .js extension.
+
+Targets and groups of targets configured in uni.yml may be run by name instead,
+such as "uni run api worker" or "uni run dev". Targets are bundled together,
+but each is restarted only when its own bundle changes, and its output is
+labeled with its name. Without --watch, the first target to exit stops the
+others.
+
+Given --build-only, programs are bundled but not run. The build output is moved
+to out/run/<hash>, named by a hash of its contents, so that identical builds
+share a path, and a line of JSON is printed for each program with its
+"entrypoint", "hash", "dir", "script" that runs it with node, "bundle",
+"sourcemap" if any, and "target" name if any. Build output is kept until
+removed, such as by "uni clean --artifacts".
+
+Given --eval (or -e), the given TypeScript code is run instead of an
+entrypoint, as with "node -e", and all arguments are passed to it. Given "-"
+as the script, or no arguments while stdin is not a terminal, the code is read
+from stdin instead. The code is bundled like any entrypoint, with imports
+resolved from the current directory (or --cwd), so it may import files by paths
+relative to that directory, as well as installed dependencies. It may export a
+main function, but need not.
+
+Given no arguments with a terminal attached, prompts for a target or a package
+entrypoint to run, chosen by typing to search.
+
+When watching with a terminal attached, the program does not receive stdin.
+Instead, enter "rs" (or "r") to force a rebuild and restart, or "q" to quit.
+
+Exit status is that of the program, or 128+N if it was killed by signal N.
+Failures of uni itself have these exit statuses instead:
+
+  123  bundling failed
+  125  invalid arguments or configuration, or another error in uni
+  126  the program could not be started, such as when node is not installed
+
 Example:
 
 export const main = async (...args: string[]) => {
@@ -43,28 +192,178 @@ export const main = async (...args: string[]) => {
   return 0; // Return an exit code (optional).
 }
 `,
-	Args:                  cobra.MinimumNArgs(1),
+	Args:                  cobra.ArbitraryArgs,
 	DisableFlagsInUseLine: true,
 	SilenceErrors:         true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		repo := mustLoadRepository()
+		// As with node, code is read from stdin given "-", or given no arguments
+		// when stdin is not a terminal.
+		if runOpts.Eval == "" && ((len(args) > 0 && args[0] == "-") || (len(args) == 0 && !internal.StdinIsTerminal())) {
+			code, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("reading stdin: %w", err)
+			}
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			runOpts.Eval = string(code)
+			if runOpts.Eval == "" {
+				return errors.New("no code to run from stdin")
+			}
+		}
+		if runOpts.Eval == "" && len(args) == 0 && !internal.CanPick() {
+			return errors.New("requires a script or target")
+		}
+		repo, err := loadRepository()
+		if err != nil {
+			return err
+		}
 		if err := internal.CheckEngines(repo); err != nil {
 			return err
 		}
 
-		var err error
-		runOpts.Entrypoint, err = filepath.Abs(args[0])
+		if runOpts.Eval == "" && len(args) == 0 {
+			picked, err := internal.PickRunnable(repo)
+			if err != nil {
+				return err
+			}
+			args = []string{picked}
+		}
+		switch {
+		case runOpts.Eval != "":
+			runOpts.Args = args
+		case internal.IsRunTarget(repo, args[0]):
+			runOpts.Targets, err = internal.ResolveRunTargets(repo, args)
+			if err != nil {
+				return err
+			}
+		default:
+			runOpts.Entrypoint, err = filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			runOpts.Args = args[1:]
+		}
+
+		if runOpts.Dir != "" {
+			runOpts.Dir, err = filepath.Abs(runOpts.Dir)
+			if err != nil {
+				return err
+			}
+			if fi, err := os.Stat(runOpts.Dir); err != nil || !fi.IsDir() {
+				return fmt.Errorf("--cwd is not a directory: %q", runOpts.Dir)
+			}
+		}
+
+		for i, envFile := range runOpts.EnvFiles {
+			runOpts.EnvFiles[i], err = filepath.Abs(envFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, worker := range runOpts.Workers {
+			runOpts.Workers[i], err = filepath.Abs(worker)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := runOpts.Externals.Validate(); err != nil {
+			return err
+		}
+
+		if runOpts.Metafile != "" {
+			runOpts.Metafile, err = filepath.Abs(runOpts.Metafile)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := internal.ValidateTarget(runOpts.Target); err != nil {
+			return err
+		}
+		runOpts.Runtime, err = internal.ParseRuntime(runRuntime)
+		if err != nil {
+			return err
+		}
+		runOpts.SourceMap, err = internal.ParseSourceMap(runSourceMap)
+		if err != nil {
+			return err
+		}
+		runOpts.Define, err = internal.ParseDefines(runDefines)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			return err
+		}
+
+		runOpts.Shutdown = repo.Shutdown
+		if shutdownSignal != "" {
+			runOpts.Shutdown.Signal, err = internal.ParseSignal(shutdownSignal)
+			if err != nil {
+				return err
+			}
+		}
+		if shutdownTimeout != 0 {
+			runOpts.Shutdown.Timeout = shutdownTimeout
+		}
+
+		runOpts.Restart = repo.Restart
+		if runRestart != "" {
+			runOpts.Restart, err = internal.ParseRestartStrategy(runRestart)
+			if err != nil {
+				return err
+			}
 		}
 
-		runOpts.Args = args[1:]
+		runOpts.CrashRestart = repo.CrashRestart
+		if cmd.Flags().Changed("restart-on-crash") && !runRestartOnCrash {
+			runOpts.CrashRestart = nil
+		} else if runRestartOnCrash || runMaxRestarts != 0 {
+			if runMaxRestarts < 0 {
+				return errors.New("--max-restarts must be positive")
+			}
+			policy := internal.DefaultCrashRestartPolicy()
+			if runOpts.CrashRestart != nil {
+				*policy = *runOpts.CrashRestart
+			}
+			if runMaxRestarts > 0 {
+				policy.MaxRetries = runMaxRestarts
+			}
+			runOpts.CrashRestart = policy
+		}
 
+		runOpts.PortConflict = repo.PortConflict
+		if runPortConflict != "" {
+			runOpts.PortConflict, err = internal.ParsePortConflictStrategy(runPortConflict)
+			if err != nil {
+				return err
+			}
+		}
+		if len(runOpts.Ports) > 0 && len(runOpts.Targets) > 0 {
+			return errors.New("--port cannot be used with targets; configure their ports instead")
+		}
+		if runOpts.TypeCheck && (!runOpts.Watch || runOpts.BuildOnly) {
+			return errors.New("--check requires --watch, without --build-only")
+		}
+
+		if inspectBrk != "" {
+			if runOpts.Inspect != "" {
+				return errors.New("--inspect and --inspect-brk are mutually exclusive")
+			}
+			runOpts.Inspect = inspectBrk
+			runOpts.InspectBrk = true
+		}
+
+		runOpts.UseDaemon = !runNoDaemon
+		if runOpts.Watch {
+			if err := serveMetrics(); err != nil {
+				return err
+			}
+		}
 		err = internal.Run(repo, runOpts)
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			os.Exit(exitErr.ExitCode())
+		// The program reports its own failures.
+		if code, ok := internal.ProgramExitCode(err); ok {
+			exit(code)
 		}
 		return err
 	},
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var serveOpts internal.ServeOptions
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveOpts.Addr, "addr", internal.DefaultServeAddress, "address to listen on")
+	serveCmd.Flags().StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics of rebuilds and watched files at http://<addr>/metrics")
+	serveCmd.Flags().StringVar(&serveOpts.StaticDir, "static", "", "directory of static files to serve (default: entrypoint directory)")
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags] <entrypoint>",
+	Short: "Serve a browser entrypoint with live reload.",
+	Long: `Bundles the given entrypoint for the browser and serves it over HTTP.
+
+The bundle is rebuilt whenever source files change, and open pages are reloaded
+automatically.
+
+The bundle is served at /bundle.js, and CSS from stylesheets it imports is
+served at /bundle.css. If the static directory contains an
+index.html file, it is served with a live reload script appended. Otherwise, a
+minimal page that loads the bundle is served.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+
+		var err error
+		serveOpts.Entrypoint, err = filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		if err := serveMetrics(); err != nil {
+			return err
+		}
+		return internal.Serve(repo, serveOpts)
+	},
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var taskOpts internal.TaskOptions
+
+func init() {
+	rootCmd.AddCommand(taskCmd)
+	taskCmd.Flags().BoolVar(&taskOpts.NoCache, "no-cache", false, "run tasks even if their inputs are unchanged")
+}
+
+var taskCmd = &cobra.Command{
+	Use:   "task [task] [package]",
+	Short: "Runs package tasks.",
+	Long: `Runs the given task of the given package, or of every package that defines it,
+after the tasks that each depends on. Given no arguments, lists all tasks.
+
+Tasks are shell commands configured per package in uni.yml, which are run from
+the project root. The builtin "build" task builds a package, as with uni build.
+
+Tasks with inputs are skipped when their inputs and outputs are unchanged since
+they last succeeded.`,
+	Args: cobra.RangeArgs(0, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+
+		if len(args) == 0 {
+			return internal.WriteTasks(os.Stdout, repo)
+		}
+
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+
+		packages := repo.Packages
+		if len(args) > 1 {
+			pkgName := args[1]
+			pkg, ok := repo.Packages[pkgName]
+			if !ok {
+				return fmt.Errorf("no such package: %q", pkgName)
+			}
+			packages = map[string]*internal.Package{
+				pkgName: pkg,
+			}
+		}
+
+		return internal.RunTasks(repo, args[0], packages, taskOpts)
+	},
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var testOpts internal.TestOptions
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+	testCmd.Flags().DurationVar(&testOpts.Poll, "poll", 0, "poll for changes at this interval in watch mode, instead of using filesystem notifications")
+	testCmd.Flags().Lookup("poll").NoOptDefVal = "1s"
+	testCmd.Flags().BoolVar(&testOpts.Watch, "watch", false, "reruns affected tests when source files change")
+	testCmd.Flags().StringVar(&testOpts.Since, "since", "", "only run tests affected by changes since a git ref")
+}
+
+var testCmd = &cobra.Command{
+	Use:   "test [paths...]",
+	Short: "Runs tests.",
+	Long: `Finds, builds, and runs test files.
+
+Test files are named with a ".test.ts" suffix and are searched for within the
+given files or directories, or the entire repository if none are given.
+
+Test files export functions with names beginning with "test". Each is called and
+awaited in turn. A test fails if it throws an exception or returns a rejected
+promise.
+
+In watch mode, only tests that depend on changed files are rerun. Test files
+created while watching are run as they are found.
+
+Example:
+
+export const testAddition = () => {
+  if (1 + 1 !== 2) {
+    throw new Error("math is broken");
+  }
+};
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+		testOpts.Paths = args
+		return internal.Test(repo, testOpts)
+	},
+}
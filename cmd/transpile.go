@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var transpileOpts internal.TranspileOptions
+
+func init() {
+	rootCmd.AddCommand(transpileCmd)
+	transpileCmd.Flags().StringVar(&transpileOpts.OutDir, "outdir", "", "directory to write modules to (default out/transpile/<package>)")
+}
+
+var transpileCmd = &cobra.Command{
+	Use:   "transpile <package>",
+	Short: "Transpiles a package's modules without bundling.",
+	Long: `Transpiles each source module of a package to its own module, preserving
+the module structure, for consumers that need unbundled output.
+
+Modules are found by following imports from the package's entrypoints, and
+are written to out/transpile/<package>, or the directory given by --outdir,
+at their paths relative to the directory containing all of them. Imports of
+source modules, including those of aliases, are rewritten to the relative
+paths of their outputs, and imports of the index modules of other packages are
+rewritten to the names of those packages. Other imported files, such as
+stylesheets and JSON, are copied.
+
+Dependencies are always left as imports, even those bundled by the build
+command. Dual format packages are transpiled to CommonJS. Unlike the build
+command, no package.json is written.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repo := mustLoadRepository()
+		pkg, ok := repo.Packages[args[0]]
+		if !ok {
+			return fmt.Errorf("no such package: %q", args[0])
+		}
+		transpileOpts.Package = pkg
+		if transpileOpts.OutDir != "" {
+			var err error
+			transpileOpts.OutDir, err = filepath.Abs(transpileOpts.OutDir)
+			if err != nil {
+				return err
+			}
+		}
+		return internal.Transpile(repo, transpileOpts)
+	},
+}
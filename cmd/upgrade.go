@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/deref/uni/internal"
+	"github.com/spf13/cobra"
+)
+
+var upgradeOpts internal.UpgradeOptions
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().BoolVarP(&upgradeOpts.Interactive, "interactive", "i", false, "prompt for an outdated dependency to upgrade")
+	upgradeCmd.Flags().BoolVar(&upgradeOpts.Build, "build", false, "rebuild packages that import upgraded dependencies")
+	upgradeCmd.Flags().BoolVar(&upgradeOpts.Test, "test", false, "run tests that import upgraded dependencies")
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [dependency[@version]...]",
+	Short: "Upgrades dependencies.",
+	Long: `Changes the versions of dependencies in uni.yml and reinstalls dependencies.
+
+Dependencies given without versions are upgraded to the versions tagged latest
+in the registry. Exact versions stay exact, and ^ and ~ ranges are kept,
+starting from the latest version. Dependencies configured with other versions,
+such as tags or URLs, must be given with the version to upgrade to.
+
+Given --interactive, fetches the latest version of every dependency from the
+registry, and prompts for one to upgrade among those whose installed versions
+are older, chosen by typing to search.
+
+Given --build, packages whose source files import upgraded dependencies are
+rebuilt. Given --test, test files that import upgraded dependencies, directly
+or through other source files, are run.
+
+Versions are edited in place, preserving the rest of uni.yml. Config scripts
+cannot be edited, so the versions to configure are printed instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if upgradeOpts.Interactive && len(args) > 0 {
+			return errors.New("cannot specify --interactive with dependencies")
+		}
+		if !upgradeOpts.Interactive && len(args) == 0 {
+			return errors.New("expected a dependency to upgrade, or --interactive")
+		}
+		repo := mustLoadRepository()
+		if err := internal.CheckEngines(repo); err != nil {
+			return err
+		}
+		upgradeOpts.Dependencies = args
+		return internal.Upgrade(os.Stderr, repo, upgradeOpts)
+	},
+}
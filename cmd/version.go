@@ -7,31 +7,28 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var verbose bool
-
 func init() {
 	rootCmd.AddCommand(versionCmd)
-	versionCmd.Flags().BoolVar(&verbose, "verbose", false, "Prints version information for all dependencies too.")
 }
 
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version of Unirepo.",
-	Long:  "Print the version of Unirepo.",
+	Long:  "Print the version of Unirepo. Given --verbose, also prints the versions of all dependencies.",
 	Run: func(cmd *cobra.Command, args []string) {
 		buildInfo, ok := debug.ReadBuildInfo()
 		if !ok {
 			panic("debug.ReadBuildInfo() failed")
 		}
 		printInfo := func(mod debug.Module) {
-			if verbose {
+			if logVerbose {
 				fmt.Println(mod.Path, mod.Version)
 			} else {
 				fmt.Println(mod.Version)
 			}
 		}
 		printInfo(buildInfo.Main)
-		if verbose {
+		if logVerbose {
 			for _, dep := range buildInfo.Deps {
 				fmt.Println(dep.Path, dep.Version)
 			}
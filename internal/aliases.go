@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Extensions tried, in order, when resolving an aliased import without one.
+var aliasExtensions = []string{".tsx", ".ts", ".jsx", ".js", ".json"}
+
+// aliasesPlugin resolves imports beginning with a configured alias to paths
+// relative to the repository root. Returns nil if no aliases are configured.
+func aliasesPlugin(repo *Repository) *api.Plugin {
+	if len(repo.Aliases) == 0 {
+		return nil
+	}
+	aliases := sortedAliases(repo)
+	patterns := make([]string, len(aliases))
+	for i, alias := range aliases {
+		patterns[i] = regexp.QuoteMeta(alias)
+	}
+	filter := "^(" + strings.Join(patterns, "|") + ")(/|$)"
+
+	return &api.Plugin{
+		Name: "unirepo:aliases",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: filter,
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				resolved, ok, err := resolveAlias(repo, aliases, args.Path)
+				if !ok || err != nil {
+					return api.OnResolveResult{}, err
+				}
+				return api.OnResolveResult{
+					Path: resolved,
+				}, nil
+			})
+		},
+	}
+}
+
+// sortedAliases returns the configured aliases, longest first, so that more
+// specific aliases take precedence.
+func sortedAliases(repo *Repository) []string {
+	aliases := make([]string, 0, len(repo.Aliases))
+	for alias := range repo.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return len(aliases[i]) > len(aliases[j])
+	})
+	return aliases
+}
+
+// resolveAlias resolves an import path beginning with one of the given
+// aliases to a file. Returns false if the path is not aliased.
+func resolveAlias(repo *Repository, aliases []string, importPath string) (string, bool, error) {
+	for _, alias := range aliases {
+		if importPath != alias && !strings.HasPrefix(importPath, alias+"/") {
+			continue
+		}
+		target := filepath.Join(repo.RootDir, repo.Aliases[alias], strings.TrimPrefix(importPath, alias))
+		if index, ok := repo.workspaceIndexes[importPath]; ok {
+			target = filepath.Join(repo.RootDir, index)
+		}
+		resolved, ok := resolveFile(target)
+		if !ok {
+			return "", true, fmt.Errorf("could not resolve %q (aliased to %q)", importPath, target)
+		}
+		return resolved, true, nil
+	}
+	return "", false, nil
+}
+
+func resolveFile(target string) (string, bool) {
+	candidates := []string{target}
+	for _, ext := range aliasExtensions {
+		candidates = append(candidates, target+ext)
+	}
+	for _, ext := range aliasExtensions {
+		candidates = append(candidates, filepath.Join(target, "index"+ext))
+	}
+	for _, candidate := range candidates {
+		if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var sourceMappingURLPattern = regexp.MustCompile(`(?m)^//# sourceMappingURL=(.*)\n?$`)
+
+const inlineSourceMapPrefix = "data:application/json;base64,"
+
+// wrapOutput adds a banner and footer to a built file, as esbuild's options
+// of the same names do for every file of a build. The banner follows any
+// hashbang line, unless it is one itself, and the footer precedes any source
+// map comment. Source maps are shifted by the lines added, whether inline or
+// in a .map file alongside the output.
+func wrapOutput(filename string, banner string, footer string) error {
+	if banner == "" && footer == "" {
+		return nil
+	}
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	code := string(bs)
+
+	// Line at which the banner is inserted.
+	at := 0
+	head := ""
+	if strings.HasPrefix(code, "#!") && !strings.HasPrefix(banner, "#!") {
+		end := strings.IndexByte(code, '\n') + 1
+		if end == 0 {
+			end = len(code)
+		}
+		head, code = code[:end], code[end:]
+		at = 1
+	}
+	if banner != "" && !strings.HasSuffix(banner, "\n") {
+		banner += "\n"
+	}
+	lines := strings.Count(banner, "\n")
+
+	tail := ""
+	if loc := sourceMappingURLPattern.FindStringIndex(code); loc != nil && strings.TrimSpace(code[loc[1]:]) == "" {
+		code, tail = code[:loc[0]], code[loc[0]:]
+	}
+	if footer != "" {
+		if code != "" && !strings.HasSuffix(code, "\n") {
+			code += "\n"
+		}
+		footer += "\n"
+	}
+
+	if lines > 0 {
+		if match := sourceMappingURLPattern.FindStringSubmatch(tail); match != nil && strings.HasPrefix(match[1], inlineSourceMapPrefix) {
+			sourceMap, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(match[1], inlineSourceMapPrefix))
+			if err != nil {
+				return fmt.Errorf("decoding inline source map: %w", err)
+			}
+			sourceMap, err = shiftSourceMap(sourceMap, at, lines)
+			if err != nil {
+				return err
+			}
+			tail = "//# sourceMappingURL=" + inlineSourceMapPrefix + base64.StdEncoding.EncodeToString(sourceMap) + "\n"
+		} else if err := shiftSourceMapFile(filename+".map", at, lines); err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(filename, []byte(head+banner+code+footer+tail), 0644)
+}
+
+// shiftSourceMapFile shifts a source map file, if it exists.
+func shiftSourceMapFile(filename string, at int, lines int) error {
+	bs, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bs, err = shiftSourceMap(bs, at, lines)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return ioutil.WriteFile(filename, bs, 0644)
+}
+
+// shiftSourceMap updates a source map for lines inserted into its generated
+// file before the given line, counting from zero. Lines of mappings are
+// separated by semicolons, so inserting empty lines of mappings suffices.
+func shiftSourceMap(bs []byte, at int, lines int) ([]byte, error) {
+	var sourceMap map[string]interface{}
+	if err := json.Unmarshal(bs, &sourceMap); err != nil {
+		return nil, fmt.Errorf("invalid source map: %w", err)
+	}
+	mappings, ok := sourceMap["mappings"].(string)
+	if !ok {
+		return nil, fmt.Errorf("source map has no mappings")
+	}
+	parts := strings.SplitN(mappings, ";", at+1)
+	if len(parts) <= at {
+		return bs, nil
+	}
+	before := strings.Join(parts[:at], ";")
+	if at > 0 {
+		before += ";"
+	}
+	sourceMap["mappings"] = before + strings.Repeat(";", lines) + parts[at]
+	return json.Marshal(sourceMap)
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Boundaries restricts which packages may import modules of which others.
+// Modules belong to the package with the deepest directory containing them,
+// where the directory of a package is the deepest one containing all of its
+// entrypoints. Modules in no package's directory, or in a directory shared by
+// several packages, may be imported by any package.
+type Boundaries struct {
+	// Whether packages may import only the exported entrypoints of other
+	// packages, and not their other modules.
+	NoDeepImports bool
+	Rules         []BoundaryRule
+}
+
+// BoundaryRule forbids packages from importing modules of other packages.
+// Both are given by names or globs of names, such as "@example/*".
+type BoundaryRule struct {
+	From     string
+	Disallow []string
+}
+
+func (rule BoundaryRule) validate(repo *Repository) error {
+	if rule.From == "" {
+		return errors.New("from is required")
+	}
+	if len(rule.Disallow) == 0 {
+		return errors.New("disallow is required")
+	}
+	for _, pattern := range append([]string{rule.From}, rule.Disallow...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		matched := false
+		for name := range repo.Packages {
+			if matchPackageName(pattern, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%q matches no packages", pattern)
+		}
+	}
+	return nil
+}
+
+// matchPackageName reports whether a package name matches a name or glob.
+// Globs match across slashes, so that "@example/*" matches every package of
+// the scope.
+func matchPackageName(pattern string, name string) bool {
+	matched, _ := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(name, "/", "\x00"))
+	return matched
+}
+
+// boundariesPlugin fails builds at imports that cross the configured package
+// boundaries, reporting the chain of imports that led to them. Only imports
+// that uni resolves itself, which are relative paths and aliases, are
+// checked. Returns nil if no boundaries are configured.
+func boundariesPlugin(repo *Repository) *api.Plugin {
+	boundaries := repo.Boundaries
+	if boundaries == nil || (!boundaries.NoDeepImports && len(boundaries.Rules) == 0) {
+		return nil
+	}
+
+	type packageDir struct {
+		name string
+		dir  string
+	}
+	var dirs []packageDir
+	exported := make(map[string]bool)
+	for _, name := range PackageNames(repo) {
+		pkg := repo.Packages[name]
+		var entrypoints []string
+		for _, entrypoint := range pkg.entrypointPaths() {
+			entrypoints = append(entrypoints, path.Join(repo.RootDir, entrypoint))
+		}
+		dirs = append(dirs, packageDir{name, commonDir(entrypoints)})
+		if pkg.Index != "" {
+			exported[path.Join(repo.RootDir, pkg.Index)] = true
+		}
+		for _, entrypoint := range pkg.Entrypoints {
+			exported[path.Join(repo.RootDir, entrypoint)] = true
+		}
+	}
+	// Deepest directories first, with those shared by packages adjacent.
+	sort.Slice(dirs, func(i, j int) bool {
+		if len(dirs[i].dir) != len(dirs[j].dir) {
+			return len(dirs[i].dir) > len(dirs[j].dir)
+		}
+		return dirs[i].dir < dirs[j].dir
+	})
+	owner := func(filename string) string {
+		for i, pd := range dirs {
+			if !strings.HasPrefix(filename, pd.dir+"/") {
+				continue
+			}
+			if i+1 < len(dirs) && dirs[i+1].dir == pd.dir {
+				return ""
+			}
+			if i > 0 && dirs[i-1].dir == pd.dir {
+				return ""
+			}
+			return pd.name
+		}
+		return ""
+	}
+
+	// Map of each module to the module that first imported it, from which
+	// import chains are reconstructed.
+	var mx sync.Mutex
+	importers := make(map[string]string)
+	chain := func(importer string, imported string) string {
+		mx.Lock()
+		defer mx.Unlock()
+		files := []string{relativeToRoot(repo, imported)}
+		seen := map[string]bool{imported: true}
+		for file := importer; file != "" && !seen[file]; file = importers[file] {
+			seen[file] = true
+			files = append(files, relativeToRoot(repo, file))
+		}
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+		return strings.Join(files, " -> ")
+	}
+
+	aliases := sortedAliases(repo)
+	return &api.Plugin{
+		Name: "unirepo:boundaries",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: ".*",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Importer == "" || args.Namespace != "file" || isNodeModulesPath(args.Importer) {
+					return api.OnResolveResult{}, nil
+				}
+				var resolved string
+				var ok bool
+				if strings.HasPrefix(args.Path, "./") || strings.HasPrefix(args.Path, "../") {
+					resolved, ok = resolveFile(filepath.Join(args.ResolveDir, args.Path))
+				} else {
+					// Errors are reported by the aliases plugin.
+					resolved, ok, _ = resolveAlias(repo, aliases, args.Path)
+				}
+				if !ok {
+					return api.OnResolveResult{}, nil
+				}
+				resolved = filepath.ToSlash(resolved)
+				importer := filepath.ToSlash(args.Importer)
+				mx.Lock()
+				if _, ok := importers[resolved]; !ok {
+					importers[resolved] = importer
+				}
+				mx.Unlock()
+
+				from, to := owner(importer), owner(resolved)
+				if from == "" || to == "" || from == to {
+					return api.OnResolveResult{}, nil
+				}
+				for _, rule := range boundaries.Rules {
+					if !matchPackageName(rule.From, from) {
+						continue
+					}
+					for _, disallowed := range rule.Disallow {
+						if matchPackageName(disallowed, to) {
+							return api.OnResolveResult{}, fmt.Errorf("package %s may not import package %s: %s", from, to, chain(importer, resolved))
+						}
+					}
+				}
+				if boundaries.NoDeepImports && !exported[resolved] {
+					return api.OnResolveResult{}, fmt.Errorf("package %s may import only the entrypoints of package %s: %s", from, to, chain(importer, resolved))
+				}
+				return api.OnResolveResult{}, nil
+			})
+		},
+	}
+}
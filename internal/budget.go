@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// SizeBudget limits the size of each JavaScript file built for a package.
+type SizeBudget struct {
+	// Maximum size in bytes, before and after gzip compression. Zero means
+	// unlimited.
+	MaxBytes     int
+	MaxGzipBytes int
+	// Report exceeded budgets as warnings instead of failing the build.
+	Warn bool
+}
+
+func newSizeBudget(cfg BudgetConfig) (*SizeBudget, error) {
+	if cfg.Raw == "" && cfg.Gzip == "" {
+		return nil, errors.New("budget requires raw or gzip")
+	}
+	budget := &SizeBudget{Warn: cfg.Warn}
+	var err error
+	if cfg.Raw != "" {
+		budget.MaxBytes, err = parseByteSize(cfg.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid raw budget: %w", err)
+		}
+	}
+	if cfg.Gzip != "" {
+		budget.MaxGzipBytes, err = parseByteSize(cfg.Gzip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip budget: %w", err)
+		}
+	}
+	return budget, nil
+}
+
+// parseByteSize parses a number of bytes with an optional unit, such as
+// "512", "100 KB", or "1.5MiB". Both decimal (KB, MB) and binary (KiB, MiB)
+// units are supported.
+func parseByteSize(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !('0' <= r && r <= '9' || r == '.')
+	})
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	multipliers := map[string]float64{
+		"":    1,
+		"b":   1,
+		"kb":  1000,
+		"mb":  1000 * 1000,
+		"kib": 1024,
+		"mib": 1024 * 1024,
+	}
+	multiplier, ok := multipliers[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit: %q", unit)
+	}
+	return int(n * multiplier), nil
+}
+
+// Number of largest contributors listed per output that exceeds its budget.
+const budgetTop = 5
+
+// checkSizeBudget compares each JavaScript output in a build's metafile
+// against a budget, reporting those over budget along with their largest
+// contributors. Fails if any output is over budget, unless the budget only
+// warns.
+func checkSizeBudget(w io.Writer, repo *Repository, budget *SizeBudget, metafilePath string) error {
+	metafile, err := readMetafile(metafilePath)
+	if err != nil {
+		return fmt.Errorf("reading metafile: %w", err)
+	}
+	outputs := make([]string, 0, len(metafile.Outputs))
+	for output := range metafile.Outputs {
+		if strings.HasSuffix(output, ".map") || strings.HasSuffix(output, ".css") {
+			continue
+		}
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+
+	var messages []api.Message
+	for _, output := range outputs {
+		filename := output
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(repo.RootDir, filename)
+		}
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var exceeded []string
+		if budget.MaxBytes > 0 && len(bs) > budget.MaxBytes {
+			exceeded = append(exceeded, fmt.Sprintf("%s, over its budget of %s", formatBytes(len(bs)), formatBytes(budget.MaxBytes)))
+		}
+		if budget.MaxGzipBytes > 0 {
+			gzipBytes, err := gzipSize(bs)
+			if err != nil {
+				return err
+			}
+			if gzipBytes > budget.MaxGzipBytes {
+				exceeded = append(exceeded, fmt.Sprintf("%s gzipped, over its budget of %s", formatBytes(gzipBytes), formatBytes(budget.MaxGzipBytes)))
+			}
+		}
+		if len(exceeded) == 0 {
+			continue
+		}
+		var text strings.Builder
+		fmt.Fprintf(&text, "%s is %s; largest contributors:", output, strings.Join(exceeded, " and "))
+		for _, input := range metafile.LargestInputs(output, budgetTop) {
+			fmt.Fprintf(&text, "\n    %-50s %10s %6s", input.name, formatBytes(input.bytes), formatPercent(input.bytes, len(bs)))
+		}
+		messages = append(messages, api.Message{Text: text.String()})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	kind := "error"
+	if budget.Warn {
+		kind = "warning"
+	}
+	fprintMessages(w, messages, kind)
+	if budget.Warn {
+		return nil
+	}
+	return errors.New("size budget exceeded")
+}
+
+func gzipSize(bs []byte) (int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(bs); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return buf.Len(), nil
+}
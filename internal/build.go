@@ -1,27 +1,270 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
 
+// Name of the bundled type declarations file in built packages.
+const typesFileName = "index.d.ts"
+
 type BuildOptions struct {
 	Package *Package
-	Version string
-	Types   bool
-	Watch   bool
+	// Version to stamp into package.json. Defaults to PackageVersion.
+	Version   string
+	Types     bool
+	Watch     bool
+	TypeCheck bool
+	NoCache   bool
+	// Additional glob patterns of paths to ignore in watch mode.
+	WatchIgnore []string
+	// If positive, poll for changes at this interval in watch mode.
+	Poll time.Duration
+	// Maximum number of packages to build concurrently in BuildPackages.
+	Jobs int
+	// Defines in addition to, or overriding, those configured.
+	Define map[string]string
+	// Fail if source files have import cycles, instead of warning.
+	FailOnCycles bool
+	// Fail if dependency versions conflict, instead of warning. See
+	// checkDependencyVersions.
+	StrictVersions bool
+	// Print a report of bundle sizes after building.
+	Analyze bool
+	// Minify, even if not configured for the package.
+	Minify bool
+	// Upload source maps as configured for the repository. Requires a version.
+	UploadSourceMaps bool
+	// Overrides the package's source map strategy, if set.
+	SourceMap SourceMap
+	// Build for production use. Implies Minify, defaults to hidden source
+	// maps, defines process.env.NODE_ENV as
+	// "production" unless otherwise defined, and removes console.debug calls
+	// whose results are unused. Debugger statements are kept.
+	Production bool
+	// Build with a running daemon, if any. See Daemon.
+	UseDaemon bool
+	// Neither restore nor save builds with the configured remote cache.
+	NoRemoteCache bool
+	// If set, write BuildMetadata of built packages to this file.
+	Metafile string
+	// Overrides of configured externals.
+	Externals Externals
+	// Overrides the esbuild target of every package, if set. See parseTarget.
+	Target string
+	// Builds deployment artifacts instead of npm packages, if set.
+	Preset BuildPreset
+	// Report what would be built without writing the build output. See
+	// printBuildPlan.
+	DryRun bool
+
+	stderr   io.Writer
+	metadata *packageMetadataFile
+}
+
+// BuildPreset is a kind of artifact built instead of an npm package.
+type BuildPreset string
+
+const (
+	// A zip file to deploy as an AWS Lambda function. See buildLambda.
+	PresetLambda BuildPreset = "lambda"
+	// Self-contained executables that embed node. See buildSingleExecutables.
+	PresetExecutable BuildPreset = "executable"
+)
+
+func ParseBuildPreset(s string) (BuildPreset, error) {
+	switch preset := BuildPreset(s); preset {
+	case "", PresetLambda, PresetExecutable:
+		return preset, nil
+	default:
+		return "", fmt.Errorf("unknown preset: %q, expected lambda or executable", s)
+	}
 }
 
 func Build(repo *Repository, opts BuildOptions) error {
+	if opts.DryRun && opts.Preset != "" {
+		return errors.New("cannot dry run presets")
+	}
+	switch opts.Preset {
+	case PresetLambda:
+		return buildLambda(repo, opts)
+	case PresetExecutable:
+		return buildSingleExecutables(repo, opts)
+	}
+
 	pkg := opts.Package
 
 	packageDir := path.Join(repo.OutDir, "dist", pkg.Name)
+	metafilePath := path.Join(repo.TmpDir, "meta", stripName(pkg.Name)+".json")
+
+	stderr := opts.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	// A dry run builds into a temporary directory, without running code
+	// generators or hooks, nor updating caches, so that the build output, the
+	// metafile, and package.json can be reported.
+	distDir := packageDir
+	if opts.DryRun {
+		if opts.Watch {
+			return errors.New("cannot dry run in watch mode")
+		}
+		if err := EnsureTmp(repo); err != nil {
+			return err
+		}
+		planDir, err := TempDir(repo, "plan")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(planDir)
+		packageDir = path.Join(planDir, "package")
+		metafilePath = path.Join(planDir, "meta.json")
+	}
+
+	if opts.Version == "" {
+		var err error
+		opts.Version, err = PackageVersion(repo, pkg)
+		if err != nil {
+			return err
+		}
+	}
+
+	hookContext := &buildHookContext{
+		Repository: repo,
+		Package:    pkg,
+		PackageDir: packageDir,
+		Version:    opts.Version,
+		Stderr:     stderr,
+	}
+	prebuildHooks := newCommandHooks("prebuild", pkg.Prebuild)
+	var beforeBuild func() error
+	if len(prebuildHooks) > 0 && !opts.DryRun {
+		beforeBuild = func() error {
+			return runBuildHooks(prebuildHooks, hookContext)
+		}
+	}
+
+	hooks := newCommandHooks("postbuild", pkg.Postbuild)
+	if opts.UploadSourceMaps {
+		if opts.Watch {
+			return errors.New("--upload-sourcemaps cannot be used with --watch")
+		}
+		if repo.SourceMapUpload == nil {
+			return errors.New("no sourcemaps.upload destination configured")
+		}
+		hooks = append(hooks, &sourceMapUploadHook{upload: *repo.SourceMapUpload})
+	}
+	if opts.DryRun {
+		hooks = nil
+	}
+
+	if opts.Metafile != "" && opts.metadata == nil && !opts.DryRun {
+		opts.metadata = newPackageMetadataFile(opts.Metafile)
+	}
+	// writeMetadata records the package as built, if requested.
+	writeMetadata := func() error {
+		if opts.metadata == nil {
+			return nil
+		}
+		pkgMetadata, err := ReadPackageJSON(packageDir)
+		if err != nil {
+			return err
+		}
+		meta, err := readBuildMetadata(repo, packageDir, metafilePath, pkgMetadata.Dependencies)
+		if err != nil {
+			return fmt.Errorf("writing metafile: %w", err)
+		}
+		meta.Package = pkg.Name
+		meta.Version = opts.Version
+		meta.Entrypoints = pkg.entrypointPaths()
+		return opts.metadata.Set(meta)
+	}
+
+	// Without watching, code generators and prebuild hooks run before
+	// checking the cache, since they may change inputs.
+	if !opts.Watch && !opts.DryRun {
+		if err := runCodegen(repo, stderr); err != nil {
+			return err
+		}
+	}
+	if beforeBuild != nil && !opts.Watch {
+		if err := beforeBuild(); err != nil {
+			return err
+		}
+		beforeBuild = nil
+	}
+
+	var cache *buildCache
+	var remote *remotePackageCache
+	if !opts.Watch && !opts.NoCache {
+		var err error
+		cache, err = newBuildCache(repo, pkg, distDir, buildCacheSettings{
+			Version:          opts.Version,
+			Types:            opts.Types,
+			Define:           opts.Define,
+			FailOnCycles:     opts.FailOnCycles,
+			StrictVersions:   opts.StrictVersions,
+			Minify:           opts.Minify,
+			Production:       opts.Production,
+			SourceMap:        opts.SourceMap,
+			UploadSourceMaps: opts.UploadSourceMaps,
+			Externals:        opts.Externals,
+			Target:           opts.Target,
+		})
+		if err != nil {
+			return err
+		}
+		if cache.UpToDate() {
+			if opts.DryRun {
+				logEvent(stderr, "plan", logFields{"package": pkg.Name, "upToDate": true}, "%s is up to date and would not be rebuilt", pkg.Name)
+				return nil
+			}
+			logEvent(stderr, "up-to-date", logFields{"package": pkg.Name}, "%s is up to date", pkg.Name)
+			if opts.Analyze {
+				if err := printAnalysis(stderr, metafilePath); err != nil {
+					return err
+				}
+			}
+			return writeMetadata()
+		}
+		if opts.DryRun {
+			cache = nil
+		} else {
+			// Invalidate first, in case this build fails part way through.
+			if err := cache.Invalidate(); err != nil {
+				return err
+			}
+		}
+
+		if cache != nil && repo.RemoteCache != nil && !opts.NoRemoteCache {
+			remote, err = newRemotePackageCache(repo, pkg, cache)
+			if err != nil {
+				Warnf("skipping remote cache for %s: %v", pkg.Name, err)
+			} else if restored, err := remote.Restore(cache, packageDir, metafilePath); err != nil {
+				Warnf("failed to restore %s from remote cache: %v", pkg.Name, err)
+			} else if restored {
+				logEvent(stderr, "restored", logFields{"package": pkg.Name}, "%s restored from remote cache", pkg.Name)
+				if opts.Analyze {
+					if err := printAnalysis(stderr, metafilePath); err != nil {
+						return err
+					}
+				}
+				return writeMetadata()
+			}
+		}
+	}
+
 	if err := os.RemoveAll(packageDir); err != nil {
 		return err
 	}
@@ -36,63 +279,137 @@ func Build(repo *Repository, opts BuildOptions) error {
 	var mx sync.Mutex
 	dependencies := make(map[string]string)
 
-	depPrefix := path.Join(repo.RootDir, "node_modules")
-	isFileFromDeps := func(filepath string) bool {
-		return strings.HasPrefix(filepath, depPrefix)
-	}
-
-	depsPlugin := api.Plugin{
-		Name: "unirepo:deps",
-		Setup: func(build api.PluginBuild) {
-			build.OnResolve(api.OnResolveOptions{
-				Filter: ".*",
-			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
-				if isFileFromDeps(args.Importer) {
-					return api.OnResolveResult{}, nil
-				}
-				moduleName := args.Path
-				if dependency, ok := repo.Dependencies[moduleName]; ok {
-					mx.Lock()
-					dependencies[moduleName] = dependency.Version
-					mx.Unlock()
-				}
-				return api.OnResolveResult{}, nil
-			})
-		},
-	}
+	externals := append(resolveExternals(repo, opts.Externals, pkg.Format != FormatESModule), pkg.externals()...)
+	depsPlugin := dependenciesPlugin(repo, externals, &mx, dependencies)
 
 	plugins := []api.Plugin{
 		depsPlugin,
 	}
+	if cache != nil {
+		plugins = append(plugins, cache.Plugin())
+	}
 
 	indexPath := path.Join(repo.RootDir, pkg.Index)
 
+	if err := os.MkdirAll(path.Dir(metafilePath), 0755); err != nil {
+		return err
+	}
+
+	targetSpec := pkg.Target
+	if opts.Target != "" {
+		targetSpec = opts.Target
+	}
+	target, engines, err := parseTarget(targetSpec)
+	if err != nil {
+		return err
+	}
+	if targetSpec == "" && pkg.Platform == PlatformNode {
+		engines = nodeEngines(repo)
+	}
+
+	minify := pkg.Minify || opts.Minify || opts.Production
+	sourcemap := SourceMapLinked
+	define := mergeDefines(repo, opts.Define)
+	var pure []string
+	if opts.Production {
+		sourcemap = SourceMapHidden
+		if _, ok := define[nodeEnv]; !ok {
+			define[nodeEnv] = `"production"`
+		}
+		// Unused pure calls are removed when minifying. Calls whose results are
+		// used are kept, and esbuild cannot remove debugger statements.
+		pure = append(pure, "console.debug")
+	}
+	if pkg.SourceMap != "" {
+		sourcemap = pkg.SourceMap
+	}
+	if opts.SourceMap != "" {
+		sourcemap = opts.SourceMap
+	}
+
 	buildOpts := api.BuildOptions{
 		AbsWorkingDir: repo.RootDir,
 		Outdir:        packageDir,
 		Bundle:        true,
-		Platform:      api.PlatformNode,
-		Format:        api.FormatCommonJS,
+		Platform:      pkg.Platform.esbuildPlatform(),
+		Format:        pkg.Format.esbuildFormat(),
+		OutExtensions: map[string]string{".js": pkg.Format.Extension()},
+		Target:        target,
+		Engines:       engines,
 		Write:         true,
 		LogLevel:      api.LogLevelWarning,
-		Sourcemap:     api.SourceMapLinked,
+		Sourcemap:     sourcemap.esbuildSourceMap(),
 		Plugins:       plugins,
-		External:      getExternals(repo),
-		Loader:        loaders,
-		// TODO: Splitting: true,
+		External:      externals,
+		Loader:        getLoaders(repo),
+		Define:        define,
+		Pure:          pure,
+		Metafile:      metafilePath,
+		Banner:        pkg.Banner,
+		Footer:        pkg.Footer,
+
+		MinifyWhitespace:  minify,
+		MinifyIdentifiers: minify,
+		MinifySyntax:      minify,
+		// Code splitting is only supported for ES modules.
+		Splitting: pkg.Format == FormatESModule,
+	}
+	if err := applyJSX(repo, pkg.JSX, &buildOpts); err != nil {
+		return err
 	}
 
+	// Exported entrypoints, keyed by subpath, with the index module at ".".
+	exportPaths := make(map[string]string)
+	var subpaths []string
 	if pkg.Index != "" {
-		buildOpts.EntryPoints = append(buildOpts.EntryPoints, indexPath)
+		exportPaths["."] = indexPath
+		subpaths = append(subpaths, ".")
+	}
+	for subpath, entrypoint := range pkg.Entrypoints {
+		exportPaths["./"+subpath] = path.Join(repo.RootDir, entrypoint)
+		subpaths = append(subpaths, "./"+subpath)
+	}
+	sort.Strings(subpaths)
+	for _, subpath := range subpaths {
+		buildOpts.EntryPoints = append(buildOpts.EntryPoints, exportPaths[subpath])
+	}
+	for _, executable := range pkg.Executables {
+		buildOpts.EntryPoints = append(buildOpts.EntryPoints, path.Join(repo.RootDir, executable.Entrypoint))
+	}
+
+	// Output paths mirror entrypoint paths relative to their common directory.
+	// This is esbuild's default, but is made explicit so that all builds of the
+	// package agree.
+	if len(buildOpts.EntryPoints) > 0 {
+		buildOpts.Outbase = commonDir(buildOpts.EntryPoints)
+	}
+	outputName := func(entrypoint string, ext string) string {
+		rel := strings.TrimPrefix(entrypoint, buildOpts.Outbase+"/")
+		return strings.TrimSuffix(rel, path.Ext(rel)) + ext
+	}
+
+	// Names of output files, which workers must not collide with.
+	var outputNames []string
+	for _, subpath := range subpaths {
+		outputNames = append(outputNames, outputName(exportPaths[subpath], pkg.Format.Extension()))
+		if pkg.Format == FormatDual {
+			outputNames = append(outputNames, outputName(exportPaths[subpath], FormatESModule.Extension()))
+		}
 	}
 
 	bin := make(map[string]string)
 	for executableName, executable := range pkg.Executables {
-		buildOpts.EntryPoints = append(buildOpts.EntryPoints, executable.Entrypoint)
 		bin[executableName] = executableName
 
-		entrypointExt := path.Ext(executable.Entrypoint)
-		entrypointOut := strings.TrimSuffix(path.Base(executable.Entrypoint), entrypointExt) + ".js"
+		entrypointOut := outputName(path.Join(repo.RootDir, executable.Entrypoint), pkg.Format.Extension())
+		outputNames = append(outputNames, executableName, entrypointOut)
+
+		// The shim itself is always CommonJS, so ES modules must be loaded
+		// with a dynamic import.
+		loadMain := fmt.Sprintf("require('./%s')", entrypointOut)
+		if pkg.Format == FormatESModule {
+			loadMain = fmt.Sprintf("await import('./%s')", entrypointOut)
+		}
 
 		// See also `script` in Run.
 		shim := fmt.Sprintf(`#!/usr/bin/env node
@@ -112,13 +429,17 @@ process.on('unhandledRejection', (reason, promise) => {
   );
 })
 
-const { main } = require('./%s');
 const args = process.argv.slice(2);
 void (async () => {
+	const { main } = %s;
+	if (typeof main !== 'function') {
+		// Scripts without a main function run when loaded.
+		return;
+	}
 	const exitCode = await main(...args);
 	process.exit(exitCode ?? 0);
 })();
-`, entrypointOut)
+`, loadMain)
 
 		executablePath := path.Join(packageDir, executableName)
 		if err := ioutil.WriteFile(executablePath, []byte(shim), 0755); err != nil {
@@ -126,40 +447,264 @@ void (async () => {
 		}
 	}
 
+	// Workers are bundled separately, alongside the index module. Like
+	// executables, workers of dual packages are built as CommonJS only.
+	workerFormat := pkg.Format
+	if workerFormat == FormatDual {
+		workerFormat = FormatCommonJS
+	}
+	workers := newWorkerSet(packageDir, workerFormat.Extension(), outputNames, func(worker string, output string) api.BuildOptions {
+		workerOpts := buildOpts
+		workerOpts.Format = workerFormat.esbuildFormat()
+		workerOpts.OutExtensions = map[string]string{".js": workerFormat.Extension()}
+		workerOpts.EntryPoints = []string{worker}
+		// Run adds the plugins of the main build to extra builds.
+		workerOpts.Plugins = nil
+		workerOpts.Metafile = ""
+		return workerOpts
+	})
+	for _, worker := range pkg.Workers {
+		workers.Add(path.Join(repo.RootDir, worker))
+	}
+	// Map of entrypoint paths, relative to the repository root, to the names
+	// of their outputs, which are wrapped with their banners and footers.
+	entrypointOutputs := make(map[string][]string)
+	addOutput := func(entrypoint string, name string) {
+		rel := strings.TrimPrefix(entrypoint, repo.RootDir+"/")
+		entrypointOutputs[rel] = append(entrypointOutputs[rel], name)
+	}
+	for _, subpath := range subpaths {
+		addOutput(exportPaths[subpath], outputName(exportPaths[subpath], pkg.Format.Extension()))
+		if pkg.Format == FormatDual {
+			addOutput(exportPaths[subpath], outputName(exportPaths[subpath], FormatESModule.Extension()))
+		}
+	}
+	for _, executable := range pkg.Executables {
+		entrypoint := path.Join(repo.RootDir, executable.Entrypoint)
+		addOutput(entrypoint, outputName(entrypoint, pkg.Format.Extension()))
+	}
+
+	var extraBuilds []api.BuildOptions
+	loadPlugins := []api.Plugin{workersPlugin(workers, workerFormat.esbuildFormat())}
+
+	if pkg.Format == FormatDual && len(subpaths) > 0 {
+		esmOpts := buildOpts
+		esmOpts.Format = api.FormatESModule
+		esmOpts.OutExtensions = map[string]string{".js": FormatESModule.Extension()}
+		esmOpts.Splitting = true
+		esmOpts.EntryPoints = nil
+		for _, subpath := range subpaths {
+			esmOpts.EntryPoints = append(esmOpts.EntryPoints, exportPaths[subpath])
+		}
+		// Refers to workers relative to import.meta.url instead. Files loaded
+		// by this plugin are still observed by the main build.
+		esmOpts.Plugins = []api.Plugin{workersPlugin(workers, api.FormatESModule)}
+		esmOpts.Metafile = ""
+		extraBuilds = append(extraBuilds, esmOpts)
+	}
+
 	isScoped := strings.HasPrefix(pkg.Name, "@")
 	private := !(pkg.Public || isScoped)
 
 	return buildAndWatch{
-		Repository: repo,
-		Esbuild:    buildOpts,
-		Types:      opts.Types,
-		Watch:      opts.Watch,
-		Package:    pkg,
+		Repository:   repo,
+		Esbuild:      buildOpts,
+		ExtraEsbuild: extraBuilds,
+		Workers:      workers,
+		LoadPlugins:  loadPlugins,
+		BeforeBuild:  beforeBuild,
+		Types:        opts.Types,
+		Watch:        opts.Watch,
+		TypeCheck:    opts.TypeCheck && opts.Watch,
+		Package:      pkg,
+		Stderr:       opts.stderr,
+		WatchIgnore:  opts.WatchIgnore,
 		CreateProcess: func() process {
 			return &funcProcess{
 				start: func() error {
+					outputs := make(map[string][]string, len(entrypointOutputs))
+					for entrypoint, names := range entrypointOutputs {
+						outputs[entrypoint] = append([]string{}, names...)
+					}
+					for worker, name := range workers.Outputs() {
+						rel := strings.TrimPrefix(worker, repo.RootDir+"/")
+						outputs[rel] = append(outputs[rel], name)
+					}
+					for entrypoint, names := range outputs {
+						banner, footer := pkg.Banners[entrypoint], pkg.Footers[entrypoint]
+						for _, name := range names {
+							if err := wrapOutput(path.Join(packageDir, name), banner, footer); err != nil {
+								return fmt.Errorf("wrapping %s: %w", name, err)
+							}
+						}
+					}
+					if err := checkImportCycles(stderr, metafilePath, opts.FailOnCycles); err != nil {
+						return err
+					}
+					mx.Lock()
+					var imported []string
+					for name := range dependencies {
+						imported = append(imported, name)
+					}
+					mx.Unlock()
+					for name := range pkg.PeerDependencies {
+						imported = append(imported, name)
+					}
+					if err := checkDependencyVersions(stderr, repo, pkg, metafilePath, imported, opts.StrictVersions); err != nil {
+						return err
+					}
+					if pkg.SideEffects.none() {
+						var exported []string
+						for _, subpath := range subpaths {
+							exported = append(exported, exportPaths[subpath])
+						}
+						if err := checkSideEffects(stderr, repo, pkg, buildOpts, exported); err != nil {
+							return err
+						}
+					}
+					if pkg.Budget != nil {
+						if err := checkSizeBudget(stderr, repo, pkg.Budget, metafilePath); err != nil {
+							return err
+						}
+					}
+					if err := writeBundledLicenses(repo, packageDir, metafilePath); err != nil {
+						return err
+					}
+					if opts.Analyze {
+						if err := printAnalysis(stderr, metafilePath); err != nil {
+							return err
+						}
+					}
+
+					// Peer and optional dependencies are declared whether or not
+					// they are imported, and are not also regular dependencies.
+					mx.Lock()
+					regularDependencies := make(map[string]string)
+					for name, version := range dependencies {
+						regularDependencies[name] = version
+					}
+					mx.Unlock()
+					var peerDependencies, optionalDependencies map[string]string
+					var peerDependenciesMeta map[string]PeerDependencyMeta
+					for name, peer := range pkg.PeerDependencies {
+						if peerDependencies == nil {
+							peerDependencies = make(map[string]string)
+						}
+						peerDependencies[name] = peer.Version
+						if peer.Optional {
+							if peerDependenciesMeta == nil {
+								peerDependenciesMeta = make(map[string]PeerDependencyMeta)
+							}
+							peerDependenciesMeta[name] = PeerDependencyMeta{Optional: true}
+						}
+						delete(regularDependencies, name)
+					}
+					for _, name := range pkg.OptionalDependencies {
+						if optionalDependencies == nil {
+							optionalDependencies = make(map[string]string)
+						}
+						optionalDependencies[name] = repo.Dependencies[name].Version
+						delete(regularDependencies, name)
+					}
+
 					pkgMetadata := PackageMetadata{
-						Name:         pkg.Name,
-						Private:      private,
-						Description:  pkg.Description,
-						Version:      opts.Version,
-						Dependencies: dependencies,
-						Bin:          bin,
-						Repository:   repo.Url,
+						Name:                 pkg.Name,
+						Private:              private,
+						Description:          pkg.Description,
+						Version:              opts.Version,
+						Dependencies:         regularDependencies,
+						PeerDependencies:     peerDependencies,
+						PeerDependenciesMeta: peerDependenciesMeta,
+						OptionalDependencies: optionalDependencies,
+						Bin:                  bin,
+						Repository:           repo.Url,
 						PublishConfig: &PublishConfig{
 							Registry: repo.Registry,
 						},
+						Extra: make(map[string]interface{}),
+					}
+					for _, fields := range []map[string]interface{}{repo.PackageJSON, pkg.PackageJSON} {
+						for name, value := range fields {
+							pkgMetadata.Extra[name] = value
+						}
+					}
+					if pkg.SideEffects != nil {
+						pkgMetadata.SideEffects = pkg.SideEffects.packageField()
+						delete(pkgMetadata.Extra, "sideEffects")
+					}
+
+					// Conditions for each exported entrypoint, and the style
+					// subpath, in order.
+					exports := newOrderedMap()
+					for _, subpath := range subpaths {
+						entrypoint := exportPaths[subpath]
+						out := "./" + outputName(entrypoint, pkg.Format.Extension())
+						var conditions interface{} = out
+						if pkg.Format == FormatDual {
+							dual := newOrderedMap()
+							if opts.Types && subpath == "." {
+								// Must come first to take precedence.
+								dual.Set("types", "./"+typesFileName)
+							}
+							dual.Set("import", "./"+outputName(entrypoint, FormatESModule.Extension()))
+							dual.Set("require", out)
+							conditions = dual
+						}
+						exports.Set(subpath, conditions)
 					}
 
 					if pkg.Index != "" {
-						base := path.Base(pkg.Index)
-						pkgMetadata.Main = strings.TrimSuffix(base, path.Ext(pkg.Index)) + ".js"
+						pkgMetadata.Main = outputName(indexPath, pkg.Format.Extension())
+						if opts.Types {
+							pkgMetadata.Types = typesFileName
+						}
+						if pkg.Platform == PlatformBrowser {
+							pkgMetadata.Browser = pkgMetadata.Main
+						}
+						// Stylesheets imported by the index module are bundled
+						// into a sibling CSS file.
+						styleName := outputName(indexPath, ".css")
+						if _, err := os.Stat(path.Join(packageDir, styleName)); err == nil {
+							pkgMetadata.Style = styleName
+							exports.Set("./"+styleName, "./"+styleName)
+						}
+					}
+
+					switch {
+					case pkg.Format == FormatCommonJS && len(pkg.Entrypoints) == 0:
+						// Without subpaths, exports are omitted so that any file
+						// may be required.
+					case pkg.Format == FormatESModule && len(subpaths) == 1 && subpaths[0] == "." && pkgMetadata.Style == "":
+						pkgMetadata.Exports = "./" + pkgMetadata.Main
+					case len(subpaths) > 0:
+						pkgMetadata.Exports = exports
 					}
 
 					if err := WritePackageJSON(pkgMetadata, packageDir); err != nil {
 						return err
 					}
+					if opts.DryRun {
+						return printBuildPlan(stderr, repo, pkgMetadata, packageDir, distDir)
+					}
+
+					if err := runBuildHooks(hooks, hookContext); err != nil {
+						return err
+					}
 
+					if err := writeMetadata(); err != nil {
+						return err
+					}
+
+					if cache != nil {
+						if err := cache.Save(); err != nil {
+							return err
+						}
+					}
+					if remote != nil {
+						if err := remote.Save(packageDir, metafilePath); err != nil {
+							Warnf("failed to upload %s to remote cache: %v", pkg.Name, err)
+						}
+					}
 					return nil
 				},
 			}
@@ -167,6 +712,45 @@ void (async () => {
 	}.Run()
 }
 
+// checkImportCycles reports import cycles found in a build's metafile as
+// warnings, or as an error if fail is set.
+func checkImportCycles(w io.Writer, metafilePath string, fail bool) error {
+	metafile, err := readMetafile(metafilePath)
+	if err != nil {
+		return fmt.Errorf("reading metafile: %w", err)
+	}
+	cycles := metafile.ImportCycles()
+	if len(cycles) == 0 {
+		return nil
+	}
+	kind := "warning"
+	if fail {
+		kind = "error"
+	}
+	messages := make([]api.Message, len(cycles))
+	for i, cycle := range cycles {
+		messages[i].Text = "import cycle: " + strings.Join(cycle, " -> ")
+	}
+	fprintMessages(w, messages, kind)
+	if fail {
+		return errors.New("import cycles found")
+	}
+	return nil
+}
+
+// Number of largest contributors listed per output by --analyze.
+const analysisTop = 10
+
+func printAnalysis(w io.Writer, metafilePath string) error {
+	metafile, err := readMetafile(metafilePath)
+	if err != nil {
+		return fmt.Errorf("reading metafile: %w", err)
+	}
+	metafile.WriteAnalysis(w, analysisTop)
+	fmt.Fprintf(w, "metafile  %s\n", metafilePath)
+	return nil
+}
+
 type funcProcess struct {
 	start func() error
 }
@@ -182,3 +766,156 @@ func (proc *funcProcess) Kill() error {
 func (proc *funcProcess) Wait() error {
 	return nil
 }
+
+// BuildPackages builds the given packages in dependency order, stopping at the
+// first failure. Up to opts.Jobs independent packages are built concurrently,
+// with output prefixed by package name. A summary of results is printed to
+// stderr.
+func BuildPackages(repo *Repository, packages map[string]*Package, opts BuildOptions) error {
+	if opts.UseDaemon && !repo.extended() && !opts.Watch && !opts.DryRun {
+		if conn, err := dialDaemon(repo); err == nil {
+			err := buildWithDaemon(conn, packages, opts)
+			if !errors.Is(err, errDaemonVersion) {
+				return err
+			}
+			Warnf("%v", err)
+		}
+	}
+
+	stderr := opts.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	if opts.Metafile != "" && !opts.DryRun {
+		opts.metadata = newPackageMetadataFile(opts.Metafile)
+	}
+
+	if len(packages) == 1 {
+		for _, pkg := range packages {
+			opts.Package = pkg
+			return Build(repo, opts)
+		}
+	}
+
+	graph, err := LoadPackageGraph(repo)
+	if err != nil {
+		return err
+	}
+	order, err := graph.Order(packages)
+	if err != nil {
+		return err
+	}
+
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if opts.Watch {
+		// Watching builds never complete, so all must run at once and
+		// dependency order cannot be enforced.
+		jobs = len(order)
+	}
+
+	var mx sync.Mutex
+	var outputMx sync.Mutex
+	var built, failed, skipped []string
+	var firstErr error
+	done := make(map[string]chan struct{}, len(order))
+	for _, pkg := range order {
+		done[pkg.Name] = make(chan struct{})
+	}
+	sem := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	for _, pkg := range order {
+		pkg := pkg
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[pkg.Name])
+
+			if !opts.Watch {
+				for _, dependency := range graph.Dependencies[pkg.Name] {
+					if ch, ok := done[dependency]; ok {
+						<-ch
+					}
+				}
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			mx.Lock()
+			abort := firstErr != nil
+			if abort {
+				skipped = append(skipped, pkg.Name)
+			}
+			mx.Unlock()
+			if abort {
+				return
+			}
+
+			pkgOpts := opts
+			pkgOpts.Package = pkg
+			if jobs > 1 {
+				output := newPrefixWriter(&outputMx, stderr, "["+pkg.Name+"]")
+				defer output.Flush()
+				pkgOpts.stderr = output
+			}
+			err := Build(repo, pkgOpts)
+
+			mx.Lock()
+			defer mx.Unlock()
+			if err != nil {
+				failed = append(failed, pkg.Name)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("building %s: %w", pkg.Name, err)
+				}
+			} else {
+				built = append(built, pkg.Name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		printBuildSummary(stderr, built, failed, skipped)
+	}
+	return firstErr
+}
+
+func printBuildSummary(w io.Writer, built, failed, skipped []string) {
+	if logSettingsOf(w).Format == LogFormatJSON {
+		logEvent(w, "summary", logFields{
+			"built":   built,
+			"failed":  failed,
+			"skipped": skipped,
+		}, "")
+		return
+	}
+	fmt.Fprintln(w, "build summary:")
+	for _, group := range []struct {
+		label string
+		names []string
+	}{
+		{"built", built},
+		{"failed", failed},
+		{"skipped", skipped},
+	} {
+		sort.Strings(group.names)
+		for _, name := range group.names {
+			fmt.Fprintf(w, "  %-8s %s\n", group.label, name)
+		}
+	}
+}
+
+// commonDir returns the deepest directory containing all of the given paths.
+func commonDir(paths []string) string {
+	dir := path.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != "/" && dir != "." && !strings.HasPrefix(p, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// BuildMetadata describes the result of a build for downstream tools, such as
+// bundle size budgets and provenance tracking. It is written by the
+// --metafile flag of uni build, keyed by package name, and of uni run.
+type BuildMetadata struct {
+	Package string `json:"package,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Paths of bundled entrypoints, relative to the repository root.
+	Entrypoints []string `json:"entrypoints,omitempty"`
+	// Directory of the output files, relative to the repository root.
+	OutDir string `json:"outDir"`
+	// Paths of all files loaded by the build, relative to the repository root.
+	Inputs []string `json:"inputs"`
+	// Map of configured dependencies imported by the build, which are not
+	// bundled, to their versions.
+	Externals map[string]string `json:"externals"`
+	// Map of output file paths, relative to OutDir, to their sizes and hashes.
+	Outputs map[string]OutputMetadata `json:"outputs"`
+	// The metafile written by esbuild for the main build.
+	Esbuild json.RawMessage `json:"esbuild"`
+}
+
+type OutputMetadata struct {
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// readBuildMetadata describes the files of outDir, except for those excluded,
+// as built with the given esbuild metafile.
+func readBuildMetadata(repo *Repository, outDir string, metafilePath string, externals map[string]string, exclude ...string) (*BuildMetadata, error) {
+	raw, err := ioutil.ReadFile(metafilePath)
+	if err != nil {
+		return nil, err
+	}
+	var metafile Metafile
+	if err := json.Unmarshal(raw, &metafile); err != nil {
+		return nil, err
+	}
+	if externals == nil {
+		externals = make(map[string]string)
+	}
+	meta := &BuildMetadata{
+		OutDir:    relativeToRoot(repo, outDir),
+		Inputs:    []string{},
+		Externals: externals,
+		Outputs:   make(map[string]OutputMetadata),
+		Esbuild:   raw,
+	}
+	for _, input := range metafile.InputPaths(repo) {
+		meta.Inputs = append(meta.Inputs, relativeToRoot(repo, input))
+	}
+	sort.Strings(meta.Inputs)
+
+	excluded := make(map[string]bool)
+	for _, filename := range append(exclude, metafilePath) {
+		excluded[filepath.Clean(filename)] = true
+	}
+	err = filepath.Walk(outDir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if excluded[filepath.Clean(filename)] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		hash, err := hashFile(filename)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(outDir, filename)
+		if err != nil {
+			return err
+		}
+		meta.Outputs[filepath.ToSlash(rel)] = OutputMetadata{
+			Bytes:  fi.Size(),
+			SHA256: hash,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func relativeToRoot(repo *Repository, filename string) string {
+	rel, err := filepath.Rel(repo.RootDir, filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// packageMetadataFile collects the metadata of packages built by one
+// invocation of uni build, rewriting the file as each package is built.
+type packageMetadataFile struct {
+	path     string
+	mx       sync.Mutex
+	packages map[string]*BuildMetadata
+}
+
+func newPackageMetadataFile(filename string) *packageMetadataFile {
+	return &packageMetadataFile{
+		path:     filename,
+		packages: make(map[string]*BuildMetadata),
+	}
+}
+
+func (file *packageMetadataFile) Set(meta *BuildMetadata) error {
+	file.mx.Lock()
+	defer file.mx.Unlock()
+	file.packages[meta.Package] = meta
+	return WriteJSON(file.path, map[string]interface{}{
+		"packages": file.packages,
+	})
+}
+
+// dependenciesPlugin records configured dependencies imported by a build,
+// and their versions, in deps. Dependencies that are bundled, rather than
+// among the given externals, are not recorded. External packages with native
+// addons are recorded with their installed versions, even if they are not
+// configured dependencies, including when imported by bundled dependencies.
+func dependenciesPlugin(repo *Repository, externals []string, mx *sync.Mutex, deps map[string]string) api.Plugin {
+	isExternal := make(map[string]bool, len(externals))
+	for _, external := range externals {
+		isExternal[external] = true
+	}
+	natives := repo.nativePackages()
+	return api.Plugin{
+		Name: "unirepo:deps",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: ".*",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				moduleName := packageNameOf(args.Path)
+				if !isExternal[moduleName] {
+					return api.OnResolveResult{}, nil
+				}
+				version := ""
+				if dependency, ok := repo.Dependencies[moduleName]; ok {
+					if !isNodeModulesPath(args.Importer) {
+						version = dependency.Version
+					}
+				} else {
+					version = natives[moduleName]
+				}
+				if version != "" {
+					mx.Lock()
+					deps[moduleName] = version
+					mx.Unlock()
+				}
+				return api.OnResolveResult{}, nil
+			})
+		},
+	}
+}
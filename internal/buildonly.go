@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// BuildOnlyOutput describes a program built by `uni run --build-only`, as
+// printed for other tools to consume.
+type BuildOnlyOutput struct {
+	// Name of the run target, if the program is one.
+	Target     string `json:"target,omitempty"`
+	Entrypoint string `json:"entrypoint"`
+	// Content hash of the build output, which names its directory.
+	Hash string `json:"hash"`
+	Dir  string `json:"dir"`
+	// Script that runs the program with node.
+	Script    string `json:"script"`
+	Bundle    string `json:"bundle"`
+	SourceMap string `json:"sourcemap,omitempty"`
+}
+
+// BuildOnlyDir returns the directory that `uni run --build-only` moves build
+// output with the given hash to.
+func BuildOnlyDir(repo *Repository, hash string) string {
+	return path.Join(repo.OutDir, "run", hash)
+}
+
+// publishBuildOnly moves the output of a run build from its temporary
+// directory to a path named by the hash of its contents, and writes a line of
+// JSON describing each program to w. Identical builds share a directory, which
+// is left as is if it already exists, so that its files are never replaced
+// while in use.
+func publishBuildOnly(repo *Repository, dir string, programs []*runProgram, rb *runBuild, w io.Writer) error {
+	// Stub entrypoints, metafiles, and the owner of the temporary directory
+	// are not needed to run the build.
+	for _, name := range []string{"entrypoints", "meta.json", tempDirOwnerFile} {
+		if err := os.RemoveAll(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	hash, err := hashBuildOutput(dir)
+	if err != nil {
+		return err
+	}
+	outDir := BuildOnlyDir(repo, hash)
+	if _, err := os.Stat(outDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(path.Dir(outDir), 0755); err != nil {
+			return err
+		}
+		// Another process may publish the same build at the same time, in
+		// which case its directory is kept.
+		if err := os.Rename(dir, outDir); err != nil {
+			if _, statErr := os.Stat(outDir); statErr != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, prog := range programs {
+		output := BuildOnlyOutput{
+			Target:     prog.Label,
+			Entrypoint: relativeToRoot(repo, prog.Entrypoint),
+			Hash:       hash,
+			Dir:        outDir,
+			Script:     path.Join(outDir, path.Base(rb.ScriptPaths[prog])),
+			Bundle:     path.Join(outDir, path.Base(rb.BundlePaths[prog])),
+		}
+		if _, err := os.Stat(output.Bundle + ".map"); err == nil {
+			output.SourceMap = output.Bundle + ".map"
+		}
+		bs, err := json.Marshal(output)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(bs, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashBuildOutput returns a hash of the names and contents of the files in a
+// directory.
+func hashBuildOutput(dir string) (string, error) {
+	hashes, err := hashDir(dir)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(hashes))
+	for filename := range hashes {
+		names = append(names, filename)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, filename := range names {
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", filepath.ToSlash(rel), hashes[filename])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// buildCache records the inputs of a package build so that the build can be
+// skipped when none of those inputs have changed.
+type buildCache struct {
+	manifestPath string
+	key          string
+	outputDir    string
+
+	mx     sync.Mutex
+	inputs map[string]struct{}
+}
+
+type cacheManifest struct {
+	Key string `json:"key"`
+	// Maps of absolute file paths to content hashes.
+	Inputs  map[string]string `json:"inputs"`
+	Outputs map[string]string `json:"outputs"`
+}
+
+// buildCacheSettings are the options of a build that affect its outputs, but
+// which are given on the command line rather than configured.
+type buildCacheSettings struct {
+	Version          string            `json:"version"`
+	Types            bool              `json:"types"`
+	Define           map[string]string `json:"define"`
+	FailOnCycles     bool              `json:"failOnCycles"`
+	StrictVersions   bool              `json:"strictVersions"`
+	Minify           bool              `json:"minify"`
+	Production       bool              `json:"production"`
+	SourceMap        SourceMap         `json:"sourceMap"`
+	UploadSourceMaps bool              `json:"uploadSourceMaps"`
+	Externals        Externals         `json:"externals"`
+	Target           string            `json:"target"`
+}
+
+// newBuildCache returns a cache for a package whose key covers the config,
+// plugins, tsconfig.json files, and version of uni, as well as the given
+// settings, none of which esbuild reports as inputs.
+func newBuildCache(repo *Repository, pkg *Package, outputDir string, settings buildCacheSettings) (*buildCache, error) {
+	// Plugins are loaded to hash their code, which is not part of the config.
+	_, pluginsHash, err := jsPlugins(repo)
+	if err != nil {
+		return nil, err
+	}
+	encodedSettings, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s\n%s\n", uniVersion(), repo.configHash, pluginsHash, hashFiles(tsconfigPaths(repo, pkg)), pkg.Name, encodedSettings)
+	return &buildCache{
+		manifestPath: path.Join(repo.TmpDir, "cache", stripName(pkg.Name)+".json"),
+		key:          hex.EncodeToString(h.Sum(nil)),
+		outputDir:    outputDir,
+		inputs:       make(map[string]struct{}),
+	}, nil
+}
+
+// tsconfigPaths returns the paths of the tsconfig.json files that esbuild may
+// read when building a package: those in the directory of its index module
+// and each parent directory up to the repository root, whether or not they
+// exist, so that creating one also changes the key.
+func tsconfigPaths(repo *Repository, pkg *Package) []string {
+	var paths []string
+	dir := path.Dir(path.Join(repo.RootDir, pkg.Index))
+	for {
+		paths = append(paths, path.Join(dir, "tsconfig.json"))
+		if dir == repo.RootDir || !strings.HasPrefix(dir, repo.RootDir+"/") {
+			return paths
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// UpToDate reports whether a previous build with the same key has inputs and
+// outputs identical to those currently on disk.
+func (cache *buildCache) UpToDate() bool {
+	var manifest cacheManifest
+	if err := ReadJSON(cache.manifestPath, &manifest); err != nil {
+		if !os.IsNotExist(err) {
+			Warnf("failed to read build cache: %v", err)
+		}
+		return false
+	}
+	if manifest.Key != cache.key || len(manifest.Inputs) == 0 {
+		return false
+	}
+	outputs, err := hashDir(cache.outputDir)
+	if err != nil || !sameHashes(outputs, manifest.Outputs) {
+		return false
+	}
+	for filename, expected := range manifest.Inputs {
+		actual, err := hashFile(filename)
+		if err != nil || actual != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func sameHashes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Plugin records every file loaded by esbuild as an input.
+func (cache *buildCache) Plugin() api.Plugin {
+	return api.Plugin{
+		Name: "unirepo:cache",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter: ".*",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				if args.Namespace == "file" {
+					cache.mx.Lock()
+					cache.inputs[args.Path] = struct{}{}
+					cache.mx.Unlock()
+				}
+				return api.OnLoadResult{}, nil
+			})
+		},
+	}
+}
+
+// AddInputs records files as inputs, as if they had been loaded by esbuild.
+func (cache *buildCache) AddInputs(filenames []string) {
+	cache.mx.Lock()
+	defer cache.mx.Unlock()
+	for _, filename := range filenames {
+		cache.inputs[filename] = struct{}{}
+	}
+}
+
+// Save records the hashes of all inputs observed since the last save.
+func (cache *buildCache) Save() error {
+	cache.mx.Lock()
+	filenames := make([]string, 0, len(cache.inputs))
+	for filename := range cache.inputs {
+		filenames = append(filenames, filename)
+	}
+	cache.mx.Unlock()
+	sort.Strings(filenames)
+
+	manifest := cacheManifest{
+		Key:    cache.key,
+		Inputs: make(map[string]string, len(filenames)),
+	}
+	for _, filename := range filenames {
+		hash, err := hashFile(filename)
+		if err != nil {
+			return err
+		}
+		manifest.Inputs[filename] = hash
+	}
+	var err error
+	manifest.Outputs, err = hashDir(cache.outputDir)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(cache.manifestPath, manifest)
+}
+
+// Invalidate removes the saved manifest, forcing the next build.
+func (cache *buildCache) Invalidate() error {
+	err := os.Remove(cache.manifestPath)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashDir returns content hashes of all regular files within a directory.
+func hashDir(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.Walk(dir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		hash, err := hashFile(filename)
+		if err != nil {
+			return err
+		}
+		hashes[filename] = hash
+		return nil
+	})
+	return hashes, err
+}
+
+// commandCache records the inputs and outputs of a command, such as a task,
+// so that the command can be skipped when none of them have changed. Inputs
+// and outputs are glob patterns relative to the repository root.
+type commandCache struct {
+	repo         *Repository
+	manifestPath string
+	command      string
+	inputs       []string
+	outputs      []string
+
+	// Computed by UpToDate.
+	key string
+}
+
+type commandManifest struct {
+	Key string `json:"key"`
+	// Map of absolute file paths to content hashes.
+	Outputs map[string]string `json:"outputs"`
+}
+
+func newCommandCache(repo *Repository, manifestPath string, command string, inputs, outputs []string) *commandCache {
+	return &commandCache{
+		repo:         repo,
+		manifestPath: manifestPath,
+		command:      command,
+		inputs:       inputs,
+		outputs:      outputs,
+	}
+}
+
+// UpToDate reports whether the command last succeeded with the same inputs,
+// and its outputs are unchanged since.
+func (cache *commandCache) UpToDate() (bool, error) {
+	files, err := globFiles(cache.repo, cache.inputs)
+	if err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", cache.command)
+	for _, file := range files {
+		hash, err := hashFile(file)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(h, "%s %s\n", file, hash)
+	}
+	cache.key = hex.EncodeToString(h.Sum(nil))
+
+	var manifest commandManifest
+	if err := ReadJSON(cache.manifestPath, &manifest); err != nil || manifest.Key != cache.key {
+		return false, nil
+	}
+	outputs, err := cache.outputHashes()
+	return err == nil && sameHashes(outputs, manifest.Outputs), nil
+}
+
+// Save records the outputs of a successful run. UpToDate must be called first.
+func (cache *commandCache) Save() error {
+	outputs, err := cache.outputHashes()
+	if err != nil {
+		return err
+	}
+	return WriteJSON(cache.manifestPath, commandManifest{
+		Key:     cache.key,
+		Outputs: outputs,
+	})
+}
+
+// Invalidate removes the saved manifest, forcing the next run.
+func (cache *commandCache) Invalidate() error {
+	err := os.Remove(cache.manifestPath)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+func (cache *commandCache) outputHashes() (map[string]string, error) {
+	files, err := globFiles(cache.repo, cache.outputs)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		hashes[file], err = hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+type CheckOptions struct {
+	// Extra arguments to pass through to tsc.
+	Args []string
+}
+
+// Check type-checks the repository with tsc. Diagnostics are printed to
+// stderr in the same style as esbuild's build errors.
+func Check(repo *Repository, opts CheckOptions) error {
+	messages, err := typeCheck(repo, opts.Args)
+	if err != nil {
+		return err
+	}
+	printMessages(messages, "error")
+	if len(messages) > 0 {
+		return fmt.Errorf("found %d type errors", len(messages))
+	}
+	return nil
+}
+
+// typeCheck runs tsc without emitting any files. If the repository has a
+// tsconfig.json, it is used to determine the set of checked files and compiler
+// options, such as path aliases. Otherwise, all package entrypoints are
+// checked.
+func typeCheck(repo *Repository, extraArgs []string) ([]api.Message, error) {
+	args := []string{"--noEmit", "--pretty", "false"}
+	tsconfigPath := path.Join(repo.RootDir, "tsconfig.json")
+	if _, err := os.Stat(tsconfigPath); err == nil {
+		args = append(args, "--project", tsconfigPath)
+	} else if os.IsNotExist(err) {
+		args = append(args, getEntrypoints(repo)...)
+	} else {
+		return nil, err
+	}
+	args = append(args, extraArgs...)
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(path.Join(repo.RootDir, "node_modules", ".bin", "tsc"), args...)
+	cmd.Dir = repo.RootDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	// tsc exits non-zero when there are diagnostics, so only treat failure to
+	// start or an absence of parsable diagnostics as an error.
+	if _, ok := err.(*exec.ExitError); !ok && err != nil {
+		return nil, fmt.Errorf("running tsc: %w", err)
+	}
+	messages := parseTscOutput(repo, stdout.Bytes())
+	if err != nil && len(messages) == 0 {
+		_, _ = os.Stderr.Write(stdout.Bytes())
+		return nil, fmt.Errorf("running tsc: %w", err)
+	}
+	return messages, nil
+}
+
+// getEntrypoints returns the sorted entrypoint paths of all packages.
+func getEntrypoints(repo *Repository) []string {
+	var entrypoints []string
+	for _, pkg := range repo.Packages {
+		entrypoints = append(entrypoints, pkg.entrypointPaths()...)
+	}
+	sort.Strings(entrypoints)
+	return entrypoints
+}
+
+var tscDiagnosticPattern = regexp.MustCompile(`^(.+)\((\d+),(\d+)\): error (TS\d+): (.*)$`)
+
+func parseTscOutput(repo *Repository, output []byte) []api.Message {
+	var messages []api.Message
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := tscDiagnosticPattern.FindStringSubmatch(line)
+		if match == nil {
+			// Continuation of a multi-line diagnostic.
+			if len(messages) > 0 {
+				messages[len(messages)-1].Text += "\n" + line
+			}
+			continue
+		}
+		file := match[1]
+		if filepath.IsAbs(file) {
+			if rel, err := filepath.Rel(repo.RootDir, file); err == nil {
+				file = rel
+			}
+		}
+		lineNumber, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		messages = append(messages, api.Message{
+			Text: fmt.Sprintf("%s [%s]", match[5], match[4]),
+			Location: &api.Location{
+				File:     file,
+				Line:     lineNumber,
+				Column:   column - 1, // tsc is 1-based, esbuild is 0-based.
+				LineText: readLine(path.Join(repo.RootDir, file), lineNumber),
+			},
+		})
+	}
+	return messages
+}
+
+func readLine(filename string, lineNumber int) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		if i == lineNumber {
+			return scanner.Text()
+		}
+	}
+	return ""
+}
+
+// printMessages prints diagnostics to stderr, mimicking esbuild's format.
+func printMessages(messages []api.Message, kind string) {
+	fprintMessages(os.Stderr, messages, kind)
+}
+
+func fprintMessages(w io.Writer, messages []api.Message, kind string) {
+	for _, message := range messages {
+		loc := message.Location
+		if logSettingsOf(w).Format == LogFormatJSON {
+			fields := logFields{"kind": kind}
+			if loc != nil {
+				fields["file"] = loc.File
+				fields["line"] = loc.Line
+				fields["column"] = loc.Column
+			}
+			logEvent(w, "diagnostic", fields, "%s", message.Text)
+			continue
+		}
+		if loc == nil {
+			fmt.Fprintf(w, " > %s: %s\n", kind, message.Text)
+			continue
+		}
+		fmt.Fprintf(w, " > %s:%d:%d: %s: %s\n", loc.File, loc.Line, loc.Column, kind, message.Text)
+		if loc.LineText != "" {
+			margin := strconv.Itoa(loc.Line)
+			fmt.Fprintf(w, "    %s │ %s\n", margin, loc.LineText)
+			fmt.Fprintf(w, "    %*s ╵ %*s^\n", len(margin), "", loc.Column, "")
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// backgroundChecker runs type checks concurrently with watch mode. Requests
+// made while a check is in progress are coalesced into a single follow-up
+// check.
+type backgroundChecker struct {
+	repo    *Repository
+	mx      sync.Mutex
+	running bool
+	pending bool
+}
+
+func (c *backgroundChecker) Request() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.running {
+		c.pending = true
+		return
+	}
+	c.running = true
+	go c.loop()
+}
+
+func (c *backgroundChecker) loop() {
+	for {
+		c.check()
+		c.mx.Lock()
+		if !c.pending {
+			c.running = false
+			c.mx.Unlock()
+			return
+		}
+		c.pending = false
+		c.mx.Unlock()
+	}
+}
+
+func (c *backgroundChecker) check() {
+	buildInfoPath := path.Join(c.repo.TmpDir, "tsbuildinfo")
+	messages, err := typeCheck(c.repo, []string{"--incremental", "--tsBuildInfoFile", buildInfoPath})
+	if err != nil {
+		logEvent(os.Stderr, "error", nil, "type check failed: %v", err)
+		return
+	}
+	printMessages(messages, "error")
+	fields := logFields{"errors": len(messages)}
+	if len(messages) == 0 {
+		logEvent(os.Stderr, "checked", fields, "type check ok")
+	} else {
+		logEvent(os.Stderr, "checked", fields, "found %d type errors", len(messages))
+	}
+}
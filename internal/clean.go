@@ -1,7 +1,95 @@
 package internal
 
-import "os"
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
 
-func Clean(repo *Repository) error {
-	return os.RemoveAll(repo.OutDir)
+// CleanOptions select what Clean removes. If none of Tmp, Cache, Dist, or
+// Artifacts are set, the entire output directory is removed.
+type CleanOptions struct {
+	// Temporary directories of runs, tests, and builds, including those left
+	// behind by uni processes that were killed.
+	Tmp bool
+	// Caches of build results, code generators, tasks, type checking, and
+	// engine versions.
+	Cache bool
+	// Built packages.
+	Dist bool
+	// Packed packages and deployment artifacts, such as lambda zip files,
+	// Dockerfiles, executables, and the output of `uni run --build-only`.
+	Artifacts bool
+	// Report what would be removed without removing anything.
+	DryRun bool
+	Stderr io.Writer
+}
+
+// Entries of the tmp directory that are caches rather than temporary files.
+var tmpCacheNames = []string{"cache", "codegen", "meta", "tasks", "tsbuildinfo"}
+
+// Entries of the output directory that are deployment artifacts.
+var artifactNames = []string{"docker", "executables", "lambda", "packed", "run"}
+
+func Clean(repo *Repository, opts CleanOptions) error {
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	var paths []string
+	if !opts.Tmp && !opts.Cache && !opts.Dist && !opts.Artifacts {
+		paths = append(paths, repo.OutDir)
+	}
+	if opts.Tmp {
+		entries, err := ioutil.ReadDir(repo.TmpDir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		isCache := make(map[string]bool, len(tmpCacheNames))
+		for _, name := range tmpCacheNames {
+			isCache[name] = true
+		}
+		for _, entry := range entries {
+			if !isCache[entry.Name()] {
+				paths = append(paths, path.Join(repo.TmpDir, entry.Name()))
+			}
+		}
+	}
+	if opts.Cache {
+		for _, name := range tmpCacheNames {
+			paths = append(paths, path.Join(repo.TmpDir, name))
+		}
+		paths = append(paths, path.Join(repo.OutDir, "engines.json"))
+	}
+	if opts.Dist {
+		paths = append(paths, repo.DistDir)
+	}
+	if opts.Artifacts {
+		for _, name := range artifactNames {
+			paths = append(paths, path.Join(repo.OutDir, name))
+		}
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		if _, err := os.Lstat(p); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		rel := relativeToRoot(repo, p)
+		fields := logFields{"path": rel, "dryRun": opts.DryRun}
+		if opts.DryRun {
+			logEvent(stderr, "clean", fields, "would remove %s", rel)
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		logEvent(stderr, "clean", fields, "removed %s", rel)
+	}
+	return nil
 }
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"path"
+)
+
+// Codegen is a command that generates source files from other files, such as
+// GraphQL schemas or protobuf definitions. Code generators run before building
+// whenever their inputs or outputs have changed, including in watch mode.
+type Codegen struct {
+	Name string
+	Run  string
+	// Glob patterns of files relative to the repository root.
+	Inputs  []string
+	Outputs []string
+}
+
+func newCodegen(name string, cfg CodegenConfig) (*Codegen, error) {
+	if cfg.Run == "" {
+		return nil, fmt.Errorf("codegen %q has no run command", name)
+	}
+	if len(cfg.Inputs) == 0 {
+		return nil, fmt.Errorf("codegen %q has no inputs", name)
+	}
+	for _, pattern := range append(append([]string{}, cfg.Inputs...), cfg.Outputs...) {
+		if _, err := compileGlob(pattern); err != nil {
+			return nil, fmt.Errorf("codegen %q has invalid pattern %q: %w", name, pattern, err)
+		}
+	}
+	return &Codegen{
+		Name:    name,
+		Run:     cfg.Run,
+		Inputs:  cfg.Inputs,
+		Outputs: cfg.Outputs,
+	}, nil
+}
+
+// codegenPatterns returns the glob patterns of the inputs and outputs of all
+// code generators.
+func codegenPatterns(repo *Repository) []string {
+	var patterns []string
+	for _, codegen := range repo.Codegen {
+		patterns = append(patterns, codegen.Inputs...)
+		patterns = append(patterns, codegen.Outputs...)
+	}
+	return patterns
+}
+
+// runCodegen runs each code generator whose inputs or outputs have changed
+// since it last succeeded, stopping at the first failure. Generators run one
+// at a time per repository, so concurrent builds do not duplicate work.
+func runCodegen(repo *Repository, w io.Writer) error {
+	if len(repo.Codegen) == 0 {
+		return nil
+	}
+	repo.codegenMx.Lock()
+	defer repo.codegenMx.Unlock()
+
+	var env []string
+	for _, codegen := range repo.Codegen {
+		manifestPath := path.Join(repo.TmpDir, "codegen", codegen.Name+".json")
+		cache := newCommandCache(repo, manifestPath, codegen.Run, codegen.Inputs, codegen.Outputs)
+		upToDate, err := cache.UpToDate()
+		if err != nil {
+			return fmt.Errorf("codegen %s: %w", codegen.Name, err)
+		}
+		if upToDate {
+			continue
+		}
+		if err := cache.Invalidate(); err != nil {
+			return err
+		}
+
+		if env == nil {
+			env, err = commandEnv(repo)
+			if err != nil {
+				return err
+			}
+		}
+		logEvent(w, "codegen", logFields{"codegen": codegen.Name, "command": codegen.Run}, "> codegen %s: %s", codegen.Name, codegen.Run)
+		cmd := shellCommand(codegen.Run)
+		cmd.Dir = repo.RootDir
+		cmd.Env = env
+		cmd.Stdout = w
+		cmd.Stderr = w
+		if err := cmd.Run(); err != nil {
+			return &codegenError{name: codegen.Name, err: err}
+		}
+		if err := cache.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// codegenError is a failure of a code generator's command. It counts as a
+// build failure, and deliberately does not unwrap to the command's error, so
+// that the generator's exit status is not mistaken for that of a program.
+type codegenError struct {
+	name string
+	err  error
+}
+
+func (e *codegenError) Error() string {
+	return fmt.Sprintf("codegen %s: %v", e.name, e.err)
+}
+
+func (e *codegenError) Is(target error) bool {
+	return target == ErrBuildFailed
+}
+
+// codegenOutputs returns the absolute paths of all existing generated files.
+func codegenOutputs(repo *Repository) ([]string, error) {
+	var patterns []string
+	for _, codegen := range repo.Codegen {
+		patterns = append(patterns, codegen.Outputs...)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return globFiles(repo, patterns)
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"path"
+	"sort"
+)
+
+// Names and paths from the repository config, for completing command-line
+// arguments. Each is sorted.
+
+func PackageNames(repo *Repository) []string {
+	names := make([]string, 0, len(repo.Packages))
+	for name := range repo.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DependencyNames returns the sorted names of the repository's dependencies.
+func DependencyNames(repo *Repository) []string {
+	names := make([]string, 0, len(repo.Dependencies))
+	for name := range repo.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TaskNames returns the names of tasks defined by any package, including the
+// builtin build task.
+func TaskNames(repo *Repository) []string {
+	seen := map[string]bool{"build": true}
+	for _, pkg := range repo.Packages {
+		for name := range pkg.Tasks {
+			seen[name] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// RunTargetNames returns the names of run targets and groups.
+func RunTargetNames(repo *Repository) []string {
+	seen := make(map[string]bool)
+	for name := range repo.RunTargets {
+		seen[name] = true
+	}
+	for name := range repo.RunGroups {
+		seen[name] = true
+	}
+	return sortedKeys(seen)
+}
+
+// EntrypointPaths returns the absolute paths of the entrypoints of all
+// packages and run targets.
+func EntrypointPaths(repo *Repository) []string {
+	seen := make(map[string]bool)
+	for _, entrypoint := range getEntrypoints(repo) {
+		seen[path.Join(repo.RootDir, entrypoint)] = true
+	}
+	for _, target := range repo.RunTargets {
+		seen[target.Entrypoint] = true
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
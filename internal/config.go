@@ -5,15 +5,220 @@ package internal
 
 type Config struct {
 	Engines      map[string]string
+	Node         *NodeConfig
 	Repository   string
 	Registry     string
 	Packages     map[string]PackageConfig
 	Dependencies map[string]string
+	Define       map[string]string
+	Loaders      map[string]string
+	Aliases      map[string]string
+	JSX          *JSXConfig
+	Run          RunConfig
+	Watch        WatchConfig
+	Tmp          TmpConfig
+	SourceMaps   SourceMapsConfig `yaml:"sourcemaps"`
+	Codegen      map[string]CodegenConfig
+	Cache        CacheConfig
+	Externals    ExternalsConfig
+	PackageJSON  map[string]interface{} `yaml:"packageJson"`
+	Licenses     *LicensesConfig
+	Boundaries   *BoundariesConfig
+	// Modules exporting esbuild plugins written in JavaScript or TypeScript.
+	Plugins []string
+	// Whether to also configure packages found by package manager workspaces.
+	DiscoverWorkspaces bool `yaml:"discoverWorkspaces"`
+	// Package manager that installs dependencies: npm, yarn, or pnpm.
+	PackageManager string `yaml:"packageManager"`
+}
+
+type JSXConfig struct {
+	Runtime      string
+	ImportSource string `yaml:"importSource"`
+	Factory      string
+	Fragment     string
+}
+
+type NodeConfig struct {
+	Path    string
+	Manager string
+}
+
+type LicensesConfig struct {
+	Allow []string
+	Deny  []string
+}
+
+type BoundariesConfig struct {
+	NoDeepImports bool `yaml:"noDeepImports"`
+	Rules         []BoundaryRuleConfig
+}
+
+type BoundaryRuleConfig struct {
+	From     string
+	Disallow []string
+}
+
+type ExternalsConfig struct {
+	Bundle   []string
+	External []string
+}
+
+type CacheConfig struct {
+	Remote *RemoteCacheConfig
+}
+
+type RemoteCacheConfig struct {
+	URL      string         `yaml:"url"`
+	TokenEnv string         `yaml:"tokenEnv"`
+	S3       *S3CacheConfig `yaml:"s3"`
+	ReadOnly bool           `yaml:"readOnly"`
+}
+
+type S3CacheConfig struct {
+	Bucket   string
+	Region   string
+	Prefix   string
+	Endpoint string
+}
+
+type CodegenConfig struct {
+	Run     string
+	Inputs  []string
+	Outputs []string
+}
+
+type SourceMapsConfig struct {
+	Upload *SourceMapUploadConfig
+}
+
+type SourceMapUploadConfig struct {
+	Sentry   *SentryUploadConfig
+	Endpoint *EndpointUploadConfig
+}
+
+type SentryUploadConfig struct {
+	URL          string `yaml:"url"`
+	Organization string
+	Project      string
+	TokenEnv     string `yaml:"tokenEnv"`
+}
+
+type EndpointUploadConfig struct {
+	URL      string `yaml:"url"`
+	TokenEnv string `yaml:"tokenEnv"`
+}
+
+type TmpConfig struct {
+	MaxAge string `yaml:"maxAge"`
+}
+
+type WatchConfig struct {
+	Ignore   []string
+	Debounce string
+}
+
+type RunConfig struct {
+	ShutdownSignal  string `yaml:"shutdownSignal"`
+	ShutdownTimeout string `yaml:"shutdownTimeout"`
+	Restart         string
+	Target          string
+	Runtime         string
+	CrashRestart    *CrashRestartConfig `yaml:"crashRestart"`
+	PortConflict    string              `yaml:"portConflict"`
+	Targets         map[string]RunTargetConfig
+	Groups          map[string][]string
+}
+
+type CrashRestartConfig struct {
+	MaxRetries *int `yaml:"maxRetries"`
+	Backoff    string
+	MaxBackoff string `yaml:"maxBackoff"`
+}
+
+type RunTargetConfig struct {
+	Entrypoint string
+	Args       []string
+	Cwd        string
+	Runtime    string
+	Env        map[string]string
+	Ports      []int
+	Ready      *ReadyConfig
+	DependsOn  []string `yaml:"dependsOn"`
+}
+
+type ReadyConfig struct {
+	Port    int
+	HTTP    string `yaml:"http"`
+	Output  string
+	Timeout string
+}
+
+type HooksConfig struct {
+	Prebuild  []string
+	Postbuild []string
+}
+
+type TaskConfig struct {
+	Run       string
+	DependsOn []string `yaml:"dependsOn"`
+	Inputs    []string
+	Outputs   []string
 }
 
 type PackageConfig struct {
 	Public      bool
 	Description string
 	Index       string
+	Version     string
 	Executables map[string]string
+	Entrypoints map[string]string
+	Workers     []string
+	Tasks       map[string]TaskConfig
+	Hooks       HooksConfig
+	Format      string
+	Platform    string
+	Target      string
+	External    []string
+	Minify      bool
+	SourceMap   string `yaml:"sourcemap"`
+	JSX         *JSXConfig
+	Lambda      *LambdaConfig
+	Docker      *DockerConfig
+	Budget      *BudgetConfig
+	Banner      string
+	Footer      string
+	// Maps of entrypoint paths to text added to their outputs only.
+	Banners     map[string]string
+	Footers     map[string]string
+	PackageJSON map[string]interface{} `yaml:"packageJson"`
+	// Either a bool or a list of globs.
+	SideEffects interface{} `yaml:"sideEffects"`
+	// Map of peer dependency names to their settings.
+	PeerDependencies     map[string]PeerDependencyConfig `yaml:"peerDependencies"`
+	OptionalDependencies []string                        `yaml:"optionalDependencies"`
+}
+
+type LambdaConfig struct {
+	Runtime   string
+	Handler   string
+	SourceMap bool `yaml:"sourcemap"`
+}
+
+type DockerConfig struct {
+	Image      string
+	BaseImage  string `yaml:"baseImage"`
+	Executable string
+	Expose     []int
+}
+
+type PeerDependencyConfig struct {
+	Version  string
+	Optional bool
+}
+
+type BudgetConfig struct {
+	Raw  string
+	Gzip string
+	Warn bool
 }
@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// ConfigError is a problem with the config file, located by line and column
+// if known.
+type ConfigError struct {
+	Filename string
+	Line     int
+	Column   int
+	Err      error
+	// The offending line of the config file, with a caret under the column.
+	Excerpt string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.Filename, e.Err)
+	}
+	msg := fmt.Sprintf("%s:%d:%d: %v", e.Filename, e.Line, e.Column, e.Err)
+	if e.Excerpt != "" {
+		msg += "\n" + e.Excerpt
+	}
+	return msg
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configSource is a parsed config file, which locates errors by the keys of
+// the config values that caused them.
+type configSource struct {
+	filename string
+	text     []byte
+	// Whether the text was output by a config script, rather than read from
+	// the config file, and so has no meaningful lines.
+	generated bool
+	// Map of key paths, joined by configKeySeparator, to their positions.
+	positions map[string]*token.Position
+}
+
+const configKeySeparator = "\x00"
+
+// parseConfigSource parses a config file and checks it against the schema of
+// Config, returning the first unknown key, duplicate key, or value of the
+// wrong type. Duplicate keys are reported first, since a type error in the
+// value of one is likely a consequence of the duplication.
+func parseConfigSource(filename string, text []byte, generated bool) (*configSource, error) {
+	src := &configSource{
+		filename:  filename,
+		text:      text,
+		generated: generated,
+		positions: make(map[string]*token.Position),
+	}
+	file, err := parser.ParseBytes(text, 0)
+	if err != nil {
+		return nil, src.yamlError(err)
+	}
+	if len(file.Docs) == 0 || file.Docs[0].Body == nil {
+		return src, nil
+	}
+	var duplicates, errs []*ConfigError
+	src.check(&duplicates, &errs, file.Docs[0].Body, reflect.TypeOf(Config{}), nil)
+	if len(duplicates) > 0 {
+		return nil, duplicates[0]
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return src, nil
+}
+
+// errorAt locates an error by the config key that caused it. If the key is
+// absent, such as when a required key is missing, the error is located at its
+// nearest present parent. Errors without location are returned unchanged.
+func (src *configSource) errorAt(err error, keys ...string) error {
+	for n := len(keys); n > 0; n-- {
+		if pos, ok := src.positions[strings.Join(keys[:n], configKeySeparator)]; ok {
+			return src.newError(pos.Line, pos.Column, err)
+		}
+	}
+	return fmt.Errorf("%s: %w", src.filename, err)
+}
+
+func (src *configSource) newError(line, column int, err error) *ConfigError {
+	if src.generated {
+		return &ConfigError{
+			Filename: src.filename,
+			Err:      err,
+		}
+	}
+	return &ConfigError{
+		Filename: src.filename,
+		Line:     line,
+		Column:   column,
+		Err:      err,
+		Excerpt:  src.excerpt(line, column),
+	}
+}
+
+func (src *configSource) excerpt(line, column int) string {
+	lines := bytes.Split(src.text, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := strings.TrimRight(string(lines[line-1]), "\r")
+	gutter := fmt.Sprintf("%5d | ", line)
+	caret := ""
+	if column >= 1 {
+		caret = "\n" + strings.Repeat(" ", len(gutter)+column-1) + "^"
+	}
+	return gutter + text + caret
+}
+
+var yamlErrorPosition = regexp.MustCompile(`\[(\d+):(\d+)\] (.*)`)
+
+// yamlError locates an error from the yaml package, which reports positions
+// as a "[line:column]" prefix of the first line of its message.
+func (src *configSource) yamlError(err error) error {
+	msg := strings.SplitN(err.Error(), "\n", 2)[0]
+	match := yamlErrorPosition.FindStringSubmatch(msg)
+	if match == nil {
+		return fmt.Errorf("%s: %w", src.filename, err)
+	}
+	line, _ := strconv.Atoi(match[1])
+	column, _ := strconv.Atoi(match[2])
+	return src.newError(line, column, fmt.Errorf("%s", match[3]))
+}
+
+// check validates a node against the type it will be decoded into, appending
+// duplicate keys and other problems to separate lists.
+func (src *configSource) check(duplicates, errs *[]*ConfigError, node ast.Node, typ reflect.Type, keys []string) {
+	switch n := node.(type) {
+	case nil, *ast.NullNode, *ast.AliasNode:
+		// Aliases are checked where their anchors are defined.
+		return
+	case *ast.AnchorNode:
+		src.check(duplicates, errs, n.Value, typ, keys)
+		return
+	case *ast.TagNode:
+		src.check(duplicates, errs, n.Value, typ, keys)
+		return
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	fail := func(format string, args ...interface{}) {
+		pos := node.GetToken().Position
+		err := fmt.Errorf(format, args...)
+		if len(keys) > 0 {
+			err = fmt.Errorf("%s: %w", strings.Join(keys, "."), err)
+		}
+		*errs = append(*errs, src.newError(pos.Line, pos.Column, err))
+	}
+	switch typ.Kind() {
+	case reflect.Interface:
+		return
+	case reflect.Struct, reflect.Map:
+		mapping, ok := node.(ast.MapNode)
+		if !ok {
+			fail("expected a mapping, but found %s", describeNode(node))
+			return
+		}
+		var fields map[string]reflect.StructField
+		if typ.Kind() == reflect.Struct {
+			fields = configFields(typ)
+		}
+		seen := make(map[string]bool)
+		iter := mapping.MapRange()
+		for iter.Next() {
+			keyNode := iter.Key()
+			if keyNode.Type() == ast.MergeKeyType {
+				continue
+			}
+			key := keyNode.GetToken().Value
+			pos := keyNode.GetToken().Position
+			childKeys := append(append([]string{}, keys...), key)
+			if seen[key] {
+				*duplicates = append(*duplicates, src.newError(pos.Line, pos.Column, fmt.Errorf("duplicate key %q", strings.Join(childKeys, "."))))
+				continue
+			}
+			seen[key] = true
+			src.positions[strings.Join(childKeys, configKeySeparator)] = pos
+			elemType := typ
+			if fields != nil {
+				field, ok := fields[key]
+				if !ok {
+					msg := fmt.Sprintf("unknown key %q", strings.Join(childKeys, "."))
+					for name := range fields {
+						if strings.EqualFold(name, key) {
+							msg += fmt.Sprintf("; did you mean %q?", name)
+						}
+					}
+					*errs = append(*errs, src.newError(pos.Line, pos.Column, fmt.Errorf("%s", msg)))
+					continue
+				}
+				elemType = field.Type
+			} else {
+				elemType = typ.Elem()
+			}
+			src.check(duplicates, errs, iter.Value(), elemType, childKeys)
+		}
+	case reflect.Slice:
+		seq, ok := node.(*ast.SequenceNode)
+		if !ok {
+			fail("expected a list, but found %s", describeNode(node))
+			return
+		}
+		for i, value := range seq.Values {
+			src.check(duplicates, errs, value, typ.Elem(), append(append([]string{}, keys...), strconv.Itoa(i)))
+		}
+	case reflect.String:
+		switch n := node.(type) {
+		case *ast.StringNode, *ast.LiteralNode, *ast.IntegerNode, *ast.BoolNode:
+		case *ast.FloatNode:
+			// Such as a version of 1.10, which would be read as "1.1".
+			if value := n.GetToken().Value; fmt.Sprint(n.Value) != value {
+				fail("number %s would be read as %v; quote it to use it as a string", value, n.Value)
+			}
+		default:
+			fail("expected a string, but found %s", describeNode(node))
+		}
+	case reflect.Bool:
+		if _, ok := node.(*ast.BoolNode); !ok {
+			fail("expected true or false, but found %s", describeNode(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, ok := node.(*ast.IntegerNode); !ok {
+			fail("expected an integer, but found %s", describeNode(node))
+		}
+	}
+}
+
+// configFields returns the fields of a config struct by their keys, which
+// follow the yaml package: a yaml or json tag, else the lowercased name.
+func configFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("yaml")
+		if tag == "" {
+			tag = field.Tag.Get("json")
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func describeNode(node ast.Node) string {
+	switch node.(type) {
+	case ast.MapNode:
+		return "a mapping"
+	case *ast.SequenceNode:
+		return "a list"
+	case *ast.BoolNode:
+		return fmt.Sprintf("boolean %s", node.GetToken().Value)
+	case *ast.IntegerNode, *ast.FloatNode, *ast.InfinityNode, *ast.NanNode:
+		return fmt.Sprintf("number %s", node.GetToken().Value)
+	default:
+		return fmt.Sprintf("%q", node.GetToken().Value)
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Evaluates a config module, whose default export is the config or a
+// function returning it, possibly asynchronously, and writes it as JSON to
+// the file named by the second argument.
+const configScriptRunner = `
+const fs = require('fs');
+const [, modulePath, outputPath] = process.argv;
+Promise.resolve().then(() => {
+  let config = require(modulePath);
+  if (config && config.__esModule && 'default' in config) {
+    config = config.default;
+  }
+  return typeof config === 'function' ? config() : config;
+}).then((config) => {
+  fs.writeFileSync(outputPath, JSON.stringify(config == null ? {} : config, null, 2));
+}, (err) => {
+  console.error(err && err.stack || err);
+  process.exit(1);
+});
+`
+
+// evaluateConfigScript bundles a TypeScript or JavaScript config file and
+// runs it with node, returning the config as JSON along with the paths of all
+// source files it loaded. Packages from node_modules are not bundled, so
+// that config files may use presets installed as dependencies. Each
+// evaluation uses its own temporary directory, so that concurrent uni
+// processes do not clobber each other's output.
+func evaluateConfigScript(repo *Repository, filename string) ([]byte, []string, error) {
+	rootDir := repo.RootDir
+	var mx sync.Mutex
+	seen := map[string]struct{}{filename: {}}
+	plugin := api.Plugin{
+		Name: "unirepo:config",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter: ".*",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				if args.Namespace == "file" {
+					mx.Lock()
+					seen[args.Path] = struct{}{}
+					mx.Unlock()
+				}
+				return api.OnLoadResult{}, nil
+			})
+		},
+	}
+
+	if err := os.MkdirAll(repo.TmpDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	dir, err := TempDir(repo, "config")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	bundlePath := path.Join(dir, "config.js")
+	result := api.Build(api.BuildOptions{
+		AbsWorkingDir: rootDir,
+		EntryPoints:   []string{filename},
+		Outfile:       bundlePath,
+		Bundle:        true,
+		Platform:      api.PlatformNode,
+		Format:        api.FormatCommonJS,
+		Sourcemap:     api.SourceMapInline,
+		Write:         true,
+		LogLevel:      api.LogLevelSilent,
+		Plugins:       []api.Plugin{externalPackagesPlugin(), plugin},
+	})
+	if len(result.Errors) > 0 {
+		printMessages(result.Errors, "error")
+		return nil, nil, fmt.Errorf("building %s failed", filepath.Base(filename))
+	}
+
+	outputPath := path.Join(dir, "config.json")
+	node := exec.Command("node", "--enable-source-maps", "-e", configScriptRunner, bundlePath, outputPath)
+	node.Dir = rootDir
+	node.Stdout = os.Stderr
+	node.Stderr = os.Stderr
+	if err := node.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, nil, fmt.Errorf("evaluating %s failed", filepath.Base(filename))
+		}
+		return nil, nil, err
+	}
+	text, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inputs := make([]string, 0, len(seen))
+	for input := range seen {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	return text, inputs, nil
+}
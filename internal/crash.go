@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultCrashMaxRetries = 5
+	DefaultCrashBackoff    = time.Second
+	DefaultCrashMaxBackoff = 30 * time.Second
+	// How long a process must run without crashing for its restart attempts
+	// to be forgotten.
+	crashResetAfter = time.Minute
+)
+
+// CrashRestartPolicy controls automatic restarts of processes that crash in
+// watch mode, rather than waiting for a change to restart them.
+type CrashRestartPolicy struct {
+	// Number of consecutive crashes to restart after before giving up.
+	MaxRetries int
+	// Delay before the first restart, which doubles after each consecutive
+	// crash, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultCrashRestartPolicy returns the policy used when crash restarts are
+// enabled without configuration.
+func DefaultCrashRestartPolicy() *CrashRestartPolicy {
+	return &CrashRestartPolicy{
+		MaxRetries: DefaultCrashMaxRetries,
+		Backoff:    DefaultCrashBackoff,
+		MaxBackoff: DefaultCrashMaxBackoff,
+	}
+}
+
+func newCrashRestartPolicy(cfg *CrashRestartConfig) (*CrashRestartPolicy, error) {
+	policy := DefaultCrashRestartPolicy()
+	if cfg.MaxRetries != nil {
+		if *cfg.MaxRetries < 1 {
+			return nil, errors.New("maxRetries must be positive")
+		}
+		policy.MaxRetries = *cfg.MaxRetries
+	}
+	var err error
+	if cfg.Backoff != "" {
+		policy.Backoff, err = time.ParseDuration(cfg.Backoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backoff: %w", err)
+		}
+		if policy.Backoff <= 0 {
+			return nil, errors.New("backoff must be positive")
+		}
+	}
+	if cfg.MaxBackoff != "" {
+		policy.MaxBackoff, err = time.ParseDuration(cfg.MaxBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxBackoff: %w", err)
+		}
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		return nil, errors.New("maxBackoff must not be less than backoff")
+	}
+	return policy, nil
+}
+
+// delay returns how long to wait before restarting after the given number of
+// consecutive crashes, starting from one.
+func (policy *CrashRestartPolicy) delay(crashes int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < crashes && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+// crashTracker counts the consecutive crashes of a process to decide whether
+// and when to restart it.
+type crashTracker struct {
+	policy  *CrashRestartPolicy
+	crashes int
+}
+
+// crashed records that a process exited with err after starting at the given
+// time. Returns the delay before it should be restarted, or false if it should
+// not be, such as when it exited successfully or has crashed too many times.
+func (t *crashTracker) crashed(err error, started time.Time) (time.Duration, bool) {
+	if t.policy == nil || err == nil {
+		return 0, false
+	}
+	if time.Since(started) >= crashResetAfter {
+		t.crashes = 0
+	}
+	if t.crashes >= t.policy.MaxRetries {
+		return 0, false
+	}
+	t.crashes++
+	return t.policy.delay(t.crashes), true
+}
+
+// reset forgets previous crashes, such as after a restart due to changes.
+func (t *crashTracker) reset() {
+	t.crashes = 0
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const cssModuleNamespace = "unirepo-css-module"
+
+// cssModulesPlugin implements CSS modules: Importing a .module.css file
+// bundles a copy of its styles in which class names are made unique to the
+// file, and the default export maps the original class names to the unique
+// ones.
+func cssModulesPlugin(repo *Repository) api.Plugin {
+	load := func(filename string) (string, map[string]string, error) {
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", nil, err
+		}
+		rel, err := filepath.Rel(repo.RootDir, filename)
+		if err != nil {
+			rel = filename
+		}
+		sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+		css, classes := scopeCSS(string(bs), "_"+hex.EncodeToString(sum[:])[:8])
+		return css, classes, nil
+	}
+
+	return api.Plugin{
+		Name: "unirepo:css-modules",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    `\.module\.css$`,
+				Namespace: "file",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				_, classes, err := load(args.Path)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+				exports, err := json.Marshal(classes)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+				imported, err := json.Marshal(args.Path)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+				contents := fmt.Sprintf("import %s;\nexport default %s;\n", imported, exports)
+				return api.OnLoadResult{
+					Contents: &contents,
+					Loader:   api.LoaderJS,
+				}, nil
+			})
+			build.OnResolve(api.OnResolveOptions{
+				Filter:    `\.module\.css$`,
+				Namespace: "file",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				// Only the import generated above, of the module's own path,
+				// refers to the scoped styles.
+				if args.Path != args.Importer {
+					return api.OnResolveResult{}, nil
+				}
+				// Use a relative path, since it appears in output comments.
+				rel, err := filepath.Rel(repo.RootDir, args.Path)
+				if err != nil {
+					return api.OnResolveResult{}, err
+				}
+				return api.OnResolveResult{
+					Path:      filepath.ToSlash(rel),
+					Namespace: cssModuleNamespace,
+				}, nil
+			})
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    ".*",
+				Namespace: cssModuleNamespace,
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				filename := filepath.Join(repo.RootDir, filepath.FromSlash(args.Path))
+				css, _, err := load(filename)
+				if err != nil {
+					return api.OnLoadResult{}, err
+				}
+				return api.OnLoadResult{
+					Contents:   &css,
+					ResolveDir: filepath.Dir(filename),
+					Loader:     api.LoaderCSS,
+				}, nil
+			})
+		},
+	}
+}
+
+var cssClassPattern = regexp.MustCompile(`\.(-?[_a-zA-Z][_a-zA-Z0-9-]*)`)
+
+// scopeCSS appends suffix to every class name used in selectors. Returns the
+// rewritten CSS and a map of original to rewritten class names.
+//
+// Selectors are found by treating any text that precedes a "{" as a block
+// prelude, excluding at-rules. Comments, strings, and declarations are left
+// untouched.
+func scopeCSS(css string, suffix string) (string, map[string]string) {
+	classes := make(map[string]string)
+	var out strings.Builder
+	preludeStart := 0
+	flushPrelude := func(end int, isBlock bool) {
+		prelude := css[preludeStart:end]
+		if !isBlock || strings.HasPrefix(strings.TrimSpace(prelude), "@") {
+			out.WriteString(prelude)
+			return
+		}
+		// Skip over comments and strings, such as in attribute selectors.
+		plainStart := 0
+		flushPlain := func(end int) {
+			out.WriteString(cssClassPattern.ReplaceAllStringFunc(prelude[plainStart:end], func(match string) string {
+				name := match[1:]
+				classes[name] = name + suffix
+				return "." + name + suffix
+			}))
+		}
+		for j := 0; j < len(prelude); j++ {
+			if end := skipCSSCommentOrString(prelude, j); end > j {
+				flushPlain(j)
+				out.WriteString(prelude[j:end])
+				plainStart = end
+				j = end - 1
+			}
+		}
+		flushPlain(len(prelude))
+	}
+	for i := 0; i < len(css); i++ {
+		if end := skipCSSCommentOrString(css, i); end > i {
+			i = end - 1
+			continue
+		}
+		switch c := css[i]; c {
+		case '{', '}', ';':
+			flushPrelude(i, c == '{')
+			out.WriteByte(c)
+			preludeStart = i + 1
+		}
+	}
+	if preludeStart < len(css) {
+		flushPrelude(len(css), false)
+	}
+	return out.String(), classes
+}
+
+// skipCSSCommentOrString returns the index just past a comment or string
+// beginning at i, or i if there is none.
+func skipCSSCommentOrString(css string, i int) int {
+	switch c := css[i]; {
+	case c == '/' && i+1 < len(css) && css[i+1] == '*':
+		end := strings.Index(css[i+2:], "*/")
+		if end < 0 {
+			return len(css)
+		}
+		return i + 2 + end + 2
+	case c == '"' || c == '\'':
+		for j := i + 1; j < len(css); j++ {
+			switch css[j] {
+			case '\\':
+				j++
+			case c:
+				return j + 1
+			}
+		}
+		return len(css)
+	default:
+		return i
+	}
+}
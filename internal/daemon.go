@@ -0,0 +1,479 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// daemonRequest is sent by a client to a daemon as a single JSON object.
+type daemonRequest struct {
+	// One of "build", "run", or "stop".
+	Command string `json:"command"`
+	// Version of the client's uni. See uniVersion.
+	Version string `json:"version"`
+	// Logging settings of the client, which apply to output of the request.
+	LogFormat  LogFormat `json:"logFormat"`
+	Color      bool      `json:"color"`
+	Timestamps bool      `json:"timestamps"`
+
+	// Names of packages to build, and how to build them.
+	Packages []string     `json:"packages,omitempty"`
+	Build    BuildOptions `json:"build"`
+
+	// Program to bundle for running.
+	Run daemonRunOptions `json:"run"`
+}
+
+// daemonRunOptions are the options of a program bundled by a daemon. They also
+// key the daemon's bundling sessions, so that every option forwarded to the
+// daemon distinguishes programs bundled differently.
+type daemonRunOptions struct {
+	Entrypoint string            `json:"entrypoint,omitempty"`
+	Define     map[string]string `json:"define,omitempty"`
+	SourceMap  SourceMap         `json:"sourcemap,omitempty"`
+	Workers    []string          `json:"workers,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	// Runtime that runs the bundle, which determines its run script.
+	Runtime Runtime `json:"runtime,omitempty"`
+}
+
+// daemonResponse is one of a stream of JSON objects sent by a daemon in reply
+// to a request. Output is streamed until a response with Done is sent.
+type daemonResponse struct {
+	Output string `json:"output,omitempty"`
+	Done   bool   `json:"done,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Whether the error is ErrBuildFailed.
+	BuildFailed bool `json:"buildFailed,omitempty"`
+	// Version of the daemon's uni, which must match the client's, since
+	// options that either does not know of would otherwise be ignored.
+	Version string `json:"version,omitempty"`
+	// Path of the script that runs a bundled program.
+	Script string `json:"script,omitempty"`
+}
+
+func daemonSocketPath(repo *Repository) string {
+	return path.Join(repo.OutDir, "daemon.sock")
+}
+
+// errDaemonVersion is returned for requests to a daemon running a different
+// version of uni, which clients then serve themselves.
+var errDaemonVersion = errors.New("daemon is running a different version of uni; restart it with `uni daemon`")
+
+// dialDaemon connects to the daemon for the repository, failing if none is
+// running.
+func dialDaemon(repo *Repository) (net.Conn, error) {
+	return net.Dial("unix", daemonSocketPath(repo))
+}
+
+// requestDaemon sends a request over conn, which is closed afterwards. Output
+// of the request is copied to stderr.
+func requestDaemon(conn net.Conn, req daemonRequest) (daemonResponse, error) {
+	defer conn.Close()
+	req.LogFormat = logFormat
+	req.Color = logColor
+	req.Timestamps = logTimestamps
+	req.Version = uniVersion()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return daemonResponse{}, fmt.Errorf("sending to daemon: %w", err)
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var resp daemonResponse
+		if err := dec.Decode(&resp); err != nil {
+			return daemonResponse{}, fmt.Errorf("receiving from daemon: %w", err)
+		}
+		if resp.Output != "" {
+			_, _ = io.WriteString(os.Stderr, resp.Output)
+		}
+		if !resp.Done {
+			continue
+		}
+		if resp.Version != req.Version {
+			// Daemons older than the version check send no version.
+			return resp, errDaemonVersion
+		}
+		if resp.BuildFailed {
+			return resp, ErrBuildFailed
+		}
+		if resp.Error != "" {
+			return resp, errors.New(resp.Error)
+		}
+		return resp, nil
+	}
+}
+
+func buildWithDaemon(conn net.Conn, packages map[string]*Package, opts BuildOptions) error {
+	req := daemonRequest{
+		Command: "build",
+		Build:   opts,
+	}
+	req.Build.Package = nil
+	for name := range packages {
+		req.Packages = append(req.Packages, name)
+	}
+	sort.Strings(req.Packages)
+	_, err := requestDaemon(conn, req)
+	return err
+}
+
+// runScriptWithDaemon bundles a program with the daemon, returning the path
+// of a script that runs it.
+func runScriptWithDaemon(conn net.Conn, opts RunOptions) (string, error) {
+	resp, err := requestDaemon(conn, daemonRequest{
+		Command: "run",
+		Run: daemonRunOptions{
+			Entrypoint: opts.Entrypoint,
+			Define:     opts.Define,
+			SourceMap:  opts.SourceMap,
+			Workers:    opts.Workers,
+			Target:     opts.Target,
+			Runtime:    opts.Runtime,
+		},
+	})
+	return resp.Script, err
+}
+
+// StopDaemon asks the daemon for the repository to exit.
+func StopDaemon(repo *Repository) error {
+	conn, err := dialDaemon(repo)
+	if err != nil {
+		return errors.New("daemon is not running")
+	}
+	_, err = requestDaemon(conn, daemonRequest{Command: "stop"})
+	return err
+}
+
+// Daemon serves requests from other uni processes over a unix socket in the
+// out directory, until interrupted or stopped with StopDaemon. Between
+// requests, it keeps the repository configuration and package graph loaded,
+// and keeps programs bundled for `uni run` up to date with incremental builds
+// in watch mode. Packages are bundled from scratch for each `uni build`.
+func Daemon(repo *Repository) error {
+	socketPath := daemonSocketPath(repo)
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return errors.New("daemon is already running")
+	}
+	if err := EnsureTmp(repo); err != nil {
+		return err
+	}
+	// Remove the socket of a daemon that did not exit cleanly.
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+
+	d := &daemon{
+		repo:     repo,
+		sessions: make(map[string]*runSession),
+		stop:     make(chan struct{}),
+	}
+	defer d.stopSessions()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			d.Stop()
+		case <-d.stop:
+		}
+		listener.Close()
+	}()
+
+	logEvent(os.Stderr, "listening", logFields{"socket": socketPath}, "listening on %s", socketPath)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-d.stop:
+				return nil
+			default:
+				return err
+			}
+		}
+		go d.serve(conn)
+	}
+}
+
+type daemon struct {
+	// Held while serving a request.
+	mx sync.Mutex
+	// Loaded repository.
+	repo *Repository
+	// Programs being bundled for `uni run`, keyed by their bundling options.
+	sessions map[string]*runSession
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (d *daemon) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	// Output is written with the client's settings.
+	out := &daemonOutput{
+		enc: json.NewEncoder(conn),
+		settings: logSettings{
+			Format:     req.LogFormat,
+			Color:      req.Color,
+			Timestamps: req.Timestamps,
+		},
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	resp := daemonResponse{Done: true, Version: uniVersion()}
+	var err error
+	switch {
+	case req.Version != resp.Version && req.Command != "stop":
+		// The client reports the mismatch.
+	case req.Command == "stop":
+		d.Stop()
+	case req.Command == "build":
+		err = d.build(req, out)
+	case req.Command == "run":
+		resp.Script, err = d.run(req, out)
+	default:
+		err = fmt.Errorf("unknown daemon command: %q", req.Command)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.BuildFailed = errors.Is(err, ErrBuildFailed)
+	}
+	out.mx.Lock()
+	defer out.mx.Unlock()
+	_ = out.enc.Encode(resp)
+}
+
+// repository returns the loaded repository, reloading it if its config file
+// has changed. Reloading discards all state derived from the old config.
+func (d *daemon) repository() (*Repository, error) {
+	if d.repo.configUpToDate() {
+		return d.repo, nil
+	}
+	repo, err := LoadRepository(d.repo.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	d.stopSessions()
+	d.repo = repo
+	return repo, nil
+}
+
+func (d *daemon) build(req daemonRequest, out io.Writer) error {
+	repo, err := d.repository()
+	if err != nil {
+		return err
+	}
+	if err := CheckEngines(repo); err != nil {
+		return err
+	}
+	packages := make(map[string]*Package)
+	for _, name := range req.Packages {
+		pkg, ok := repo.Packages[name]
+		if !ok {
+			return fmt.Errorf("no such package: %q", name)
+		}
+		packages[name] = pkg
+	}
+	opts := req.Build
+	opts.UseDaemon = false
+	opts.stderr = out
+	return BuildPackages(repo, packages, opts)
+}
+
+func (d *daemon) run(req daemonRequest, out io.Writer) (string, error) {
+	repo, err := d.repository()
+	if err != nil {
+		return "", err
+	}
+	run := req.Run
+	if run.Runtime == "" {
+		run.Runtime = RuntimeNode
+	}
+	key, err := json.Marshal(run)
+	if err != nil {
+		return "", err
+	}
+	session, ok := d.sessions[string(key)]
+	if !ok {
+		session, err = startRunSession(repo, RunOptions{
+			Entrypoint: run.Entrypoint,
+			Define:     run.Define,
+			SourceMap:  run.SourceMap,
+			Workers:    run.Workers,
+			Target:     run.Target,
+			Runtime:    run.Runtime,
+		})
+		if err != nil {
+			return "", err
+		}
+		d.sessions[string(key)] = session
+	}
+	if err := session.Sync(out); err != nil {
+		if session.Stopped() {
+			delete(d.sessions, string(key))
+		}
+		return "", err
+	}
+	return session.script, nil
+}
+
+func (d *daemon) stopSessions() {
+	for key, session := range d.sessions {
+		session.Stop()
+		delete(d.sessions, key)
+	}
+}
+
+// runSession bundles a program in watch mode, without running it.
+type runSession struct {
+	script string
+	sync   chan syncRequest
+	stop   chan struct{}
+	// Closed after watching has stopped, which is when err is set.
+	done chan struct{}
+	err  error
+}
+
+func startRunSession(repo *Repository, opts RunOptions) (*runSession, error) {
+	dir, err := TempDir(repo, "run")
+	if err != nil {
+		return nil, err
+	}
+	prog := &runProgram{Entrypoint: opts.Entrypoint}
+	rb, err := newRunBuild(repo, opts, []*runProgram{prog}, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	session := &runSession{
+		script: rb.ScriptPaths[prog],
+		sync:   make(chan syncRequest),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	bw := rb.Build
+	bw.Watch = true
+	bw.Stop = session.stop
+	bw.Sync = session.sync
+	// Messages are only written for rebuilds requested by Sync.
+	bw.Stderr = ioutil.Discard
+	bw.CreateProcess = func() process {
+		return newIdleProcess()
+	}
+	go func() {
+		session.err = bw.Run()
+		os.RemoveAll(dir)
+		close(session.done)
+	}()
+	return session, nil
+}
+
+// Sync rebuilds the program, writing build messages to w, and fails if the
+// build has errors.
+func (session *runSession) Sync(w io.Writer) error {
+	reply := make(chan int, 1)
+	select {
+	case session.sync <- syncRequest{Stderr: w, Reply: reply}:
+	case <-session.done:
+		return session.stoppedErr()
+	}
+	select {
+	case errs := <-reply:
+		if errs > 0 {
+			return ErrBuildFailed
+		}
+		return nil
+	case <-session.done:
+		return session.stoppedErr()
+	}
+}
+
+func (session *runSession) stoppedErr() error {
+	if session.err != nil {
+		return session.err
+	}
+	return errors.New("build stopped")
+}
+
+func (session *runSession) Stopped() bool {
+	select {
+	case <-session.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (session *runSession) Stop() {
+	close(session.stop)
+	<-session.done
+}
+
+// daemonOutput sends each write as an output response.
+type daemonOutput struct {
+	mx       sync.Mutex
+	enc      *json.Encoder
+	settings logSettings
+}
+
+func (out *daemonOutput) logSettings() logSettings {
+	return out.settings
+}
+
+func (out *daemonOutput) Write(p []byte) (int, error) {
+	out.mx.Lock()
+	defer out.mx.Unlock()
+	if err := out.enc.Encode(daemonResponse{Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// idleProcess does nothing until killed.
+type idleProcess struct {
+	killed   chan struct{}
+	killOnce sync.Once
+}
+
+func newIdleProcess() *idleProcess {
+	return &idleProcess{killed: make(chan struct{})}
+}
+
+func (proc *idleProcess) Start() error {
+	return nil
+}
+
+func (proc *idleProcess) Kill() error {
+	proc.killOnce.Do(func() { close(proc.killed) })
+	return nil
+}
+
+func (proc *idleProcess) Wait() error {
+	<-proc.killed
+	return nil
+}
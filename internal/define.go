@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identifier commonly defined to select development or production behavior.
+const nodeEnv = "process.env.NODE_ENV"
+
+// ParseDefines parses KEY=VALUE arguments into a map of identifiers to
+// replacement expressions.
+func ParseDefines(args []string) (map[string]string, error) {
+	defines := make(map[string]string, len(args))
+	for _, arg := range args {
+		eq := strings.IndexByte(arg, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid define %q, expected KEY=VALUE", arg)
+		}
+		defines[arg[:eq]] = arg[eq+1:]
+	}
+	return defines, nil
+}
+
+// mergeDefines returns the repository's defines, overridden by the given
+// defines.
+func mergeDefines(repo *Repository, defines map[string]string) map[string]string {
+	merged := make(map[string]string, len(repo.Define)+len(defines))
+	for k, v := range repo.Define {
+		merged[k] = v
+	}
+	for k, v := range defines {
+		merged[k] = v
+	}
+	return merged
+}
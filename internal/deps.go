@@ -1,14 +1,57 @@
 package internal
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"path"
+	"sort"
+	"strings"
 )
 
 type InstallDependenciesOptions struct {
+	// Installs exactly the versions in the lock file, failing if it does not
+	// match the configured dependencies, instead of updating it.
 	Frozen bool
 }
 
+// PackageManager is the program that installs dependencies into node_modules.
+type PackageManager string
+
+const (
+	PackageManagerNpm  PackageManager = "npm"
+	PackageManagerYarn PackageManager = "yarn"
+	PackageManagerPnpm PackageManager = "pnpm"
+)
+
+// Lockfile returns the name of the lock file that the package manager writes
+// in the repository root.
+func (pm PackageManager) Lockfile() string {
+	switch pm {
+	case PackageManagerYarn:
+		return "yarn.lock"
+	case PackageManagerPnpm:
+		return "pnpm-lock.yaml"
+	default:
+		return "package-lock.json"
+	}
+}
+
+func (pm PackageManager) installArgs(frozen bool) []string {
+	switch {
+	case pm == PackageManagerNpm && frozen:
+		return []string{"ci"}
+	case frozen:
+		return []string{"install", "--frozen-lockfile"}
+	default:
+		return []string{"install"}
+	}
+}
+
+// InstallDependencies installs the configured dependencies with the configured
+// package manager, from a generated root package.json, and then verifies that
+// the lock file exists and that the installed versions satisfy the configured
+// versions.
 func InstallDependencies(repo *Repository, opts InstallDependenciesOptions) error {
 	metadata := PackageMetadata{
 		Name:         "@unirepo/placeholder",
@@ -23,18 +66,64 @@ func InstallDependencies(repo *Repository, opts InstallDependenciesOptions) erro
 	for dependencyName, dependency := range repo.Dependencies {
 		metadata.Dependencies[dependencyName] = dependency.Version
 	}
+	if len(repo.WorkspaceGlobs) > 0 {
+		metadata.Extra = map[string]interface{}{
+			"workspaces": repo.WorkspaceGlobs,
+		}
+	}
 	if err := WritePackageJSON(metadata, repo.RootDir); err != nil {
 		return err
 	}
 
-	subcommand := "install"
+	pm := repo.PackageManager
+	if pm == "" {
+		pm = PackageManagerNpm
+	}
+	lockfile := path.Join(repo.RootDir, pm.Lockfile())
 	if opts.Frozen {
-		subcommand = "ci"
+		if _, err := os.Stat(lockfile); os.IsNotExist(err) {
+			return fmt.Errorf("%s not found; install without --frozen to create it", pm.Lockfile())
+		}
 	}
 
-	npm := exec.Command("npm", subcommand)
-	npm.Stdin = os.Stdin
-	npm.Stdout = os.Stdout
-	npm.Stderr = os.Stderr
-	return npm.Run()
+	install := exec.Command(string(pm), pm.installArgs(opts.Frozen)...)
+	install.Dir = repo.RootDir
+	install.Stdin = os.Stdin
+	install.Stdout = os.Stdout
+	install.Stderr = os.Stderr
+	if err := install.Run(); err != nil {
+		return fmt.Errorf("%s: %w", pm, err)
+	}
+
+	if _, err := os.Stat(lockfile); os.IsNotExist(err) {
+		Warnf("%s not found; installs are not reproducible", pm.Lockfile())
+	}
+	return verifyInstalledDependencies(repo)
+}
+
+// verifyInstalledDependencies checks that every configured dependency is
+// installed at the top of node_modules, at a version in its configured range.
+// Dependencies configured with versions that are not semver ranges, such as
+// tags or URLs, need only be installed.
+func verifyInstalledDependencies(repo *Repository) error {
+	var mismatched []string
+	for name, dependency := range repo.Dependencies {
+		installed := installedVersion(repo, name)
+		if installed == "" {
+			mismatched = append(mismatched, fmt.Sprintf("%s (not installed)", name))
+			continue
+		}
+		r, err := parseSemverRange(dependency.Version)
+		if err != nil {
+			continue
+		}
+		if version, err := parseSemver(installed); err == nil && !r.Contains(version) {
+			mismatched = append(mismatched, fmt.Sprintf("%s (configured %s, installed %s)", name, dependency.Version, installed))
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("node_modules does not match configured dependencies: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
 }
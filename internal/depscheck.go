@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// DependencyReport describes how the source files of a repository use its
+// configured dependencies.
+type DependencyReport struct {
+	// Configured dependencies that no source file imports, sorted.
+	Unused []string
+	// Installed packages that source files import without configuring them as
+	// dependencies, mapped to the sorted paths of the files that import them,
+	// relative to the repository root. They are missing from generated
+	// package.json files, and are installed only by chance, such as by being
+	// dependencies of dependencies.
+	Undeclared map[string][]string
+	// Every installed package that source files import, mapped to the sorted
+	// paths of the files that import it, relative to the repository root.
+	Importers map[string][]string
+}
+
+// AnalyzeDependencies finds the dependencies imported by the entrypoints of
+// every package and run target, and every test file, and the modules they
+// import in turn. Only imports of packages installed in node_modules count,
+// so imports of packages that are not installed are not reported, nor are
+// imports of TypeScript path mappings mistaken for packages. Imports of
+// types only are erased before they can be observed, so dependencies used
+// only for their types are reported as unused.
+//
+// Dependencies that uni itself requires, type declaration packages, peer and
+// optional dependencies of packages, and packages that install executables,
+// which are presumed to be tools, are never reported as unused.
+func AnalyzeDependencies(repo *Repository) (*DependencyReport, error) {
+	var entrypoints []string
+	for _, name := range PackageNames(repo) {
+		for _, entrypoint := range repo.Packages[name].entrypointPaths() {
+			entrypoints = append(entrypoints, path.Join(repo.RootDir, entrypoint))
+		}
+	}
+	for _, target := range repo.RunTargets {
+		entrypoints = append(entrypoints, target.Entrypoint)
+	}
+	tests, err := FindTests(repo, nil)
+	if err != nil {
+		return nil, err
+	}
+	entrypoints = append(entrypoints, tests...)
+
+	var mx sync.Mutex
+	importers := make(map[string]map[string]bool)
+	aliases := sortedAliases(repo)
+	// Records imports of installed packages by source files, leaving them
+	// external, since their own imports do not matter.
+	importsPlugin := api.Plugin{
+		Name: "unirepo:imports",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: ".*",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Importer == "" || args.Namespace != "file" || isNodeModulesPath(args.Importer) {
+					return api.OnResolveResult{}, nil
+				}
+				if strings.HasPrefix(args.Path, ".") || path.IsAbs(args.Path) || filepath.IsAbs(args.Path) || nodeBuiltinPattern.MatchString(args.Path) {
+					return api.OnResolveResult{}, nil
+				}
+				if _, ok, _ := resolveAlias(repo, aliases, args.Path); ok {
+					return api.OnResolveResult{}, nil
+				}
+				name := packageNameOf(args.Path)
+				if _, err := os.Stat(path.Join(repo.RootDir, "node_modules", name)); err != nil {
+					return api.OnResolveResult{}, nil
+				}
+				mx.Lock()
+				if importers[name] == nil {
+					importers[name] = make(map[string]bool)
+				}
+				importers[name][relativeToRoot(repo, args.Importer)] = true
+				mx.Unlock()
+				return api.OnResolveResult{
+					Path:     args.Path,
+					External: true,
+				}, nil
+			})
+		},
+	}
+	plugins, err := modulePlugins(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entrypoints) > 0 {
+		// Errors are ignored, since imports that resolve are still observed.
+		_ = api.Build(api.BuildOptions{
+			AbsWorkingDir: repo.RootDir,
+			EntryPoints:   entrypoints,
+			Outdir:        path.Join(repo.TmpDir, "analyze"),
+			Bundle:        true,
+			Platform:      api.PlatformNode,
+			Format:        api.FormatCommonJS,
+			LogLevel:      api.LogLevelSilent,
+			Loader:        getLoaders(repo),
+			Define:        repo.Define,
+			Plugins:       append([]api.Plugin{importsPlugin}, plugins...),
+		})
+	}
+
+	exempt := make(map[string]bool)
+	for name := range requiredDependencies {
+		exempt[name] = true
+	}
+	for _, pkg := range repo.Packages {
+		for name := range pkg.PeerDependencies {
+			exempt[name] = true
+		}
+		for _, name := range pkg.OptionalDependencies {
+			exempt[name] = true
+		}
+	}
+
+	report := &DependencyReport{
+		Undeclared: make(map[string][]string),
+		Importers:  make(map[string][]string),
+	}
+	for name := range repo.Dependencies {
+		if importers[name] != nil || exempt[name] || strings.HasPrefix(name, "@types/") || installsExecutables(repo, name) {
+			continue
+		}
+		report.Unused = append(report.Unused, name)
+	}
+	sort.Strings(report.Unused)
+	for name, files := range importers {
+		for file := range files {
+			report.Importers[name] = append(report.Importers[name], file)
+		}
+		sort.Strings(report.Importers[name])
+		if _, ok := repo.Dependencies[name]; !ok {
+			report.Undeclared[name] = report.Importers[name]
+		}
+	}
+	return report, nil
+}
+
+// installsExecutables reports whether an installed package has executables.
+func installsExecutables(repo *Repository, name string) bool {
+	var metadata struct {
+		Bin interface{} `json:"bin"`
+	}
+	if err := ReadJSON(path.Join(repo.RootDir, "node_modules", name, "package.json"), &metadata); err != nil {
+		return false
+	}
+	return metadata.Bin != nil
+}
+
+// CheckDependencies reports unused and undeclared dependencies to w, and
+// fails if there are any. See AnalyzeDependencies.
+func CheckDependencies(repo *Repository, w io.Writer) error {
+	report, err := AnalyzeDependencies(repo)
+	if err != nil {
+		return err
+	}
+	for _, name := range report.Unused {
+		logEvent(w, "unused-dependency", logFields{"dependency": name}, "unused dependency: %s", name)
+	}
+	undeclared := make([]string, 0, len(report.Undeclared))
+	for name := range report.Undeclared {
+		undeclared = append(undeclared, name)
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		files := report.Undeclared[name]
+		logEvent(w, "undeclared-dependency", logFields{"dependency": name, "importers": files}, "undeclared dependency: %s, imported by %s", name, strings.Join(files, ", "))
+	}
+	if problems := len(report.Unused) + len(undeclared); problems > 0 {
+		return fmt.Errorf("found %d unused or undeclared dependencies", problems)
+	}
+	return nil
+}
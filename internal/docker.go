@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DockerOptions control how a package is built into a Docker image.
+type DockerOptions struct {
+	// Name of the image, without a tag. Defaults to the package name without
+	// its scope.
+	Image string
+	// Image to build from. Defaults to the slim node image of the lowest node
+	// version allowed by engines, or else of the LTS version.
+	BaseImage string
+	// Name of the executable that the container runs. Defaults to the
+	// package's only executable.
+	Executable string
+	// Ports that the container listens on.
+	Expose []int
+}
+
+func newDockerOptions(cfg *DockerConfig) (*DockerOptions, error) {
+	for _, port := range cfg.Expose {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %d", port)
+		}
+	}
+	return &DockerOptions{
+		Image:      cfg.Image,
+		BaseImage:  cfg.BaseImage,
+		Executable: cfg.Executable,
+		Expose:     cfg.Expose,
+	}, nil
+}
+
+// DockerImagesOptions control BuildDockerImages.
+type DockerImagesOptions struct {
+	// Only write Dockerfiles, without building images.
+	DockerfileOnly bool
+	Stderr         io.Writer
+}
+
+// DockerfilePath returns the path of the Dockerfile written for a package.
+func DockerfilePath(repo *Repository, pkg *Package) string {
+	return path.Join(repo.OutDir, "docker", stripName(pkg.Name), "Dockerfile")
+}
+
+// BuildDockerImages writes a Dockerfile for each of the given packages, which
+// must have been built, and builds their images with docker. The build context
+// of each image is the package's build output, in which only its runtime
+// dependencies, those left external by the bundle, are installed. Images are
+// tagged with the version of the build and as latest.
+func BuildDockerImages(repo *Repository, packages map[string]*Package, opts DockerImagesOptions) error {
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pkg := packages[name]
+		docker := pkg.Docker
+		if docker == nil {
+			docker = &DockerOptions{}
+		}
+		distDir := path.Join(repo.DistDir, pkg.Name)
+		metadata, err := ReadPackageJSON(distDir)
+		if err != nil {
+			return fmt.Errorf("reading build of %s: %w", name, err)
+		}
+		dockerfile, err := dockerfileContents(repo, pkg, docker, metadata)
+		if err != nil {
+			return fmt.Errorf("package %q: %w", name, err)
+		}
+		dockerfilePath := DockerfilePath(repo, pkg)
+		if err := os.MkdirAll(path.Dir(dockerfilePath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
+			return err
+		}
+		fields := logFields{"package": name, "dockerfile": dockerfilePath}
+		if opts.DockerfileOnly {
+			logEvent(stderr, "dockerfile", fields, "wrote %s", dockerfilePath)
+			continue
+		}
+
+		image := docker.Image
+		if image == "" {
+			image = stripScope(pkg.Name)
+		}
+		args := []string{"build", "--file", dockerfilePath, "--tag", image + ":latest"}
+		if metadata.Version != "" {
+			args = append(args, "--tag", image+":"+dockerTag(metadata.Version))
+		}
+		args = append(args, distDir)
+		binary, err := exec.LookPath("docker")
+		if err != nil {
+			return errors.New("building images requires docker; use --docker=dockerfile to only write Dockerfiles")
+		}
+		cmd := exec.Command(binary, args...)
+		cmd.Stdout = stderr
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("building image of %s: %w", name, err)
+		}
+		fields["image"] = image
+		logEvent(stderr, "image", fields, "built image %s of %s", image, name)
+	}
+	return nil
+}
+
+// dockerfileContents returns a Dockerfile that installs a package's runtime
+// dependencies, copies its build, and runs one of its executables.
+func dockerfileContents(repo *Repository, pkg *Package, docker *DockerOptions, metadata *PackageMetadata) (string, error) {
+	executable := docker.Executable
+	if executable == "" {
+		if len(pkg.Executables) != 1 {
+			return "", errors.New("docker images run an executable, so the package must have exactly one or configure docker.executable")
+		}
+		for name := range pkg.Executables {
+			executable = name
+		}
+	} else if _, ok := pkg.Executables[executable]; !ok {
+		return "", fmt.Errorf("docker.executable %q is not an executable of the package", executable)
+	}
+
+	baseImage := docker.BaseImage
+	if baseImage == "" {
+		baseImage = "node:lts-slim"
+		if engines := nodeEngines(repo); len(engines) > 0 {
+			baseImage = "node:" + strings.SplitN(engines[0].Version, ".", 2)[0] + "-slim"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by uni for %s. Build from the package's build output.\n", pkg.Name)
+	fmt.Fprintf(&b, "FROM %s\n", baseImage)
+	b.WriteString("ENV NODE_ENV=production\n")
+	b.WriteString("WORKDIR /app\n")
+	if len(metadata.Dependencies)+len(metadata.PeerDependencies)+len(metadata.OptionalDependencies) > 0 {
+		b.WriteString("COPY package.json ./\n")
+		b.WriteString("RUN npm install --omit=dev --no-package-lock --no-audit --no-fund && npm cache clean --force\n")
+	}
+	b.WriteString("COPY . ./\n")
+	ports := append([]int{}, docker.Expose...)
+	sort.Ints(ports)
+	for _, port := range ports {
+		fmt.Fprintf(&b, "EXPOSE %d\n", port)
+	}
+	b.WriteString("USER node\n")
+	fmt.Fprintf(&b, "CMD [\"node\", \"--enable-source-maps\", %s]\n", strconv.Quote(executable))
+	return b.String(), nil
+}
+
+// stripScope returns a package name without its scope, if any.
+func stripScope(name string) string {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+// dockerTag returns a version as a valid image tag, which may not contain "+".
+func dockerTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
@@ -0,0 +1,396 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiagnosticStatus is the outcome of a doctor check.
+type DiagnosticStatus string
+
+const (
+	DiagnosticOK      DiagnosticStatus = "ok"
+	DiagnosticWarning DiagnosticStatus = "warning"
+	DiagnosticError   DiagnosticStatus = "error"
+)
+
+// Diagnostic is the result of one check made by uni doctor.
+type Diagnostic struct {
+	Check   string
+	Status  DiagnosticStatus
+	Message string
+	// Suggested remedy for warnings and errors.
+	Fix string
+}
+
+// Below this many inotify watches, file watching in large repositories may
+// fail with "no space left on device".
+const minInotifyWatches = 65536
+
+// Diagnose checks the environment and installed dependencies of a repository
+// for common problems.
+func Diagnose(repo *Repository) []Diagnostic {
+	var diags []Diagnostic
+	diags = append(diags, diagnoseEngines(repo)...)
+	diags = append(diags, diagnoseSourceMapSupport(repo))
+	diags = append(diags, diagnoseLockfile(repo)...)
+	diags = append(diags, diagnoseExternals(repo)...)
+	diags = append(diags, diagnoseDuplicates(repo)...)
+	if diag, ok := diagnoseWatches(repo); ok {
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+func diagnoseEngines(repo *Repository) []Diagnostic {
+	env, err := AnalyzeEnvironment(repo)
+	if err != nil {
+		return []Diagnostic{{
+			Check:   "engines",
+			Status:  DiagnosticError,
+			Message: err.Error(),
+			Fix:     "install the engines configured in uni.yml and ensure they are on PATH",
+		}}
+	}
+	if len(env.Engines) == 0 {
+		return []Diagnostic{{
+			Check:   "engines",
+			Status:  DiagnosticWarning,
+			Message: "no engine versions configured",
+			Fix:     "pin the node version under engines in uni.yml",
+		}}
+	}
+	sort.Slice(env.Engines, func(i, j int) bool {
+		return env.Engines[i].Name < env.Engines[j].Name
+	})
+	var diags []Diagnostic
+	for _, engine := range env.Engines {
+		diag := Diagnostic{
+			Check:   "engines",
+			Status:  DiagnosticOK,
+			Message: fmt.Sprintf("%s version %s", engine.Name, engine.ActualVersion),
+		}
+		if !engine.OK {
+			diag.Status = DiagnosticError
+			diag.Message = fmt.Sprintf("expected %s version %s, but have %s", engine.Name, engine.ExpectedVersion, engine.ActualVersion)
+			diag.Fix = fmt.Sprintf("install %s %s, or update engines in uni.yml", engine.Name, engine.ExpectedVersion)
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+func diagnoseSourceMapSupport(repo *Repository) Diagnostic {
+	dir := path.Join(repo.RootDir, "node_modules", "source-map-support")
+	if _, err := os.Stat(dir); err != nil {
+		return Diagnostic{
+			Check:   "source-map-support",
+			Status:  DiagnosticError,
+			Message: "not installed; uni run requires it",
+			Fix:     "run `uni install`",
+		}
+	}
+	message := "installed"
+	if metadata, err := ReadPackageJSON(dir); err == nil && metadata.Version != "" {
+		message = fmt.Sprintf("version %s installed", metadata.Version)
+	}
+	return Diagnostic{
+		Check:   "source-map-support",
+		Status:  DiagnosticOK,
+		Message: message,
+	}
+}
+
+// packageLock is the subset of an npm package-lock.json file that is checked
+// by uni doctor. Lockfile version 2 and later list packages by their paths,
+// with the root package under the empty path.
+type packageLock struct {
+	LockfileVersion int `json:"lockfileVersion"`
+	Packages        map[string]struct {
+		Version      string            `json:"version"`
+		Dependencies map[string]string `json:"dependencies"`
+	} `json:"packages"`
+	Dependencies map[string]struct {
+		Version string `json:"version"`
+	} `json:"dependencies"`
+}
+
+func diagnoseLockfile(repo *Repository) []Diagnostic {
+	if pm := repo.PackageManager; pm != "" && pm != PackageManagerNpm {
+		// Only npm's lock file is checked against the installed versions.
+		if _, err := os.Stat(path.Join(repo.RootDir, pm.Lockfile())); err != nil {
+			return []Diagnostic{{
+				Check:   "lockfile",
+				Status:  DiagnosticWarning,
+				Message: fmt.Sprintf("%s not found; installs are not reproducible", pm.Lockfile()),
+				Fix:     fmt.Sprintf("run `uni install` and commit %s", pm.Lockfile()),
+			}}
+		}
+		return []Diagnostic{{
+			Check:   "lockfile",
+			Status:  DiagnosticOK,
+			Message: fmt.Sprintf("%s found", pm.Lockfile()),
+		}}
+	}
+
+	var lock packageLock
+	if err := ReadJSON(path.Join(repo.RootDir, "package-lock.json"), &lock); err != nil {
+		diag := Diagnostic{
+			Check:   "lockfile",
+			Status:  DiagnosticError,
+			Message: fmt.Sprintf("reading package-lock.json: %v", err),
+			Fix:     "run `uni install` to regenerate it",
+		}
+		if os.IsNotExist(err) {
+			diag.Status = DiagnosticWarning
+			diag.Message = "package-lock.json not found; installs are not reproducible"
+			diag.Fix = "run `uni install` and commit package-lock.json"
+		}
+		return []Diagnostic{diag}
+	}
+
+	// Map of package names to versions locked at the top of node_modules.
+	locked := make(map[string]string)
+	if lock.Packages != nil {
+		for key, pkg := range lock.Packages {
+			if name := strings.TrimPrefix(key, "node_modules/"); name != key && !strings.Contains(name, "/node_modules/") {
+				locked[name] = pkg.Version
+			}
+		}
+	} else {
+		for name, dep := range lock.Dependencies {
+			locked[name] = dep.Version
+		}
+	}
+
+	var stale []string
+	if root, ok := lock.Packages[""]; ok {
+		for name, dependency := range repo.Dependencies {
+			if root.Dependencies[name] != dependency.Version {
+				stale = append(stale, name)
+			}
+		}
+		for name := range root.Dependencies {
+			if _, ok := repo.Dependencies[name]; !ok {
+				stale = append(stale, name)
+			}
+		}
+	} else {
+		for name := range repo.Dependencies {
+			if _, ok := locked[name]; !ok {
+				stale = append(stale, name)
+			}
+		}
+	}
+	var mismatched []string
+	for name, version := range locked {
+		metadata, err := ReadPackageJSON(path.Join(repo.RootDir, "node_modules", name))
+		if err != nil {
+			mismatched = append(mismatched, fmt.Sprintf("%s (locked %s, not installed)", name, version))
+		} else if metadata.Version != version {
+			mismatched = append(mismatched, fmt.Sprintf("%s (locked %s, installed %s)", name, version, metadata.Version))
+		}
+	}
+
+	var diags []Diagnostic
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		diags = append(diags, Diagnostic{
+			Check:   "lockfile",
+			Status:  DiagnosticError,
+			Message: fmt.Sprintf("package-lock.json does not match configured dependencies: %s", strings.Join(stale, ", ")),
+			Fix:     "run `uni install`",
+		})
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		diags = append(diags, Diagnostic{
+			Check:   "lockfile",
+			Status:  DiagnosticError,
+			Message: fmt.Sprintf("node_modules does not match package-lock.json: %s", strings.Join(mismatched, ", ")),
+			Fix:     "run `uni install --frozen`",
+		})
+	}
+	if len(diags) == 0 {
+		diags = append(diags, Diagnostic{
+			Check:   "lockfile",
+			Status:  DiagnosticOK,
+			Message: "package-lock.json matches configured and installed dependencies",
+		})
+	}
+	return diags
+}
+
+// diagnoseExternals checks that each module left external by the repository
+// configuration, and so loaded from node_modules at runtime, is installed.
+func diagnoseExternals(repo *Repository) []Diagnostic {
+	required := make(map[string]string)
+	for name := range repo.Dependencies {
+		required[name] = "dependencies"
+	}
+	for _, pattern := range repo.Externals.External {
+		if !strings.Contains(pattern, "*") {
+			required[packageNameOf(pattern)] = "externals"
+		}
+	}
+	// Optional dependencies of packages may be absent.
+	for _, pkg := range repo.Packages {
+		names := append([]string{}, pkg.External...)
+		for name, peer := range pkg.PeerDependencies {
+			if !peer.Optional {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if _, ok := required[name]; !ok {
+				required[name] = fmt.Sprintf("package %s", pkg.Name)
+			}
+		}
+	}
+	var missing []string
+	for name, source := range required {
+		if _, err := os.Stat(path.Join(repo.RootDir, "node_modules", name)); err != nil {
+			missing = append(missing, fmt.Sprintf("%s (from %s)", name, source))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return []Diagnostic{{
+			Check:   "externals",
+			Status:  DiagnosticError,
+			Message: fmt.Sprintf("external modules cannot be resolved from node_modules: %s", strings.Join(missing, ", ")),
+			Fix:     "run `uni install`, after adding any that are not dependencies to uni.yml",
+		}}
+	}
+	return []Diagnostic{{
+		Check:   "externals",
+		Status:  DiagnosticOK,
+		Message: fmt.Sprintf("all %d external modules are installed", len(required)),
+	}}
+}
+
+// diagnoseDuplicates finds configured dependencies that are installed at more
+// than one version, such as when a nested copy is required by another
+// package. Duplicates of stateful libraries often misbehave at runtime.
+func diagnoseDuplicates(repo *Repository) []Diagnostic {
+	versions := make(map[string]map[string]bool)
+	collectInstalledVersions(path.Join(repo.RootDir, "node_modules"), versions)
+	var conflicts []string
+	for name := range repo.Dependencies {
+		if len(versions[name]) < 2 {
+			continue
+		}
+		var vs []string
+		for version := range versions[name] {
+			vs = append(vs, version)
+		}
+		sort.Strings(vs)
+		conflicts = append(conflicts, fmt.Sprintf("%s (%s)", name, strings.Join(vs, ", ")))
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return []Diagnostic{{
+			Check:   "duplicates",
+			Status:  DiagnosticWarning,
+			Message: fmt.Sprintf("dependencies installed at conflicting versions: %s", strings.Join(conflicts, ", ")),
+			Fix:     "align dependency versions in uni.yml with those required by other packages, then run `uni install`",
+		}}
+	}
+	return []Diagnostic{{
+		Check:   "duplicates",
+		Status:  DiagnosticOK,
+		Message: "no conflicting duplicate dependencies",
+	}}
+}
+
+// collectInstalledVersions records the versions of packages installed in a
+// node_modules directory and, recursively, those nested within them.
+func collectInstalledVersions(dir string, versions map[string]map[string]bool) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(name, "@") {
+			names = append(names, name)
+			continue
+		}
+		scoped, err := ioutil.ReadDir(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, entry := range scoped {
+			names = append(names, name+"/"+entry.Name())
+		}
+	}
+	for _, name := range names {
+		pkgDir := path.Join(dir, name)
+		var metadata struct {
+			Version string `json:"version"`
+		}
+		if err := ReadJSON(path.Join(pkgDir, "package.json"), &metadata); err == nil && metadata.Version != "" {
+			if versions[name] == nil {
+				versions[name] = make(map[string]bool)
+			}
+			versions[name][metadata.Version] = true
+		}
+		collectInstalledVersions(path.Join(pkgDir, "node_modules"), versions)
+	}
+}
+
+// diagnoseWatches checks the inotify watch limit on Linux, which watch mode
+// may exhaust. Not applicable elsewhere.
+func diagnoseWatches(repo *Repository) (Diagnostic, bool) {
+	if runtime.GOOS != "linux" {
+		return Diagnostic{}, false
+	}
+	bs, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return Diagnostic{}, false
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil {
+		return Diagnostic{}, false
+	}
+	if limit < minInotifyWatches {
+		return Diagnostic{
+			Check:   "watches",
+			Status:  DiagnosticWarning,
+			Message: fmt.Sprintf("inotify max_user_watches is %d; watch mode may fail in large repositories", limit),
+			Fix:     fmt.Sprintf("run `sudo sysctl fs.inotify.max_user_watches=%d` and persist it in /etc/sysctl.conf", 8*minInotifyWatches),
+		}, true
+	}
+	return Diagnostic{
+		Check:   "watches",
+		Status:  DiagnosticOK,
+		Message: fmt.Sprintf("inotify max_user_watches is %d", limit),
+	}, true
+}
+
+// DumpDiagnostics prints diagnostics, with suggested fixes, and reports
+// whether none of them are errors.
+func DumpDiagnostics(w io.Writer, diags []Diagnostic) bool {
+	ok := true
+	for _, diag := range diags {
+		fmt.Fprintf(w, "%-8s %s: %s\n", diag.Status, diag.Check, diag.Message)
+		if diag.Fix != "" && diag.Status != DiagnosticOK {
+			fmt.Fprintf(w, "         fix: %s\n", diag.Fix)
+		}
+		if diag.Status == DiagnosticError {
+			ok = false
+		}
+	}
+	return ok
+}
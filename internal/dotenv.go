@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Env files loaded by `uni run` from the repository root, if they exist. Later
+// files take precedence.
+var defaultEnvFiles = []string{".env", ".env.local"}
+
+// envFiles returns the absolute paths of the default env files followed by
+// the given extra files.
+func envFiles(repo *Repository, extra []string) []string {
+	var files []string
+	for _, name := range defaultEnvFiles {
+		files = append(files, path.Join(repo.RootDir, name))
+	}
+	return append(files, extra...)
+}
+
+// loadEnv returns the current environment extended with variables from env
+// files. Variables already set in the environment take precedence, and among
+// files later ones take precedence. Default env files may be missing, but
+// explicitly given files must exist.
+func loadEnv(repo *Repository, extra []string) ([]string, error) {
+	vars := make(map[string]string)
+	var names []string
+	for i, filename := range envFiles(repo, extra) {
+		fileVars, err := readEnvFile(filename)
+		if os.IsNotExist(err) && i < len(defaultEnvFiles) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range fileVars {
+			if _, exists := vars[v[0]]; !exists {
+				names = append(names, v[0])
+			}
+			vars[v[0]] = v[1]
+		}
+	}
+
+	env := os.Environ()
+	for _, name := range names {
+		if _, isSet := os.LookupEnv(name); !isSet {
+			env = append(env, name+"="+vars[name])
+		}
+	}
+	return env, nil
+}
+
+// readEnvFile parses a dotenv style file of KEY=VALUE lines, returning
+// key/value pairs in order. Blank lines, comments starting with #, and an
+// optional "export" prefix are allowed. Values may be single quoted, taken
+// literally, or double quoted, with Go-style escapes.
+func readEnvFile(filename string) ([][2]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars [][2]string
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", filename, lineno)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid quoted value: %w", filename, lineno, err)
+			}
+		default:
+			// Strip trailing comments from unquoted values.
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, scanner.Err()
+}
+
+// expandEnvValue replaces references of the form ${NAME} in a configured env
+// value with the result of lookup, or with the default given as
+// ${NAME:-default} if lookup fails or is empty. "$$" is a literal "$".
+// References to unknown names without a default expand to nothing.
+func expandEnvValue(value string, lookup func(name string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(value, '$')
+		if i < 0 || i == len(value)-1 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		b.WriteString(value[:i])
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			value = value[i+2:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			value = value[i+1:]
+			continue
+		}
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", value)
+		}
+		ref := value[i+2 : i+end]
+		value = value[i+end+1:]
+		name, fallback, hasFallback := ref, "", false
+		if j := strings.Index(ref, ":-"); j >= 0 {
+			name, fallback, hasFallback = ref[:j], ref[j+2:], true
+		}
+		if name == "" {
+			return "", fmt.Errorf("empty reference in %q", "${"+ref+"}")
+		}
+		if v, ok := lookup(name); ok && (v != "" || !hasFallback) {
+			b.WriteString(v)
+		} else {
+			b.WriteString(fallback)
+		}
+	}
+}
+
+// withEnv returns env, a list of KEY=VALUE strings, with the given variables
+// set, replacing any existing values.
+func withEnv(env []string, vars map[string]string) []string {
+	result := make([]string, 0, len(env)+len(vars))
+	for _, kv := range env {
+		name := kv
+		if eq := strings.IndexByte(kv, '='); eq >= 0 {
+			name = kv[:eq]
+		}
+		if _, ok := vars[name]; !ok {
+			result = append(result, kv)
+		}
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, name+"="+vars[name])
+	}
+	return result
+}
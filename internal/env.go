@@ -54,7 +54,14 @@ func AnalyzeEnvironment(repo *Repository) (*Environment, error) {
 	var env Environment
 	env.OK = true
 	for engineName, expectedVersion := range repo.Engines {
-		info, err := getEngineInfo(engineCache, engineName)
+		binpath := ""
+		if engineName == "node" {
+			binpath, err = NodeBinary(repo)
+			if err != nil {
+				return nil, fmt.Errorf("error checking node: %w", err)
+			}
+		}
+		info, err := getEngineInfo(engineCache, engineName, binpath)
 		if err != nil {
 			return nil, fmt.Errorf("error checking %s: %w", engineName, err)
 		}
@@ -62,7 +69,7 @@ func AnalyzeEnvironment(repo *Repository) (*Environment, error) {
 			Name:            engineName,
 			ActualVersion:   info.Version,
 			ExpectedVersion: expectedVersion,
-			OK:              info.Version == expectedVersion,
+			OK:              engineVersionOK(info.Version, expectedVersion),
 		}
 		env.Engines = append(env.Engines, engine)
 		env.OK = env.OK && engine.OK
@@ -78,21 +85,41 @@ func AnalyzeEnvironment(repo *Repository) (*Environment, error) {
 
 type engineInfo struct {
 	Version string    `json:"version"`
+	Path    string    `json:"path"`
 	ModTime time.Time `json:"modTime"`
 	Size    int64     `json:"size"`
 }
 
-// side-effect: updates cache.
-func getEngineInfo(cache map[string]engineInfo, name string) (engineInfo, error) {
+// engineVersionOK reports whether the actual version of an engine satisfies
+// the expected version, which may be an exact version or a range such as
+// ">=14.17".
+func engineVersionOK(actual string, expected string) bool {
+	if actual == expected {
+		return true
+	}
+	v, err := parseSemver(actual)
+	if err != nil {
+		return false
+	}
+	r, err := parseSemverRange(expected)
+	return err == nil && r.Contains(v)
+}
+
+// side-effect: updates cache. The binary is found on the PATH, unless binpath
+// is given.
+func getEngineInfo(cache map[string]engineInfo, name string, binpath string) (engineInfo, error) {
 	args, ok := engineCheckers[name]
 	if !ok {
 		return engineInfo{}, fmt.Errorf("no engine checker for %q", name)
 	}
 
 	// Check binary file.
-	binpath, err := exec.LookPath(name)
-	if err != nil {
-		return engineInfo{}, err
+	var err error
+	if binpath == "" {
+		binpath, err = exec.LookPath(name)
+		if err != nil {
+			return engineInfo{}, err
+		}
 	}
 	fileInfo, err := os.Stat(binpath)
 	if err != nil {
@@ -101,11 +128,12 @@ func getEngineInfo(cache map[string]engineInfo, name string) (engineInfo, error)
 
 	// Skip running binary if cached file matches.
 	res := engineInfo{
+		Path:    binpath,
 		ModTime: fileInfo.ModTime(),
 		Size:    fileInfo.Size(),
 	}
 	if cached, ok := cache[name]; ok {
-		if cached.ModTime.Equal(fileInfo.ModTime()) && cached.Size == fileInfo.Size() {
+		if cached.Path == binpath && cached.ModTime.Equal(fileInfo.ModTime()) && cached.Size == fileInfo.Size() {
 			res.Version = cached.Version
 			return res, nil
 		}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// prepareEval sets up opts to run source code given with RunOptions.Eval, as
+// `node -e` does. The code is written as a TypeScript module to dir, which is
+// its entrypoint, but its imports are resolved from the program's directory,
+// which defaults to the current directory. See evalPlugin.
+func prepareEval(opts *RunOptions, dir string) error {
+	switch {
+	case len(opts.Targets) > 0:
+		return errors.New("cannot evaluate code while running targets")
+	case opts.BuildOnly:
+		return errors.New("cannot evaluate code with --build-only")
+	case opts.Hot:
+		return errors.New("cannot evaluate code with hot reloading")
+	case opts.Watch && opts.Restart == RestartPrestart:
+		return errors.New("cannot evaluate code with the prestart restart strategy")
+	case opts.Runtime == RuntimeDeno:
+		return errors.New("cannot evaluate code with deno")
+	}
+	if opts.Dir == "" {
+		var err error
+		opts.Dir, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+	opts.Entrypoint = path.Join(dir, "eval.ts")
+	return ioutil.WriteFile(opts.Entrypoint, []byte(opts.Eval), 0644)
+}
+
+// evalPlugin loads evaluated code such that its imports resolve from resolveDir,
+// rather than from the temporary directory of its entrypoint.
+func evalPlugin(entrypoint string, code string, resolveDir string) api.Plugin {
+	return api.Plugin{
+		Name: "unirepo:eval",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter: "^" + regexp.QuoteMeta(entrypoint) + "$",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				return api.OnLoadResult{
+					Contents:   &code,
+					ResolveDir: resolveDir,
+					Loader:     api.LoaderTS,
+				}, nil
+			})
+		},
+	}
+}
+
+// writeEvalRunScript writes a script that loads a bundle of evaluated code.
+// Unlike writeRunScript, a main function is optional, and without one, the
+// process exits once it has nothing left to do, as with `node -e`.
+func writeEvalRunScript(scriptPath string, bundleName string, sourceMapSupport bool) error {
+	script := fmt.Sprintf(`const { main } = require(%s);
+if (typeof main === 'function') {
+	void (async () => {
+		const exitCode = await main(...process.argv.slice(2));
+		process.exit(exitCode ?? 0);
+	})();
+}
+`, strconv.Quote("./"+bundleName))
+	if sourceMapSupport {
+		script = "require('source-map-support').install();\n\n" + script
+	}
+	return ioutil.WriteFile(scriptPath, []byte(script), 0644)
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ExecOptions struct {
+	Package *Package
+	// Command and arguments to execute.
+	Command []string
+	// Skip building the package first. Otherwise, the package is built,
+	// unless it is up to date.
+	NoBuild bool
+	// Absolute paths of env files to load after the default .env files.
+	EnvFiles []string
+}
+
+// Exec runs a command in the context of a built package. Built packages are
+// made resolvable via NODE_PATH, the package's executables and those of
+// installed dependencies are put on PATH, and env files are loaded as for
+// Run.
+//
+// Status code may be returned within an exec.ExitError return value.
+func Exec(repo *Repository, opts ExecOptions) error {
+	pkg := opts.Package
+	if len(opts.Command) == 0 {
+		return errors.New("no command given")
+	}
+
+	if !opts.NoBuild {
+		if err := Build(repo, BuildOptions{Package: pkg}); err != nil {
+			return fmt.Errorf("building %s: %w", pkg.Name, err)
+		}
+	}
+
+	env, err := loadEnv(repo, opts.EnvFiles)
+	if err != nil {
+		return fmt.Errorf("loading env: %w", err)
+	}
+	distDir := path.Join(repo.OutDir, "dist")
+	packageDir := path.Join(distDir, pkg.Name)
+	env = prependEnvPath(env, "PATH", packageDir, path.Join(repo.RootDir, "node_modules", ".bin"))
+	env = prependEnvPath(env, "NODE_PATH", distDir, path.Join(repo.RootDir, "node_modules"))
+	env = append(env,
+		"UNI_PACKAGE="+pkg.Name,
+		"UNI_PACKAGE_DIR="+packageDir,
+	)
+
+	// Resolve the command using the amended PATH, rather than uni's own.
+	name := opts.Command[0]
+	if !strings.ContainsRune(name, filepath.Separator) {
+		for _, dir := range filepath.SplitList(lookupEnv(env, "PATH")) {
+			candidate := filepath.Join(dir, name)
+			if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() && fi.Mode()&0111 != 0 {
+				name = candidate
+				break
+			}
+		}
+	}
+	cmd := exec.Command(name, opts.Command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := repo.wrapCommand(cmd); err != nil {
+		return err
+	}
+
+	proc := newCmdProcess(cmd, repo.Shutdown)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if err := proc.Signal(sig); err != nil {
+				logEvent(os.Stderr, "error", nil, "could not forward %v: %v", sig, err)
+			}
+		}
+	}()
+
+	if err := proc.Start(); err != nil {
+		return err
+	}
+	return proc.Wait()
+}
+
+// commandEnv returns the environment for configured commands, such as tasks
+// and hooks, with env files loaded and installed executables on PATH.
+func commandEnv(repo *Repository) ([]string, error) {
+	env, err := loadEnv(repo, nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading env: %w", err)
+	}
+	return prependEnvPath(env, "PATH", path.Join(repo.RootDir, "node_modules", ".bin")), nil
+}
+
+// prependEnvPath returns env with dirs prepended to the path list variable
+// with the given name.
+func prependEnvPath(env []string, name string, dirs ...string) []string {
+	value := strings.Join(dirs, string(filepath.ListSeparator))
+	if existing := lookupEnv(env, name); existing != "" {
+		value += string(filepath.ListSeparator) + existing
+	}
+	return setEnv(env, name, value)
+}
+
+func lookupEnv(env []string, name string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			return kv[len(name)+1:]
+		}
+	}
+	return ""
+}
+
+func setEnv(env []string, name, value string) []string {
+	res := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			res = append(res, kv)
+		}
+	}
+	return append(res, name+"="+value)
+}
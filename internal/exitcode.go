@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// Exit codes of `uni run`, other than those of the program itself, which are
+// passed through. These are chosen to be unlikely to collide with the exit
+// codes of programs, following the conventions of shells and container
+// runtimes.
+const (
+	// Bundling the program failed.
+	ExitBuildFailed = 123
+	// An error in uni itself, including invalid arguments and configuration.
+	ExitInternalError = 125
+	// The program could not be started, such as when node is not installed.
+	ExitStartFailed = 126
+)
+
+// ErrBuildFailed is returned when a program is not run because bundling it
+// failed. Build messages have already been reported.
+var ErrBuildFailed = errors.New("build error")
+
+// StartError is returned when a program's process cannot be started, as
+// opposed to failing once started or failing the checks of a build.
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return "could not start: " + e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// ProgramExitCode returns the exit code of a program that exited
+// unsuccessfully, or false if err is not from the program exiting. A program
+// killed by signal N has exit code 128+N, as reported by shells.
+func ProgramExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+	return exitErr.ExitCode(), true
+}
+
+// RunExitCode returns the exit code of `uni run` for an error returned by Run.
+func RunExitCode(err error) int {
+	if code, ok := ProgramExitCode(err); ok {
+		return code
+	}
+	var startErr *StartError
+	switch {
+	case errors.Is(err, ErrBuildFailed):
+		return ExitBuildFailed
+	case errors.As(err, &startErr):
+		return ExitStartFailed
+	default:
+		return ExitInternalError
+	}
+}
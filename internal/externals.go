@@ -1,11 +1,292 @@
 package internal
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Externals overrides which modules are bundled and which are left external,
+// such that they are loaded from node_modules at runtime. By default, all
+// configured dependencies are external and everything else is bundled.
+//
+// Patterns are module names, optionally with one "*" wildcard that matches
+// any characters, such as "@aws-sdk/*". When a module matches more than one
+// pattern, the most specific pattern wins, which is the pattern with the most
+// characters other than the wildcard. Ties go to the later pattern, with
+// External after Bundle.
+type Externals struct {
+	Bundle   []string `json:"bundle,omitempty"`
+	External []string `json:"external,omitempty"`
+}
+
+func (externals Externals) Validate() error {
+	for _, patterns := range [][]string{externals.Bundle, externals.External} {
+		for _, pattern := range patterns {
+			if pattern == "" || strings.Count(pattern, "*") > 1 || strings.HasPrefix(pattern, ".") || strings.HasPrefix(pattern, "/") {
+				return fmt.Errorf("invalid module pattern: %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchModulePattern reports whether a module name matches a pattern.
+func matchModulePattern(pattern string, name string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == name
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	return len(name) >= len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+// getExternals returns the configured externals of CommonJS bundles.
 func getExternals(repo *Repository) []string {
-	externals := make([]string, len(repo.Dependencies))
-	i := 0
-	for external := range repo.Dependencies {
-		externals[i] = external
-		i++
+	return resolveExternals(repo, Externals{}, true)
+}
+
+// getPackageExternals returns the repository's externals plus any additional
+// externals configured for the package, including its peer and optional
+// dependencies.
+func getPackageExternals(repo *Repository, pkg *Package) []string {
+	return append(resolveExternals(repo, Externals{}, pkg.Format != FormatESModule), pkg.externals()...)
+}
+
+// resolveExternals returns the names of modules to leave external, given the
+// repository's configured externals followed by overrides. Wildcard patterns
+// match configured dependencies and packages installed in node_modules.
+//
+// Since CommonJS bundles load externals with require, which cannot load ES
+// modules, dependencies that are only published as ES modules are bundled
+// into CommonJS bundles, unless externalized by name. Conversely, packages
+// with native addons, which are loaded by relative paths that bundling
+// breaks, are always external unless bundled by name.
+func resolveExternals(repo *Repository, overrides Externals, commonJS bool) []string {
+	type rule struct {
+		pattern  string
+		external bool
 	}
+	var rules []rule
+	candidates := make(map[string]bool)
+	wildcard := false
+	for _, externals := range []Externals{repo.Externals, overrides} {
+		for _, pattern := range externals.Bundle {
+			rules = append(rules, rule{pattern, false})
+		}
+		for _, pattern := range externals.External {
+			rules = append(rules, rule{pattern, true})
+			if strings.Contains(pattern, "*") {
+				wildcard = true
+			} else {
+				candidates[pattern] = true
+			}
+		}
+	}
+	for name := range repo.Dependencies {
+		candidates[name] = true
+	}
+	if wildcard {
+		for _, name := range installedPackages(repo) {
+			candidates[name] = true
+		}
+	}
+	natives := repo.nativePackages()
+	for name := range natives {
+		candidates[name] = true
+	}
+
+	var externals []string
+	for name := range candidates {
+		_, external := repo.Dependencies[name]
+		specificity := -1
+		byName := false
+		for _, r := range rules {
+			n := len(strings.Replace(r.pattern, "*", "", 1))
+			if n >= specificity && matchModulePattern(r.pattern, name) {
+				specificity = n
+				external = r.external
+				byName = r.pattern == name
+			}
+		}
+		if external && !byName && commonJS && isESMOnlyPackage(repo, name) {
+			external = false
+		}
+		if _, native := natives[name]; native && !byName {
+			external = true
+		}
+		if external {
+			externals = append(externals, name)
+		}
+	}
+	sort.Strings(externals)
 	return externals
 }
+
+// isESMOnlyPackage reports whether an installed package can only be
+// imported, not required, according to its package.json.
+func isESMOnlyPackage(repo *Repository, name string) bool {
+	var metadata struct {
+		Type    string      `json:"type"`
+		Main    string      `json:"main"`
+		Exports interface{} `json:"exports"`
+	}
+	if err := ReadJSON(path.Join(repo.RootDir, "node_modules", name, "package.json"), &metadata); err != nil {
+		return false
+	}
+	isESM := func(target string) bool {
+		switch path.Ext(target) {
+		case ".mjs":
+			return true
+		case ".cjs":
+			return false
+		default:
+			return metadata.Type == "module"
+		}
+	}
+	if metadata.Exports == nil {
+		main := metadata.Main
+		if main == "" {
+			main = "index.js"
+		}
+		return isESM(main)
+	}
+	// Exports are ES modules only if no condition other than "import" leads to
+	// a CommonJS file.
+	var requirable func(exports interface{}) bool
+	requirable = func(exports interface{}) bool {
+		switch exports := exports.(type) {
+		case string:
+			return !isESM(exports)
+		case []interface{}:
+			for _, alternative := range exports {
+				if requirable(alternative) {
+					return true
+				}
+			}
+		case map[string]interface{}:
+			for key, value := range exports {
+				if key != "import" && requirable(value) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return !requirable(metadata.Exports)
+}
+
+// Packages that native addons depend on to build or load their binaries.
+var nativeAddonHelpers = []string{
+	"bindings",
+	"node-gyp-build",
+	"prebuild-install",
+	"node-pre-gyp",
+	"@mapbox/node-pre-gyp",
+	"node-addon-api",
+	"nan",
+}
+
+// nativePackages returns the names and versions of installed packages with
+// native addons. Results are cached until node_modules changes.
+func (repo *Repository) nativePackages() map[string]string {
+	dir := path.Join(repo.RootDir, "node_modules")
+	stamp := statFileStamp(dir)
+	repo.nativePackagesMx.Lock()
+	defer repo.nativePackagesMx.Unlock()
+	if repo.nativePackagesCache != nil && repo.nativePackagesStamp == stamp {
+		return repo.nativePackagesCache
+	}
+	natives := make(map[string]string)
+	for _, name := range installedPackages(repo) {
+		if version, ok := nativePackageVersion(path.Join(dir, name)); ok {
+			natives[name] = version
+		}
+	}
+	repo.nativePackagesCache = natives
+	repo.nativePackagesStamp = stamp
+	return natives
+}
+
+// nativePackageVersion returns the version of an installed package, and
+// whether it has a native addon. Addons are recognized by their node-gyp
+// build files, prebuilt binaries, or helper dependencies.
+func nativePackageVersion(dir string) (string, bool) {
+	var metadata struct {
+		Version      string            `json:"version"`
+		Gypfile      bool              `json:"gypfile"`
+		Binary       interface{}       `json:"binary"`
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if err := ReadJSON(path.Join(dir, "package.json"), &metadata); err != nil {
+		return "", false
+	}
+	if metadata.Gypfile || metadata.Binary != nil {
+		return metadata.Version, true
+	}
+	for _, name := range []string{"binding.gyp", "prebuilds", "build/Release"} {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return metadata.Version, true
+		}
+	}
+	for _, helper := range nativeAddonHelpers {
+		if _, ok := metadata.Dependencies[helper]; ok {
+			return metadata.Version, true
+		}
+	}
+	return metadata.Version, false
+}
+
+// installedPackages returns the names of packages installed in the
+// repository's node_modules directory.
+func installedPackages(repo *Repository) []string {
+	dir := path.Join(repo.RootDir, "node_modules")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !strings.HasPrefix(name, "@") {
+			names = append(names, name)
+			continue
+		}
+		scoped, err := ioutil.ReadDir(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, entry := range scoped {
+			names = append(names, name+"/"+entry.Name())
+		}
+	}
+	return names
+}
+
+// isNodeModulesPath reports whether a file path is inside any node_modules
+// directory. This accounts for both hoisted and nested dependency layouts.
+func isNodeModulesPath(filename string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(filename), "/") {
+		if segment == "node_modules" {
+			return true
+		}
+	}
+	return false
+}
+
+// packageNameOf returns the package name portion of a bare import path. For
+// example, "date-fns/format" is "date-fns" and "@scope/pkg/sub" is "@scope/pkg".
+func packageNameOf(importPath string) string {
+	parts := strings.SplitN(importPath, "/", 3)
+	if strings.HasPrefix(importPath, "@") && len(parts) >= 2 {
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// compileGlob converts a glob pattern to a regular expression. Patterns match
+// slash-separated paths. A "*" matches within a single path segment, and "**"
+// matches across any number of segments.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" also matches zero segments.
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// globSet matches paths relative to a root directory against a list of
+// glob patterns.
+type globSet struct {
+	root     string
+	patterns []*regexp.Regexp
+}
+
+func newGlobSet(root string, patterns []string) (*globSet, error) {
+	set := &globSet{root: root}
+	for _, pattern := range patterns {
+		re, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		set.patterns = append(set.patterns, re)
+	}
+	return set, nil
+}
+
+// Match reports whether a path matches any pattern. Absolute paths are made
+// relative to the root first.
+func (set *globSet) Match(name string) bool {
+	if set == nil {
+		return false
+	}
+	if filepath.IsAbs(name) {
+		if rel, err := filepath.Rel(set.root, name); err == nil {
+			name = rel
+		}
+	}
+	name = filepath.ToSlash(name)
+	for _, re := range set.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// globFiles returns the sorted absolute paths of files within the repository
+// that match any of the given patterns. Dependencies, hidden directories, and
+// the out directory are skipped.
+func globFiles(repo *Repository, patterns []string) ([]string, error) {
+	set, err := newGlobSet(repo.RootDir, patterns)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.Walk(repo.RootDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			name := fi.Name()
+			if file != repo.RootDir && (name == "node_modules" || strings.HasPrefix(name, ".") || file == repo.OutDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if set.Match(file) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
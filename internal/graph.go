@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// PackageGraph describes which packages import the index modules of other
+// packages.
+type PackageGraph struct {
+	Packages map[string]*Package
+	// Map of package name to sorted names of packages it depends on.
+	Dependencies map[string][]string
+	// Map of package name to absolute paths of all source files it loads.
+	Inputs map[string][]string
+	// Modification times and sizes of inputs when they were analyzed.
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFileStamp(filename string) fileStamp {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// upToDate reports whether no input has changed since the graph was loaded.
+func (graph *PackageGraph) upToDate() bool {
+	for filename, stamp := range graph.stamps {
+		if statFileStamp(filename) != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadPackageGraph analyzes the imports of every package in the repository.
+// The graph is remembered by the repository, and reused until its inputs
+// change, such as by a daemon.
+func LoadPackageGraph(repo *Repository) (*PackageGraph, error) {
+	repo.packageGraphMx.Lock()
+	defer repo.packageGraphMx.Unlock()
+	if graph := repo.packageGraph; graph != nil && graph.upToDate() {
+		return graph, nil
+	}
+
+	graph := &PackageGraph{
+		Packages:     repo.Packages,
+		Dependencies: make(map[string][]string),
+		Inputs:       make(map[string][]string),
+		stamps:       make(map[string]fileStamp),
+	}
+
+	indexOwners := make(map[string]string)
+	for _, pkg := range repo.Packages {
+		if pkg.Index != "" {
+			indexOwners[path.Join(repo.RootDir, pkg.Index)] = pkg.Name
+		}
+	}
+
+	for _, pkg := range repo.Packages {
+		inputs := analyzeInputs(repo, pkg)
+		graph.Inputs[pkg.Name] = inputs
+		for _, input := range inputs {
+			graph.stamps[input] = statFileStamp(input)
+		}
+		dependencies := []string{}
+		for _, input := range inputs {
+			if owner, ok := indexOwners[input]; ok && owner != pkg.Name {
+				dependencies = append(dependencies, owner)
+			}
+		}
+		sort.Strings(dependencies)
+		graph.Dependencies[pkg.Name] = dependencies
+	}
+
+	repo.packageGraph = graph
+	return graph, nil
+}
+
+// analyzeInputs bundles a package without writing output, collecting the
+// source files that would be loaded.
+func analyzeInputs(repo *Repository, pkg *Package) []string {
+	var entrypoints []string
+	for _, entrypoint := range pkg.entrypointPaths() {
+		entrypoints = append(entrypoints, path.Join(repo.RootDir, entrypoint))
+	}
+	return analyzeEntrypoints(repo, entrypoints, api.BuildOptions{
+		Platform: pkg.Platform.esbuildPlatform(),
+		External: getPackageExternals(repo, pkg),
+	})
+}
+
+// analyzeEntrypoints bundles entrypoints without writing output, returning
+// the sorted absolute paths of the source files that would be loaded. Only
+// the platform and externals of the given options are used. Build errors are
+// ignored, since a partial analysis is still useful and errors will be
+// reported by the subsequent real build.
+func analyzeEntrypoints(repo *Repository, entrypoints []string, opts api.BuildOptions) []string {
+	if len(entrypoints) == 0 {
+		return nil
+	}
+
+	var mx sync.Mutex
+	seen := make(map[string]struct{})
+	inputsPlugin := api.Plugin{
+		Name: "unirepo:inputs",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter: ".*",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				if args.Namespace == "file" {
+					mx.Lock()
+					seen[args.Path] = struct{}{}
+					mx.Unlock()
+				}
+				return api.OnLoadResult{}, nil
+			})
+		},
+	}
+
+	plugins := []api.Plugin{inputsPlugin}
+	if aliases := aliasesPlugin(repo); aliases != nil {
+		plugins = append(plugins, *aliases)
+	}
+
+	_ = api.Build(api.BuildOptions{
+		AbsWorkingDir: repo.RootDir,
+		EntryPoints:   entrypoints,
+		Outdir:        path.Join(repo.TmpDir, "analyze"),
+		Bundle:        true,
+		Platform:      opts.Platform,
+		Format:        api.FormatCommonJS,
+		Write:         false,
+		LogLevel:      api.LogLevelSilent,
+		External:      opts.External,
+		Loader:        getLoaders(repo),
+		Define:        repo.Define,
+		Plugins:       plugins,
+	})
+	inputs := make([]string, 0, len(seen))
+	for input := range seen {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	return inputs
+}
+
+// Order returns the given packages sorted such that every package comes after
+// the packages it depends on. Ties are broken by name.
+func (graph *PackageGraph) Order(packages map[string]*Package) ([]*Package, error) {
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var order []*Package
+	var stack []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			i := 0
+			for stack[i] != name {
+				i++
+			}
+			cycle := append(append([]string{}, stack[i:]...), name)
+			return fmt.Errorf("package dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dependency := range graph.Dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		if pkg, ok := packages[name]; ok {
+			order = append(order, pkg)
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Formats supported by PackageGraph.Write.
+const (
+	GraphFormatText = "text"
+	GraphFormatJSON = "json"
+	GraphFormatDot  = "dot"
+)
+
+// Write prints the package dependency graph in the given format.
+func (graph *PackageGraph) Write(w io.Writer, format string) error {
+	names := make([]string, 0, len(graph.Packages))
+	for name := range graph.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch format {
+	case GraphFormatText:
+		for _, name := range names {
+			fmt.Fprintln(w, name)
+			for _, dependency := range graph.Dependencies[name] {
+				fmt.Fprintf(w, "  -> %s\n", dependency)
+			}
+		}
+		return nil
+	case GraphFormatJSON:
+		bs, err := json.MarshalIndent(graph.Dependencies, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", bs)
+		return err
+	case GraphFormatDot:
+		fmt.Fprintln(w, "digraph packages {")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %q;\n", name)
+			for _, dependency := range graph.Dependencies[name] {
+				fmt.Fprintf(w, "  %q -> %q;\n", name, dependency)
+			}
+		}
+		fmt.Fprintln(w, "}")
+		return nil
+	default:
+		return fmt.Errorf("unknown graph format: %q", format)
+	}
+}
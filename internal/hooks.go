@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// buildHook runs before a package is built, or after it is built
+// successfully, once its package.json has been written.
+type buildHook interface {
+	Name() string
+	Run(build *buildHookContext) error
+}
+
+type buildHookContext struct {
+	Repository *Repository
+	Package    *Package
+	// Directory containing the built package. Empty or stale before building.
+	PackageDir string
+	// Version stamped into the built package, possibly empty.
+	Version string
+	Stderr  io.Writer
+}
+
+// runBuildHooks runs hooks in order, stopping at the first failure.
+func runBuildHooks(hooks []buildHook, build *buildHookContext) error {
+	for _, hook := range hooks {
+		if err := hook.Run(build); err != nil {
+			return fmt.Errorf("%s: %w", hook.Name(), err)
+		}
+	}
+	return nil
+}
+
+// commandHook runs a configured hook command. Commands are run with a shell
+// from the repository root, except that a path to a script with a JavaScript
+// or TypeScript extension is run with uni run.
+type commandHook struct {
+	stage   string
+	command string
+}
+
+func (hook *commandHook) Name() string {
+	return hook.stage + " hook"
+}
+
+func (hook *commandHook) Run(build *buildHookContext) error {
+	repo := build.Repository
+	var cmd *exec.Cmd
+	if isScriptPath(hook.command) {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command(self, "run", hook.command)
+	} else {
+		cmd = shellCommand(hook.command)
+	}
+	cmd.Dir = repo.RootDir
+
+	env, err := commandEnv(repo)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(env,
+		"UNI_PACKAGE="+build.Package.Name,
+		"UNI_PACKAGE_DIR="+build.PackageDir,
+		"UNI_VERSION="+build.Version,
+	)
+	cmd.Stdout = build.Stderr // Intentional redirect.
+	cmd.Stderr = build.Stderr
+	return cmd.Run()
+}
+
+// isScriptPath reports whether a hook command is a path to a script to be run
+// with uni run, rather than a shell command.
+func isScriptPath(command string) bool {
+	if strings.ContainsAny(command, " \t") {
+		return false
+	}
+	switch path.Ext(command) {
+	case ".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs":
+		return true
+	}
+	return false
+}
+
+// newCommandHooks returns hooks for the given stage, either "prebuild" or
+// "postbuild", that run the given commands.
+func newCommandHooks(stage string, commands []string) []buildHook {
+	hooks := make([]buildHook, len(commands))
+	for i, command := range commands {
+		hooks[i] = &commandHook{stage: stage, command: command}
+	}
+	return hooks
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// Runs the main function exported by a bundle, like the script written by
+// writeRunScript, but lets the process exit on its own rather than as soon as
+// main returns, so that long-running programs such as servers can be
+// reloaded. The exit code returned by main, if any, is used when the process
+// exits. Each line read from file descriptor 3 requests
+// a reload: the "dispose" function exported by the bundle, if any, is awaited,
+// then the bundle is evicted from the require cache and loaded again, and its
+// main function is called again. Modules that are not bundled, such as
+// dependencies loaded from node_modules, stay loaded.
+const hotRunScript = `require('source-map-support').install();
+
+const net = require('net');
+const { inspect } = require('util');
+process.on('uncaughtException', (exception) => {
+  process.stderr.write('uncaught exception: ' + inspect(exception) + '\n', () => {
+    process.exit(1);
+  });
+});
+process.on('unhandledRejection', (reason, promise) => {
+  process.stderr.write(
+    'unhandled rejection at: ' + inspect(promise) + '\nreason: ' + inspect(reason) + '\n',
+    () => {
+      process.exit(1);
+    },
+  );
+})
+
+const bundlePath = require.resolve(%s);
+const args = process.argv.slice(2);
+let current = null;
+
+const start = () => {
+  current = require(bundlePath);
+  if (typeof current.main !== 'function') {
+    process.stderr.write('error: ' + %s + ' does not export a main function\n', () => {
+      process.exit(1);
+    });
+    return;
+  }
+  void (async () => {
+    const exitCode = await current.main(...args);
+    if (typeof exitCode === 'number') {
+      process.exitCode = exitCode;
+    }
+  })();
+};
+
+let reloading = Promise.resolve();
+const reload = async () => {
+  if (typeof current.dispose === 'function') {
+    await current.dispose();
+  }
+  delete require.cache[bundlePath];
+  start();
+};
+
+const updates = new net.Socket({ fd: 3, readable: true, writable: false });
+let buffered = '';
+updates.setEncoding('utf8');
+updates.on('data', (chunk) => {
+  buffered += chunk;
+  const lines = buffered.split('\n');
+  buffered = lines.pop();
+  if (lines.length > 0) {
+    reloading = reloading.then(reload);
+  }
+});
+// Waiting for updates does not keep the process running by itself.
+updates.unref();
+
+start();
+`
+
+// writeHotRunScript writes a script like writeRunScript, but which reloads
+// the bundle when requested by a hotProcess.
+func writeHotRunScript(scriptPath string, bundleName string, entrypoint string) error {
+	script := fmt.Sprintf(hotRunScript, strconv.Quote("./"+bundleName), strconv.Quote(entrypoint))
+	return ioutil.WriteFile(scriptPath, []byte(script), 0644)
+}
+
+// hotProcess is a process run by a hot run script, which can reload its
+// bundle without restarting.
+type hotProcess struct {
+	*cmdProcess
+	// Read end of the pipe of reload requests, which is passed to the process.
+	requests *os.File
+	// Write end of the pipe.
+	updates *os.File
+}
+
+func newHotProcess(proc *cmdProcess) (*hotProcess, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	proc.cmd.ExtraFiles = []*os.File{r}
+	return &hotProcess{
+		cmdProcess: proc,
+		requests:   r,
+		updates:    w,
+	}, nil
+}
+
+func (proc *hotProcess) Start() error {
+	err := proc.cmdProcess.Start()
+	// The process has its own copy of the read end.
+	proc.requests.Close()
+	if err != nil {
+		proc.updates.Close()
+	}
+	return err
+}
+
+func (proc *hotProcess) Reload() error {
+	_, err := io.WriteString(proc.updates, "reload\n")
+	return err
+}
+
+func (proc *hotProcess) Kill() error {
+	err := proc.cmdProcess.Kill()
+	proc.updates.Close()
+	return err
+}
@@ -0,0 +1,437 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// JavaScript plugins, configured with the plugins key, are esbuild plugins
+// written for esbuild's JavaScript API. They are bundled together and loaded
+// into a node sidecar process, which stays running while uni does. Each of
+// their onResolve and onLoad callbacks is registered with esbuild as a Go
+// callback that calls the sidecar over its stdin and stdout, as esbuild's own
+// JavaScript API does with its Go binary.
+
+// Loads plugins from the bundle named by the first argument, describes them
+// to uni on stdout, and then calls their callbacks for requests read from
+// stdin, one JSON object per line. Output of the plugins themselves goes to
+// stderr, so as not to corrupt the protocol.
+const jsPluginRunner = `
+const readline = require('readline');
+const [, bundlePath] = process.argv;
+const write = process.stdout.write.bind(process.stdout);
+process.stdout.write = process.stderr.write.bind(process.stderr);
+const send = (message) => write(JSON.stringify(message) + '\n');
+const fail = (err) => {
+  console.error(err && err.stack || err);
+  process.exit(1);
+};
+const callbacks = [];
+const encodeMessages = (messages) => (messages || []).map((message) => ({
+  text: String(message.text || ''),
+  location: message.location || null,
+}));
+const encodeResult = (result) => {
+  result = Object.assign({}, result || {});
+  if (result.contents != null) {
+    result.contents = Buffer.from(result.contents).toString('base64');
+  }
+  result.errors = encodeMessages(result.errors);
+  result.warnings = encodeMessages(result.warnings);
+  return result;
+};
+(async () => {
+  const plugins = [];
+  for (const [spec, exported] of require(bundlePath)) {
+    let value = exported && exported.__esModule && 'default' in exported ? exported.default : exported;
+    if (typeof value === 'function') {
+      value = await value();
+    }
+    for (const plugin of [].concat(value)) {
+      if (!plugin || typeof plugin.setup !== 'function') {
+        throw new Error(spec + ': expected an esbuild plugin, an array of plugins, or a function returning either');
+      }
+      const description = { name: String(plugin.name || spec), onResolve: [], onLoad: [] };
+      const register = (list) => (options, callback) => {
+        if (!options || !(options.filter instanceof RegExp)) {
+          throw new Error(description.name + ': filter must be a regular expression');
+        }
+        list.push({ id: callbacks.length, filter: options.filter.source, namespace: options.namespace || '' });
+        callbacks.push(callback);
+      };
+      await plugin.setup({
+        initialOptions: {},
+        onResolve: register(description.onResolve),
+        onLoad: register(description.onLoad),
+        onStart() {},
+        onEnd() {},
+      });
+      plugins.push(description);
+    }
+  }
+  send({ plugins });
+  readline.createInterface({ input: process.stdin }).on('line', async (line) => {
+    const { id, callback, args } = JSON.parse(line);
+    try {
+      send({ id, result: encodeResult(await callbacks[callback](args)) });
+    } catch (err) {
+      send({ id, error: String(err && err.message || err) });
+    }
+  }).on('close', () => process.exit(0));
+})().catch(fail);
+`
+
+// jsPluginHost is a running sidecar process that hosts JavaScript plugins.
+type jsPluginHost struct {
+	stdin io.WriteCloser
+
+	mx      sync.Mutex
+	nextID  int
+	pending map[int]chan jsPluginResponse
+	// Why the sidecar can no longer be called, once it exits.
+	err error
+}
+
+type jsPluginDescription struct {
+	Name      string               `json:"name"`
+	OnResolve []jsPluginCallbackID `json:"onResolve"`
+	OnLoad    []jsPluginCallbackID `json:"onLoad"`
+}
+
+type jsPluginCallbackID struct {
+	ID        int    `json:"id"`
+	Filter    string `json:"filter"`
+	Namespace string `json:"namespace"`
+}
+
+type jsPluginRequest struct {
+	ID       int         `json:"id"`
+	Callback int         `json:"callback"`
+	Args     interface{} `json:"args"`
+}
+
+type jsPluginResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+type jsPluginMessage struct {
+	Text     string        `json:"text"`
+	Location *api.Location `json:"location"`
+}
+
+type jsPluginResult struct {
+	Path       string            `json:"path"`
+	External   bool              `json:"external"`
+	Namespace  string            `json:"namespace"`
+	Contents   *string           `json:"contents"`
+	ResolveDir string            `json:"resolveDir"`
+	Loader     string            `json:"loader"`
+	PluginData interface{}       `json:"pluginData"`
+	Errors     []jsPluginMessage `json:"errors"`
+	Warnings   []jsPluginMessage `json:"warnings"`
+}
+
+// jsPlugins returns esbuild plugins that call the repository's JavaScript
+// plugins, starting their sidecar on first use, along with a hash of their
+// code for build caches. Returns no plugins if none are configured.
+func jsPlugins(repo *Repository) ([]api.Plugin, string, error) {
+	if len(repo.PluginFiles) == 0 {
+		return nil, "", nil
+	}
+	repo.jsPluginsOnce.Do(func() {
+		repo.jsPlugins, repo.jsPluginsHash, repo.jsPluginsErr = startJSPlugins(repo)
+		if repo.jsPluginsErr != nil {
+			repo.jsPluginsErr = fmt.Errorf("loading plugins: %w", repo.jsPluginsErr)
+		}
+	})
+	return repo.jsPlugins, repo.jsPluginsHash, repo.jsPluginsErr
+}
+
+func startJSPlugins(repo *Repository) ([]api.Plugin, string, error) {
+	if err := EnsureTmp(repo); err != nil {
+		return nil, "", err
+	}
+	// Each process bundles plugins to its own directory, so that concurrent
+	// uni processes do not clobber each other's bundle. The host has loaded
+	// the bundle by the time it is ready, so the directory is not needed once
+	// the host is started.
+	dir, err := TempDir(repo, "plugins")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+	bundlePath := path.Join(dir, "plugins.js")
+
+	// The entry exports pairs of each configured plugin and its module, which
+	// is resolved from the project root.
+	var entry strings.Builder
+	entry.WriteString("module.exports = [\n")
+	for _, spec := range repo.PluginFiles {
+		quoted, _ := json.Marshal(spec)
+		fmt.Fprintf(&entry, "  [%s, require(%s)],\n", quoted, quoted)
+	}
+	entry.WriteString("];\n")
+
+	result := api.Build(api.BuildOptions{
+		AbsWorkingDir: repo.RootDir,
+		Stdin: &api.StdinOptions{
+			Contents:   entry.String(),
+			ResolveDir: repo.RootDir,
+			Sourcefile: "uni-plugins.js",
+		},
+		Outfile:   bundlePath,
+		Bundle:    true,
+		Platform:  api.PlatformNode,
+		Format:    api.FormatCommonJS,
+		Sourcemap: api.SourceMapInline,
+		Write:     true,
+		LogLevel:  api.LogLevelSilent,
+		Plugins:   []api.Plugin{externalPackagesPlugin()},
+	})
+	if len(result.Errors) > 0 {
+		printMessages(result.Errors, "error")
+		return nil, "", errors.New("bundling failed")
+	}
+	bundle, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(bundle)
+
+	node, err := nodeCommand(repo, "--enable-source-maps", "-e", jsPluginRunner, bundlePath)
+	if err != nil {
+		return nil, "", err
+	}
+	node.Dir = repo.RootDir
+	node.Stderr = os.Stderr
+	stdin, err := node.StdinPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	stdout, err := node.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := node.Start(); err != nil {
+		return nil, "", err
+	}
+
+	lines := bufio.NewReader(stdout)
+	line, err := lines.ReadBytes('\n')
+	if err != nil {
+		_ = node.Wait()
+		return nil, "", errors.New("plugin host exited")
+	}
+	var ready struct {
+		Plugins []jsPluginDescription `json:"plugins"`
+	}
+	if err := json.Unmarshal(line, &ready); err != nil {
+		_ = node.Process.Kill()
+		return nil, "", fmt.Errorf("reading plugin host: %w", err)
+	}
+
+	host := &jsPluginHost{
+		stdin:   stdin,
+		pending: make(map[int]chan jsPluginResponse),
+	}
+	go func() {
+		err := host.readResponses(lines)
+		if waitErr := node.Wait(); waitErr != nil {
+			err = fmt.Errorf("plugin host exited: %w", waitErr)
+		}
+		host.close(err)
+	}()
+
+	plugins := make([]api.Plugin, len(ready.Plugins))
+	for i, description := range ready.Plugins {
+		plugins[i] = host.plugin(description)
+	}
+	return plugins, hex.EncodeToString(sum[:]), nil
+}
+
+// externalPackagesPlugin leaves imports of packages external, so that they
+// are loaded from node_modules, as for config scripts.
+func externalPackagesPlugin() api.Plugin {
+	return api.Plugin{
+		Name: "unirepo:external-packages",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: `^[^./]`,
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if filepath.IsAbs(args.Path) {
+					return api.OnResolveResult{}, nil
+				}
+				return api.OnResolveResult{Path: args.Path, External: true}, nil
+			})
+		},
+	}
+}
+
+func (host *jsPluginHost) readResponses(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("plugin host exited")
+			}
+			return err
+		}
+		var resp jsPluginResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return fmt.Errorf("reading plugin host: %w", err)
+		}
+		host.mx.Lock()
+		reply := host.pending[resp.ID]
+		delete(host.pending, resp.ID)
+		host.mx.Unlock()
+		if reply != nil {
+			reply <- resp
+		}
+	}
+}
+
+// close fails pending and future calls with err.
+func (host *jsPluginHost) close(err error) {
+	host.mx.Lock()
+	defer host.mx.Unlock()
+	host.err = err
+	for id, reply := range host.pending {
+		reply <- jsPluginResponse{ID: id, Error: err.Error()}
+		delete(host.pending, id)
+	}
+}
+
+// call calls a plugin callback with args and decodes its result.
+func (host *jsPluginHost) call(callback int, args interface{}) (jsPluginResult, error) {
+	reply := make(chan jsPluginResponse, 1)
+	host.mx.Lock()
+	if host.err != nil {
+		host.mx.Unlock()
+		return jsPluginResult{}, host.err
+	}
+	id := host.nextID
+	host.nextID++
+	host.pending[id] = reply
+	bs, err := json.Marshal(jsPluginRequest{ID: id, Callback: callback, Args: args})
+	if err == nil {
+		_, err = host.stdin.Write(append(bs, '\n'))
+	}
+	if err != nil {
+		delete(host.pending, id)
+		host.mx.Unlock()
+		return jsPluginResult{}, err
+	}
+	host.mx.Unlock()
+
+	resp := <-reply
+	if resp.Error != "" {
+		return jsPluginResult{}, errors.New(resp.Error)
+	}
+	var result jsPluginResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return jsPluginResult{}, err
+	}
+	return result, nil
+}
+
+func (host *jsPluginHost) plugin(description jsPluginDescription) api.Plugin {
+	name := description.Name
+	return api.Plugin{
+		Name: name,
+		Setup: func(build api.PluginBuild) {
+			for _, callback := range description.OnResolve {
+				callback := callback
+				build.OnResolve(api.OnResolveOptions{
+					Filter:    callback.Filter,
+					Namespace: callback.Namespace,
+				}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					result, err := host.call(callback.ID, map[string]interface{}{
+						"path":       args.Path,
+						"importer":   args.Importer,
+						"namespace":  args.Namespace,
+						"resolveDir": args.ResolveDir,
+						"pluginData": args.PluginData,
+					})
+					if err != nil {
+						return api.OnResolveResult{}, err
+					}
+					return api.OnResolveResult{
+						PluginName: name,
+						Errors:     jsPluginMessages(result.Errors),
+						Warnings:   jsPluginMessages(result.Warnings),
+						Path:       result.Path,
+						External:   result.External,
+						Namespace:  result.Namespace,
+						PluginData: result.PluginData,
+					}, nil
+				})
+			}
+			for _, callback := range description.OnLoad {
+				callback := callback
+				build.OnLoad(api.OnLoadOptions{
+					Filter:    callback.Filter,
+					Namespace: callback.Namespace,
+				}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					result, err := host.call(callback.ID, map[string]interface{}{
+						"path":       args.Path,
+						"namespace":  args.Namespace,
+						"pluginData": args.PluginData,
+					})
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					loaded := api.OnLoadResult{
+						PluginName: name,
+						Errors:     jsPluginMessages(result.Errors),
+						Warnings:   jsPluginMessages(result.Warnings),
+						ResolveDir: result.ResolveDir,
+						PluginData: result.PluginData,
+					}
+					if result.Contents != nil {
+						contents, err := base64.StdEncoding.DecodeString(*result.Contents)
+						if err != nil {
+							return api.OnLoadResult{}, err
+						}
+						s := string(contents)
+						loaded.Contents = &s
+					}
+					if result.Loader != "" {
+						loader, ok := loadersByName[result.Loader]
+						if !ok {
+							return api.OnLoadResult{}, fmt.Errorf("unknown loader: %q", result.Loader)
+						}
+						loaded.Loader = loader
+					}
+					return loaded, nil
+				})
+			}
+		},
+	}
+}
+
+func jsPluginMessages(messages []jsPluginMessage) []api.Message {
+	converted := make([]api.Message, len(messages))
+	for i, message := range messages {
+		converted[i] = api.Message{
+			Text:     message.Text,
+			Location: message.Location,
+		}
+	}
+	return converted
+}
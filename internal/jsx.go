@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// JSXRuntime is how JSX elements are created.
+type JSXRuntime string
+
+const (
+	// Calls a factory function, React.createElement by default, which must be
+	// in scope wherever JSX is used.
+	JSXClassic JSXRuntime = "classic"
+	// Calls the functions of <importSource>/jsx-runtime, which are imported
+	// automatically, as introduced by React 17.
+	JSXAutomatic JSXRuntime = "automatic"
+)
+
+const defaultJSXImportSource = "react"
+
+// JSXOptions control how JSX in .jsx and .tsx files is compiled.
+type JSXOptions struct {
+	// Empty means classic.
+	Runtime JSXRuntime
+	// Package that provides jsx-runtime, for the automatic runtime. Empty
+	// means react.
+	ImportSource string
+	// Expressions that create elements and fragments, for the classic
+	// runtime. Empty means esbuild's defaults, React.createElement and
+	// React.Fragment.
+	Factory  string
+	Fragment string
+}
+
+// newJSXOptions returns options configured by cfg, with those it does not set
+// inherited from base. Changing the runtime inherits none of the options of
+// the other runtime.
+func newJSXOptions(base JSXOptions, cfg *JSXConfig) (JSXOptions, error) {
+	opts := base
+	if cfg == nil {
+		return opts, nil
+	}
+	if cfg.Runtime != "" {
+		runtime := JSXRuntime(cfg.Runtime)
+		switch runtime {
+		case JSXClassic, JSXAutomatic:
+		default:
+			return JSXOptions{}, fmt.Errorf("unknown runtime: %q, expected classic or automatic", cfg.Runtime)
+		}
+		if runtime != opts.runtime() {
+			opts = JSXOptions{}
+		}
+		opts.Runtime = runtime
+	}
+	if cfg.ImportSource != "" {
+		opts.ImportSource = cfg.ImportSource
+	}
+	if cfg.Factory != "" {
+		opts.Factory = cfg.Factory
+	}
+	if cfg.Fragment != "" {
+		opts.Fragment = cfg.Fragment
+	}
+	if opts.runtime() == JSXAutomatic {
+		if opts.Factory != "" || opts.Fragment != "" {
+			return JSXOptions{}, errors.New("factory and fragment apply only to the classic runtime")
+		}
+	} else if opts.ImportSource != "" {
+		return JSXOptions{}, errors.New("importSource applies only to the automatic runtime")
+	}
+	return opts, nil
+}
+
+func (opts JSXOptions) runtime() JSXRuntime {
+	if opts.Runtime == "" {
+		return JSXClassic
+	}
+	return opts.Runtime
+}
+
+func (opts JSXOptions) importSource() string {
+	if opts.ImportSource == "" {
+		return defaultJSXImportSource
+	}
+	return opts.ImportSource
+}
+
+// applyJSX sets the JSX options of an esbuild build.
+//
+// The bundled version of esbuild only supports the classic runtime, so the
+// automatic runtime is emulated: JSX is compiled to calls of functions in an
+// injected module, which adapt them to the functions of jsx-runtime. Unlike
+// with the classic runtime, React need not be in scope. The module is marked
+// free of side effects, so that it and its import of jsx-runtime are omitted
+// from bundles without JSX.
+func applyJSX(repo *Repository, opts JSXOptions, esbuildOpts *api.BuildOptions) error {
+	if opts.runtime() == JSXClassic {
+		esbuildOpts.JSXFactory = opts.Factory
+		esbuildOpts.JSXFragment = opts.Fragment
+		return nil
+	}
+	shim, err := writeJSXShim(repo, opts.importSource())
+	if err != nil {
+		return fmt.Errorf("writing jsx runtime shim: %w", err)
+	}
+	esbuildOpts.Inject = append(append([]string{}, esbuildOpts.Inject...), shim)
+	esbuildOpts.JSXFactory = "__uniJsx"
+	esbuildOpts.JSXFragment = "__uniFragment"
+	return nil
+}
+
+// writeJSXShim writes the module injected for the automatic runtime with the
+// given import source, returning its path. The module is written beneath the
+// tmp directory, so that the import source resolves from the repository's
+// node_modules.
+func writeJSXShim(repo *Repository, importSource string) (string, error) {
+	sum := sha256.Sum256([]byte(importSource))
+	dir := filepath.Join(repo.TmpDir, "jsx", hex.EncodeToString(sum[:])[:8])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	manifest := []byte(`{"sideEffects": false}` + "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "package.json"), manifest, 0644); err != nil {
+		return "", err
+	}
+	// Written in ES5, so that it compiles for any target.
+	shim := fmt.Sprintf(`import { jsx, jsxs, Fragment } from %s;
+
+export function __uniJsx(type, props) {
+  var config = {};
+  var key;
+  for (var name in props) {
+    if (name === "key") {
+      key = props[name];
+    } else {
+      config[name] = props[name];
+    }
+  }
+  var count = arguments.length - 2;
+  if (count === 1) {
+    config.children = arguments[2];
+  } else if (count > 1) {
+    config.children = Array.prototype.slice.call(arguments, 2);
+  }
+  return (count > 1 ? jsxs : jsx)(type, config, key);
+}
+
+export { Fragment as __uniFragment };
+`, strconv.Quote(importSource+"/jsx-runtime"))
+	filename := filepath.Join(dir, "runtime.js")
+	if err := ioutil.WriteFile(filename, []byte(shim), 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const (
+	DefaultLambdaRuntime = "nodejs20.x"
+	DefaultLambdaHandler = "handler"
+)
+
+// LambdaOptions control how a package is built with the lambda preset.
+type LambdaOptions struct {
+	// Lambda runtime identifier, such as nodejs20.x.
+	Runtime string
+	// Name of the function exported by the index module that handles events.
+	Handler string
+	// Include a source map in the artifact, and enable source maps in node.
+	SourceMap bool
+}
+
+var lambdaRuntimePattern = regexp.MustCompile(`^nodejs(\d+)\.x$`)
+
+var lambdaHandlerPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func newLambdaOptions(cfg *LambdaConfig) (*LambdaOptions, error) {
+	opts := &LambdaOptions{
+		Runtime:   cfg.Runtime,
+		Handler:   cfg.Handler,
+		SourceMap: cfg.SourceMap,
+	}
+	if opts.Runtime == "" {
+		opts.Runtime = DefaultLambdaRuntime
+	}
+	if !lambdaRuntimePattern.MatchString(opts.Runtime) {
+		return nil, fmt.Errorf("unsupported runtime: %q, expected a node runtime such as %s", opts.Runtime, DefaultLambdaRuntime)
+	}
+	if opts.Handler == "" {
+		opts.Handler = DefaultLambdaHandler
+	}
+	if !lambdaHandlerPattern.MatchString(opts.Handler) {
+		return nil, fmt.Errorf("invalid handler: %q", opts.Handler)
+	}
+	return opts, nil
+}
+
+// nodeMajor returns the major version of node that the runtime provides.
+func (opts *LambdaOptions) nodeMajor() int {
+	major, _ := strconv.Atoi(lambdaRuntimePattern.FindStringSubmatch(opts.Runtime)[1])
+	return major
+}
+
+// sdkExternals returns patterns of the AWS SDK modules that the runtime
+// provides: v3 since nodejs18.x, and v2 before.
+func (opts *LambdaOptions) sdkExternals() []string {
+	if opts.nodeMajor() >= 18 {
+		return []string{"@aws-sdk/*", "@smithy/*"}
+	}
+	return []string{"aws-sdk"}
+}
+
+// LambdaArtifactPath returns the path of the zip file built for a package
+// with the lambda preset.
+func LambdaArtifactPath(repo *Repository, pkg *Package) string {
+	return path.Join(repo.OutDir, "lambda", stripName(pkg.Name)+".zip")
+}
+
+// buildLambda builds a package as an AWS Lambda deployment package: a zip
+// file whose index.js exports the package's handler as "handler", so that
+// the function's handler setting is always index.handler. Everything is
+// bundled, except the AWS SDK provided by the runtime and modules
+// externalized by name, since the artifact has no node_modules.
+func buildLambda(repo *Repository, opts BuildOptions) error {
+	pkg := opts.Package
+	stderr := opts.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if opts.Watch {
+		return errors.New("the lambda preset cannot be used in watch mode")
+	}
+	if pkg.Index == "" {
+		return fmt.Errorf("package %q has no index module to export a handler", pkg.Name)
+	}
+	lambda := pkg.Lambda
+	if lambda == nil {
+		lambda, _ = newLambdaOptions(&LambdaConfig{})
+	}
+
+	if err := runCodegen(repo, stderr); err != nil {
+		return err
+	}
+	if err := EnsureTmp(repo); err != nil {
+		return err
+	}
+	dir, err := TempDir(repo, "lambda")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	esbuildOpts, err := standaloneEsbuildOptions(repo, opts, lambda.nodeMajor(), lambda.sdkExternals())
+	if err != nil {
+		return err
+	}
+	esbuildOpts.EntryPoints = []string{path.Join(repo.RootDir, pkg.Index)}
+	esbuildOpts.Outfile = path.Join(dir, "bundle.js")
+	if lambda.SourceMap {
+		esbuildOpts.Sourcemap = api.SourceMapLinked
+	}
+
+	artifactPath := LambdaArtifactPath(repo, pkg)
+	return buildAndWatch{
+		Repository: repo,
+		Esbuild:    esbuildOpts,
+		Package:    pkg,
+		Stderr:     opts.stderr,
+		CreateProcess: func() process {
+			return &funcProcess{
+				start: func() error {
+					if err := writeLambdaHandler(path.Join(dir, "index.js"), lambda, pkg.Index); err != nil {
+						return err
+					}
+					files := []string{"index.js", "bundle.js"}
+					if lambda.SourceMap {
+						files = append(files, "bundle.js.map")
+					}
+					if err := writeZip(artifactPath, dir, files); err != nil {
+						return fmt.Errorf("writing lambda artifact: %w", err)
+					}
+					logEvent(stderr, "lambda", logFields{"package": pkg.Name, "path": artifactPath, "runtime": lambda.Runtime},
+						"%s built for %s as %s, with handler index.handler", pkg.Name, lambda.Runtime, artifactPath)
+					return nil
+				},
+			}
+		},
+	}.Run()
+}
+
+// standaloneEsbuildOptions returns options for bundling a package's code to
+// run without node_modules, by the given major version of node. Everything is
+// bundled, except the given externals, the package's optional dependencies,
+// and modules externalized by name. Source maps are not written by default.
+func standaloneEsbuildOptions(repo *Repository, opts BuildOptions, nodeMajor int, externals []string) (api.BuildOptions, error) {
+	pkg := opts.Package
+	target, engines, err := parseTarget(fmt.Sprintf("node%d", nodeMajor))
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+	if opts.Target != "" {
+		target, engines, err = parseTarget(opts.Target)
+		if err != nil {
+			return api.BuildOptions{}, err
+		}
+	}
+	define := mergeDefines(repo, opts.Define)
+	if _, ok := define[nodeEnv]; !ok && opts.Production {
+		define[nodeEnv] = `"production"`
+	}
+	minify := pkg.Minify || opts.Minify || opts.Production
+	external := append(append([]string{}, externals...), pkg.OptionalDependencies...)
+	external = append(external, opts.Externals.External...)
+	esbuildOpts := api.BuildOptions{
+		AbsWorkingDir:     repo.RootDir,
+		Bundle:            true,
+		Platform:          api.PlatformNode,
+		Format:            api.FormatCommonJS,
+		Target:            target,
+		Engines:           engines,
+		Write:             true,
+		LogLevel:          api.LogLevelWarning,
+		Sourcemap:         api.SourceMapNone,
+		External:          external,
+		Loader:            getLoaders(repo),
+		Define:            define,
+		MinifyWhitespace:  minify,
+		MinifyIdentifiers: minify,
+		MinifySyntax:      minify,
+	}
+	if err := applyJSX(repo, pkg.JSX, &esbuildOpts); err != nil {
+		return api.BuildOptions{}, err
+	}
+	return esbuildOpts, nil
+}
+
+// writeLambdaHandler writes the module that Lambda loads, which exports the
+// handler of the bundle. Loading fails if there is no such handler, which
+// Lambda reports as an initialization error.
+func writeLambdaHandler(filename string, lambda *LambdaOptions, index string) error {
+	sourceMaps := ""
+	if lambda.SourceMap {
+		sourceMaps = "process.setSourceMapsEnabled(true);\n"
+	}
+	script := fmt.Sprintf(`%sconst handler = require('./bundle.js')[%[2]s];
+if (typeof handler !== 'function') {
+  throw new Error(%[3]s + ' does not export a function named ' + %[2]s);
+}
+exports.handler = handler;
+`, sourceMaps, strconv.Quote(lambda.Handler), strconv.Quote(index))
+	return ioutil.WriteFile(filename, []byte(script), 0644)
+}
+
+// writeZip writes the named files of dir to a zip file, at its root. Files are
+// readable by all and have a fixed modification time, so that the contents of
+// the zip file only depend on those of the files.
+func writeZip(filename string, dir string, names []string) error {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	modified := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range names {
+		bs, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: modified,
+		}
+		header.SetMode(0644)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
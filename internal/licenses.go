@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the file listing the licenses of bundled third-party packages,
+// which is written into built packages that bundle any.
+const noticesFileName = "THIRD_PARTY_NOTICES"
+
+// LicensePolicy restricts the licenses of third-party packages that may be
+// bundled into built packages. Licenses are SPDX identifiers, such as "MIT".
+type LicensePolicy struct {
+	// If not empty, only these licenses are allowed.
+	Allow []string
+	// Licenses that are never allowed.
+	Deny []string
+}
+
+// bundledLicense describes a third-party package bundled into a build.
+type bundledLicense struct {
+	Name    string
+	Version string
+	// SPDX license expression, or "UNKNOWN".
+	License string
+	// Contents of the package's license and notice files.
+	Text string
+}
+
+// findBundledLicenses returns the licenses of packages in node_modules that
+// contributed to a build, according to its metafile, sorted by name.
+func findBundledLicenses(repo *Repository, metafilePath string) ([]*bundledLicense, error) {
+	metafile, err := readMetafile(metafilePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading metafile: %w", err)
+	}
+	// Map of package directories to names.
+	dirs := make(map[string]string)
+	for _, input := range metafile.InputPaths(repo) {
+		if !isNodeModulesPath(input) {
+			continue
+		}
+		name := nodeModulesPackageName(input)
+		slashed := filepath.ToSlash(input)
+		i := strings.LastIndex(slashed, "node_modules/")
+		dirs[filepath.FromSlash(slashed[:i]+"node_modules/"+name)] = name
+	}
+
+	var licenses []*bundledLicense
+	for dir, name := range dirs {
+		license, err := readBundledLicense(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		licenses = append(licenses, license)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		a, b := licenses[i], licenses[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return licenses, nil
+}
+
+func readBundledLicense(dir string, name string) (*bundledLicense, error) {
+	var metadata struct {
+		Version string      `json:"version"`
+		License interface{} `json:"license"`
+		// Deprecated, but still found in older packages.
+		Licenses []struct {
+			Type string `json:"type"`
+		} `json:"licenses"`
+	}
+	if err := ReadJSON(path.Join(dir, "package.json"), &metadata); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	license := &bundledLicense{
+		Name:    name,
+		Version: metadata.Version,
+		License: "UNKNOWN",
+	}
+	switch l := metadata.License.(type) {
+	case string:
+		license.License = l
+	case map[string]interface{}:
+		if typ, ok := l["type"].(string); ok {
+			license.License = typ
+		}
+	default:
+		var types []string
+		for _, l := range metadata.Licenses {
+			types = append(types, l.Type)
+		}
+		if len(types) > 0 {
+			license.License = strings.Join(types, " OR ")
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var texts []string
+	for _, entry := range entries {
+		upper := strings.ToUpper(entry.Name())
+		if entry.IsDir() || !(strings.HasPrefix(upper, "LICENSE") || strings.HasPrefix(upper, "LICENCE") || strings.HasPrefix(upper, "COPYING") || strings.HasPrefix(upper, "NOTICE")) {
+			continue
+		}
+		bs, err := ioutil.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, strings.TrimSpace(string(bs)))
+	}
+	license.Text = strings.Join(texts, "\n\n")
+	return license, nil
+}
+
+// Check returns an error describing each license that is not allowed.
+func (policy *LicensePolicy) Check(licenses []*bundledLicense) error {
+	var violations []string
+	for _, license := range licenses {
+		if !policy.allows(license.License) {
+			violations = append(violations, fmt.Sprintf("%s@%s (%s)", license.Name, license.Version, license.License))
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("bundled packages with disallowed licenses: %s", strings.Join(violations, ", "))
+}
+
+// allows reports whether an SPDX license expression is allowed. An expression
+// of alternatives joined by OR is allowed if any alternative is, and one of
+// licenses joined by AND is allowed if all of them are.
+func (policy *LicensePolicy) allows(expression string) bool {
+	expression = strings.NewReplacer("(", " ", ")", " ").Replace(expression)
+	for _, alternative := range strings.Split(expression, " OR ") {
+		allowed := true
+		for _, license := range strings.Split(alternative, " AND ") {
+			license = strings.TrimSpace(license)
+			if containsFold(policy.Deny, license) || (len(policy.Allow) > 0 && !containsFold(policy.Allow, license)) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(strs []string, s string) bool {
+	for _, str := range strs {
+		if strings.EqualFold(str, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotices writes a summary of bundled licenses, followed by the text of
+// each.
+func writeNotices(w io.Writer, licenses []*bundledLicense) {
+	fmt.Fprintln(w, "This package bundles the following third-party packages:")
+	fmt.Fprintln(w)
+	for _, license := range licenses {
+		fmt.Fprintf(w, "  %s@%s  %s\n", license.Name, license.Version, license.License)
+	}
+	for _, license := range licenses {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, strings.Repeat("=", 80))
+		fmt.Fprintf(w, "%s@%s  %s\n", license.Name, license.Version, license.License)
+		if license.Text != "" {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, license.Text)
+		}
+	}
+}
+
+// writeBundledLicenses checks the licenses of third-party packages bundled
+// into a built package and, if there are any, lists them in a notices file.
+func writeBundledLicenses(repo *Repository, packageDir string, metafilePath string) error {
+	licenses, err := findBundledLicenses(repo, metafilePath)
+	if err != nil {
+		return err
+	}
+	if repo.Licenses != nil {
+		if err := repo.Licenses.Check(licenses); err != nil {
+			return err
+		}
+	}
+	if len(licenses) == 0 {
+		return nil
+	}
+	f, err := os.Create(path.Join(packageDir, noticesFileName))
+	if err != nil {
+		return err
+	}
+	writeNotices(f, licenses)
+	return f.Close()
+}
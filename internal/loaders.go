@@ -1,10 +1,58 @@
 package internal
 
-import "github.com/evanw/esbuild/pkg/api"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
 
 // WARNING: Temporarily turns these loaders in to effective no-ops.
 // TODO: Plugins or something, but probably not that.
-var loaders = map[string]api.Loader{
+var defaultLoaders = map[string]api.Loader{
 	".scss": api.LoaderText,
 	".svg":  api.LoaderText,
 }
+
+var loadersByName = map[string]api.Loader{
+	"js":      api.LoaderJS,
+	"jsx":     api.LoaderJSX,
+	"ts":      api.LoaderTS,
+	"tsx":     api.LoaderTSX,
+	"json":    api.LoaderJSON,
+	"text":    api.LoaderText,
+	"base64":  api.LoaderBase64,
+	"dataurl": api.LoaderDataURL,
+	"file":    api.LoaderFile,
+	"binary":  api.LoaderBinary,
+	"css":     api.LoaderCSS,
+}
+
+// parseLoaders validates a map of file extensions to loader names.
+func parseLoaders(cfg map[string]string) (map[string]api.Loader, error) {
+	loaders := make(map[string]api.Loader, len(cfg))
+	for ext, name := range cfg {
+		if !strings.HasPrefix(ext, ".") {
+			return nil, fmt.Errorf("loader extension must begin with a dot: %q", ext)
+		}
+		loader, ok := loadersByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown loader for %s: %q", ext, name)
+		}
+		loaders[ext] = loader
+	}
+	return loaders, nil
+}
+
+// getLoaders returns the default loaders, overridden by those configured for
+// the repository.
+func getLoaders(repo *Repository) map[string]api.Loader {
+	loaders := make(map[string]api.Loader, len(defaultLoaders)+len(repo.Loaders))
+	for ext, loader := range defaultLoaders {
+		loaders[ext] = loader
+	}
+	for ext, loader := range repo.Loaders {
+		loaders[ext] = loader
+	}
+	return loaders
+}
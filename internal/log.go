@@ -1,10 +1,232 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+// LogFormat controls how uni writes its own diagnostics and lifecycle
+// messages. Output of child processes is passed through unchanged.
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	// Each message is written as a JSON object on its own line. See logEvent.
+	LogFormatJSON LogFormat = "json"
+)
+
+var logFormat = LogFormatText
+
+func SetLogFormat(format string) error {
+	switch LogFormat(format) {
+	case LogFormatText, LogFormatJSON:
+		logFormat = LogFormat(format)
+		return nil
+	default:
+		return fmt.Errorf("invalid log format: %q", format)
+	}
+}
+
+var (
+	// Whether labels of prefixed output are colored.
+	logColor bool
+	// Whether text output is prefixed with the time of day.
+	logTimestamps bool
+)
+
+// SetLogColor enables or disables colored labels. See ColorSupported.
+func SetLogColor(enabled bool) {
+	logColor = enabled
+}
+
+// SetLogTimestamps enables or disables timestamps on lines of text output.
+func SetLogTimestamps(enabled bool) {
+	logTimestamps = enabled
+}
+
+// logSettings are the settings that control how uni writes to a writer.
+type logSettings struct {
+	Format     LogFormat
+	Color      bool
+	Timestamps bool
+}
+
+// logSettingsOf returns the settings for writing to w. Writers may carry
+// their own settings, such as the output of a daemon request, which is
+// written as the client requested. Otherwise, the global settings apply.
+func logSettingsOf(w io.Writer) logSettings {
+	for {
+		switch v := w.(type) {
+		case interface{ logSettings() logSettings }:
+			return v.logSettings()
+		case *prefixWriter:
+			w = v.w
+			continue
+		}
+		return logSettings{
+			Format:     logFormat,
+			Color:      logColor,
+			Timestamps: logTimestamps,
+		}
+	}
+}
+
+// ColorSupported reports whether colored output should be written to f by
+// default, which is when f is a terminal and the NO_COLOR environment
+// variable is not set.
+func ColorSupported(f *os.File) bool {
+	return isTerminal(f) && os.Getenv("NO_COLOR") == ""
+}
+
+const timestampLayout = "15:04:05.000"
+
+// Colors for labels, as ANSI foreground color codes. Red is omitted, so as
+// not to be confused with errors.
+var labelColors = []int{32, 33, 34, 35, 36, 92, 93, 94, 95, 96}
+
+var labelColorsMx sync.Mutex
+var labelColorIndexes = make(map[string]int)
+
+// colorLabel returns a colored label. Colors are assigned in order of first
+// use, so that concurrent labels are distinct.
+func colorLabel(label string) string {
+	labelColorsMx.Lock()
+	i, ok := labelColorIndexes[label]
+	if !ok {
+		i = len(labelColorIndexes)
+		labelColorIndexes[label] = i
+	}
+	labelColorsMx.Unlock()
+	color := labelColors[i%len(labelColors)]
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", color, label)
+}
+
+type logFields map[string]interface{}
+
+// logEvent writes a message about an event, such as a process starting or
+// exiting. In text mode, only the message is written, if not empty. In JSON
+// mode, an object with the time, event name, message, and given fields is
+// written, so that events without a text message are still reported.
+func logEvent(w io.Writer, event string, fields logFields, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	settings := logSettingsOf(w)
+	if settings.Format != LogFormatJSON {
+		if message != "" {
+			// Prefixed output is timestamped by the prefixWriter.
+			if _, prefixed := w.(*prefixWriter); settings.Timestamps && !prefixed {
+				message = time.Now().Format(timestampLayout) + " " + message
+			}
+			_, _ = fmt.Fprintln(w, message)
+		}
+		return
+	}
+	obj := make(logFields, len(fields)+3)
+	for k, v := range fields {
+		obj[k] = v
+	}
+	obj["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	obj["event"] = event
+	if message != "" {
+		obj["message"] = message
+	}
+	// Label events of prefixed output with a field instead, bypassing the
+	// prefixWriter, which passes output through unchanged in JSON mode.
+	if pw, ok := w.(*prefixWriter); ok {
+		obj["label"] = strings.Trim(pw.prefix, "[]")
+		pw.mx.Lock()
+		defer pw.mx.Unlock()
+		w = pw.w
+	}
+	writeJSONLine(w, obj)
+}
+
+func writeJSONLine(w io.Writer, obj logFields) {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		bs, _ = json.Marshal(logFields{"event": "error", "message": err.Error()})
+	}
+	// A single write, so that concurrent lines do not interleave.
+	_, _ = w.Write(append(bs, '\n'))
+}
+
+// LogError reports an error that terminates uni.
+func LogError(err error) {
+	logEvent(os.Stderr, "error", nil, "%v", err)
+}
+
 func Warnf(format string, args ...interface{}) {
+	if logFormat == LogFormatJSON {
+		logEvent(os.Stderr, "warning", nil, format, args...)
+		return
+	}
 	_, _ = fmt.Fprintf(os.Stderr, "warning: "+format+"\n", args...)
 }
+
+// prefixWriter writes complete lines to an underlying writer, prefixing each
+// with a label. In JSON mode, lines are written unchanged, and only events
+// logged to the writer are labeled. Writers sharing a mutex may be used
+// concurrently without interleaving partial lines.
+type prefixWriter struct {
+	mx     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(mx *sync.Mutex, w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{
+		mx:     mx,
+		w:      w,
+		prefix: prefix,
+	}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := pw.writeLine(pw.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+}
+
+// Flush writes any buffered partial line.
+func (pw *prefixWriter) Flush() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	err := pw.writeLine(append(pw.buf, '\n'))
+	pw.buf = nil
+	return err
+}
+
+func (pw *prefixWriter) writeLine(line []byte) error {
+	pw.mx.Lock()
+	defer pw.mx.Unlock()
+	settings := logSettingsOf(pw.w)
+	if settings.Format == LogFormatJSON {
+		// Program output is not uni's to annotate. See logEvent.
+		_, err := pw.w.Write(line)
+		return err
+	}
+	prefix := pw.prefix
+	if settings.Color {
+		prefix = colorLabel(prefix)
+	}
+	if settings.Timestamps {
+		prefix = time.Now().Format(timestampLayout) + " " + prefix
+	}
+	_, err := fmt.Fprintf(pw.w, "%s %s", prefix, line)
+	return err
+}
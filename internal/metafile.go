@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Metafile is the subset of esbuild's metafile used by unirepo.
+type Metafile struct {
+	Inputs map[string]MetafileInput `json:"inputs"`
+	// Map of output file paths, relative to the working directory.
+	Outputs map[string]MetafileOutput `json:"outputs"`
+}
+
+type MetafileInput struct {
+	Bytes   int              `json:"bytes"`
+	Imports []MetafileImport `json:"imports"`
+}
+
+type MetafileImport struct {
+	Path string `json:"path"`
+}
+
+type MetafileOutput struct {
+	Bytes  int                            `json:"bytes"`
+	Inputs map[string]MetafileOutputInput `json:"inputs"`
+}
+
+type MetafileOutputInput struct {
+	BytesInOutput int `json:"bytesInOutput"`
+}
+
+func readMetafile(filename string) (*Metafile, error) {
+	var metafile Metafile
+	if err := ReadJSON(filename, &metafile); err != nil {
+		return nil, err
+	}
+	return &metafile, nil
+}
+
+// InputPaths returns sorted absolute paths of all input files.
+func (metafile *Metafile) InputPaths(repo *Repository) []string {
+	paths := make([]string, 0, len(metafile.Inputs))
+	for input := range metafile.Inputs {
+		if !filepath.IsAbs(input) {
+			input = filepath.Join(repo.RootDir, input)
+		}
+		paths = append(paths, input)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ImportCycles returns import cycles among source files, excluding files in
+// node_modules. Each cycle is a path of input files that begins and ends with
+// the same file. One cycle is reported per strongly connected group of files.
+func (metafile *Metafile) ImportCycles() [][]string {
+	graph := make(map[string][]string)
+	var nodes []string
+	for input, info := range metafile.Inputs {
+		if isNodeModulesPath(input) {
+			continue
+		}
+		nodes = append(nodes, input)
+		for _, imp := range info.Imports {
+			if _, ok := metafile.Inputs[imp.Path]; ok && !isNodeModulesPath(imp.Path) {
+				graph[input] = append(graph[input], imp.Path)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	for _, edges := range graph {
+		sort.Strings(edges)
+	}
+
+	// Tarjan's strongly connected components algorithm.
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+	var connect func(node string)
+	connect = func(node string) {
+		index[node] = len(index)
+		lowlink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range graph[node] {
+			if _, visited := index[next]; !visited {
+				connect(next)
+				if lowlink[next] < lowlink[node] {
+					lowlink[node] = lowlink[next]
+				}
+			} else if onStack[next] && index[next] < lowlink[node] {
+				lowlink[node] = index[next]
+			}
+		}
+		if lowlink[node] == index[node] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	for _, node := range nodes {
+		if _, visited := index[node]; !visited {
+			connect(node)
+		}
+	}
+
+	var cycles [][]string
+	for _, component := range components {
+		members := make(map[string]bool, len(component))
+		for _, node := range component {
+			members[node] = true
+		}
+		sort.Strings(component)
+		start := component[0]
+		if len(component) == 1 && !containsString(graph[start], start) {
+			continue
+		}
+		cycles = append(cycles, findCycle(graph, members, start))
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+// findCycle returns a path from start back to itself, staying within members.
+// Such a path must exist because members are strongly connected.
+func findCycle(graph map[string][]string, members map[string]bool, start string) []string {
+	// Breadth-first search for the shortest cycle.
+	prev := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[node] {
+			if !members[next] {
+				continue
+			}
+			if next == start {
+				cycle := []string{start}
+				for n := node; n != start; n = prev[n] {
+					cycle = append(cycle, n)
+				}
+				cycle = append(cycle, start)
+				for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+					cycle[i], cycle[j] = cycle[j], cycle[i]
+				}
+				return cycle
+			}
+			if _, seen := prev[next]; !seen {
+				prev[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return []string{start, start}
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteAnalysis prints the size of each JavaScript output, the inputs that
+// contribute most to it, and the sizes of bundled dependencies.
+func (metafile *Metafile) WriteAnalysis(w io.Writer, top int) {
+	outputs := make([]string, 0, len(metafile.Outputs))
+	for output := range metafile.Outputs {
+		if strings.HasSuffix(output, ".map") {
+			continue
+		}
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+
+	total := 0
+	for _, output := range outputs {
+		info := metafile.Outputs[output]
+		total += info.Bytes
+		fmt.Fprintf(w, "%s  %s\n", output, formatBytes(info.Bytes))
+
+		dependencies := make(map[string]int)
+		for input, inputInfo := range info.Inputs {
+			if isNodeModulesPath(input) {
+				dependencies[nodeModulesPackageName(input)] += inputInfo.BytesInOutput
+			}
+		}
+		for _, input := range metafile.LargestInputs(output, top) {
+			fmt.Fprintf(w, "  %-50s %10s %6s\n", input.name, formatBytes(input.bytes), formatPercent(input.bytes, info.Bytes))
+		}
+
+		if len(dependencies) > 0 {
+			var deps []sizeContribution
+			for name, bytes := range dependencies {
+				deps = append(deps, sizeContribution{name, bytes})
+			}
+			sortContributions(deps)
+			if len(deps) > top {
+				deps = deps[:top]
+			}
+			fmt.Fprintf(w, "  bundled dependencies:\n")
+			for _, dep := range deps {
+				fmt.Fprintf(w, "    %-48s %10s %6s\n", dep.name, formatBytes(dep.bytes), formatPercent(dep.bytes, info.Bytes))
+			}
+		}
+	}
+	fmt.Fprintf(w, "total  %s\n", formatBytes(total))
+}
+
+// sizeContribution is the number of bytes that an input contributes to an
+// output.
+type sizeContribution struct {
+	name  string
+	bytes int
+}
+
+func sortContributions(contributions []sizeContribution) {
+	sort.Slice(contributions, func(i, j int) bool {
+		a, b := contributions[i], contributions[j]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return a.name < b.name
+	})
+}
+
+// LargestInputs returns up to top inputs that contribute the most bytes to an
+// output, largest first.
+func (metafile *Metafile) LargestInputs(output string, top int) []sizeContribution {
+	var inputs []sizeContribution
+	for input, inputInfo := range metafile.Outputs[output].Inputs {
+		inputs = append(inputs, sizeContribution{input, inputInfo.BytesInOutput})
+	}
+	sortContributions(inputs)
+	if len(inputs) > top {
+		inputs = inputs[:top]
+	}
+	return inputs
+}
+
+// nodeModulesPackageName returns the name of the package containing a file
+// in the innermost node_modules directory of its path.
+func nodeModulesPackageName(filename string) string {
+	filename = filepath.ToSlash(filename)
+	const marker = "node_modules/"
+	i := strings.LastIndex(filename, marker)
+	if i < 0 {
+		return filename
+	}
+	return packageNameOf(filename[i+len(marker):])
+}
+
+func formatBytes(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+	}
+}
+
+func formatPercent(n, total int) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
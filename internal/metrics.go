@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of watch mode, exposed in the Prometheus text format by
+// ServeMetrics. They are labeled by package, which is empty for programs
+// outside of any package.
+var watchMetrics = &metricsRegistry{
+	rebuilds:     make(map[metricKey]int),
+	durations:    make(map[string]*histogram),
+	restarts:     make(map[string]int),
+	watchedFiles: make(map[string]int),
+}
+
+// Upper bounds, in seconds, of the buckets of the rebuild duration histogram.
+var rebuildDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricKey struct {
+	pkg    string
+	result string
+}
+
+type histogram struct {
+	counts []int
+	count  int
+	sum    float64
+}
+
+type metricsRegistry struct {
+	mx           sync.Mutex
+	rebuilds     map[metricKey]int
+	durations    map[string]*histogram
+	restarts     map[string]int
+	watchedFiles map[string]int
+}
+
+// recordRebuild counts a rebuild in watch mode, and how long it took.
+func (reg *metricsRegistry) recordRebuild(pkg string, elapsed time.Duration, failed bool) {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	result := "success"
+	if failed {
+		result = "failure"
+	}
+	reg.rebuilds[metricKey{pkg, result}]++
+	h := reg.durations[pkg]
+	if h == nil {
+		h = &histogram{counts: make([]int, len(rebuildDurationBuckets))}
+		reg.durations[pkg] = h
+	}
+	seconds := elapsed.Seconds()
+	for i, bound := range rebuildDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// recordRestart counts a start of a process that replaces an earlier one,
+// whether due to changes, a forced restart, or a crash.
+func (reg *metricsRegistry) recordRestart(pkg string) {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	reg.restarts[pkg]++
+}
+
+// setWatchedFiles records the number of files that are watched as inputs.
+func (reg *metricsRegistry) setWatchedFiles(pkg string, n int) {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	reg.watchedFiles[pkg] = n
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (reg *metricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	reg.mx.Lock()
+	defer reg.mx.Unlock()
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# HELP uni_rebuilds_total Number of rebuilds in watch mode.\n")
+	fmt.Fprintf(&b, "# TYPE uni_rebuilds_total counter\n")
+	keys := make([]metricKey, 0, len(reg.rebuilds))
+	for key := range reg.rebuilds {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pkg != keys[j].pkg {
+			return keys[i].pkg < keys[j].pkg
+		}
+		return keys[i].result < keys[j].result
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "uni_rebuilds_total{package=%s,result=%q} %d\n", metricLabel(key.pkg), key.result, reg.rebuilds[key])
+	}
+
+	fmt.Fprintf(&b, "# HELP uni_rebuild_duration_seconds Time taken by rebuilds in watch mode, including code generation.\n")
+	fmt.Fprintf(&b, "# TYPE uni_rebuild_duration_seconds histogram\n")
+	pkgs := make([]string, 0, len(reg.durations))
+	for pkg := range reg.durations {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		h := reg.durations[pkg]
+		label := metricLabel(pkg)
+		for i, bound := range rebuildDurationBuckets {
+			fmt.Fprintf(&b, "uni_rebuild_duration_seconds_bucket{package=%s,le=\"%g\"} %d\n", label, bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "uni_rebuild_duration_seconds_bucket{package=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(&b, "uni_rebuild_duration_seconds_sum{package=%s} %g\n", label, h.sum)
+		fmt.Fprintf(&b, "uni_rebuild_duration_seconds_count{package=%s} %d\n", label, h.count)
+	}
+
+	fmt.Fprintf(&b, "# HELP uni_restarts_total Number of times a process was restarted in watch mode.\n")
+	fmt.Fprintf(&b, "# TYPE uni_restarts_total counter\n")
+	for _, pkg := range sortedMetricPackages(reg.restarts) {
+		fmt.Fprintf(&b, "uni_restarts_total{package=%s} %d\n", metricLabel(pkg), reg.restarts[pkg])
+	}
+
+	fmt.Fprintf(&b, "# HELP uni_watched_files Number of files watched for changes as build inputs.\n")
+	fmt.Fprintf(&b, "# TYPE uni_watched_files gauge\n")
+	for _, pkg := range sortedMetricPackages(reg.watchedFiles) {
+		fmt.Fprintf(&b, "uni_watched_files{package=%s} %d\n", metricLabel(pkg), reg.watchedFiles[pkg])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func sortedMetricPackages(values map[string]int) []string {
+	pkgs := make([]string, 0, len(values))
+	for pkg := range values {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// metricLabel quotes a label value, escaping as the text format requires.
+func metricLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+// ServeMetrics listens on addr and serves metrics of watch mode at /metrics,
+// in the background, for monitoring long running development environments.
+func ServeMetrics(addr string, stderr io.Writer) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("serving metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = watchMetrics.WriteTo(w)
+	})
+	url := "http://" + listener.Addr().String() + "/metrics"
+	logEvent(stderr, "metrics", logFields{"url": url}, "serving metrics at %s", url)
+	go func() {
+		_ = http.Serve(listener, mux)
+	}()
+	return nil
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// NodeManager is a tool that installs versions of node and selects among
+// them, which may be used to find the node binary to run.
+type NodeManager string
+
+const (
+	NodeManagerVolta NodeManager = "volta"
+	NodeManagerFnm   NodeManager = "fnm"
+)
+
+// NodeOptions control which node binary is run.
+type NodeOptions struct {
+	// Absolute path of the node binary, if configured.
+	Path string
+	// Manager to ask for the node binary, if configured.
+	Manager NodeManager
+}
+
+func newNodeOptions(rootDir string, cfg *NodeConfig) (NodeOptions, error) {
+	opts := NodeOptions{
+		Manager: NodeManager(cfg.Manager),
+	}
+	switch opts.Manager {
+	case "", NodeManagerVolta, NodeManagerFnm:
+	default:
+		return NodeOptions{}, fmt.Errorf("unknown manager: %q, expected volta or fnm", cfg.Manager)
+	}
+	if cfg.Path != "" {
+		if opts.Manager != "" {
+			return NodeOptions{}, errors.New("path and manager are mutually exclusive")
+		}
+		opts.Path = cfg.Path
+		if !filepath.IsAbs(opts.Path) {
+			opts.Path = filepath.Join(rootDir, opts.Path)
+		}
+	}
+	return opts, nil
+}
+
+// NodeBinary returns the path of the node binary to run: the configured path,
+// the binary selected by the configured manager for the repository, or else
+// node found on the PATH.
+func NodeBinary(repo *Repository) (string, error) {
+	repo.nodeMx.Lock()
+	defer repo.nodeMx.Unlock()
+	if repo.nodeBinary != "" {
+		return repo.nodeBinary, nil
+	}
+	var binary string
+	var err error
+	switch {
+	case repo.Node.Path != "":
+		binary = repo.Node.Path
+	case repo.Node.Manager == NodeManagerVolta:
+		binary, err = managedNodeBinary(repo, "volta", "which", "node")
+	case repo.Node.Manager == NodeManagerFnm:
+		args := []string{"exec"}
+		// Otherwise, fnm selects a version by files such as .node-version.
+		if version, ok := repo.Engines["node"]; ok && isExactVersion(version) {
+			args = append(args, "--using="+version)
+		}
+		args = append(args, "--", "node", "-p", "process.execPath")
+		binary, err = managedNodeBinary(repo, "fnm", args...)
+	default:
+		binary, err = exec.LookPath("node")
+	}
+	if err != nil {
+		return "", err
+	}
+	repo.nodeBinary = binary
+	return binary, nil
+}
+
+// managedNodeBinary runs a node manager in the repository root, returning the
+// path of a node binary that it prints.
+func managedNodeBinary(repo *Repository, manager string, args ...string) (string, error) {
+	cmd := exec.Command(manager, args...)
+	cmd.Dir = repo.RootDir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("finding node with %s: %s", manager, msg)
+		}
+		return "", fmt.Errorf("finding node with %s: %w", manager, err)
+	}
+	binary := strings.TrimSpace(string(out))
+	if binary == "" {
+		return "", fmt.Errorf("finding node with %s: no path output", manager)
+	}
+	return binary, nil
+}
+
+// nodeCommand returns a command that runs node with the given arguments.
+func nodeCommand(repo *Repository, args ...string) (*exec.Cmd, error) {
+	binary, err := NodeBinary(repo)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(binary, args...), nil
+}
+
+// isExactVersion reports whether a version constraint is a single version,
+// rather than a range.
+func isExactVersion(s string) bool {
+	_, err := parseSemver(s)
+	return err == nil
+}
+
+// nodeEngines returns the esbuild engine constraint for the lowest version of
+// node allowed by the engines config, if any, so that syntax that version does
+// not support is transformed.
+func nodeEngines(repo *Repository) []api.Engine {
+	constraint, ok := repo.Engines["node"]
+	if !ok {
+		return nil
+	}
+	r, err := parseSemverRange(constraint)
+	if err != nil {
+		return nil
+	}
+	min, ok := r.Min()
+	if !ok {
+		return nil
+	}
+	return []api.Engine{{
+		Name:    api.EngineNode,
+		Version: fmt.Sprintf("%d.%d.%d", min.Major, min.Minor, min.Patch),
+	}}
+}
@@ -1,22 +1,124 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path"
+	"sort"
 )
 
 type PackageMetadata struct {
-	Name          string            `json:"name,omitempty"`
-	Description   string            `json:"description,omitempty"`
-	Version       string            `json:"version,omitempty"`
-	Private       bool              `json:"private"`
-	Repository    string            `json:"repository,omitempty"`
-	Main          string            `json:"main,omitempty"`
-	Bin           map[string]string `json:"bin,omitempty"`
-	Dependencies  map[string]string `json:"dependencies,omitempty"`
-	Scripts       map[string]string `json:"scripts,omitempty"`
-	PublishConfig *PublishConfig    `json:"publishConfig,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Version     string      `json:"version,omitempty"`
+	Private     bool        `json:"private"`
+	Repository  string      `json:"repository,omitempty"`
+	Main        string      `json:"main,omitempty"`
+	Browser     string      `json:"browser,omitempty"`
+	Exports     interface{} `json:"exports,omitempty"`
+	Types       string      `json:"types,omitempty"`
+	Style       string      `json:"style,omitempty"`
+	// Either a bool or globs of files with side effects, if declared.
+	SideEffects  interface{}       `json:"sideEffects,omitempty"`
+	Bin          map[string]string `json:"bin,omitempty"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+	// Peer dependencies, with optional ones marked by PeerDependenciesMeta.
+	PeerDependencies     map[string]string             `json:"peerDependencies,omitempty"`
+	PeerDependenciesMeta map[string]PeerDependencyMeta `json:"peerDependenciesMeta,omitempty"`
+	OptionalDependencies map[string]string             `json:"optionalDependencies,omitempty"`
+	Scripts              map[string]string             `json:"scripts,omitempty"`
+	PublishConfig        *PublishConfig                `json:"publishConfig,omitempty"`
+	// Additional fields, such as license and keywords, which are written after
+	// the others. Objects are merged with generated objects of the same name,
+	// such as publishConfig, whose own fields take precedence.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Fields of package.json files that are always generated, and so may not be
+// configured with packageJson.
+var generatedPackageFields = []string{
+	"name",
+	"version",
+	"private",
+	"main",
+	"browser",
+	"exports",
+	"types",
+	"style",
+	"bin",
+	"dependencies",
+	"peerDependencies",
+	"peerDependenciesMeta",
+	"optionalDependencies",
+}
+
+func validatePackageFields(fields map[string]interface{}) error {
+	for _, name := range generatedPackageFields {
+		if _, ok := fields[name]; ok {
+			return fmt.Errorf("package.json field %q is generated and may not be configured", name)
+		}
+	}
+	return nil
+}
+
+func (metadata PackageMetadata) MarshalJSON() ([]byte, error) {
+	type plain PackageMetadata
+	bs, err := marshalJSON(plain(metadata))
+	if err != nil || len(metadata.Extra) == 0 {
+		return bs, err
+	}
+
+	// Rebuild the object, preserving the order of generated fields.
+	fields := newOrderedMap()
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields.Set(key.(string), value)
+	}
+
+	names := make([]string, 0, len(metadata.Extra))
+	for name := range metadata.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		extra := metadata.Extra[name]
+		generated, ok := fields.values[name]
+		if !ok {
+			fields.Set(name, extra)
+			continue
+		}
+		extraObject, isObject := extra.(map[string]interface{})
+		var generatedObject map[string]interface{}
+		if !isObject || json.Unmarshal(generated.(json.RawMessage), &generatedObject) != nil {
+			continue
+		}
+		merged := make(map[string]interface{})
+		for k, v := range extraObject {
+			merged[k] = v
+		}
+		for k, v := range generatedObject {
+			merged[k] = v
+		}
+		fields.Set(name, merged)
+	}
+	return marshalJSON(fields)
+}
+
+type PeerDependencyMeta struct {
+	Optional bool `json:"optional"`
 }
 
 type PublishConfig struct {
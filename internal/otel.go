@@ -0,0 +1,320 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans of verbose timings, such as builds, rebuilds, processes, and test
+// runs, are exported to an OpenTelemetry collector when configured by the
+// standard environment variables. Each invocation of uni is a trace, whose
+// root span is the command. Spans are sent with OTLP over HTTP, in its JSON
+// encoding, in batches every few seconds and when uni exits.
+
+// Interval between exports of finished spans, so that those of long running
+// commands, such as in watch mode, are not held until exit.
+const otelExportInterval = 5 * time.Second
+
+type otelExporter struct {
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+	resource []otelAttribute
+	traceID  string
+	// Span that the command's span is a child of, such as that of a CI job
+	// given by TRACEPARENT.
+	parentID string
+	rootID   string
+	root     *otelSpan
+
+	mx      sync.Mutex
+	pending []otelSpan
+	warned  bool
+}
+
+var otel *otelExporter
+
+type otelSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otelAttribute `json:"attributes,omitempty"`
+	Status       *otelStatus     `json:"status,omitempty"`
+}
+
+type otelAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otelStatus struct {
+	// 2 is an error.
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// Internal span kind.
+const otelSpanKindInternal = 1
+
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// ConfigureOTel enables exporting spans if an OTLP endpoint is configured by
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT, and
+// exporting is not disabled by OTEL_SDK_DISABLED or OTEL_TRACES_EXPORTER. The
+// command's span is started, to be ended by ShutdownOTel.
+func ConfigureOTel(command string) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" && exporter != "otlp" {
+		return
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		Warnf("not exporting spans: unsupported OTLP protocol %q, only http/json is supported", protocol)
+		return
+	}
+
+	exporter := &otelExporter{
+		endpoint: endpoint,
+		headers:  make(map[string]string),
+		timeout:  10 * time.Second,
+		traceID:  randomHex(16),
+		rootID:   randomHex(8),
+	}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for key, value := range parseOTelList(os.Getenv(name)) {
+			exporter.headers[key] = value
+		}
+	}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_TIMEOUT", "OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"} {
+		if ms, err := strconv.Atoi(os.Getenv(name)); err == nil && ms > 0 {
+			exporter.timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if match := traceparentPattern.FindStringSubmatch(os.Getenv("TRACEPARENT")); match != nil {
+		exporter.traceID = match[1]
+		exporter.parentID = match[2]
+	}
+
+	resource := parseOTelList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resource["service.name"] = name
+	}
+	if _, ok := resource["service.name"]; !ok {
+		resource["service.name"] = "uni"
+	}
+	resourceFields := make(logFields, len(resource))
+	for key, value := range resource {
+		resourceFields[key] = value
+	}
+	exporter.resource = otelAttributes(resourceFields)
+
+	exporter.root = &otelSpan{
+		TraceID:      exporter.traceID,
+		SpanID:       exporter.rootID,
+		ParentSpanID: exporter.parentID,
+		Name:         command,
+		Kind:         otelSpanKindInternal,
+		Start:        unixNano(time.Now()),
+	}
+	otel = exporter
+	go func() {
+		for range time.Tick(otelExportInterval) {
+			exporter.flush()
+		}
+	}()
+}
+
+// ShutdownOTel ends the command's span, with the command's exit status, and
+// exports all remaining spans.
+func ShutdownOTel(exitCode int) {
+	exporter := otel
+	if exporter == nil {
+		return
+	}
+	root := *exporter.root
+	root.End = unixNano(time.Now())
+	root.Attributes = otelAttributes(logFields{"exit_code": exitCode})
+	if exitCode != 0 {
+		root.Status = &otelStatus{Code: 2}
+	}
+	exporter.mx.Lock()
+	exporter.pending = append(exporter.pending, root)
+	exporter.mx.Unlock()
+	exporter.flush()
+}
+
+// exportSpan queues a finished span to be exported, if enabled.
+func exportSpan(name string, start time.Time, end time.Time, fields logFields, failure string) {
+	exporter := otel
+	if exporter == nil {
+		return
+	}
+	span := otelSpan{
+		TraceID:      exporter.traceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: exporter.rootID,
+		Name:         name,
+		Kind:         otelSpanKindInternal,
+		Start:        unixNano(start),
+		End:          unixNano(end),
+		Attributes:   otelAttributes(fields),
+	}
+	if failure != "" {
+		span.Status = &otelStatus{Code: 2, Message: failure}
+	}
+	exporter.mx.Lock()
+	exporter.pending = append(exporter.pending, span)
+	exporter.mx.Unlock()
+}
+
+// flush exports pending spans. Failures are warned about once, and the spans
+// are dropped, so that an unreachable collector does not slow uni down.
+func (exporter *otelExporter) flush() {
+	exporter.mx.Lock()
+	spans := exporter.pending
+	exporter.pending = nil
+	exporter.mx.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	err := exporter.send(spans)
+	if err != nil {
+		exporter.mx.Lock()
+		warn := !exporter.warned
+		exporter.warned = true
+		exporter.mx.Unlock()
+		if warn {
+			Warnf("exporting spans to %s: %v", exporter.endpoint, err)
+		}
+	}
+}
+
+func (exporter *otelExporter) send(spans []otelSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": exporter.resource,
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "uni"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", exporter.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.headers {
+		req.Header.Set(key, value)
+	}
+	client := &http.Client{Timeout: exporter.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// otelAttributes converts fields to attributes, sorted by key. Values of types
+// that attributes cannot represent are formatted as strings.
+func otelAttributes(fields logFields) []otelAttribute {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attributes := make([]otelAttribute, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := fields[key].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		attributes = append(attributes, otelAttribute{Key: key, Value: value})
+	}
+	return attributes
+}
+
+// parseOTelList parses a list of the form key1=value1,key2=value2, with
+// URL-encoded values, as used by OTEL_RESOURCE_ATTRIBUTES and
+// OTEL_EXPORTER_OTLP_HEADERS. Malformed entries are skipped.
+func parseOTelList(s string) map[string]string {
+	entries := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		i := strings.IndexByte(entry, '=')
+		if i <= 0 {
+			continue
+		}
+		key := strings.TrimSpace(entry[:i])
+		value, err := url.QueryUnescape(strings.TrimSpace(entry[i+1:]))
+		if err != nil {
+			continue
+		}
+		entries[key] = value
+	}
+	return entries
+}
+
+func randomHex(n int) string {
+	bs := make([]byte, n)
+	_, _ = rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
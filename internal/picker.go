@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrPickerCanceled is returned when the user cancels a picker.
+var ErrPickerCanceled = errors.New("canceled")
+
+// PickerItem is a choice offered by a picker.
+type PickerItem struct {
+	// Text that is displayed and searched.
+	Label string
+	// Additional text that is displayed, but not searched.
+	Detail string
+	// Value of the item when picked.
+	Value string
+}
+
+// Maximum number of items shown at once.
+const pickerHeight = 10
+
+// CanPick reports whether an interactive picker can be shown, which requires
+// stdin and stderr to be terminals.
+func CanPick() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+// Pick prompts the user to choose an item by typing to fuzzy search among
+// them, and returns the value of the chosen item. Where the terminal cannot be
+// put into raw mode, items are numbered and chosen by entering a number or a
+// search instead.
+func Pick(prompt string, items []PickerItem) (string, error) {
+	if len(items) == 0 {
+		return "", errors.New("nothing to pick from")
+	}
+	tty, restore, err := openRawTerminal()
+	if err != nil {
+		return pickByLine(os.Stdin, os.Stderr, prompt, items)
+	}
+	defer tty.Close()
+	defer restore()
+	return pickRaw(tty, prompt, items)
+}
+
+// pickRaw runs a picker on a terminal in raw mode, redrawing the matching items
+// as the query is edited.
+func pickRaw(tty io.ReadWriter, prompt string, items []PickerItem) (string, error) {
+	query := ""
+	selected := 0
+	drawn := 0
+	buf := make([]byte, 64)
+	for {
+		matches := fuzzyFilter(items, query)
+		if selected >= len(matches) {
+			selected = len(matches) - 1
+		}
+		if selected < 0 {
+			selected = 0
+		}
+
+		// Redraw over the previous frame.
+		var frame strings.Builder
+		if drawn > 0 {
+			fmt.Fprintf(&frame, "\x1b[%dA", drawn)
+		}
+		frame.WriteString("\r\x1b[J")
+		offset := 0
+		if selected >= pickerHeight {
+			offset = selected - pickerHeight + 1
+		}
+		lines := 0
+		for i := offset; i < len(matches) && i < offset+pickerHeight; i++ {
+			item := matches[i]
+			marker := "  "
+			if i == selected {
+				marker = "\x1b[7m>\x1b[0m "
+			}
+			fmt.Fprintf(&frame, "%s%s", marker, item.Label)
+			if item.Detail != "" {
+				fmt.Fprintf(&frame, "  \x1b[2m%s\x1b[0m", item.Detail)
+			}
+			frame.WriteString("\n")
+			lines++
+		}
+		fmt.Fprintf(&frame, "\x1b[2m%d/%d\x1b[0m %s %s", len(matches), len(items), prompt, query)
+		drawn = lines
+		if _, err := io.WriteString(tty, frame.String()); err != nil {
+			return "", err
+		}
+
+		n, err := tty.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		input := buf[:n]
+		for len(input) > 0 {
+			switch {
+			case input[0] == '\r' || input[0] == '\n':
+				if len(matches) > 0 {
+					clearFrame(tty, drawn)
+					return matches[selected].Value, nil
+				}
+			case input[0] == 3 || input[0] == 4 || (input[0] == 27 && len(input) == 1):
+				// Ctrl-C, Ctrl-D, or escape.
+				clearFrame(tty, drawn)
+				return "", ErrPickerCanceled
+			case input[0] == 27 && len(input) >= 3 && input[1] == '[':
+				switch input[2] {
+				case 'A':
+					selected--
+				case 'B':
+					selected++
+				}
+				input = input[3:]
+				continue
+			case input[0] == 16: // Ctrl-P
+				selected--
+			case input[0] == 14: // Ctrl-N
+				selected++
+			case input[0] == 127 || input[0] == 8:
+				if query != "" {
+					query = query[:len(query)-1]
+				}
+				selected = 0
+			case input[0] == 21: // Ctrl-U
+				query = ""
+				selected = 0
+			case input[0] >= 32 && input[0] < 127:
+				query += string(input[0])
+				selected = 0
+			}
+			input = input[1:]
+		}
+	}
+}
+
+func clearFrame(w io.Writer, drawn int) {
+	if drawn > 0 {
+		fmt.Fprintf(w, "\x1b[%dA", drawn)
+	}
+	io.WriteString(w, "\r\x1b[J")
+}
+
+// pickByLine runs a picker without raw mode. Entering a number picks that
+// item, and entering other text narrows the items to those that match it.
+func pickByLine(r io.Reader, w io.Writer, prompt string, items []PickerItem) (string, error) {
+	scanner := bufio.NewScanner(r)
+	matches := items
+	for {
+		for i, item := range matches {
+			fmt.Fprintf(w, "%3d) %s", i+1, item.Label)
+			if item.Detail != "" {
+				fmt.Fprintf(w, "  %s", item.Detail)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s (number or search): ", prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", ErrPickerCanceled
+		}
+		input := strings.TrimSpace(scanner.Text())
+		if n, err := strconv.Atoi(input); err == nil && 1 <= n && n <= len(matches) {
+			return matches[n-1].Value, nil
+		}
+		found := fuzzyFilter(items, input)
+		switch len(found) {
+		case 0:
+			fmt.Fprintf(w, "no matches for %q\n", input)
+		case 1:
+			return found[0].Value, nil
+		default:
+			matches = found
+		}
+	}
+}
+
+// fuzzyFilter returns the items whose labels contain the characters of the
+// query in order, ignoring case, best matches first. Matches are better when
+// their characters are closer together and nearer the start of the label.
+func fuzzyFilter(items []PickerItem, query string) []PickerItem {
+	type match struct {
+		item  PickerItem
+		score int
+	}
+	var matches []match
+	for _, item := range items {
+		if score, ok := fuzzyScore(item.Label, query); ok {
+			matches = append(matches, match{item, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+	result := make([]PickerItem, len(matches))
+	for i, m := range matches {
+		result[i] = m.item
+	}
+	return result
+}
+
+// fuzzyScore scores a match of a query against a label, where lower is better.
+func fuzzyScore(label string, query string) (int, bool) {
+	label = strings.ToLower(label)
+	query = strings.ToLower(query)
+	score := 0
+	pos := 0
+	first := -1
+	for _, r := range query {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		i := strings.IndexRune(label[pos:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if first < 0 {
+			first = pos + i
+		}
+		score += i
+		pos += i + len(string(r))
+	}
+	if first > 0 {
+		score += first
+	}
+	return score, true
+}
+
+// PickRunnable prompts the user to choose a run target or a configured
+// entrypoint. Returns the name of a target, or the absolute path of an
+// entrypoint.
+func PickRunnable(repo *Repository) (string, error) {
+	var items []PickerItem
+	for _, name := range RunTargetNames(repo) {
+		detail := "target"
+		if _, ok := repo.RunGroups[name]; ok {
+			detail = "group"
+		}
+		items = append(items, PickerItem{Label: name, Detail: detail, Value: name})
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	owners := make(map[string]string)
+	for _, name := range PackageNames(repo) {
+		for _, entrypoint := range repo.Packages[name].entrypointPaths() {
+			owners[filepath.Join(repo.RootDir, entrypoint)] = name
+		}
+	}
+	for _, entrypoint := range EntrypointPaths(repo) {
+		label := entrypoint
+		if rel, err := filepath.Rel(cwd, entrypoint); err == nil {
+			label = rel
+		}
+		items = append(items, PickerItem{Label: label, Detail: owners[entrypoint], Value: entrypoint})
+	}
+	return Pick("run", items)
+}
+
+// PickPackage prompts the user to choose a package, returning its name.
+func PickPackage(repo *Repository) (string, error) {
+	var items []PickerItem
+	for _, name := range PackageNames(repo) {
+		items = append(items, PickerItem{
+			Label:  name,
+			Detail: repo.Packages[name].Description,
+			Value:  name,
+		})
+	}
+	return Pick("package", items)
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// openRawTerminal opens the controlling terminal and disables line buffering,
+// echo, and signal keys, so that each keypress is read as typed. The returned
+// function restores the previous terminal settings.
+func openRawTerminal() (*os.File, func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = tty
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		tty.Close()
+		return nil, nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		tty.Close()
+		return nil, nil, err
+	}
+	restore := func() {
+		_, _ = stty(saved)
+	}
+	return tty, restore, nil
+}
@@ -0,0 +1,12 @@
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// openRawTerminal is not supported on Windows, where pickers prompt for a line
+// of input instead.
+func openRawTerminal() (*os.File, func(), error) {
+	return nil, nil, errors.New("raw terminal mode not supported")
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// printBuildPlan reports the files that a dry run built into packageDir, as
+// they would be written to distDir, and the dependencies of its package.json.
+func printBuildPlan(w io.Writer, repo *Repository, metadata PackageMetadata, packageDir string, distDir string) error {
+	files := make(map[string]int64)
+	var names []string
+	err := filepath.Walk(packageDir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(packageDir, filename)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files[rel] = fi.Size()
+		names = append(names, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	dir := relativeToRoot(repo, distDir)
+	fields := logFields{
+		"package":              metadata.Name,
+		"dir":                  dir,
+		"files":                files,
+		"dependencies":         metadata.Dependencies,
+		"peerDependencies":     metadata.PeerDependencies,
+		"optionalDependencies": metadata.OptionalDependencies,
+	}
+	version := ""
+	if metadata.Version != "" {
+		fields["version"] = metadata.Version
+		version = "@" + metadata.Version
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "would build %s%s into %s:\n", metadata.Name, version, dir)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-40s %10s\n", name, formatBytes(int(files[name])))
+	}
+	for _, group := range []struct {
+		label        string
+		dependencies map[string]string
+	}{
+		{"dependencies", metadata.Dependencies},
+		{"peerDependencies", metadata.PeerDependencies},
+		{"optionalDependencies", metadata.OptionalDependencies},
+	} {
+		if len(group.dependencies) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n", group.label)
+		dependencyNames := make([]string, 0, len(group.dependencies))
+		for name := range group.dependencies {
+			dependencyNames = append(dependencyNames, name)
+		}
+		sort.Strings(dependencyNames)
+		for _, name := range dependencyNames {
+			fmt.Fprintf(&b, "  %s %s\n", name, group.dependencies[name])
+		}
+	}
+	logEvent(w, "plan", fields, "%s", strings.TrimSuffix(b.String(), "\n"))
+	return nil
+}
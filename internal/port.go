@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PortConflictStrategy is what to do when a port that a program listens on is
+// still in use as it is about to start, such as by an orphaned child process
+// of the program it replaces.
+type PortConflictStrategy string
+
+const (
+	// Wait for the port to be released, up to the shutdown timeout.
+	PortConflictWait PortConflictStrategy = "wait"
+	// Stop the processes listening on the port.
+	PortConflictKill PortConflictStrategy = "kill"
+)
+
+func ParsePortConflictStrategy(s string) (PortConflictStrategy, error) {
+	switch strategy := PortConflictStrategy(s); strategy {
+	case "":
+		return PortConflictWait, nil
+	case PortConflictWait, PortConflictKill:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown port conflict strategy: %q", s)
+	}
+}
+
+// How often to check whether ports have been released.
+const portPollInterval = 100 * time.Millisecond
+
+// portGuard frees the ports that a program listens on before it starts.
+type portGuard struct {
+	Ports    []int
+	Strategy PortConflictStrategy
+	// How long to wait for ports to be released, including after stopping
+	// the processes that hold them.
+	Timeout time.Duration
+	// Where to report conflicts.
+	Stderr io.Writer
+	// Directory of records of the processes that uni started. See record.
+	PidDir string
+}
+
+// free ensures that the guarded ports are not in use, if possible. Ports that
+// remain in use are reported, but are not an error, since the program will
+// report its own failure to listen. Only processes that uni started are
+// stopped.
+func (guard *portGuard) free() {
+	for _, port := range guard.Ports {
+		if !portInUse(port) {
+			continue
+		}
+		holders := portHolders(port)
+		fields := logFields{"port": port}
+		if len(holders) > 0 {
+			fields["pids"] = holders
+		}
+		holder := describePortHolders(holders)
+		var stoppable []int
+		for _, pid := range holders {
+			if guard.started(pid) {
+				stoppable = append(stoppable, pid)
+			}
+		}
+		switch {
+		case guard.Strategy == PortConflictKill && len(stoppable) > 0:
+			logEvent(guard.Stderr, "port-conflict", fields, "port %d is in use by %s, stopping it", port, holder)
+			for _, pid := range stoppable {
+				if err := stopPortHolder(pid, false); err != nil {
+					logEvent(guard.Stderr, "error", nil, "could not stop pid %d: %v", pid, err)
+				}
+			}
+			if !guard.awaitRelease(port) {
+				for _, pid := range stoppable {
+					_ = stopPortHolder(pid, true)
+				}
+			}
+		case guard.Strategy == PortConflictKill && len(holders) > 0:
+			logEvent(guard.Stderr, "port-conflict", fields, "port %d is in use by %s, which uni did not start, waiting for it to be released", port, holder)
+		default:
+			logEvent(guard.Stderr, "port-conflict", fields, "port %d is in use by %s, waiting for it to be released", port, holder)
+		}
+		if guard.awaitRelease(port) {
+			logEvent(guard.Stderr, "port-released", fields, "port %d released", port)
+			continue
+		}
+		hint := ""
+		if guard.Strategy != PortConflictKill {
+			hint = "; set run.portConflict to kill to stop it automatically"
+		}
+		logEvent(guard.Stderr, "port-conflict", fields, "port %d is still in use by %s, starting anyway%s", port, holder, hint)
+	}
+}
+
+// startedProcess is the record of a process that uni started, which is kept
+// in a file named by its pid, so that uni may stop it, or processes left in
+// its process group, even after the uni process that started it has exited.
+type startedProcess struct {
+	// Command line of the process, which distinguishes it from an unrelated
+	// process that reuses its pid.
+	Command string `json:"command"`
+}
+
+// record records a started process, and removes the records of processes
+// that have exited along with their process groups.
+func (guard *portGuard) record(pid int) {
+	if guard.PidDir == "" {
+		return
+	}
+	if err := os.MkdirAll(guard.PidDir, 0755); err != nil {
+		Warnf("recording pid %d: %v", pid, err)
+		return
+	}
+	if entries, err := ioutil.ReadDir(guard.PidDir); err == nil {
+		for _, entry := range entries {
+			if other, err := strconv.Atoi(entry.Name()); err == nil && !processRunning(other) && !processGroupRunning(other) {
+				_ = os.Remove(filepath.Join(guard.PidDir, entry.Name()))
+			}
+		}
+	}
+	record := startedProcess{Command: processCommand(pid)}
+	if err := WriteJSON(filepath.Join(guard.PidDir, strconv.Itoa(pid)), record); err != nil {
+		Warnf("recording pid %d: %v", pid, err)
+	}
+}
+
+// started reports whether uni started a process, or the leader of its
+// process group, according to the recorded processes.
+func (guard *portGuard) started(pid int) bool {
+	if guard.PidDir == "" || isProtectedPid(pid) {
+		return false
+	}
+	leaders := []int{pid}
+	if pgid := processGroupOf(pid); pgid != pid && !isProtectedPid(pgid) {
+		leaders = append(leaders, pgid)
+	}
+	for _, leader := range leaders {
+		var record startedProcess
+		if err := ReadJSON(filepath.Join(guard.PidDir, strconv.Itoa(leader)), &record); err != nil {
+			continue
+		}
+		// A group leader that has exited cannot have had its pid reused while
+		// its group remains.
+		if leader != pid && !processRunning(leader) {
+			return true
+		}
+		if command := processCommand(leader); command != "" && command == record.Command {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitRelease waits up to the timeout for a port to be released, reporting
+// whether it was.
+func (guard *portGuard) awaitRelease(port int) bool {
+	deadline := time.Now().Add(guard.Timeout)
+	for portInUse(port) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(portPollInterval)
+	}
+	return true
+}
+
+// portInUse reports whether a TCP port cannot be listened on because another
+// process is listening on it. Loopback addresses are probed as well as all
+// addresses, since on some systems listening on all addresses succeeds even
+// while another process listens on the same port of localhost only.
+func portInUse(port int) bool {
+	for _, host := range []string{"", "127.0.0.1", "::1"} {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			if isAddrInUse(err) {
+				return true
+			}
+			continue
+		}
+		ln.Close()
+	}
+	return false
+}
+
+// describePortHolders describes processes by their pids and, if known, their
+// commands, such as `pid 123 (node server.js)`.
+func describePortHolders(pids []int) string {
+	if len(pids) == 0 {
+		return "an unknown process"
+	}
+	descriptions := make([]string, len(pids))
+	for i, pid := range pids {
+		descriptions[i] = fmt.Sprintf("pid %d", pid)
+		if command := processCommand(pid); command != "" {
+			descriptions[i] += fmt.Sprintf(" (%s)", command)
+		}
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// isProtectedPid reports whether a process must never be stopped for holding
+// a port, such as uni itself.
+func isProtectedPid(pid int) bool {
+	return pid <= 1 || pid == os.Getpid()
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portHolders returns the pids of processes listening on a TCP port, as found
+// by lsof, if it is installed.
+func portHolders(port int) []int {
+	out, err := exec.Command("lsof", "-nP", "-t", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN").Output()
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(out)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// processCommand returns the command line of a process, if known.
+func processCommand(pid int) string {
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// stopPortHolder asks a process to terminate, or kills it if force is set.
+func stopPortHolder(pid int, force bool) error {
+	if isProtectedPid(pid) {
+		return fmt.Errorf("refusing to stop pid %d", pid)
+	}
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// processGroupOf returns the process group ID of a process, or its pid if
+// unknown.
+func processGroupOf(pid int) int {
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		return pid
+	}
+	return pgid
+}
+
+// processGroupRunning reports whether any process is in the process group
+// led by pid.
+func processGroupRunning(pid int) bool {
+	err := syscall.Kill(-pid, 0)
+	return err == nil || err == syscall.EPERM
+}
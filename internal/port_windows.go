@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Windows Sockets' equivalent of EADDRINUSE.
+const wsaeaddrinuse = syscall.Errno(10048)
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, wsaeaddrinuse) || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portHolders returns the pids of processes listening on a TCP port, as
+// reported by netstat.
+func portHolders(port int) []int {
+	out, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return nil
+	}
+	suffix := fmt.Sprintf(":%d", port)
+	seen := make(map[int]bool)
+	var pids []int
+	for _, line := range strings.Split(string(out), "\n") {
+		// Proto, local address, foreign address, state, and pid.
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[3] != "LISTENING" || !strings.HasSuffix(fields[1], suffix) {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[4]); err == nil && !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// processCommand returns the image name of a process, if known.
+func processCommand(pid int) string {
+	out, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid), "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return ""
+	}
+	// Without a match, an informational message is printed instead.
+	if !strings.HasPrefix(string(out), `"`) {
+		return ""
+	}
+	record, err := csv.NewReader(strings.NewReader(string(out))).Read()
+	if err != nil || len(record) == 0 {
+		return ""
+	}
+	return record[0]
+}
+
+// stopPortHolder kills a process and its children. Windows has no equivalent
+// of a termination request for console processes, so force is ignored.
+func stopPortHolder(pid int, force bool) error {
+	if isProtectedPid(pid) {
+		return fmt.Errorf("refusing to stop pid %d", pid)
+	}
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// processGroupOf returns pid, since Windows has no process groups. Processes
+// left in the job object of a process are killed when it exits instead.
+func processGroupOf(pid int) int {
+	return pid
+}
+
+func processGroupRunning(pid int) bool {
+	return processRunning(pid)
+}
@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RestartStrategy is how a running program is replaced when it is restarted
+// in watch mode.
+type RestartStrategy string
+
+const (
+	// Stop the running process, then start its replacement.
+	RestartStop RestartStrategy = "stop"
+	// Start the replacement while the running process keeps running, and stop
+	// the running process only once the replacement is ready.
+	RestartPrestart RestartStrategy = "prestart"
+)
+
+func ParseRestartStrategy(s string) (RestartStrategy, error) {
+	switch strategy := RestartStrategy(s); strategy {
+	case "":
+		return RestartStop, nil
+	case RestartStop, RestartPrestart:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown restart strategy: %q", s)
+	}
+}
+
+// Runs the main function exported by a bundle, like the script written by
+// writeRunScript, but in two phases. First, the bundle is loaded and its
+// "prepare" function, if any, is awaited with the same arguments as main.
+// Then "ready" is written to file descriptor 4, and main is called once a line
+// is read from file descriptor 3. Preparation should not acquire resources
+// held by the process being replaced, such as listening ports.
+//
+// Listening sockets held by uni may be passed as further file descriptors,
+// listed in UNI_LISTEN_FDS as port=fd pairs separated by commas. Servers that
+// listen on those ports listen on the passed sockets instead, which the
+// process being replaced is also listening on. Then main is called as soon as
+// preparation finishes, rather than waiting to be activated.
+const prestartRunScript = `require('source-map-support').install();
+
+const net = require('net');
+const { inspect } = require('util');
+process.on('uncaughtException', (exception) => {
+  process.stderr.write('uncaught exception: ' + inspect(exception) + '\n', () => {
+    process.exit(1);
+  });
+});
+process.on('unhandledRejection', (reason, promise) => {
+  process.stderr.write(
+    'unhandled rejection at: ' + inspect(promise) + '\nreason: ' + inspect(reason) + '\n',
+    () => {
+      process.exit(1);
+    },
+  );
+})
+
+const listenFds = new Map();
+for (const pair of (process.env.UNI_LISTEN_FDS || '').split(',')) {
+	const [port, fd] = pair.split('=').map(Number);
+	if (port > 0 && fd > 0) {
+		listenFds.set(port, fd);
+	}
+}
+delete process.env.UNI_LISTEN_FDS;
+if (listenFds.size > 0) {
+	const listen = net.Server.prototype.listen;
+	net.Server.prototype.listen = function (...args) {
+		const options = args[0];
+		const port = Number(options !== null && typeof options === 'object' ? options.port : options);
+		const fd = listenFds.get(port);
+		if (fd === undefined) {
+			return listen.apply(this, args);
+		}
+		const callback = args.find((arg) => typeof arg === 'function');
+		return listen.call(this, { fd }, callback);
+	};
+}
+
+const { main, prepare } = require(%s);
+if (typeof main === 'function') {
+	const args = process.argv.slice(2);
+	const commands = new net.Socket({ fd: 3, readable: true, writable: false });
+	const ready = new net.Socket({ fd: 4, readable: false, writable: true });
+	const run = () => {
+		void (async () => {
+			const exitCode = await main(...args);
+			process.exit(exitCode ?? 0);
+		})();
+	};
+	void (async () => {
+		if (typeof prepare === 'function') {
+			await prepare(...args);
+		}
+		ready.end('ready\n');
+		if (listenFds.size > 0) {
+			commands.destroy();
+			run();
+			return;
+		}
+		commands.once('data', () => {
+			commands.destroy();
+			run();
+		});
+	})();
+} else {
+	process.stderr.write('error: ' + %s + ' does not export a main function\n', () => {
+		process.exit(1);
+	});
+}
+`
+
+// writePrestartRunScript writes a script like writeRunScript, but which waits
+// to run main until activated by a prestartProcess.
+func writePrestartRunScript(scriptPath string, bundleName string, entrypoint string) error {
+	script := fmt.Sprintf(prestartRunScript, strconv.Quote("./"+bundleName), strconv.Quote(entrypoint))
+	return ioutil.WriteFile(scriptPath, []byte(script), 0644)
+}
+
+// prestartProcess is a process run by a prestart run script, which may be
+// started while the process it replaces is still running.
+type prestartProcess struct {
+	*cmdProcess
+	// Pipe of commands to the process, and of its readiness to uni. The
+	// process has its own copies of the other ends.
+	commands      *os.File
+	commandsChild *os.File
+	ready         *os.File
+	readyChild    *os.File
+	readyErr      chan error
+	// Frees the ports that the process listens on before it is activated,
+	// since the process it replaces holds them while it starts.
+	ports *portGuard
+	// Whether the process was passed listening sockets, in which case it
+	// runs main without being activated.
+	listening bool
+}
+
+// newPrestartProcess prepares a process to be prestarted. If listeners are
+// given, and can listen, the process is passed their sockets, and its ports
+// are not freed, since uni holds them.
+func newPrestartProcess(proc *cmdProcess, listeners *portListeners) (*prestartProcess, error) {
+	commandsChild, commands, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ready, readyChild, err := os.Pipe()
+	if err != nil {
+		commandsChild.Close()
+		commands.Close()
+		return nil, err
+	}
+	proc.cmd.ExtraFiles = []*os.File{commandsChild, readyChild}
+	ports := proc.ports
+	proc.ports = nil
+	listening := false
+	if listeners != nil {
+		if files, err := listeners.open(); err != nil {
+			logEvent(listeners.guard.Stderr, "error", nil, "could not listen on ports for the program: %v", err)
+		} else {
+			var fds []string
+			for i, file := range files {
+				fds = append(fds, fmt.Sprintf("%d=%d", listeners.guard.Ports[i], 3+len(proc.cmd.ExtraFiles)))
+				proc.cmd.ExtraFiles = append(proc.cmd.ExtraFiles, file)
+			}
+			if proc.cmd.Env == nil {
+				proc.cmd.Env = os.Environ()
+			}
+			proc.cmd.Env = append(proc.cmd.Env, "UNI_LISTEN_FDS="+strings.Join(fds, ","))
+			ports = nil
+			listening = true
+		}
+	}
+	return &prestartProcess{
+		ports:         ports,
+		listening:     listening,
+		cmdProcess:    proc,
+		commands:      commands,
+		commandsChild: commandsChild,
+		ready:         ready,
+		readyChild:    readyChild,
+		readyErr:      make(chan error, 1),
+	}, nil
+}
+
+func (proc *prestartProcess) Start() error {
+	err := proc.cmdProcess.Start()
+	proc.commandsChild.Close()
+	proc.readyChild.Close()
+	if err != nil {
+		proc.commands.Close()
+		proc.ready.Close()
+		return err
+	}
+	go func() {
+		defer proc.ready.Close()
+		line, err := bufio.NewReader(proc.ready).ReadString('\n')
+		switch {
+		case line == "ready\n":
+			proc.readyErr <- nil
+		case err == io.EOF:
+			proc.readyErr <- errors.New("exited before it was ready")
+		default:
+			proc.readyErr <- err
+		}
+	}()
+	return nil
+}
+
+// Ready receives nil once the process is ready to be activated, or an error
+// if it never will be.
+func (proc *prestartProcess) Ready() <-chan error {
+	return proc.readyErr
+}
+
+// Activate lets the process run main, unless it already has.
+func (proc *prestartProcess) Activate() error {
+	if proc.listening {
+		return nil
+	}
+	if proc.ports != nil {
+		proc.ports.free()
+	}
+	_, err := io.WriteString(proc.commands, "run\n")
+	return err
+}
+
+func (proc *prestartProcess) Kill() error {
+	err := proc.cmdProcess.Kill()
+	proc.commands.Close()
+	return err
+}
+
+// portListeners are listening sockets that uni holds for the ports of a
+// program restarted with the prestart strategy, and passes to each of its
+// processes. A replacement then listens while the process it replaces still
+// is, and connections wait to be accepted by either, rather than being
+// refused while one stops and the other starts.
+type portListeners struct {
+	// Frees the ports before listening on them.
+	guard *portGuard
+
+	once  sync.Once
+	files []*os.File
+	err   error
+}
+
+func newPortListeners(guard *portGuard) *portListeners {
+	return &portListeners{guard: guard}
+}
+
+// open listens on the ports the first time it is called, returning the files
+// of the listening sockets in the order of the ports.
+func (pl *portListeners) open() ([]*os.File, error) {
+	pl.once.Do(func() {
+		pl.guard.free()
+		for _, port := range pl.guard.Ports {
+			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				pl.err = err
+				break
+			}
+			file, err := ln.(*net.TCPListener).File()
+			ln.Close()
+			if err != nil {
+				pl.err = err
+				break
+			}
+			pl.files = append(pl.files, file)
+		}
+		if pl.err != nil {
+			pl.Close()
+		}
+	})
+	return pl.files, pl.err
+}
+
+// Close stops listening, once no process holds the sockets.
+func (pl *portListeners) Close() {
+	for _, file := range pl.files {
+		file.Close()
+	}
+	pl.files = nil
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Signals that are forwarded from uni to managed processes.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// configureProcessGroup starts the command in a new process group, so that
+// signals can be delivered to it and any of its own child processes together.
+//
+// Commands that read from the terminal stay in uni's process group, which is
+// in the foreground, since reading from a terminal in a background process
+// group stops the process with SIGTTIN. Terminal signals such as ^C reach them
+// and their child processes anyway.
+func configureProcessGroup(cmd *exec.Cmd) {
+	if stdin, ok := cmd.Stdin.(*os.File); ok && isTerminal(stdin) {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// trackProcessGroup is unnecessary where process groups are configured before
+// starting.
+func trackProcessGroup(proc *os.Process) {}
+
+// releaseProcessGroup is unnecessary where process groups need no handles.
+// Processes left in the group keep running until killed.
+func releaseProcessGroup(proc *os.Process) {}
+
+func signalProcessGroup(proc *os.Process, sig os.Signal) error {
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		return proc.Signal(sig)
+	}
+	if pgid, err := syscall.Getpgid(proc.Pid); err == nil && pgid != proc.Pid {
+		// Not in its own group, which would include uni.
+		return syscall.Kill(proc.Pid, sysSig)
+	}
+	// A negative pid signals the entire process group.
+	return syscall.Kill(-proc.Pid, sysSig)
+}
+
+// processRunning reports whether a process with the given ID exists.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+// Windows has no process groups that can be signaled, so each started process
+// is instead assigned to a job object, which its child processes also belong
+// to. Killing the job kills the whole tree, even if intermediate processes
+// have exited. Processes are started suspended, and only resumed once
+// assigned, so that none of their children escape the job.
+
+// configureProcessGroup starts the command suspended. See trackProcessGroup.
+func configureProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= createSuspended
+}
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procThread32First            = kernel32.NewProc("Thread32First")
+	procThread32Next             = kernel32.NewProc("Thread32Next")
+	procOpenThread               = kernel32.NewProc("OpenThread")
+	procResumeThread             = kernel32.NewProc("ResumeThread")
+)
+
+const (
+	createSuspended                   = 0x00000004
+	processTerminate                  = 0x0001
+	processSetQuota                   = 0x0100
+	threadSuspendResume               = 0x0002
+	th32csSnapThread                  = 0x00000004
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x00002000
+)
+
+// jobObjectExtendedLimitInfo is JOBOBJECT_EXTENDED_LIMIT_INFORMATION.
+type jobObjectExtendedLimitInfo struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoInfo                  [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// threadEntry32 is THREADENTRY32.
+type threadEntry32 struct {
+	Size           uint32
+	Usage          uint32
+	ThreadID       uint32
+	OwnerProcessID uint32
+	BasePri        int32
+	DeltaPri       int32
+	Flags          uint32
+}
+
+var jobsMx sync.Mutex
+
+// Map of processes to the job objects that contain them. Processes are keyed
+// by identity rather than ID, since IDs are reused once processes exit.
+var jobs = make(map[*os.Process]syscall.Handle)
+
+// trackProcessGroup assigns a started process to a new job object, which
+// kills any processes still in it when closed, and then resumes the process.
+// If assignment fails, such as on versions of Windows without nested jobs,
+// the process is resumed anyway, and killing falls back to taskkill.
+func trackProcessGroup(proc *os.Process) {
+	defer resumeProcess(proc.Pid)
+	job, _, _ := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return
+	}
+	info := jobObjectExtendedLimitInfo{LimitFlags: jobObjectLimitKillOnJobClose}
+	if ok, _, _ := procSetInformationJobObject.Call(job, jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info)); ok == 0 {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	handle, err := syscall.OpenProcess(processTerminate|processSetQuota, false, uint32(proc.Pid))
+	if err != nil {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	defer syscall.CloseHandle(handle)
+	if ok, _, _ := procAssignProcessToJobObject.Call(job, uintptr(handle)); ok == 0 {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return
+	}
+	jobsMx.Lock()
+	jobs[proc] = syscall.Handle(job)
+	jobsMx.Unlock()
+}
+
+// resumeProcess resumes the threads of a process that was started suspended.
+func resumeProcess(pid int) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(th32csSnapThread, 0)
+	if err != nil {
+		return
+	}
+	defer syscall.CloseHandle(snapshot)
+	entry := threadEntry32{Size: uint32(unsafe.Sizeof(threadEntry32{}))}
+	ok, _, _ := procThread32First.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry)))
+	for ok != 0 {
+		if entry.OwnerProcessID == uint32(pid) {
+			if thread, _, _ := procOpenThread.Call(threadSuspendResume, 0, uintptr(entry.ThreadID)); thread != 0 {
+				_, _, _ = procResumeThread.Call(thread)
+				_ = syscall.CloseHandle(syscall.Handle(thread))
+			}
+		}
+		ok, _, _ = procThread32Next.Call(uintptr(snapshot), uintptr(unsafe.Pointer(&entry)))
+	}
+}
+
+// releaseProcessGroup closes the job object of a process that has been waited
+// for, which kills any of its descendants that are still running.
+func releaseProcessGroup(proc *os.Process) {
+	jobsMx.Lock()
+	job, ok := jobs[proc]
+	delete(jobs, proc)
+	jobsMx.Unlock()
+	if ok {
+		_ = syscall.CloseHandle(job)
+	}
+}
+
+func signalProcessGroup(proc *os.Process, sig os.Signal) error {
+	if sig != os.Kill {
+		return proc.Signal(sig)
+	}
+	jobsMx.Lock()
+	job, ok := jobs[proc]
+	delete(jobs, proc)
+	jobsMx.Unlock()
+	if ok {
+		defer syscall.CloseHandle(job)
+		if ok, _, err := procTerminateJobObject.Call(uintptr(job), 1); ok == 0 {
+			return os.NewSyscallError("TerminateJobObject", err)
+		}
+		return nil
+	}
+	// Kill the process tree by parent process IDs, which misses descendants
+	// whose parents have already exited.
+	taskkill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(proc.Pid))
+	if err := taskkill.Run(); err != nil {
+		return proc.Kill()
+	}
+	return nil
+}
+
+const (
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// processRunning reports whether a process with the given ID is running.
+func processRunning(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Processes of other users may exist without being accessible.
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
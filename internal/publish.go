@@ -1,27 +1,151 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 )
 
-func Publish(repo *Repository, pkg *Package) error {
+type PublishOptions struct {
+	// If set, build and pack the package with this version before publishing.
+	// Otherwise, the package must already be packed.
+	Version string
+	// Include type declarations when building.
+	Types bool
+	// Distribution tag to publish under. npm defaults to "latest".
+	Tag string
+	// Either "public" or "restricted". Defaults according to the package's
+	// public setting.
+	Access string
+	// One-time password for registries that require two-factor authentication.
+	OTP string
+	// Report what would be built, packed, and published, without writing
+	// anything or publishing. The packed package, if any, is checked with
+	// `npm publish --dry-run`.
+	DryRun bool
+}
+
+func Publish(repo *Repository, pkg *Package, opts PublishOptions) error {
+	access := opts.Access
+	switch access {
+	case "":
+		access = "restricted"
+		if pkg.Public {
+			access = "public"
+		}
+	case "public", "restricted":
+	default:
+		return fmt.Errorf("invalid access: %q", access)
+	}
+
+	if opts.Version != "" {
+		if err := Build(repo, BuildOptions{
+			Package: pkg,
+			Version: opts.Version,
+			Types:   opts.Types,
+			DryRun:  opts.DryRun,
+		}); err != nil {
+			return fmt.Errorf("building: %w", err)
+		}
+		if opts.DryRun {
+			// Nothing was packed to check with npm.
+			logPublishPlan(repo, pkg, opts.Version, access, opts.Tag)
+			return nil
+		}
+		if _, err := Pack(repo, pkg); err != nil {
+			return fmt.Errorf("packing: %w", err)
+		}
+	}
+
+	if err := validatePackage(repo, pkg); err != nil {
+		return err
+	}
+
 	strippedName := stripName(pkg.Name)
 
 	packedDir := path.Join(repo.OutDir, "packed")
 	packedName := fmt.Sprintf("%s.tgz", strippedName)
 	packedPath := path.Join(packedDir, packedName)
+	if _, err := os.Stat(packedPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is not packed", pkg.Name)
+		}
+		return err
+	}
 
-	access := "restricted"
-	if pkg.Public {
-		access = "public"
+	args := []string{"publish", packedPath, "--access", access}
+	if opts.Tag != "" {
+		args = append(args, "--tag", opts.Tag)
+	}
+	if opts.OTP != "" {
+		args = append(args, "--otp", opts.OTP)
+	}
+	if opts.DryRun {
+		metadata, err := ReadPackageJSON(path.Join(repo.DistDir, pkg.Name))
+		if err != nil {
+			return err
+		}
+		logPublishPlan(repo, pkg, metadata.Version, access, opts.Tag)
+		args = append(args, "--dry-run")
 	}
 
-	npm := exec.Command("npm", "publish", packedPath, "--access", access)
+	npm := exec.Command("npm", args...)
 	npm.Stdin = os.Stdin
 	npm.Stdout = os.Stdout
 	npm.Stderr = os.Stderr
 	return npm.Run()
 }
+
+// logPublishPlan reports what publishing a package would do.
+func logPublishPlan(repo *Repository, pkg *Package, version string, access string, tag string) {
+	registry := repo.Registry
+	if registry == "" {
+		registry = "the default registry"
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	logEvent(os.Stderr, "plan", logFields{
+		"package":  pkg.Name,
+		"version":  version,
+		"registry": repo.Registry,
+		"access":   access,
+		"tag":      tag,
+	}, "would publish %s@%s to %s with %s access, tagged %s", pkg.Name, version, registry, access, tag)
+}
+
+// validatePackage checks that the built package is publishable.
+func validatePackage(repo *Repository, pkg *Package) error {
+	distPath := path.Join(repo.DistDir, pkg.Name)
+	metadata, err := ReadPackageJSON(distPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is not built", pkg.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if metadata.Name != pkg.Name {
+		return fmt.Errorf("built package.json has name %q, expected %q", metadata.Name, pkg.Name)
+	}
+	if metadata.Version == "" {
+		return errors.New("version not set in package build")
+	}
+	if metadata.Private {
+		return fmt.Errorf("%s is private; set public or use a scoped name", pkg.Name)
+	}
+	files := []string{}
+	if metadata.Main != "" {
+		files = append(files, metadata.Main)
+	}
+	for _, bin := range metadata.Bin {
+		files = append(files, bin)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(path.Join(distPath, file)); err != nil {
+			return fmt.Errorf("%s is missing %s: %w", pkg.Name, file, err)
+		}
+	}
+	return nil
+}
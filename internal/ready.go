@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadinessProbe determines when a started program is ready, such as to
+// accept connections.
+type ReadinessProbe struct {
+	// Port on localhost that accepts connections once ready.
+	Port int
+	// URL that responds with a 2xx status once ready.
+	URL string
+	// Pattern that matches a line of output printed once ready.
+	Output *regexp.Regexp
+	// How long to wait for readiness before giving up.
+	Timeout time.Duration
+}
+
+const (
+	DefaultReadyTimeout = 30 * time.Second
+	readyPollInterval   = 100 * time.Millisecond
+)
+
+// newReadinessProbe validates a readiness probe configuration.
+func newReadinessProbe(cfg *ReadyConfig) (*ReadinessProbe, error) {
+	probe := &ReadinessProbe{
+		Port:    cfg.Port,
+		Timeout: DefaultReadyTimeout,
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("invalid port: %d", cfg.Port)
+	}
+	switch {
+	case cfg.HTTP == "":
+	case strings.HasPrefix(cfg.HTTP, "/"):
+		if cfg.Port == 0 {
+			return nil, errors.New("http path requires port")
+		}
+		probe.URL = "http://localhost:" + strconv.Itoa(cfg.Port) + cfg.HTTP
+	case strings.HasPrefix(cfg.HTTP, "http://") || strings.HasPrefix(cfg.HTTP, "https://"):
+		probe.URL = cfg.HTTP
+	default:
+		return nil, fmt.Errorf("http must be a URL or a path: %q", cfg.HTTP)
+	}
+	if cfg.Output != "" {
+		if cfg.Port != 0 || cfg.HTTP != "" {
+			return nil, errors.New("output cannot be combined with port or http")
+		}
+		var err error
+		probe.Output, err = regexp.Compile(cfg.Output)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output pattern: %w", err)
+		}
+	}
+	if probe.Port == 0 && probe.URL == "" && probe.Output == nil {
+		return nil, errors.New("requires port, http, or output")
+	}
+	if cfg.Timeout != "" {
+		var err error
+		probe.Timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return probe, nil
+}
+
+// wait polls until the probe passes, or fails once the process exits or the
+// timeout elapses. For output probes, matched is closed when the output
+// matches.
+func (probe *ReadinessProbe) wait(matched <-chan struct{}, exited <-chan struct{}) error {
+	timeout := time.NewTimer(probe.Timeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		if probe.Output == nil && probe.check() {
+			return nil
+		}
+		select {
+		case <-matched:
+			return nil
+		case <-exited:
+			return errors.New("exited before it was ready")
+		case <-timeout.C:
+			return fmt.Errorf("not ready after %v", probe.Timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reports whether the port accepts connections and the URL responds
+// successfully, whichever are configured.
+func (probe *ReadinessProbe) check() bool {
+	if probe.URL != "" {
+		client := http.Client{Timeout: time.Second}
+		resp, err := client.Get(probe.URL)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return 200 <= resp.StatusCode && resp.StatusCode < 300
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(probe.Port)), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Longest partial line that outputMatcher buffers.
+const maxMatchedLine = 64 * 1024
+
+// outputMatcher passes output through to w, and closes matched once a line of
+// the output matches pattern.
+type outputMatcher struct {
+	w       io.Writer
+	pattern *regexp.Regexp
+	matched chan struct{}
+	line    []byte
+	done    bool
+}
+
+func newOutputMatcher(w io.Writer, pattern *regexp.Regexp) *outputMatcher {
+	return &outputMatcher{
+		w:       w,
+		pattern: pattern,
+		matched: make(chan struct{}),
+	}
+}
+
+func (m *outputMatcher) Write(p []byte) (int, error) {
+	if !m.done {
+		m.line = append(m.line, p...)
+		for {
+			i := bytes.IndexByte(m.line, '\n')
+			if i < 0 {
+				break
+			}
+			if m.pattern.Match(m.line[:i]) {
+				m.done = true
+				m.line = nil
+				close(m.matched)
+				break
+			}
+			m.line = m.line[i+1:]
+		}
+		if len(m.line) > maxMatchedLine {
+			m.line = m.line[len(m.line)-maxMatchedLine:]
+		}
+	}
+	return m.w.Write(p)
+}
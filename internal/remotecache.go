@@ -0,0 +1,431 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// RemoteCache configures a store of built packages shared between machines,
+// such as by CI and developers. Exactly one of URL or S3 is set.
+type RemoteCache struct {
+	// Base URL of an HTTP server that stores archives with PUT and serves them
+	// with GET, at <URL>/<key>.tar.gz.
+	URL string
+	// Name of the environment variable holding a bearer token for URL, if any.
+	TokenEnv string
+	S3       *S3Cache
+	// If set, packages are downloaded but never uploaded.
+	ReadOnly bool
+}
+
+// S3Cache stores archives as objects in an S3 bucket, or a bucket of another
+// service with an S3-compatible API, such as Google Cloud Storage. Credentials
+// are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN environment variables.
+type S3Cache struct {
+	Bucket string
+	Region string
+	// Prepended to object keys.
+	Prefix string
+	// Base URL of the service. Defaults to AWS S3 in Region.
+	Endpoint string
+}
+
+// remoteStore gets and puts archives by key.
+type remoteStore interface {
+	// Get returns the archive stored with key, or nil if there is none.
+	Get(key string) ([]byte, error)
+	Put(key string, archive []byte) error
+}
+
+func newRemoteStore(cfg *RemoteCache) remoteStore {
+	if cfg.S3 != nil {
+		return &s3Store{cfg: *cfg.S3}
+	}
+	return &httpStore{url: cfg.URL, tokenEnv: cfg.TokenEnv}
+}
+
+// remotePackageCache restores and saves builds of a package with a remote
+// store.
+type remotePackageCache struct {
+	store    remoteStore
+	readOnly bool
+	key      string
+	inputs   []string
+}
+
+func newRemotePackageCache(repo *Repository, pkg *Package, cache *buildCache) (*remotePackageCache, error) {
+	key, inputs, err := remoteCacheKey(repo, pkg, cache)
+	if err != nil {
+		return nil, err
+	}
+	return &remotePackageCache{
+		store:    newRemoteStore(repo.RemoteCache),
+		readOnly: repo.RemoteCache.ReadOnly,
+		key:      key,
+		inputs:   inputs,
+	}, nil
+}
+
+// Restore replaces the package directory and metafile with a stored build, if
+// any, and records it in the local cache. Reports whether a build was found.
+func (rc *remotePackageCache) Restore(cache *buildCache, packageDir string, metafilePath string) (bool, error) {
+	archive, err := rc.store.Get(rc.key)
+	if err != nil || archive == nil {
+		return false, err
+	}
+	if err := extractPackage(archive, packageDir, metafilePath); err != nil {
+		return false, err
+	}
+	cache.AddInputs(rc.inputs)
+	return true, cache.Save()
+}
+
+// Save stores the built package, unless the cache is read-only.
+func (rc *remotePackageCache) Save(packageDir string, metafilePath string) error {
+	if rc.readOnly {
+		return nil
+	}
+	archive, err := archivePackage(packageDir, metafilePath)
+	if err != nil {
+		return err
+	}
+	return rc.store.Put(rc.key, archive)
+}
+
+// remoteCacheKey identifies a package build by its settings and the contents
+// of the files it loads, such that the key is the same on any machine with
+// identical sources. The loaded files are also returned.
+func remoteCacheKey(repo *Repository, pkg *Package, cache *buildCache) (string, []string, error) {
+	var entrypoints []string
+	for _, entrypoint := range pkg.entrypointPaths() {
+		entrypoints = append(entrypoints, path.Join(repo.RootDir, entrypoint))
+	}
+	opts := api.BuildOptions{
+		Platform: pkg.Platform.esbuildPlatform(),
+		External: getPackageExternals(repo, pkg),
+	}
+	_, inputs := findWorkers(repo, entrypoints, opts)
+	if len(inputs) == 0 {
+		return "", nil, errors.New("no inputs found")
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", cache.key)
+	for _, input := range inputs {
+		hash, err := hashFile(input)
+		if err != nil {
+			return "", nil, err
+		}
+		rel, err := filepath.Rel(repo.RootDir, input)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(h, "%s %s\n", hash, filepath.ToSlash(rel))
+	}
+	return hex.EncodeToString(h.Sum(nil)), inputs, nil
+}
+
+// Names of special entries in remote cache archives. Files of the package
+// directory are stored under packageArchiveDir.
+const (
+	packageArchiveDir   = "package/"
+	metafileArchiveName = "meta.json"
+)
+
+// archivePackage returns a gzipped tarball of a built package directory and
+// its metafile.
+func archivePackage(packageDir string, metafilePath string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	addFile := func(name string, filename string, mode os.FileMode) error {
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: int64(mode.Perm()),
+			Size: int64(len(bs)),
+		}); err != nil {
+			return err
+		}
+		_, err = tw.Write(bs)
+		return err
+	}
+	err := filepath.Walk(packageDir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(packageDir, filename)
+		if err != nil {
+			return err
+		}
+		return addFile(packageArchiveDir+filepath.ToSlash(rel), filename, fi.Mode())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(metafilePath); err == nil {
+		if err := addFile(metafileArchiveName, metafilePath, fi.Mode()); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extractPackage replaces a package directory and its metafile with the
+// contents of an archive created by archivePackage.
+func extractPackage(archive []byte, packageDir string, metafilePath string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(packageDir); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var filename string
+		switch {
+		case hdr.Name == metafileArchiveName:
+			filename = metafilePath
+		case strings.HasPrefix(hdr.Name, packageArchiveDir):
+			rel := path.Clean(strings.TrimPrefix(hdr.Name, packageArchiveDir))
+			if rel == "." || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+				return fmt.Errorf("invalid archive entry: %q", hdr.Name)
+			}
+			filename = filepath.Join(packageDir, filepath.FromSlash(rel))
+		default:
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		bs, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, bs, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+	}
+}
+
+type httpStore struct {
+	url      string
+	tokenEnv string
+}
+
+func (store *httpStore) request(method string, key string, body []byte) (*http.Response, error) {
+	var token string
+	if store.tokenEnv != "" {
+		token = os.Getenv(store.tokenEnv)
+		if token == "" {
+			return nil, fmt.Errorf("%s is not set", store.tokenEnv)
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(store.url, "/")+"/"+key+".tar.gz", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (store *httpStore) Get(key string) ([]byte, error) {
+	resp, err := store.request("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readStoreResponse(resp)
+}
+
+func (store *httpStore) Put(key string, archive []byte) error {
+	resp, err := store.request("PUT", key, archive)
+	if err != nil {
+		return err
+	}
+	_, err = readStoreResponse(resp)
+	return err
+}
+
+// readStoreResponse returns the body of a successful response, or nil if the
+// requested object was not found.
+func readStoreResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type s3Store struct {
+	cfg S3Cache
+}
+
+func (store *s3Store) request(method string, key string, body []byte) (*http.Response, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	region := store.cfg.Region
+	if region == "" {
+		// S3-compatible services generally accept any region.
+		region = "us-east-1"
+	}
+	endpoint := store.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	objectPath := "/" + store.cfg.Bucket + "/" + store.cfg.Prefix + key + ".tar.gz"
+	u := *base
+	u.Path = strings.TrimSuffix(base.Path, "/") + objectPath
+	// Send the path encoded exactly as it is signed.
+	u.RawPath = awsURIEncodePath(u.Path)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signS3Request(req, body, region, accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), time.Now().UTC())
+	return http.DefaultClient.Do(req)
+}
+
+func (store *s3Store) Get(key string) ([]byte, error) {
+	resp, err := store.request("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return readStoreResponse(resp)
+}
+
+func (store *s3Store) Put(key string, archive []byte) error {
+	resp, err := store.request("PUT", key, archive)
+	if err != nil {
+		return err
+	}
+	_, err = readStoreResponse(resp)
+	return err
+}
+
+// signS3Request adds an AWS Signature Version 4 authorization header to a
+// request without a query string.
+func signS3Request(req *http.Request, body []byte, region string, accessKey string, secretKey string, sessionToken string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncodePath(req.URL.Path),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func awsURIEncodePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsURIEncode percent-encodes all but unreserved characters, as required by
+// AWS signatures.
+func awsURIEncode(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.' || b == '~' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// The REPL is node's own, started by replRunner, but each input is compiled
+// by uni before node evaluates it. The runner posts inputs to a server on the
+// loopback interface, rather than using stdin and stdout, which belong to the
+// REPL itself. Inputs are compiled from TypeScript and their import statements
+// are replaced with requires of bundles, built just as `uni run` builds
+// programs, so that imports of workspace packages, aliases, and TypeScript
+// modules work as they do in programs.
+
+// Starts a REPL that compiles each input with the server at the URL given by
+// the first argument. Inputs that end prematurely are recoverable, so that
+// they may be continued on the following lines.
+const replRunner = `
+const http = require('http');
+const repl = require('repl');
+const vm = require('vm');
+const [, compileURL, historyPath] = process.argv;
+global.__uniImportDefault = (mod) => mod && mod.__esModule ? mod.default : mod;
+const compile = (code) => new Promise((resolve, reject) => {
+  const req = http.request(compileURL, { method: 'POST' }, (res) => {
+    let body = '';
+    res.setEncoding('utf8');
+    res.on('data', (chunk) => { body += chunk; });
+    res.on('end', () => {
+      try {
+        resolve(JSON.parse(body));
+      } catch (err) {
+        reject(err);
+      }
+    });
+  });
+  req.on('error', reject);
+  req.end(JSON.stringify({ code }));
+});
+// Inputs are evaluated in order, even when piped faster than they compile.
+let pending = Promise.resolve();
+const server = repl.start({
+  useGlobal: true,
+  eval(input, context, filename, callback) {
+    pending = pending.then(() => compile(input)).then((compiled) => {
+      if (compiled.error) {
+        const err = new SyntaxError(compiled.error);
+        err.stack = 'SyntaxError: ' + compiled.error;
+        callback(compiled.recoverable ? new repl.Recoverable(err) : err);
+        return;
+      }
+      let result;
+      try {
+        result = vm.runInThisContext(compiled.code, { filename });
+      } catch (err) {
+        callback(err);
+        return;
+      }
+      callback(null, result);
+    }, callback);
+  },
+});
+server.setupHistory(historyPath, () => {});
+`
+
+// Matches import statements as printed by esbuild, each on its own line.
+var replImportPattern = regexp.MustCompile(`(?m)^import (?:(.+) from )?("(?:[^"\\]|\\.)*");$`)
+
+// Matches the named imports of an import clause, such as "a as b".
+var replNamedImportPattern = regexp.MustCompile(`^([\w$]+|"(?:[^"\\]|\\.)*")(?: as ([\w$]+))?$`)
+
+// Prevents esbuild from dropping imports that are unused, or used only as
+// types, since a later input may use them.
+const replTsconfig = `{"compilerOptions":{"importsNotUsedAsValues":"preserve"}}`
+
+type replCompiler struct {
+	repo    *Repository
+	dir     string
+	cwd     string
+	plugins []api.Plugin
+
+	mx      sync.Mutex
+	bundles int
+}
+
+type replRequest struct {
+	Code string `json:"code"`
+}
+
+type replResponse struct {
+	Code        string `json:"code,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Recoverable bool   `json:"recoverable,omitempty"`
+}
+
+// Repl starts node's REPL in the current directory, with TypeScript and
+// imports of modules resolved as for programs run by uni.
+func Repl(repo *Repository) error {
+	if err := EnsureTmp(repo); err != nil {
+		return err
+	}
+	dir, err := TempDir(repo, "repl")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	plugins, err := modulePlugins(repo)
+	if err != nil {
+		return err
+	}
+	compiler := &replCompiler{
+		repo:    repo,
+		dir:     dir,
+		cwd:     cwd,
+		plugins: plugins,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	go func() {
+		_ = http.Serve(listener, compiler)
+	}()
+
+	compileURL := "http://" + listener.Addr().String() + "/"
+	historyPath := path.Join(repo.TmpDir, "repl_history")
+	node, err := nodeCommand(repo, "--enable-source-maps", "-e", replRunner, compileURL, historyPath)
+	if err != nil {
+		return err
+	}
+	node.Stdin = os.Stdin
+	node.Stdout = os.Stdout
+	node.Stderr = os.Stderr
+	if err := repo.wrapCommand(node); err != nil {
+		return err
+	}
+	return node.Run()
+}
+
+func (c *replCompiler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var input replRequest
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.compile(input.Code))
+}
+
+// compile compiles an input of the REPL into a script for node to evaluate.
+func (c *replCompiler) compile(input string) replResponse {
+	// Like node, treat input that begins with a brace as an object literal,
+	// rather than a block, if that parses.
+	trimmed := strings.TrimSpace(input)
+	if strings.HasPrefix(trimmed, "{") && !strings.HasSuffix(trimmed, ";") {
+		if result := c.transform("(" + trimmed + ")"); len(result.Errors) == 0 {
+			return c.link(string(result.Code))
+		}
+	}
+	result := c.transform(input)
+	if len(result.Errors) > 0 {
+		message := result.Errors[0]
+		return replResponse{
+			Error:       message.Text,
+			Recoverable: strings.Contains(message.Text, "end of file"),
+		}
+	}
+	return c.link(string(result.Code))
+}
+
+func (c *replCompiler) transform(code string) api.TransformResult {
+	return api.Transform(code, api.TransformOptions{
+		Loader:      api.LoaderTS,
+		Sourcefile:  "repl",
+		TsconfigRaw: replTsconfig,
+		Engines:     nodeEngines(c.repo),
+		Define:      c.repo.Define,
+		LogLevel:    api.LogLevelSilent,
+	})
+}
+
+// link replaces the import statements of compiled code with requires of
+// bundles of the imported modules.
+func (c *replCompiler) link(code string) replResponse {
+	var linkErr error
+	code = replImportPattern.ReplaceAllStringFunc(code, func(stmt string) string {
+		if linkErr != nil {
+			return stmt
+		}
+		match := replImportPattern.FindStringSubmatch(stmt)
+		clause := match[1]
+		specifier, err := strconv.Unquote(match[2])
+		if err != nil {
+			linkErr = fmt.Errorf("invalid import of %s", match[2])
+			return stmt
+		}
+		bundle, err := c.bundle(specifier)
+		if err != nil {
+			linkErr = err
+			return stmt
+		}
+		required := "require(" + strconv.Quote(bundle) + ")"
+		if clause == "" {
+			return required + ";"
+		}
+		bindings, err := replImportBindings(clause, required)
+		if err != nil {
+			linkErr = err
+			return stmt
+		}
+		return bindings
+	})
+	if linkErr != nil {
+		return replResponse{Error: linkErr.Error()}
+	}
+	return replResponse{Code: code}
+}
+
+// replImportBindings returns declarations of the bindings of an import clause,
+// such as `a, { b as c }`, from the module required by the given expression.
+// Declarations use var, so that imports may be repeated.
+func replImportBindings(clause string, required string) (string, error) {
+	var defaultName string
+	if !strings.HasPrefix(clause, "{") && !strings.HasPrefix(clause, "*") {
+		defaultName = clause
+		clause = ""
+		if comma := strings.Index(defaultName, ","); comma >= 0 {
+			defaultName, clause = defaultName[:comma], strings.TrimSpace(defaultName[comma+1:])
+		}
+	}
+	var decls []string
+	ns := required
+	if defaultName != "" && clause != "" {
+		// Evaluate the require only once.
+		ns = "__uniImport"
+		decls = append(decls, "var __uniImport = "+required+";")
+	}
+	if defaultName != "" {
+		decls = append(decls, "var "+defaultName+" = __uniImportDefault("+ns+");")
+	}
+	switch {
+	case clause == "":
+	case strings.HasPrefix(clause, "* as "):
+		decls = append(decls, "var "+strings.TrimPrefix(clause, "* as ")+" = "+ns+";")
+	case strings.HasPrefix(clause, "{") && strings.HasSuffix(clause, "}"):
+		var props []string
+		for _, item := range strings.Split(clause[1:len(clause)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			match := replNamedImportPattern.FindStringSubmatch(item)
+			if match == nil {
+				return "", fmt.Errorf("unsupported import of %s", item)
+			}
+			name, local := match[1], match[2]
+			if local == "" {
+				local = name
+			}
+			if name == "default" || name == `"default"` {
+				decls = append(decls, "var "+local+" = __uniImportDefault("+ns+");")
+				continue
+			}
+			props = append(props, name+": "+local)
+		}
+		if len(props) > 0 {
+			decls = append(decls, "var { "+strings.Join(props, ", ")+" } = "+ns+";")
+		}
+	default:
+		return "", fmt.Errorf("unsupported import clause: %s", clause)
+	}
+	return strings.Join(decls, " "), nil
+}
+
+// bundle bundles a module imported by the REPL, as resolved from the current
+// directory, and returns the path of the bundle. Each import is bundled anew,
+// so that importing a module again picks up changes to it.
+func (c *replCompiler) bundle(specifier string) (string, error) {
+	c.mx.Lock()
+	c.bundles++
+	outfile := path.Join(c.dir, fmt.Sprintf("import%d.js", c.bundles))
+	c.mx.Unlock()
+
+	opts, err := runEsbuildOptions(c.repo, "", outfile)
+	if err != nil {
+		return "", err
+	}
+	opts.EntryPoints = nil
+	opts.Stdin = &api.StdinOptions{
+		Contents:   "module.exports = require(" + strconv.Quote(specifier) + ");",
+		ResolveDir: c.cwd,
+		Sourcefile: "repl",
+	}
+	opts.External = resolveExternals(c.repo, Externals{}, true)
+	opts.Sourcemap = api.SourceMapInline
+	opts.LogLevel = api.LogLevelSilent
+	opts.Plugins = c.plugins
+	result := api.Build(opts)
+	if len(result.Errors) > 0 {
+		messages := make([]string, len(result.Errors))
+		for i, message := range result.Errors {
+			messages[i] = message.Text
+		}
+		return "", errors.New(strings.Join(messages, "\n"))
+	}
+	return outfile, nil
+}
@@ -1,25 +1,138 @@
 package internal
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/evanw/esbuild/pkg/api"
 	"github.com/goccy/go-yaml"
 )
 
 type Repository struct {
-	ConfigPath   string
+	ConfigPath string
+	// Files the config was loaded from: the config file, the modules imported
+	// by config scripts, and the manifests of discovered workspaces.
+	ConfigInputs []string
+	// Hash of the loaded config, which for config scripts is their output,
+	// and of the other files it was loaded from.
+	configHash   string
+	configStamps map[string]fileStamp
 	RootDir      string
 	OutDir       string
 	DistDir      string
 	TmpDir       string
 	Engines      map[string]string
+	// Which node binary to run.
+	Node NodeOptions
+	// The node binary found by NodeBinary, once found.
+	nodeMx       sync.Mutex
+	nodeBinary   string
 	Packages     map[string]*Package
 	Dependencies map[string]*Dependency
 	Url          string
 	Registry     string
+	// Package manager that installs dependencies.
+	PackageManager PackageManager
+	// Defaults for stopping processes started by `uni run`.
+	Shutdown ShutdownOptions
+	// Default strategy for restarting processes in watch mode.
+	Restart RestartStrategy
+	// Default policy for restarting processes that crash in watch mode, if
+	// they are restarted automatically.
+	CrashRestart *CrashRestartPolicy
+	// What to do in watch mode when a port that a program listens on is in use
+	// as it starts.
+	PortConflict PortConflictStrategy
+	// Default esbuild target of programs run by uni, rather than one derived
+	// from the node engine version, if set. See parseTarget.
+	ProgramTarget string
+	// Default runtime of programs run by uni, unless configured for a target.
+	Runtime Runtime
+	// Named entrypoints for `uni run`, and named lists of them to run
+	// together.
+	RunTargets map[string]*RunTarget
+	RunGroups  map[string][]string
+	// Glob patterns of paths, relative to RootDir, that never trigger rebuilds
+	// in watch mode.
+	WatchIgnore []string
+	// How long to wait after a change for more changes, so that many changes
+	// at once cause a single rebuild. Each change restarts the wait.
+	WatchDebounce time.Duration
+	// How long temporary directories that no running process owns are kept.
+	// Zero means forever. See collectTempDirs.
+	TmpMaxAge time.Duration
+	// Map of global identifiers to JavaScript expressions that replace them at
+	// build time.
+	Define map[string]string
+	// Map of import path prefixes to directories or files relative to RootDir.
+	Aliases map[string]string
+	// Map of aliased workspace names to their index modules, which imports of
+	// the names alone resolve to.
+	workspaceIndexes map[string]string
+	// Code generators, sorted by name.
+	Codegen []*Codegen
+	// Held while running code generators, which concurrent builds share.
+	codegenMx sync.Mutex
+	// Map of file extensions to loaders, in addition to the defaults.
+	Loaders map[string]api.Loader
+	// How JSX is compiled, unless overridden by a package.
+	JSX JSXOptions
+	// Where to upload source maps after building, if configured.
+	SourceMapUpload *SourceMapUpload
+	// Additional fields of generated package.json files.
+	PackageJSON map[string]interface{}
+	// Licenses of third-party packages that may be bundled, if restricted.
+	Licenses *LicensePolicy
+	// Restrictions on imports between packages, if configured.
+	Boundaries *Boundaries
+	// Workspace globs to preserve in the generated root package.json, when
+	// packages are discovered from them.
+	WorkspaceGlobs []string
+	// Overrides of which modules are bundled and which are external.
+	Externals Externals
+	// Where to share built packages between machines, if configured.
+	RemoteCache *RemoteCache
+	// Modules exporting JavaScript esbuild plugins, as configured: paths
+	// relative to RootDir, or names of installed packages. See jsPlugins.
+	PluginFiles []string
+	// The plugins, their hash, and any error, once loaded.
+	jsPluginsOnce sync.Once
+	jsPlugins     []api.Plugin
+	jsPluginsHash string
+	jsPluginsErr  error
+	// Installed packages with native addons. See nativePackages.
+	nativePackagesCache map[string]string
+	nativePackagesStamp fileStamp
+	nativePackagesMx    sync.Mutex
+	// Most recently loaded package graph. See LoadPackageGraph.
+	packageGraph   *PackageGraph
+	packageGraphMx sync.Mutex
+
+	// Extensions set by programs that embed uni, rather than by config. See
+	// package github.com/deref/uni/pkg/uni. Daemons are not used when these
+	// are set, since they would not apply.
+
+	// Additional esbuild plugins of every build and run. They come after uni's
+	// plugins that resolve aliases and observe loaded files, so their load
+	// callbacks may supply the contents of files that are still watched.
+	Plugins []api.Plugin
+	// Called with the command of each process started by run, exec, and test
+	// before it starts, such as to adjust its environment or to wrap it with
+	// another program. An error fails the start.
+	WrapCommand func(cmd *exec.Cmd) error
 }
 
 type Dependency struct {
@@ -32,7 +145,209 @@ type Package struct {
 	Public      bool
 	Description string
 	Index       string
+	// Version stamped into built packages, unless overridden. If empty, the
+	// latest tagged version is used instead. See PackageVersion.
+	Version     string
 	Executables map[string]*Executable
+	// Map of export subpaths, such as "cli" for "<package>/cli", to the paths
+	// of their entrypoint modules.
+	Entrypoints map[string]string
+	// Paths of additional entrypoints, such as worker_threads workers or
+	// scripts for child processes, that are bundled separately alongside the
+	// index module.
+	Workers []string
+	// Map of task name to task, for uni task.
+	Tasks map[string]*Task
+	// Commands run before each build and after each successful build. See
+	// commandHook.
+	Prebuild  []string
+	Postbuild []string
+	Format    Format
+	Platform  Platform
+	// Language and engine versions to compile for, in esbuild's --target
+	// syntax. Empty means the latest language version.
+	Target string
+	// Additional module names to exclude from the bundle.
+	External []string
+	Minify   bool
+	// Empty if unspecified.
+	SourceMap SourceMap
+	// How JSX is compiled, including the repository's options that the
+	// package does not override.
+	JSX JSXOptions
+	// Limits on the size of built files, if any.
+	Budget *SizeBudget
+	// How the package is built with the lambda preset, if configured.
+	Lambda *LambdaOptions
+	// How the package is built into a Docker image, if configured.
+	Docker *DockerOptions
+	// Text added to the start and end of every built JavaScript file, such as
+	// license headers.
+	Banner string
+	Footer string
+	// Maps of entrypoint paths, relative to the repository root, to text
+	// added to the start and end of their outputs only, such as hashbangs.
+	// See wrapOutput.
+	Banners map[string]string
+	Footers map[string]string
+	// Additional fields of the generated package.json file, which take
+	// precedence over those of the repository.
+	PackageJSON map[string]interface{}
+	// Modules that consumers of the package must provide, such as react. Peer
+	// dependencies are never bundled.
+	PeerDependencies map[string]*PeerDependency
+	// Names of configured dependencies that the package can do without, such
+	// as fsevents. Optional dependencies are never bundled.
+	OptionalDependencies []string
+	// Which built files have side effects when imported, if declared.
+	SideEffects *SideEffects
+}
+
+// SideEffects is the sideEffects field of a built package.json, which lets
+// bundlers of the package's consumers drop imports of files without side
+// effects whose exports are unused.
+type SideEffects struct {
+	// Globs of built files that have side effects, if any are given.
+	Files []string
+	// Whether every built file has side effects, if no globs are given.
+	All bool
+}
+
+// packageField returns the value of the sideEffects field of package.json.
+func (sideEffects *SideEffects) packageField() interface{} {
+	if sideEffects.Files != nil {
+		return sideEffects.Files
+	}
+	return sideEffects.All
+}
+
+// none reports whether the package declares that no files have side effects.
+func (sideEffects *SideEffects) none() bool {
+	return sideEffects != nil && sideEffects.Files == nil && !sideEffects.All
+}
+
+// parseSideEffects parses a sideEffects setting, which is either a bool or a
+// list of globs. Returns nil if unset.
+func parseSideEffects(value interface{}) (*SideEffects, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &SideEffects{All: value}, nil
+	case []interface{}:
+		files := make([]string, 0, len(value))
+		for _, elem := range value {
+			glob, ok := elem.(string)
+			if !ok || glob == "" {
+				return nil, fmt.Errorf("expected a glob, but found %v", elem)
+			}
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", glob, err)
+			}
+			files = append(files, glob)
+		}
+		return &SideEffects{Files: files}, nil
+	default:
+		return nil, errors.New("expected true, false, or a list of globs")
+	}
+}
+
+type PeerDependency struct {
+	// Semver range of supported versions.
+	Version  string
+	Optional bool
+}
+
+// externals returns the names of modules that the package configures to
+// never be bundled.
+func (pkg *Package) externals() []string {
+	externals := append([]string{}, pkg.External...)
+	for name := range pkg.PeerDependencies {
+		externals = append(externals, name)
+	}
+	externals = append(externals, pkg.OptionalDependencies...)
+	sort.Strings(externals)
+	return externals
+}
+
+// Platform is the runtime environment targeted by a built package.
+type Platform string
+
+const (
+	PlatformNode    Platform = "node"
+	PlatformBrowser Platform = "browser"
+)
+
+func (platform Platform) esbuildPlatform() api.Platform {
+	if platform == PlatformBrowser {
+		return api.PlatformBrowser
+	}
+	return api.PlatformNode
+}
+
+// Format is the module format of a built package.
+type Format string
+
+const (
+	FormatCommonJS Format = "cjs"
+	FormatESModule Format = "esm"
+	// FormatDual builds both CommonJS and ES modules. CommonJS is primary.
+	FormatDual Format = "dual"
+)
+
+// Extension of built JavaScript files of this format.
+func (format Format) Extension() string {
+	if format == FormatESModule {
+		return ".mjs"
+	}
+	return ".js"
+}
+
+func (format Format) esbuildFormat() api.Format {
+	if format == FormatESModule {
+		return api.FormatESModule
+	}
+	return api.FormatCommonJS
+}
+
+// SourceMap is a strategy for generating source maps.
+type SourceMap string
+
+const (
+	// SourceMapLinked writes a separate source map file, referenced by a comment
+	// in the output.
+	SourceMapLinked SourceMap = "linked"
+	// SourceMapExternal is an alias of SourceMapLinked.
+	SourceMapExternal SourceMap = "external"
+	// SourceMapHidden writes a separate source map file without referencing it,
+	// such as for uploading to error reporting services.
+	SourceMapHidden SourceMap = "hidden"
+	SourceMapInline SourceMap = "inline"
+	SourceMapNone   SourceMap = "none"
+)
+
+// ParseSourceMap validates a source map strategy. The empty string is
+// returned as is, meaning unspecified.
+func ParseSourceMap(s string) (SourceMap, error) {
+	switch sourcemap := SourceMap(s); sourcemap {
+	case "", SourceMapLinked, SourceMapExternal, SourceMapHidden, SourceMapInline, SourceMapNone:
+		return sourcemap, nil
+	default:
+		return "", fmt.Errorf("invalid sourcemap: %q, expected linked, external, hidden, inline, or none", s)
+	}
+}
+
+func (sourcemap SourceMap) esbuildSourceMap() api.SourceMap {
+	switch sourcemap {
+	case SourceMapHidden:
+		return api.SourceMapExternal
+	case SourceMapInline:
+		return api.SourceMapInline
+	case SourceMapNone:
+		return api.SourceMapNone
+	default:
+		return api.SourceMapLinked
+	}
 }
 
 type Executable struct {
@@ -40,23 +355,80 @@ type Executable struct {
 	Entrypoint string
 }
 
+// entrypointPaths returns the sorted paths of all of a package's entrypoint
+// modules, relative to the repository root.
+func (pkg *Package) entrypointPaths() []string {
+	var entrypoints []string
+	if pkg.Index != "" {
+		entrypoints = append(entrypoints, pkg.Index)
+	}
+	for _, entrypoint := range pkg.Entrypoints {
+		entrypoints = append(entrypoints, entrypoint)
+	}
+	for _, executable := range pkg.Executables {
+		entrypoints = append(entrypoints, executable.Entrypoint)
+	}
+	entrypoints = append(entrypoints, pkg.Workers...)
+	sort.Strings(entrypoints)
+	return entrypoints
+}
+
 const DefaultRegistry = "https://registry.npmjs.org/"
 
+// Watch ignore patterns that apply in addition to those configured.
+var defaultWatchIgnore = []string{
+	"**/.git/**",
+	"**/node_modules/**",
+	"out/**",
+	// Editor temporary files.
+	"**/*~",
+	"**/.#*",
+	"**/*.swp",
+	"**/4913",
+}
+
+const (
+	DefaultShutdownSignal  = "SIGTERM"
+	DefaultShutdownTimeout = 5 * time.Second
+	DefaultWatchDebounce   = 50 * time.Millisecond
+	DefaultTmpMaxAge       = 24 * time.Hour
+)
+
 func LoadRepository(searchDir string) (*Repository, error) {
-	f, err := openConfigFile(searchDir)
+	sp := startSpan(os.Stderr, LogLevelVerbose, "config", "load config", "", nil)
+	defer sp.End()
+
+	configPath, err := findConfigFile(searchDir)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
 	var repo Repository
-	repo.ConfigPath = f.Name()
+	repo.ConfigPath = configPath
 	repo.RootDir = path.Dir(repo.ConfigPath)
 	repo.OutDir = path.Join(repo.RootDir, "out")
 	repo.DistDir = path.Join(repo.OutDir, "dist")
 	repo.TmpDir = path.Join(repo.OutDir, "tmp")
 
-	dec := yaml.NewDecoder(f, yaml.Strict())
+	var text []byte
+	generated := path.Ext(configPath) != ".yml"
+	if generated {
+		text, repo.ConfigInputs, err = evaluateConfigScript(&repo, configPath)
+	} else {
+		text, err = ioutil.ReadFile(configPath)
+		repo.ConfigInputs = []string{configPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Validate against the schema first, since the decoder reports only the
+	// first problem and locates only some kinds of problems.
+	// Errors name the config file relative to the repository root.
+	src, err := parseConfigSource(path.Base(repo.ConfigPath), text, generated)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(text), yaml.Strict())
 	var cfg Config
 	err = dec.Decode(&cfg)
 	if err == io.EOF {
@@ -64,19 +436,335 @@ func LoadRepository(searchDir string) (*Repository, error) {
 		err = nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, src.yamlError(err)
 	}
 
+	if cfg.DiscoverWorkspaces {
+		// Packages configured explicitly take precedence.
+		ws, err := discoverWorkspaces(repo.RootDir, cfg.Packages)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("discoverWorkspaces: %w", err), "discoverWorkspaces")
+		}
+		if cfg.Packages == nil {
+			cfg.Packages = make(map[string]PackageConfig)
+		}
+		for name, packageConfig := range ws.Packages {
+			cfg.Packages[name] = packageConfig
+		}
+		// Workspaces import each other by name, so alias those names to their
+		// directories, rather than resolving them from node_modules. Imports of
+		// a name alone resolve to the workspace's index module.
+		if cfg.Aliases == nil {
+			cfg.Aliases = make(map[string]string)
+		}
+		repo.workspaceIndexes = make(map[string]string)
+		for _, name := range ws.Names {
+			if _, ok := cfg.Aliases[name]; !ok && cfg.Packages[name].Index != "" {
+				cfg.Aliases[name] = ws.Dirs[name]
+				repo.workspaceIndexes[name] = cfg.Packages[name].Index
+			}
+		}
+		repo.WorkspaceGlobs = ws.PackageJSONGlobs
+		repo.ConfigInputs = append(repo.ConfigInputs, ws.Manifests...)
+	}
+
+	h := sha256.New()
+	h.Write(text)
+	repo.configStamps = make(map[string]fileStamp)
+	for _, input := range repo.ConfigInputs {
+		repo.configStamps[input] = statFileStamp(input)
+		if input != configPath {
+			inputHash, _ := hashFile(input)
+			fmt.Fprintf(h, "\n%s", inputHash)
+		}
+	}
+	repo.configHash = hex.EncodeToString(h.Sum(nil))
+
 	repo.Engines = make(map[string]string)
 	for engineName, engineVersion := range cfg.Engines {
 		repo.Engines[engineName] = engineVersion
 	}
+	if version, ok := repo.Engines["node"]; ok {
+		if _, err := parseSemverRange(version); err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid engines.node: %w", err), "engines", "node")
+		}
+	}
+	if cfg.Node != nil {
+		repo.Node, err = newNodeOptions(repo.RootDir, cfg.Node)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid node: %w", err), "node")
+		}
+	}
 
 	repo.Url = cfg.Repository
 	repo.Registry = cfg.Registry
 	if repo.Registry == "" {
 		repo.Registry = DefaultRegistry
 	}
+	switch PackageManager(cfg.PackageManager) {
+	case "", PackageManagerNpm:
+		repo.PackageManager = PackageManagerNpm
+	case PackageManagerYarn, PackageManagerPnpm:
+		repo.PackageManager = PackageManager(cfg.PackageManager)
+	default:
+		return nil, src.errorAt(fmt.Errorf("invalid packageManager: %q", cfg.PackageManager), "packageManager")
+	}
+
+	shutdownSignal := cfg.Run.ShutdownSignal
+	if shutdownSignal == "" {
+		shutdownSignal = DefaultShutdownSignal
+	}
+	repo.Shutdown.Signal, err = ParseSignal(shutdownSignal)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid run.shutdownSignal: %w", err), "run", "shutdownSignal")
+	}
+	repo.Shutdown.Timeout = DefaultShutdownTimeout
+	if cfg.Run.ShutdownTimeout != "" {
+		repo.Shutdown.Timeout, err = time.ParseDuration(cfg.Run.ShutdownTimeout)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid run.shutdownTimeout: %w", err), "run", "shutdownTimeout")
+		}
+	}
+	repo.Restart, err = ParseRestartStrategy(cfg.Run.Restart)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid run.restart: %w", err), "run", "restart")
+	}
+	repo.ProgramTarget = cfg.Run.Target
+	if err := ValidateTarget(repo.ProgramTarget); err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid run.target: %w", err), "run", "target")
+	}
+	repo.Runtime, err = ParseRuntime(cfg.Run.Runtime)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid run.runtime: %w", err), "run", "runtime")
+	}
+	if repo.Runtime == "" {
+		repo.Runtime = RuntimeNode
+	}
+	repo.PortConflict, err = ParsePortConflictStrategy(cfg.Run.PortConflict)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid run.portConflict: %w", err), "run", "portConflict")
+	}
+	if cfg.Run.CrashRestart != nil {
+		repo.CrashRestart, err = newCrashRestartPolicy(cfg.Run.CrashRestart)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid run.crashRestart: %w", err), "run", "crashRestart")
+		}
+	}
+
+	repo.RunTargets = make(map[string]*RunTarget)
+	for name, targetConfig := range cfg.Run.Targets {
+		if targetConfig.Entrypoint == "" {
+			return nil, src.errorAt(fmt.Errorf("run target %q requires entrypoint", name), "run", "targets", name, "entrypoint")
+		}
+		target := &RunTarget{
+			Name:       name,
+			Entrypoint: path.Join(repo.RootDir, targetConfig.Entrypoint),
+			Args:       targetConfig.Args,
+			Ports:      targetConfig.Ports,
+			DependsOn:  targetConfig.DependsOn,
+		}
+		if targetConfig.Ready != nil {
+			target.Ready, err = newReadinessProbe(targetConfig.Ready)
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("run target %q ready: %w", name, err), "run", "targets", name, "ready")
+			}
+			if len(target.Ports) == 0 && target.Ready.Port != 0 {
+				target.Ports = []int{target.Ready.Port}
+			}
+		}
+		if targetConfig.Cwd != "" {
+			target.Dir = path.Join(repo.RootDir, targetConfig.Cwd)
+			if fi, err := os.Stat(target.Dir); err != nil || !fi.IsDir() {
+				return nil, src.errorAt(fmt.Errorf("run target %q cwd is not a directory: %q", name, targetConfig.Cwd), "run", "targets", name, "cwd")
+			}
+		}
+		target.Runtime, err = ParseRuntime(targetConfig.Runtime)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("run target %q: %w", name, err), "run", "targets", name, "runtime")
+		}
+		target.Env = targetConfig.Env
+		envNames := make([]string, 0, len(target.Env))
+		for envName := range target.Env {
+			envNames = append(envNames, envName)
+		}
+		sort.Strings(envNames)
+		for _, envName := range envNames {
+			// Check syntax, since the values of references are only known when
+			// the target is run.
+			_, err := expandEnvValue(target.Env[envName], func(string) (string, bool) { return "", true })
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("run target %q env %s: %w", name, envName, err), "run", "targets", name, "env", envName)
+			}
+		}
+		for _, port := range target.Ports {
+			if port < 1 || port > 65535 {
+				return nil, src.errorAt(fmt.Errorf("run target %q has invalid port: %d", name, port), "run", "targets", name, "ports")
+			}
+		}
+		repo.RunTargets[name] = target
+	}
+	targetNames := make(map[string]bool)
+	for name, target := range repo.RunTargets {
+		targetNames[name] = true
+		for _, dependency := range target.DependsOn {
+			if _, ok := repo.RunTargets[dependency]; !ok {
+				return nil, src.errorAt(fmt.Errorf("run target %q depends on unknown target: %q", name, dependency), "run", "targets", name, "dependsOn")
+			}
+		}
+	}
+	if _, err := orderRunTargets(&repo, sortedKeys(targetNames)); err != nil {
+		return nil, src.errorAt(err, "run", "targets")
+	}
+	repo.RunGroups = make(map[string][]string)
+	for name, members := range cfg.Run.Groups {
+		if _, ok := repo.RunTargets[name]; ok {
+			return nil, src.errorAt(fmt.Errorf("run group %q has the same name as a run target", name), "run", "groups", name)
+		}
+		if len(members) == 0 {
+			return nil, src.errorAt(fmt.Errorf("run group %q is empty", name), "run", "groups", name)
+		}
+		for _, member := range members {
+			if _, ok := repo.RunTargets[member]; !ok {
+				return nil, src.errorAt(fmt.Errorf("run group %q has unknown target: %q", name, member), "run", "groups", name)
+			}
+		}
+		repo.RunGroups[name] = members
+	}
+
+	repo.Define = make(map[string]string)
+	for k, v := range cfg.Define {
+		repo.Define[k] = v
+	}
+
+	for name, codegenConfig := range cfg.Codegen {
+		codegen, err := newCodegen(name, codegenConfig)
+		if err != nil {
+			return nil, src.errorAt(err, "codegen", name)
+		}
+		repo.Codegen = append(repo.Codegen, codegen)
+	}
+	sort.Slice(repo.Codegen, func(i, j int) bool {
+		return repo.Codegen[i].Name < repo.Codegen[j].Name
+	})
+
+	repo.Aliases = make(map[string]string)
+	for alias, target := range cfg.Aliases {
+		if alias == "" || strings.HasSuffix(alias, "/") {
+			return nil, src.errorAt(fmt.Errorf("invalid alias: %q", alias), "aliases", alias)
+		}
+		repo.Aliases[alias] = target
+	}
+
+	repo.Loaders, err = parseLoaders(cfg.Loaders)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid loaders: %w", err), "loaders")
+	}
+
+	repo.JSX, err = newJSXOptions(JSXOptions{}, cfg.JSX)
+	if err != nil {
+		return nil, src.errorAt(fmt.Errorf("invalid jsx: %w", err), "jsx")
+	}
+
+	if upload := cfg.SourceMaps.Upload; upload != nil {
+		repo.SourceMapUpload = &SourceMapUpload{}
+		switch {
+		case upload.Sentry != nil && upload.Endpoint != nil:
+			return nil, src.errorAt(errors.New("sourcemaps.upload may have only one of sentry or endpoint"), "sourcemaps", "upload")
+		case upload.Sentry != nil:
+			sentry := *upload.Sentry
+			if sentry.Organization == "" || sentry.Project == "" {
+				return nil, src.errorAt(errors.New("sourcemaps.upload.sentry requires organization and project"), "sourcemaps", "upload", "sentry")
+			}
+			if sentry.URL == "" {
+				sentry.URL = DefaultSentryURL
+			}
+			if sentry.TokenEnv == "" {
+				sentry.TokenEnv = DefaultSentryTokenEnv
+			}
+			repo.SourceMapUpload.Sentry = (*SentryUpload)(&sentry)
+		case upload.Endpoint != nil:
+			if upload.Endpoint.URL == "" {
+				return nil, src.errorAt(errors.New("sourcemaps.upload.endpoint requires url"), "sourcemaps", "upload", "endpoint")
+			}
+			repo.SourceMapUpload.Endpoint = (*EndpointUpload)(upload.Endpoint)
+		default:
+			return nil, src.errorAt(errors.New("sourcemaps.upload requires sentry or endpoint"), "sourcemaps", "upload")
+		}
+	}
+
+	if err := validatePackageFields(cfg.PackageJSON); err != nil {
+		return nil, src.errorAt(fmt.Errorf("packageJson: %w", err), "packageJson")
+	}
+	repo.PackageJSON = cfg.PackageJSON
+
+	if cfg.Licenses != nil {
+		repo.Licenses = (*LicensePolicy)(cfg.Licenses)
+	}
+
+	for i, spec := range cfg.Plugins {
+		if strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+			if _, err := os.Stat(path.Join(repo.RootDir, spec)); err != nil {
+				return nil, src.errorAt(fmt.Errorf("plugin not found: %q", spec), "plugins", strconv.Itoa(i))
+			}
+		} else if spec == "" || path.IsAbs(spec) || strings.HasPrefix(spec, ".") {
+			return nil, src.errorAt(fmt.Errorf("plugin must be a relative path starting with ./ or a package name: %q", spec), "plugins", strconv.Itoa(i))
+		}
+	}
+	repo.PluginFiles = cfg.Plugins
+
+	repo.Externals = Externals(cfg.Externals)
+	if err := repo.Externals.Validate(); err != nil {
+		return nil, src.errorAt(fmt.Errorf("externals: %w", err), "externals")
+	}
+
+	if remote := cfg.Cache.Remote; remote != nil {
+		switch {
+		case remote.URL != "" && remote.S3 != nil:
+			return nil, src.errorAt(errors.New("cache.remote may have only one of url or s3"), "cache", "remote")
+		case remote.S3 != nil:
+			if remote.S3.Bucket == "" {
+				return nil, src.errorAt(errors.New("cache.remote.s3 requires bucket"), "cache", "remote", "s3")
+			}
+			if remote.S3.Region == "" && remote.S3.Endpoint == "" {
+				return nil, src.errorAt(errors.New("cache.remote.s3 requires region or endpoint"), "cache", "remote", "s3")
+			}
+		case remote.URL == "":
+			return nil, src.errorAt(errors.New("cache.remote requires url or s3"), "cache", "remote")
+		}
+		repo.RemoteCache = &RemoteCache{
+			URL:      remote.URL,
+			TokenEnv: remote.TokenEnv,
+			S3:       (*S3Cache)(remote.S3),
+			ReadOnly: remote.ReadOnly,
+		}
+	}
+
+	repo.WatchIgnore = append(append([]string{}, defaultWatchIgnore...), cfg.Watch.Ignore...)
+	for _, pattern := range repo.WatchIgnore {
+		if _, err := compileGlob(pattern); err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid watch ignore pattern %q: %w", pattern, err), "watch", "ignore")
+		}
+	}
+	repo.WatchDebounce = DefaultWatchDebounce
+	if cfg.Watch.Debounce != "" {
+		repo.WatchDebounce, err = time.ParseDuration(cfg.Watch.Debounce)
+		if err == nil && repo.WatchDebounce < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid watch.debounce: %w", err), "watch", "debounce")
+		}
+	}
+	repo.TmpMaxAge = DefaultTmpMaxAge
+	if cfg.Tmp.MaxAge != "" {
+		repo.TmpMaxAge, err = time.ParseDuration(cfg.Tmp.MaxAge)
+		if err == nil && repo.TmpMaxAge < 0 {
+			err = errors.New("must not be negative")
+		}
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("invalid tmp.maxAge: %w", err), "tmp", "maxAge")
+		}
+	}
 
 	repo.Packages = make(map[string]*Package)
 	for packageName, packageConfig := range cfg.Packages {
@@ -85,6 +773,117 @@ func LoadRepository(searchDir string) (*Repository, error) {
 			Public:      packageConfig.Public,
 			Description: packageConfig.Description,
 			Index:       packageConfig.Index,
+			Version:     packageConfig.Version,
+			Target:      packageConfig.Target,
+			External:    packageConfig.External,
+			Minify:      packageConfig.Minify,
+			Entrypoints: packageConfig.Entrypoints,
+			Workers:     packageConfig.Workers,
+			Prebuild:    packageConfig.Hooks.Prebuild,
+			Postbuild:   packageConfig.Hooks.Postbuild,
+		}
+		if pkg.Index == "" && len(pkg.Entrypoints) == 0 && len(packageConfig.Executables) == 0 && len(pkg.Workers) == 0 {
+			return nil, src.errorAt(fmt.Errorf("package %q has no entrypoints; configure index, entrypoints, executables, or workers", packageName), "packages", packageName)
+		}
+		for subpath := range pkg.Entrypoints {
+			if subpath == "" || strings.HasPrefix(subpath, ".") || strings.HasPrefix(subpath, "/") || strings.HasSuffix(subpath, "/") {
+				return nil, src.errorAt(fmt.Errorf("package %q has invalid entrypoint subpath: %q", packageName, subpath), "packages", packageName, "entrypoints", subpath)
+			}
+		}
+		pkg.SourceMap, err = ParseSourceMap(packageConfig.SourceMap)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q has %w", packageName, err), "packages", packageName, "sourcemap")
+		}
+		pkg.JSX, err = newJSXOptions(repo.JSX, packageConfig.JSX)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q has invalid jsx: %w", packageName, err), "packages", packageName, "jsx")
+		}
+		if packageConfig.Lambda != nil {
+			pkg.Lambda, err = newLambdaOptions(packageConfig.Lambda)
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("package %q lambda: %w", packageName, err), "packages", packageName, "lambda")
+			}
+		}
+		if packageConfig.Docker != nil {
+			pkg.Docker, err = newDockerOptions(packageConfig.Docker)
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("package %q docker: %w", packageName, err), "packages", packageName, "docker")
+			}
+		}
+		if err := validatePackageFields(packageConfig.PackageJSON); err != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q packageJson: %w", packageName, err), "packages", packageName, "packageJson")
+		}
+		pkg.PackageJSON = packageConfig.PackageJSON
+		pkg.SideEffects, err = parseSideEffects(packageConfig.SideEffects)
+		if err != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q has invalid sideEffects: %w", packageName, err), "packages", packageName, "sideEffects")
+		}
+		if _, ok := pkg.PackageJSON["sideEffects"]; ok && pkg.SideEffects != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q configures both sideEffects and packageJson.sideEffects", packageName), "packages", packageName, "packageJson", "sideEffects")
+		}
+		pkg.PeerDependencies = make(map[string]*PeerDependency)
+		for name, peerConfig := range packageConfig.PeerDependencies {
+			version := peerConfig.Version
+			if version == "" {
+				version = cfg.Dependencies[name]
+			}
+			if version == "" {
+				return nil, src.errorAt(fmt.Errorf("package %q has peer dependency %q with no version, which is not a configured dependency", packageName, name), "packages", packageName, "peerDependencies", name)
+			}
+			pkg.PeerDependencies[name] = &PeerDependency{
+				Version:  version,
+				Optional: peerConfig.Optional,
+			}
+		}
+		for _, name := range packageConfig.OptionalDependencies {
+			if _, ok := cfg.Dependencies[name]; !ok {
+				return nil, src.errorAt(fmt.Errorf("package %q has optional dependency %q, which is not a configured dependency", packageName, name), "packages", packageName, "optionalDependencies")
+			}
+			if _, ok := pkg.PeerDependencies[name]; ok {
+				return nil, src.errorAt(fmt.Errorf("package %q has %q as both a peer and an optional dependency", packageName, name), "packages", packageName, "optionalDependencies")
+			}
+		}
+		pkg.OptionalDependencies = packageConfig.OptionalDependencies
+		if packageConfig.Budget != nil {
+			pkg.Budget, err = newSizeBudget(*packageConfig.Budget)
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("package %q has %w", packageName, err), "packages", packageName, "budget")
+			}
+		}
+		if pkg.Version != "" {
+			if _, err := parseSemver(pkg.Version); err != nil {
+				return nil, src.errorAt(fmt.Errorf("package %q has %w", packageName, err), "packages", packageName, "version")
+			}
+		}
+		if _, _, err := parseTarget(pkg.Target); err != nil {
+			return nil, src.errorAt(fmt.Errorf("package %q has %w", packageName, err), "packages", packageName, "target")
+		}
+		switch Format(packageConfig.Format) {
+		case "", FormatCommonJS:
+			pkg.Format = FormatCommonJS
+		case FormatESModule, FormatDual:
+			pkg.Format = Format(packageConfig.Format)
+		default:
+			return nil, src.errorAt(fmt.Errorf("package %q has invalid format: %q", packageName, packageConfig.Format), "packages", packageName, "format")
+		}
+		switch Platform(packageConfig.Platform) {
+		case "", PlatformNode:
+			pkg.Platform = PlatformNode
+		case PlatformBrowser:
+			pkg.Platform = PlatformBrowser
+			if len(packageConfig.Executables) > 0 {
+				return nil, src.errorAt(fmt.Errorf("package %q targets the browser and cannot have executables", packageName), "packages", packageName, "executables")
+			}
+		default:
+			return nil, src.errorAt(fmt.Errorf("package %q has invalid platform: %q", packageName, packageConfig.Platform), "packages", packageName, "platform")
+		}
+		pkg.Tasks = make(map[string]*Task)
+		for taskName, taskConfig := range packageConfig.Tasks {
+			task, err := newTask(pkg, taskName, taskConfig)
+			if err != nil {
+				return nil, src.errorAt(fmt.Errorf("package %q has %w", packageName, err), "packages", packageName, "tasks", taskName)
+			}
+			pkg.Tasks[taskName] = task
 		}
 		pkg.Executables = make(map[string]*Executable)
 		for executableName, executableEntrypoint := range packageConfig.Executables {
@@ -93,9 +892,46 @@ func LoadRepository(searchDir string) (*Repository, error) {
 				Entrypoint: executableEntrypoint,
 			}
 		}
+		pkg.Banner = packageConfig.Banner
+		pkg.Footer = packageConfig.Footer
+		pkg.Banners = make(map[string]string)
+		pkg.Footers = make(map[string]string)
+		entrypoints := make(map[string]bool)
+		for _, entrypoint := range pkg.entrypointPaths() {
+			entrypoints[path.Clean(entrypoint)] = true
+		}
+		for _, wrap := range []struct {
+			key     string
+			config  map[string]string
+			results map[string]string
+		}{
+			{"banners", packageConfig.Banners, pkg.Banners},
+			{"footers", packageConfig.Footers, pkg.Footers},
+		} {
+			for entrypoint, text := range wrap.config {
+				if !entrypoints[path.Clean(entrypoint)] {
+					return nil, src.errorAt(fmt.Errorf("package %q has %s for %q, which is not one of its entrypoints", packageName, wrap.key, entrypoint), "packages", packageName, wrap.key, entrypoint)
+				}
+				wrap.results[path.Clean(entrypoint)] = text
+			}
+		}
 		repo.Packages[packageName] = pkg
 	}
 
+	if cfg.Boundaries != nil {
+		repo.Boundaries = &Boundaries{
+			NoDeepImports: cfg.Boundaries.NoDeepImports,
+		}
+		for i, ruleConfig := range cfg.Boundaries.Rules {
+			keys := []string{"boundaries", "rules", strconv.Itoa(i)}
+			rule := BoundaryRule(ruleConfig)
+			if err := rule.validate(&repo); err != nil {
+				return nil, src.errorAt(fmt.Errorf("invalid boundaries rule: %w", err), keys...)
+			}
+			repo.Boundaries.Rules = append(repo.Boundaries.Rules, rule)
+		}
+	}
+
 	repo.Dependencies = make(map[string]*Dependency)
 	addDependency := func(name, version string) {
 		repo.Dependencies[name] = &Dependency{
@@ -115,21 +951,58 @@ func LoadRepository(searchDir string) (*Repository, error) {
 	return &repo, nil
 }
 
-const configName = "uni.yml"
+// Names of config files, in order of precedence. Only one may be present.
+var configNames = []string{"uni.yml", "uni.config.ts", "uni.config.js"}
 
-var ErrNoConfig = fmt.Errorf("cannot find %s config file", configName)
+var ErrNoConfig = fmt.Errorf("cannot find %s config file", strings.Join(configNames, " or "))
 
-func openConfigFile(searchDir string) (*os.File, error) {
+// findConfigFile returns the path of the config file in searchDir or its
+// nearest ancestor with one.
+func findConfigFile(searchDir string) (string, error) {
 	for {
-		configPath := path.Join(searchDir, configName)
-		f, err := os.Open(configPath)
-		if os.IsNotExist(err) {
+		var found []string
+		for _, name := range configNames {
+			configPath := path.Join(searchDir, name)
+			if _, err := os.Stat(configPath); err == nil {
+				found = append(found, configPath)
+			} else if !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		switch len(found) {
+		case 0:
 			searchDir = path.Dir(searchDir)
 			if len(searchDir) <= 1 {
-				return nil, ErrNoConfig
+				return "", ErrNoConfig
 			}
-			continue
+		case 1:
+			return found[0], nil
+		default:
+			return "", fmt.Errorf("found more than one config file: %s", strings.Join(found, ", "))
 		}
-		return f, err
 	}
 }
+
+// configUpToDate reports whether no file the config was loaded from has
+// changed since it was loaded.
+func (repo *Repository) configUpToDate() bool {
+	for filename, stamp := range repo.configStamps {
+		if statFileStamp(filename) != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// extended reports whether the repository has extensions set by an embedding
+// program, which a daemon would not apply.
+func (repo *Repository) extended() bool {
+	return len(repo.Plugins) > 0 || repo.WrapCommand != nil
+}
+
+func (repo *Repository) wrapCommand(cmd *exec.Cmd) error {
+	if repo.WrapCommand == nil {
+		return nil
+	}
+	return repo.WrapCommand(cmd)
+}
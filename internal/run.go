@@ -18,11 +18,22 @@
 package internal
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
@@ -30,15 +41,259 @@ import (
 type RunOptions struct {
 	Watch      bool
 	Entrypoint string
+	// TypeScript source code to run instead of Entrypoint, as with `node -e`.
+	// Its imports are resolved from Dir, which defaults to the current
+	// directory. It may export a main function, but need not.
+	Eval      string
+	Args      []string
+	BuildOnly bool
+	// Inspect is the [host:]port for the node inspector, if enabled. An
+	// explicit address is always passed through to node, so that restarts in
+	// watch mode reuse the same port and debuggers can re-attach.
+	Inspect    string
+	InspectBrk bool
+	TypeCheck  bool
+	Shutdown   ShutdownOptions
+	// Additional glob patterns of paths to ignore in watch mode.
+	WatchIgnore []string
+	// If positive, poll for changes at this interval in watch mode.
+	Poll time.Duration
+	// Defines in addition to, or overriding, those configured.
+	Define map[string]string
+	// Absolute paths of env files to load after the default .env files.
+	EnvFiles []string
+	// Source map strategy for the bundle. Defaults to linked.
+	SourceMap SourceMap
+	// Absolute paths of additional entrypoints to bundle alongside the
+	// program, such as scripts for child processes. Workers referenced with
+	// `new URL(path, import.meta.url)` are found automatically.
+	Workers []string
+	// Configured targets to run concurrently, instead of Entrypoint and Args.
+	Targets []*RunTarget
+	// Bundle with a running daemon, if any, unless watching. See Daemon.
+	UseDaemon bool
+	// If set, write BuildMetadata of the bundle to this file after each build.
+	Metafile string
+	// Overrides of configured externals.
+	Externals Externals
+	// In watch mode, reload changed bundles into running processes, instead
+	// of restarting them. See hotRunScript.
+	Hot bool
+	// How processes are replaced when restarted in watch mode.
+	Restart RestartStrategy
+	// In watch mode, restart processes that crash, instead of waiting for a
+	// change, if set.
+	CrashRestart *CrashRestartPolicy
+	// TCP ports that the program listens on, which are freed before it starts
+	// in watch mode according to PortConflict. Targets configure their own.
+	Ports        []int
+	PortConflict PortConflictStrategy
+	// Overrides the esbuild target of the bundle, if set. See parseTarget.
+	Target string
+	// Absolute path of the working directory of the program. Defaults to the
+	// package directory of each entrypoint; see entrypointDir.
+	Dir string
+	// Runtime that runs the program, overriding those configured for
+	// targets, if set.
+	Runtime Runtime
+}
+
+// ShutdownOptions control how a running process is stopped, such as when it is
+// restarted in watch mode.
+type ShutdownOptions struct {
+	Signal  os.Signal
+	Timeout time.Duration
+}
+
+const DefaultInspectAddress = "127.0.0.1:9229"
+
+// RunTarget is a named entrypoint that may be run with `uni run <name>`.
+type RunTarget struct {
+	Name string
+	// Absolute path of the entrypoint module.
+	Entrypoint string
+	Args       []string
+	// Absolute path of the working directory of the program, if configured.
+	Dir string
+	// Runtime that runs the program, if configured.
+	Runtime Runtime
+	// Environment variables to set, whose values may reference others; see
+	// expandEnvValue and programEnv.
+	Env map[string]string
+	// TCP ports that the program listens on.
+	Ports []int
+	// How to tell when the program is ready after starting, if at all.
+	Ready *ReadinessProbe
+	// Names of targets that must be ready before this target is started, when
+	// run together.
+	DependsOn []string
+}
+
+// ResolveRunTargets returns the targets with the given names, expanding group
+// names into their members. Each target is included once, after the targets
+// it depends on, and otherwise in order of first mention.
+func ResolveRunTargets(repo *Repository, names []string) ([]*RunTarget, error) {
+	var targets []*RunTarget
+	seen := make(map[string]bool)
+	add := func(name string) error {
+		target, ok := repo.RunTargets[name]
+		if !ok {
+			return fmt.Errorf("unknown run target: %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			targets = append(targets, target)
+		}
+		return nil
+	}
+	for _, name := range names {
+		if members, ok := repo.RunGroups[name]; ok {
+			for _, member := range members {
+				if err := add(member); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	ordered := make([]string, len(targets))
+	for i, target := range targets {
+		ordered[i] = target.Name
+	}
+	return orderRunTargets(repo, ordered)
+}
+
+// orderRunTargets returns the named targets sorted such that every target
+// comes after the targets it depends on that are also named. Ties are broken
+// by the order of names.
+func orderRunTargets(repo *Repository, names []string) ([]*RunTarget, error) {
+	named := make(map[string]bool)
+	for _, name := range names {
+		named[name] = true
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var order []*RunTarget
+	var stack []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			i := 0
+			for stack[i] != name {
+				i++
+			}
+			cycle := append(append([]string{}, stack[i:]...), name)
+			return fmt.Errorf("run target dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		target := repo.RunTargets[name]
+		for _, dependency := range target.DependsOn {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		if named[name] {
+			order = append(order, target)
+		}
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// IsRunTarget reports whether name is the name of a run target or group.
+func IsRunTarget(repo *Repository, name string) bool {
+	_, isTarget := repo.RunTargets[name]
+	_, isGroup := repo.RunGroups[name]
+	return isTarget || isGroup
+}
+
+// runProgram is an entrypoint run by Run, along with the writers that connect
+// it to the terminal.
+type runProgram struct {
+	// Labels output when running multiple programs.
+	Label      string
+	Entrypoint string
 	Args       []string
-	BuildOnly  bool
+	// Working directory of the program.
+	Dir string
+	// Environment variables to set for the program, before expansion.
+	Env    map[string]string
+	Stdout io.Writer
+	Stderr io.Writer
+	// Stdin, or nil if the program does not receive input.
+	Stdin io.Reader
+	// TCP ports that the program listens on.
+	Ports []int
+	// How to tell when the program is ready, if at all.
+	Ready *ReadinessProbe
+	// Labels of programs that must be ready before this program is started.
+	DependsOn []string
 }
 
 // TODO: Need to handle interrupts in order to have a higher chance
 // of cleaning up temporary files.
 
-// Status code may be returend within an exec.ExitError return value.
+// Status code may be returend within an exec.ExitError return value. Failure to
+// bundle is reported as ErrBuildFailed, and failure to start as a StartError;
+// see RunExitCode.
+//
+// If opts.Targets is not empty, each target is run concurrently instead of
+// opts.Entrypoint. Targets are bundled together by one build, so that modules
+// they share are only loaded and parsed once, but in watch mode each target is
+// only restarted when its own bundle changes. Without watch mode, the first
+// target to exit stops the others, and its result is returned.
 func Run(repo *Repository, opts RunOptions) error {
+	if opts.Runtime == "" {
+		var err error
+		opts.Runtime, err = runTargetsRuntime(repo, opts.Targets)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Runtime != RuntimeNode {
+		if opts.Hot {
+			return fmt.Errorf("hot reloading is not supported by %s", opts.Runtime)
+		}
+		if opts.Watch && opts.Restart == RestartPrestart {
+			return fmt.Errorf("the prestart restart strategy is not supported by %s", opts.Runtime)
+		}
+	}
+	if opts.Hot {
+		if !opts.Watch {
+			return errors.New("hot reloading requires watch mode")
+		}
+		if runtime.GOOS == "windows" {
+			return errors.New("hot reloading is not supported on Windows")
+		}
+	}
+	if opts.Restart == RestartPrestart {
+		if opts.Hot {
+			return errors.New("hot reloading cannot be combined with the prestart restart strategy")
+		}
+		if runtime.GOOS == "windows" {
+			return errors.New("the prestart restart strategy is not supported on Windows")
+		}
+	}
+
 	if err := EnsureTmp(repo); err != nil {
 		return err
 	}
@@ -47,14 +302,491 @@ func Run(repo *Repository, opts RunOptions) error {
 	if err != nil {
 		return err
 	}
+	// Given --build-only, the directory is moved to its stable path instead.
+	defer os.RemoveAll(dir)
+
+	if opts.Eval != "" {
+		if err := prepareEval(&opts, dir); err != nil {
+			return err
+		}
+	}
+
+	programs := []*runProgram{{
+		Entrypoint: opts.Entrypoint,
+		Args:       opts.Args,
+		Dir:        opts.Dir,
+		Ports:      opts.Ports,
+	}}
+	if len(opts.Targets) > 0 {
+		programs = nil
+		for _, target := range opts.Targets {
+			programs = append(programs, &runProgram{
+				Label:      target.Name,
+				Entrypoint: target.Entrypoint,
+				Args:       target.Args,
+				Dir:        target.Dir,
+				Env:        target.Env,
+				Ports:      target.Ports,
+				Ready:      target.Ready,
+				DependsOn:  target.DependsOn,
+			})
+		}
+	}
+
+	for _, prog := range programs {
+		if opts.Dir != "" {
+			prog.Dir = opts.Dir
+		}
+		if prog.Dir == "" {
+			prog.Dir = entrypointDir(repo, prog.Entrypoint)
+		}
+	}
+
+	watch := opts.Watch && !opts.BuildOnly
+	prestart := watch && opts.Restart == RestartPrestart
+
+	// Forward signals to the running processes, rather than letting them
+	// terminate uni. In watch mode, interrupts also stop watching.
+	var mx sync.Mutex
+	// Set of processes that may be running. A program may have more than one
+	// while a replacement is prestarted.
+	current := make(map[*cmdProcess]bool)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopWatching := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+	signals := make(chan os.Signal, 1)
+	if !opts.BuildOnly {
+		signal.Notify(signals, forwardedSignals...)
+		defer signal.Stop(signals)
+		go func() {
+			for sig := range signals {
+				if watch && (sig == os.Interrupt || sig == syscall.SIGTERM) {
+					stopWatching()
+					continue
+				}
+				mx.Lock()
+				procs := make([]*cmdProcess, 0, len(current))
+				for proc := range current {
+					select {
+					case <-proc.exited:
+						delete(current, proc)
+					default:
+						procs = append(procs, proc)
+					}
+				}
+				mx.Unlock()
+				for _, proc := range procs {
+					if err := proc.Signal(sig); err != nil {
+						logEvent(os.Stderr, "error", nil, "could not forward %v: %v", sig, err)
+					}
+				}
+			}
+		}()
+	}
+
+	// When watching interactively, uni reads commands from the terminal instead
+	// of passing it through to the programs.
+	restart := make(chan struct{})
+	interactive := watch && isTerminal(os.Stdin)
+	if interactive {
+		logEvent(os.Stderr, "hint", nil, "type rs to restart, q to quit")
+		go readWatchCommands(os.Stdin, func() { restart <- struct{}{} }, stopWatching)
+	}
+
+	// Output is labeled when running more than one program.
+	var outputMx sync.Mutex
+	var prefixers []*prefixWriter
+	for _, prog := range programs {
+		prog.Stdout, prog.Stderr = os.Stdout, os.Stderr
+		if len(programs) > 1 {
+			label := "[" + prog.Label + "]"
+			stdout := newPrefixWriter(&outputMx, os.Stdout, label)
+			stderr := newPrefixWriter(&outputMx, os.Stderr, label)
+			prefixers = append(prefixers, stdout, stderr)
+			prog.Stdout, prog.Stderr = stdout, stderr
+		} else if !interactive {
+			prog.Stdin = os.Stdin
+		}
+		// Matching output requires piping it, which would keep programs from
+		// detecting a terminal, such as to color their output.
+		if prog.Ready != nil && prog.Ready.Output != nil && prog.Stdout == os.Stdout && isTerminal(os.Stdout) {
+			Warnf("stdout is a terminal, so the output readiness probe of %s is skipped", prog.Label)
+			prog.Ready = nil
+		}
+	}
+	defer func() {
+		for _, prefixer := range prefixers {
+			prefixer.Flush()
+		}
+	}()
+
+	// Sockets that prestarted processes listen on, held for the duration.
+	listeners := make(map[*runProgram]*portListeners)
+	if prestart {
+		for _, prog := range programs {
+			if len(prog.Ports) > 0 {
+				listeners[prog] = newPortListeners(&portGuard{
+					Ports:    prog.Ports,
+					Strategy: opts.PortConflict,
+					Timeout:  opts.Shutdown.Timeout,
+					Stderr:   prog.Stderr,
+					PidDir:   path.Join(repo.TmpDir, "pids"),
+				})
+			}
+		}
+		defer func() {
+			for _, pl := range listeners {
+				pl.Close()
+			}
+		}()
+	}
+
+	var scriptPaths map[*runProgram]string
+	createProcess := func(prog *runProgram) process {
+		if opts.BuildOnly {
+			return &funcProcess{
+				start: func() error {
+					return nil
+				},
+			}
+		}
+
+		// Reload env files for every process, since they may have changed.
+		env, err := loadEnv(repo, opts.EnvFiles)
+		if err == nil && len(prog.Env) > 0 {
+			env, err = programEnv(repo, prog, env)
+		}
+		if err != nil {
+			return &funcProcess{
+				start: func() error {
+					return fmt.Errorf("loading env: %w", err)
+				},
+			}
+		}
+
+		var flags []string
+		if opts.Inspect != "" {
+			flag := "--inspect"
+			if opts.InspectBrk {
+				flag = "--inspect-brk"
+			}
+			flags = append(flags, flag+"="+opts.Inspect)
+		}
+		node, err := runtimeCommand(repo, opts.Runtime, flags, scriptPaths[prog], prog.Args)
+		if err != nil {
+			return &funcProcess{
+				start: func() error {
+					return &StartError{Err: err}
+				},
+			}
+		}
+		node.Dir = prog.Dir
+		node.Env = env
+		node.Stdin = prog.Stdin
+		node.Stdout = prog.Stdout
+		node.Stderr = prog.Stderr
+		if err := repo.wrapCommand(node); err != nil {
+			return &funcProcess{
+				start: func() error {
+					return &StartError{Err: err}
+				},
+			}
+		}
+
+		proc := newCmdProcess(node, opts.Shutdown)
+		proc.probe = prog.Ready
+		if prog.Ready != nil && prog.Ready.Output != nil {
+			matcher := newOutputMatcher(prog.Stdout, prog.Ready.Output)
+			node.Stdout = matcher
+			proc.outputMatched = matcher.matched
+		}
+		if watch && len(prog.Ports) > 0 {
+			proc.ports = &portGuard{
+				Ports:    prog.Ports,
+				Strategy: opts.PortConflict,
+				Timeout:  opts.Shutdown.Timeout,
+				Stderr:   prog.Stderr,
+				PidDir:   path.Join(repo.TmpDir, "pids"),
+			}
+		}
+		mx.Lock()
+		current[proc] = true
+		mx.Unlock()
+		if prestart {
+			prestarter, err := newPrestartProcess(proc, listeners[prog])
+			if err != nil {
+				return &funcProcess{
+					start: func() error {
+						return &StartError{Err: err}
+					},
+				}
+			}
+			return prestarter
+		}
+		if opts.Hot {
+			hot, err := newHotProcess(proc)
+			if err != nil {
+				return &funcProcess{
+					start: func() error {
+						return &StartError{Err: err}
+					},
+				}
+			}
+			return hot
+		}
+		return proc
+	}
+
+	// A running daemon may bundle a single program that is run once, rather
+	// than bundling it from scratch.
+	if opts.UseDaemon && !repo.extended() && opts.Eval == "" && !watch && !opts.BuildOnly && len(programs) == 1 && opts.Metafile == "" && len(opts.Externals.Bundle)+len(opts.Externals.External) == 0 {
+		if conn, err := dialDaemon(repo); err == nil {
+			script, err := runScriptWithDaemon(conn, opts)
+			switch {
+			case errors.Is(err, errDaemonVersion):
+				Warnf("%v", err)
+			case err != nil:
+				return err
+			default:
+				scriptPaths = map[*runProgram]string{programs[0]: script}
+				proc := createProcess(programs[0])
+				if err := proc.Start(); err != nil {
+					return err
+				}
+				return proc.Wait()
+			}
+		}
+	}
+
+	rb, err := newRunBuild(repo, opts, programs, dir)
+	if err != nil {
+		return err
+	}
+	scriptPaths = rb.ScriptPaths
+
+	bw := rb.Build
+	bw.Watch = watch
+	bw.Stop = stop
+	bw.Restart = restart
+	bw.TypeCheck = opts.TypeCheck && opts.Watch && !opts.BuildOnly
+	bw.Prestart = prestart
+	bw.CrashRestart = opts.CrashRestart
+	if len(programs) == 1 {
+		bw.Outputs = []string{rb.BundlePaths[programs[0]]}
+		bw.CreateProcess = func() process {
+			return createProcess(programs[0])
+		}
+	} else {
+		// Programs are in dependency order, so dependencies come first.
+		indexes := make(map[string]int)
+		for i, prog := range programs {
+			prog := prog
+			indexes[prog.Label] = i
+			var dependsOn []int
+			for _, dependency := range prog.DependsOn {
+				if j, ok := indexes[dependency]; ok {
+					dependsOn = append(dependsOn, j)
+				}
+			}
+			bw.Programs = append(bw.Programs, buildProgram{
+				Outputs: []string{rb.BundlePaths[prog]},
+				CreateProcess: func() process {
+					return createProcess(prog)
+				},
+				Stderr:    prog.Stderr,
+				DependsOn: dependsOn,
+			})
+		}
+	}
 	if !opts.BuildOnly {
-		defer os.RemoveAll(dir)
+		return bw.Run()
+	}
+	if err := bw.Run(); err != nil {
+		return err
+	}
+	return publishBuildOnly(repo, dir, programs, rb, os.Stdout)
+}
+
+// runBuild bundles programs for Run, without watching or running them.
+type runBuild struct {
+	Build buildAndWatch
+	// Paths of each program's script, which runs its bundle, and the bundle
+	// itself.
+	ScriptPaths map[*runProgram]string
+	BundlePaths map[*runProgram]string
+}
+
+// newRunBuild prepares to build programs into dir. Each program is run by a
+// script that loads its bundle. See also `shim` in Build.
+func newRunBuild(repo *Repository, opts RunOptions, programs []*runProgram, dir string) (*runBuild, error) {
+	scriptPaths := make(map[*runProgram]string)
+	bundlePaths := make(map[*runProgram]string)
+	var esbuildOpts api.BuildOptions
+	var entrypoints []string
+	var err error
+	if len(programs) == 1 {
+		prog := programs[0]
+		entrypoints = []string{prog.Entrypoint}
+		scriptPaths[prog] = path.Join(dir, "script.js")
+		bundlePaths[prog] = path.Join(dir, "bundle.js")
+		esbuildOpts, err = runEsbuildOptions(repo, prog.Entrypoint, bundlePaths[prog])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Bundles are written side by side, from stub entrypoints that re-export
+		// each target's entrypoint. Targets with the same entrypoint share a
+		// bundle.
+		stubDir := path.Join(dir, "entrypoints")
+		if err := os.Mkdir(stubDir, 0755); err != nil {
+			return nil, err
+		}
+		stubs := make(map[string]string)
+		for i, prog := range programs {
+			stub, ok := stubs[prog.Entrypoint]
+			if !ok {
+				stub = path.Join(stubDir, fmt.Sprintf("entry-%d.js", len(stubs)))
+				contents := fmt.Sprintf("export * from %s;\n", strconv.Quote(prog.Entrypoint))
+				if err := ioutil.WriteFile(stub, []byte(contents), 0644); err != nil {
+					return nil, err
+				}
+				stubs[prog.Entrypoint] = stub
+				entrypoints = append(entrypoints, prog.Entrypoint)
+			}
+			scriptPaths[prog] = path.Join(dir, fmt.Sprintf("script-%d.js", i))
+			bundlePaths[prog] = path.Join(dir, path.Base(stub))
+		}
+		esbuildOpts, err = runEsbuildOptions(repo, "", "")
+		if err != nil {
+			return nil, err
+		}
+		esbuildOpts.EntryPoints = nil
+		for _, entrypoint := range entrypoints {
+			esbuildOpts.EntryPoints = append(esbuildOpts.EntryPoints, stubs[entrypoint])
+		}
+		esbuildOpts.Outdir = dir
+		esbuildOpts.Outbase = stubDir
+	}
+	for _, prog := range programs {
+		write := writeRunScript
+		switch {
+		case opts.Eval != "":
+			sourceMapSupport := opts.Runtime != RuntimeBun
+			write = func(scriptPath string, bundleName string, entrypoint string) error {
+				return writeEvalRunScript(scriptPath, bundleName, sourceMapSupport)
+			}
+		case opts.Runtime == RuntimeDeno:
+			write = writeDenoRunScript
+		case opts.Runtime == RuntimeBun:
+			write = writeBunRunScript
+		case opts.Hot:
+			write = writeHotRunScript
+		case opts.Watch && !opts.BuildOnly && opts.Restart == RestartPrestart:
+			write = writePrestartRunScript
+		}
+		// Relative to the root, so that --build-only hashes do not depend on where
+		// the repository is.
+		if err := write(scriptPaths[prog], path.Base(bundlePaths[prog]), relativeToRoot(repo, prog.Entrypoint)); err != nil {
+			return nil, err
+		}
+	}
+	applyRuntime(repo, opts.Runtime, &esbuildOpts)
+	esbuildOpts.Define = mergeDefines(repo, opts.Define)
+	esbuildOpts.External = resolveExternals(repo, opts.Externals, true)
+	if opts.Target != "" {
+		esbuildOpts.Target, esbuildOpts.Engines, err = parseTarget(opts.Target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.SourceMap != "" {
+		esbuildOpts.Sourcemap = opts.SourceMap.esbuildSourceMap()
+	}
+
+	// Workers are bundled separately, next to the programs' bundles.
+	reserved := []string{"bundle.js", "script.js"}
+	for _, prog := range programs {
+		reserved = append(reserved, path.Base(scriptPaths[prog]), path.Base(bundlePaths[prog]))
+	}
+	// Options for workers, without those set below for the programs only.
+	baseWorkerOpts := esbuildOpts
+	workers := newWorkerSet(dir, ".js", reserved, func(worker string, output string) api.BuildOptions {
+		workerOpts := baseWorkerOpts
+		workerOpts.EntryPoints = []string{worker}
+		workerOpts.Outdir = ""
+		workerOpts.Outbase = ""
+		workerOpts.Outfile = path.Join(dir, output)
+		return workerOpts
+	})
+	for _, worker := range opts.Workers {
+		workers.Add(worker)
+	}
+	loadPlugins := []api.Plugin{workersPlugin(workers, esbuildOpts.Format)}
+	if opts.Eval != "" {
+		loadPlugins = append(loadPlugins, evalPlugin(opts.Entrypoint, opts.Eval, opts.Dir))
+	}
+
+	var onResult func(result api.BuildResult)
+	if opts.Metafile != "" {
+		var mx sync.Mutex
+		externals := make(map[string]string)
+		esbuildOpts.Metafile = path.Join(dir, "meta.json")
+		esbuildOpts.Plugins = append(esbuildOpts.Plugins, dependenciesPlugin(repo, esbuildOpts.External, &mx, externals))
+		stubDir := path.Join(dir, "entrypoints")
+		onResult = func(result api.BuildResult) {
+			if len(result.Errors) > 0 {
+				return
+			}
+			mx.Lock()
+			defer mx.Unlock()
+			meta, err := readBuildMetadata(repo, dir, esbuildOpts.Metafile, externals, stubDir)
+			if err == nil {
+				for _, entrypoint := range entrypoints {
+					meta.Entrypoints = append(meta.Entrypoints, relativeToRoot(repo, entrypoint))
+				}
+				err = WriteJSON(opts.Metafile, meta)
+			}
+			if err != nil {
+				logEvent(os.Stderr, "error", nil, "writing metafile: %v", err)
+			}
+		}
 	}
 
-	// See also `shim` in Build.
-	script := fmt.Sprintf(`require('source-map-support').install();
+	return &runBuild{
+		Build: buildAndWatch{
+			Repository:  repo,
+			WatchFiles:  envFiles(repo, opts.EnvFiles),
+			WatchIgnore: opts.WatchIgnore,
+			Poll:        opts.Poll,
+			Esbuild:     esbuildOpts,
+			Workers:     workers,
+			LoadPlugins: loadPlugins,
+			OnResult:    onResult,
+		},
+		ScriptPaths: scriptPaths,
+		BundlePaths: bundlePaths,
+	}, nil
+}
+
+// writeRunScript writes a script that runs the main function exported by a
+// bundle, which is named relative to the script.
+func writeRunScript(scriptPath string, bundleName string, entrypoint string) error {
+	script := "require('source-map-support').install();\n\n" + runScript(bundleName, entrypoint)
+	return ioutil.WriteFile(scriptPath, []byte(script), 0644)
+}
+
+// writeBunRunScript writes a script like writeRunScript, but for bun, which
+// does not need source-map-support.
+func writeBunRunScript(scriptPath string, bundleName string, entrypoint string) error {
+	return ioutil.WriteFile(scriptPath, []byte(runScript(bundleName, entrypoint)), 0644)
+}
 
-const { inspect } = require('util');
+// runScript returns the CommonJS script written by writeRunScript, without
+// installing source map support.
+func runScript(bundleName string, entrypoint string) string {
+	return fmt.Sprintf(`const { inspect } = require('util');
 process.on('uncaughtException', (exception) => {
   process.stderr.write('uncaught exception: ' + inspect(exception) + '\n', () => {
     process.exit(1);
@@ -69,7 +801,7 @@ process.on('unhandledRejection', (reason, promise) => {
   );
 })
 
-const { main } = require('./bundle.js');
+const { main } = require(%s);
 if (typeof main === 'function') {
 	const args = process.argv.slice(2);
 	void (async () => {
@@ -77,71 +809,269 @@ if (typeof main === 'function') {
 		process.exit(exitCode ?? 0);
 	})();
 } else {
-	process.stderr.write('error: %s does not export a main function\n', () => {
+	process.stderr.write('error: ' + %s + ' does not export a main function\n', () => {
 		process.exit(1);
 	});
 }
-`, opts.Entrypoint)
-	scriptPath := path.Join(dir, "script.js")
-	if err := ioutil.WriteFile(scriptPath, []byte(script), 0644); err != nil {
-		return err
+`, strconv.Quote("./"+bundleName), strconv.Quote(entrypoint))
+}
+
+// writeDenoRunScript writes a script like writeRunScript, but for deno, which
+// imports an ES module bundle. Deno applies source maps and reports uncaught
+// errors itself.
+func writeDenoRunScript(scriptPath string, bundleName string, entrypoint string) error {
+	script := fmt.Sprintf(`import { main } from %s;
+
+if (typeof main === 'function') {
+	const exitCode = await main(...Deno.args);
+	Deno.exit(exitCode ?? 0);
+} else {
+	console.error('error: ' + %s + ' does not export a main function');
+	Deno.exit(1);
+}
+`, strconv.Quote("./"+bundleName), strconv.Quote(entrypoint))
+	return ioutil.WriteFile(scriptPath, []byte(script), 0644)
+}
+
+// StdinIsTerminal reports whether stdin is a terminal, rather than a pipe or
+// file.
+func StdinIsTerminal() bool {
+	return isTerminal(os.Stdin)
+}
+
+// readWatchCommands reads line-oriented commands until EOF. Either "r" or "rs"
+// requests a restart and "q" requests to quit.
+func readWatchCommands(r io.Reader, restart func(), quit func()) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		switch strings.TrimSpace(scanner.Text()) {
+		case "r", "rs":
+			restart()
+		case "q":
+			quit()
+			return
+		case "":
+		default:
+			logEvent(os.Stderr, "hint", nil, "unknown command; type rs to restart, q to quit")
+		}
 	}
+}
 
-	return buildAndWatch{
-		Repository: repo,
-		Watch:      opts.Watch && !opts.BuildOnly,
-		Esbuild: api.BuildOptions{
-			AbsWorkingDir: repo.RootDir,
-			EntryPoints:   []string{opts.Entrypoint},
-			Outfile:       path.Join(dir, "bundle.js"),
-			Bundle:        true,
-			Platform:      api.PlatformNode,
-			Format:        api.FormatCommonJS,
-			Write:         true,
-			LogLevel:      api.LogLevelWarning,
-			Sourcemap:     api.SourceMapLinked,
-			External:      getExternals(repo),
-			Loader:        loaders,
-		},
-		CreateProcess: func() process {
-			if opts.BuildOnly {
-				return &funcProcess{
-					start: func() error {
-						fmt.Println(dir)
-						return nil
-					},
-				}
-			}
+// isTerminal reports whether f is an interactive terminal. This is
+// approximated as any character device other than the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
 
-			nodeArgs := append([]string{scriptPath}, opts.Args...)
-			node := exec.Command("node", nodeArgs...)
-			node.Stdin = os.Stdin
-			node.Stdout = os.Stdout
-			node.Stderr = os.Stderr
+// runEsbuildOptions returns options for bundling an entrypoint to be executed
+// directly by node, rather than published. JSX is compiled with the options of
+// the repository, rather than those of any package.
+func runEsbuildOptions(repo *Repository, entrypoint string, outfile string) (api.BuildOptions, error) {
+	opts := api.BuildOptions{
+		AbsWorkingDir: repo.RootDir,
+		EntryPoints:   []string{entrypoint},
+		Outfile:       outfile,
+		Bundle:        true,
+		Platform:      api.PlatformNode,
+		Format:        api.FormatCommonJS,
+		Write:         true,
+		LogLevel:      api.LogLevelWarning,
+		Sourcemap:     api.SourceMapLinked,
+		Engines:       nodeEngines(repo),
+		External:      getExternals(repo),
+		Loader:        getLoaders(repo),
+		Define:        repo.Define,
+	}
+	if repo.ProgramTarget != "" {
+		// Validated when loading the repository.
+		opts.Target, opts.Engines, _ = parseTarget(repo.ProgramTarget)
+	}
+	if err := applyJSX(repo, repo.JSX, &opts); err != nil {
+		return api.BuildOptions{}, err
+	}
+	return opts, nil
+}
 
-			return &cmdProcess{cmd: node}
-		},
-	}.Run()
+// programEnv returns env with the configured variables of a program set.
+// Their values may reference the following, or else other variables of env:
+//
+//	${rootDir}     the repository root
+//	${outDir}      the out directory
+//	${package}     the name of the package with the entrypoint, if any
+//	${packageDir}  the package directory of the entrypoint; see entrypointDir
+func programEnv(repo *Repository, prog *runProgram, env []string) ([]string, error) {
+	builtins := map[string]string{
+		"rootDir":    repo.RootDir,
+		"outDir":     repo.OutDir,
+		"package":    "",
+		"packageDir": entrypointDir(repo, prog.Entrypoint),
+	}
+	if pkg := entrypointPackage(repo, prog.Entrypoint); pkg != nil {
+		builtins["package"] = pkg.Name
+	}
+	inherited := make(map[string]string)
+	for _, kv := range env {
+		if eq := strings.IndexByte(kv, '='); eq > 0 {
+			inherited[kv[:eq]] = kv[eq+1:]
+		}
+	}
+	lookup := func(name string) (string, bool) {
+		if value, ok := builtins[name]; ok {
+			return value, true
+		}
+		value, ok := inherited[name]
+		return value, ok
+	}
+	vars := make(map[string]string, len(prog.Env))
+	for name, value := range prog.Env {
+		expanded, err := expandEnvValue(value, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+		vars[name] = expanded
+	}
+	return withEnv(env, vars), nil
+}
+
+// entrypointPackage returns the package with the given entrypoint module
+// among its entrypoints, or nil if there is none.
+func entrypointPackage(repo *Repository, entrypoint string) *Package {
+	for _, name := range PackageNames(repo) {
+		pkg := repo.Packages[name]
+		for _, p := range pkg.entrypointPaths() {
+			if filepath.Join(repo.RootDir, p) == entrypoint {
+				return pkg
+			}
+		}
+	}
+	return nil
+}
+
+// entrypointDir returns the package directory of an entrypoint, which is the
+// nearest directory containing both it and a package.json file, or else the
+// repository root.
+func entrypointDir(repo *Repository, entrypoint string) string {
+	root := filepath.Clean(repo.RootDir)
+	dir := filepath.Dir(entrypoint)
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if _, err := os.Stat(filepath.Join(dir, "package.json")); err == nil {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	return root
 }
 
 type cmdProcess struct {
 	cmd *exec.Cmd
+	// Signal sent to request graceful shutdown. If the process has not exited
+	// after the timeout, it is killed forcefully. A zero signal kills
+	// immediately.
+	shutdownSignal  os.Signal
+	shutdownTimeout time.Duration
+	// How to tell when the process is ready, if at all, and for output
+	// probes, closed once its output matches.
+	probe         *ReadinessProbe
+	outputMatched <-chan struct{}
+	// Frees the ports that the process listens on before it starts, if set.
+	ports *portGuard
+	// Times the process from start to exit, in verbose mode.
+	lifetime *traceSpan
+
+	exited chan struct{}
+}
+
+func newCmdProcess(cmd *exec.Cmd, shutdown ShutdownOptions) *cmdProcess {
+	return &cmdProcess{
+		cmd:             cmd,
+		shutdownSignal:  shutdown.Signal,
+		shutdownTimeout: shutdown.Timeout,
+		exited:          make(chan struct{}),
+	}
 }
 
 func (proc *cmdProcess) Start() error {
-	return proc.cmd.Start()
+	if proc.ports != nil {
+		proc.ports.free()
+	}
+	configureProcessGroup(proc.cmd)
+	var w io.Writer = os.Stderr
+	if proc.cmd.Stderr != nil {
+		w = proc.cmd.Stderr
+	}
+	fields := logFields{"command": filepath.Base(proc.cmd.Path)}
+	sp := startSpan(w, LogLevelVerbose, "process", "start process", "", fields)
+	err := proc.cmd.Start()
+	sp.End()
+	if err != nil {
+		return &StartError{Err: err}
+	}
+	proc.lifetime = startSpan(w, LogLevelVerbose, "process", "process", "", fields)
+	trackProcessGroup(proc.cmd.Process)
+	if proc.ports != nil {
+		proc.ports.record(proc.cmd.Process.Pid)
+	}
+	return nil
+}
+
+// WaitReady waits for the process to pass its readiness probe, returning
+// false if it has none.
+func (proc *cmdProcess) WaitReady() (bool, error) {
+	if proc.probe == nil {
+		return false, nil
+	}
+	return true, proc.probe.wait(proc.outputMatched, proc.exited)
+}
+
+// Signal delivers a signal to the process and its process group.
+func (proc *cmdProcess) Signal(sig os.Signal) error {
+	if proc.cmd.Process == nil {
+		return nil
+	}
+	return signalProcessGroup(proc.cmd.Process, sig)
 }
 
 func (proc *cmdProcess) Kill() error {
 	if proc.cmd.Process == nil {
 		return nil
 	}
-	return proc.cmd.Process.Kill()
+	select {
+	case <-proc.exited:
+		// Clean up any stragglers left in the process group.
+		_ = proc.Signal(os.Kill)
+		return nil
+	default:
+	}
+	if proc.shutdownSignal != nil && proc.exited != nil {
+		if err := proc.Signal(proc.shutdownSignal); err == nil {
+			select {
+			case <-proc.exited:
+				return nil
+			case <-time.After(proc.shutdownTimeout):
+				logEvent(os.Stderr, "killing", nil, "process did not exit within %v, killing", proc.shutdownTimeout)
+			}
+		}
+	}
+	return proc.Signal(os.Kill)
 }
 
 func (proc *cmdProcess) Wait() error {
 	if proc.cmd.Process == nil {
 		return nil
 	}
-	return proc.cmd.Wait()
+	if proc.exited != nil {
+		defer close(proc.exited)
+	}
+	err := proc.cmd.Wait()
+	releaseProcessGroup(proc.cmd.Process)
+	if err != nil {
+		proc.lifetime.Fail(err.Error())
+	}
+	proc.lifetime.End()
+	return err
 }
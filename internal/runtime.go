@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Runtime is the JavaScript runtime that runs programs for `uni run`.
+type Runtime string
+
+const (
+	RuntimeNode Runtime = "node"
+	// Runs an ES module bundle with `deno run`, with all permissions, as node
+	// would have.
+	RuntimeDeno Runtime = "deno"
+	RuntimeBun  Runtime = "bun"
+)
+
+// ParseRuntime validates a runtime. The empty string is returned as is,
+// meaning unspecified.
+func ParseRuntime(s string) (Runtime, error) {
+	switch runtime := Runtime(s); runtime {
+	case "", RuntimeNode, RuntimeDeno, RuntimeBun:
+		return runtime, nil
+	default:
+		return "", fmt.Errorf("unknown runtime: %q, expected node, deno, or bun", s)
+	}
+}
+
+// runTargetsRuntime returns the runtime of the given targets, or the default
+// runtime if there are none. Targets are bundled together, so they must share
+// a runtime.
+func runTargetsRuntime(repo *Repository, targets []*RunTarget) (Runtime, error) {
+	runtime := repo.Runtime
+	var first *RunTarget
+	for _, target := range targets {
+		targetRuntime := target.Runtime
+		if targetRuntime == "" {
+			targetRuntime = repo.Runtime
+		}
+		if first != nil && targetRuntime != runtime {
+			return "", fmt.Errorf("run targets %q and %q cannot be run together, since they use different runtimes: %s and %s", first.Name, target.Name, runtime, targetRuntime)
+		}
+		if first == nil {
+			first = target
+			runtime = targetRuntime
+		}
+	}
+	return runtime, nil
+}
+
+// runtimeCommand returns a command that runs a script with a runtime, given
+// runtime flags such as --inspect, which are common to all runtimes, and
+// arguments of the script.
+func runtimeCommand(repo *Repository, runtime Runtime, flags []string, script string, args []string) (*exec.Cmd, error) {
+	switch runtime {
+	case RuntimeDeno, RuntimeBun:
+		binary, err := exec.LookPath(string(runtime))
+		if err != nil {
+			return nil, err
+		}
+		var runtimeArgs []string
+		if runtime == RuntimeDeno {
+			runtimeArgs = append(runtimeArgs, "run", "--allow-all")
+		}
+		runtimeArgs = append(append(append(runtimeArgs, flags...), script), args...)
+		return exec.Command(binary, runtimeArgs...), nil
+	default:
+		return nodeCommand(repo, append(append(append([]string{}, flags...), script), args...)...)
+	}
+}
+
+// applyRuntime adjusts options for bundling a program to be run by a runtime.
+// Bun and deno support the latest language version, so unless a target is
+// configured, syntax is not transformed for the node engine version.
+//
+// Deno runs ES modules, with node's builtin modules imported by their node:
+// specifiers. Since bundled CommonJS modules may call require, and programs
+// may refer to __filename and __dirname, these are defined by a banner.
+func applyRuntime(repo *Repository, runtime Runtime, opts *api.BuildOptions) {
+	if runtime == "" || runtime == RuntimeNode {
+		return
+	}
+	if repo.ProgramTarget == "" {
+		opts.Engines = nil
+	}
+	if runtime != RuntimeDeno {
+		return
+	}
+	opts.Format = api.FormatESModule
+	opts.Banner = strings.Join([]string{
+		`import { createRequire as __uniCreateRequire } from "node:module";`,
+		`import { fileURLToPath as __uniFileURLToPath } from "node:url";`,
+		`import { dirname as __uniDirname } from "node:path";`,
+		`const require = __uniCreateRequire(import.meta.url);`,
+		`const __filename = __uniFileURLToPath(import.meta.url);`,
+		`const __dirname = __uniDirname(__filename);`,
+	}, "\n")
+	opts.Plugins = append(append([]api.Plugin{}, opts.Plugins...), nodeBuiltinsPlugin())
+}
+
+// Builtin modules of node, which deno provides by node: specifiers.
+var nodeBuiltins = []string{
+	"assert", "async_hooks", "buffer", "child_process", "cluster", "console",
+	"constants", "crypto", "dgram", "diagnostics_channel", "dns", "domain",
+	"events", "fs", "http", "http2", "https", "inspector", "module", "net",
+	"os", "path", "perf_hooks", "process", "punycode", "querystring",
+	"readline", "repl", "stream", "string_decoder", "sys", "timers", "tls",
+	"trace_events", "tty", "url", "util", "v8", "vm", "wasi",
+	"worker_threads", "zlib",
+}
+
+// Matches imports of builtin modules and their subpaths, such as fs/promises,
+// with or without the node: prefix.
+var nodeBuiltinPattern = regexp.MustCompile(`^(node:)?(` + strings.Join(nodeBuiltins, "|") + `)(/.*)?$`)
+
+// nodeBuiltinsPlugin leaves imports of node's builtin modules external, by
+// their node: specifiers.
+func nodeBuiltinsPlugin() api.Plugin {
+	return api.Plugin{
+		Name: "unirepo:node-builtins",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: nodeBuiltinPattern.String(),
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				return api.OnResolveResult{
+					Path:     "node:" + strings.TrimPrefix(args.Path, "node:"),
+					External: true,
+				}, nil
+			})
+		},
+	}
+}
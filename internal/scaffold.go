@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const initConfigTemplate = `# Unirepo configuration.
+# See https://github.com/deref/uni/blob/main/doc/config.md
+%s
+# Add packages with: uni new package <name>
+packages:
+
+# Add dependencies here, then run: uni install
+dependencies:
+`
+
+const initTSConfig = `{
+  "compilerOptions": {
+    "target": "es2019",
+    "module": "commonjs",
+    "moduleResolution": "node",
+    "strict": true,
+    "esModuleInterop": true,
+    "skipLibCheck": true,
+    "noEmit": true
+  },
+  "exclude": ["node_modules", "out"]
+}
+`
+
+// Lines ensured to be in the .gitignore file of new repositories.
+var initGitignore = []string{"node_modules/", "out/"}
+
+// Init creates a new repository in dir: a config file, a tsconfig.json file,
+// and the out directory. Existing tsconfig.json files are kept, but an existing
+// config file is an error. Created files are reported to w.
+func Init(w io.Writer, dir string) error {
+	for _, name := range configNames {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return fmt.Errorf("%s already exists", name)
+		}
+	}
+
+	// Pin the versions of installed engines.
+	var engines strings.Builder
+	cache := make(map[string]engineInfo)
+	for _, name := range []string{"node", "npm"} {
+		if info, err := getEngineInfo(cache, name, ""); err == nil {
+			if engines.Len() == 0 {
+				engines.WriteString("engines:\n")
+			}
+			fmt.Fprintf(&engines, "  %s: %q\n", name, info.Version)
+		}
+	}
+
+	create := func(name string, content string) error {
+		filename := path.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			fmt.Fprintf(w, "kept %s\n", name)
+			return nil
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "created %s\n", name)
+		return nil
+	}
+	if err := create(configNames[0], fmt.Sprintf(initConfigTemplate, engines.String())); err != nil {
+		return err
+	}
+	if err := create("tsconfig.json", initTSConfig); err != nil {
+		return err
+	}
+	if err := ensureLines(path.Join(dir, ".gitignore"), initGitignore); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "updated .gitignore")
+
+	outDir := path.Join(dir, "out")
+	for _, d := range []string{path.Join(outDir, "dist"), path.Join(outDir, "tmp")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w, "created out/")
+	return nil
+}
+
+// ensureLines appends any of the given lines that are missing from a file,
+// creating it if necessary.
+func ensureLines(filename string, lines []string) error {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(bs), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+	var buf bytes.Buffer
+	buf.Write(bs)
+	if len(bs) > 0 && !bytes.HasSuffix(bs, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	for _, line := range lines {
+		if !existing[line] {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+type NewPackageOptions struct {
+	// Directory of the package, relative to the repository root. Defaults to
+	// packages/<name>, without any scope.
+	Dir string
+}
+
+var packageNamePattern = regexp.MustCompile(`^(@[a-z0-9-~][a-z0-9-._~]*/)?[a-z0-9-~][a-z0-9-._~]*$`)
+
+const newPackageIndex = `export const greet = (name: string): string => ` + "`Hello, ${name}!`" + `;
+`
+
+const newPackageTest = `import { greet } from './index';
+
+export const testGreet = () => {
+  const greeting = greet('world');
+  if (greeting !== 'Hello, world!') {
+    throw new Error(` + "`unexpected greeting: ${greeting}`" + `);
+  }
+};
+`
+
+// NewPackage creates a package directory with an index module and a test,
+// and registers the package in the config file. Config scripts cannot be
+// edited, so the configuration to add is printed instead.
+func NewPackage(w io.Writer, repo *Repository, name string, opts NewPackageOptions) error {
+	if !packageNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid package name: %q", name)
+	}
+	if _, ok := repo.Packages[name]; ok {
+		return fmt.Errorf("package %q already exists", name)
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = path.Join("packages", name[strings.LastIndex(name, "/")+1:])
+	}
+	dir = path.Clean(dir)
+	if path.IsAbs(dir) || strings.HasPrefix(dir, "..") {
+		return fmt.Errorf("package directory must be within the repository: %q", dir)
+	}
+	absDir := path.Join(repo.RootDir, dir)
+	if _, err := os.Stat(absDir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"index.ts":      newPackageIndex,
+		"index.test.ts": newPackageTest,
+	}
+	for _, filename := range []string{"index.ts", "index.test.ts"} {
+		if err := ioutil.WriteFile(path.Join(absDir, filename), []byte(files[filename]), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "created %s\n", path.Join(dir, filename))
+	}
+
+	index := path.Join(dir, "index.ts")
+	if path.Ext(repo.ConfigPath) != ".yml" {
+		fmt.Fprintf(w, "add to packages in %s:\n  %q: { index: %q }\n", path.Base(repo.ConfigPath), name, index)
+		return nil
+	}
+	original, err := ioutil.ReadFile(repo.ConfigPath)
+	if err != nil {
+		return err
+	}
+	updated := addPackageToConfig(string(original), name, index)
+	if err := ioutil.WriteFile(repo.ConfigPath, []byte(updated), 0644); err != nil {
+		return err
+	}
+	// Don't leave behind a config that no longer loads.
+	if _, err := LoadRepository(repo.RootDir); err != nil {
+		_ = ioutil.WriteFile(repo.ConfigPath, original, 0644)
+		return fmt.Errorf("registering package: %w", err)
+	}
+	fmt.Fprintf(w, "registered %s in %s\n", name, path.Base(repo.ConfigPath))
+	return nil
+}
+
+var (
+	packagesKeyPattern = regexp.MustCompile(`^packages:\s*(\{\s*\})?\s*(#.*)?$`)
+	indentPattern      = regexp.MustCompile(`^(\s+)\S`)
+)
+
+// addPackageToConfig adds a package to the end of the packages section of a
+// YAML config file, preserving the rest of its text, including comments. The
+// section is added if absent.
+func addPackageToConfig(text string, name string, index string) string {
+	lines := strings.Split(text, "\n")
+	start := -1
+	for i, line := range lines {
+		if packagesKeyPattern.MatchString(line) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		text = strings.TrimRight(text, "\n")
+		if text != "" {
+			text += "\n\n"
+		}
+		return text + fmt.Sprintf("packages:\n  %s:\n    index: %s\n", yamlKey(name), index)
+	}
+	// Empty flow mappings become block mappings.
+	lines[start] = strings.TrimRight(strings.Replace(lines[start], "{}", "", 1), " \t")
+
+	// The section ends before the next unindented line, excluding trailing
+	// blank lines and unindented comments, which likely precede the next key.
+	end := start + 1
+	for end < len(lines) && (strings.TrimSpace(lines[end]) == "" || indentPattern.MatchString(lines[end]) || strings.HasPrefix(lines[end], "#")) {
+		end++
+	}
+	for end > start+1 && (strings.TrimSpace(lines[end-1]) == "" || strings.HasPrefix(lines[end-1], "#")) {
+		end--
+	}
+
+	// Match the indentation and spacing of existing entries.
+	indent := ""
+	spaced := false
+	for _, line := range lines[start+1 : end] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			spaced = true
+		} else if match := indentPattern.FindStringSubmatch(line); match != nil && indent == "" && !strings.HasPrefix(trimmed, "#") {
+			indent = match[1]
+		}
+	}
+	if indent == "" {
+		indent = "  "
+	}
+	var entry []string
+	if spaced {
+		entry = append(entry, "")
+	}
+	entry = append(entry,
+		fmt.Sprintf("%s%s:", indent, yamlKey(name)),
+		fmt.Sprintf("%s%sindex: %s", indent, indent, index),
+	)
+	result := append(append(append([]string{}, lines[:end]...), entry...), lines[end:]...)
+	return strings.Join(result, "\n")
+}
+
+// yamlKey quotes package names that would otherwise be invalid YAML keys, as
+// scoped names are.
+func yamlKey(name string) string {
+	if strings.HasPrefix(name, "@") {
+		return strconv.Quote(name)
+	}
+	return name
+}
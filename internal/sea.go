@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the resource that node single executable applications load their
+// script from, and the fuse that enables loading it.
+const (
+	seaResource = "NODE_SEA_BLOB"
+	seaFuse     = "NODE_SEA_FUSE_fce680ab2cc467b6e072b8b5df1996b2"
+)
+
+// Oldest version of node that supports single executable applications.
+var minSeaVersion = semver{Major: 20}
+
+// ExecutablesDir returns the directory that self-contained executables of a
+// package are written to.
+func ExecutablesDir(repo *Repository, pkg *Package) string {
+	return path.Join(repo.OutDir, "executables", stripName(pkg.Name))
+}
+
+// buildSingleExecutables builds each executable of a package as a single
+// executable application: a copy of the node binary with a bundle of the
+// executable embedded, which runs on machines without node for the same
+// operating system and architecture. Since nothing is loaded from
+// node_modules, everything is bundled except optional dependencies and
+// modules externalized by name.
+//
+// The bundle is embedded with postject, which must be installed, either in
+// node_modules or on the PATH. It is not fetched on demand, so that builds
+// don't run whatever version is latest.
+func buildSingleExecutables(repo *Repository, opts BuildOptions) error {
+	pkg := opts.Package
+	stderr := opts.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if opts.Watch {
+		return errors.New("the executable preset cannot be used in watch mode")
+	}
+	if len(pkg.Executables) == 0 {
+		return fmt.Errorf("package %q has no executables", pkg.Name)
+	}
+
+	node, err := NodeBinary(repo)
+	if err != nil {
+		return err
+	}
+	postject, err := findPostject(repo)
+	if err != nil {
+		return err
+	}
+	info, err := getEngineInfo(make(map[string]engineInfo), "node", node)
+	if err != nil {
+		return fmt.Errorf("checking node version: %w", err)
+	}
+	version, err := parseSemver(strings.TrimPrefix(info.Version, "v"))
+	if err != nil {
+		return fmt.Errorf("checking node version: %w", err)
+	}
+	if version.Compare(minSeaVersion) < 0 {
+		return fmt.Errorf("single executables require node 20 or later, but %s is %s", node, info.Version)
+	}
+
+	if err := runCodegen(repo, stderr); err != nil {
+		return err
+	}
+	if err := EnsureTmp(repo); err != nil {
+		return err
+	}
+	dir, err := TempDir(repo, "executables")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	esbuildOpts, err := standaloneEsbuildOptions(repo, opts, version.Major, nil)
+	if err != nil {
+		return err
+	}
+	// Each executable is bundled from a stub that calls its main function.
+	stubDir := path.Join(dir, "stubs")
+	if err := os.Mkdir(stubDir, 0755); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(pkg.Executables))
+	for name := range pkg.Executables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stub := path.Join(stubDir, name+".js")
+		entrypoint := path.Join(repo.RootDir, pkg.Executables[name].Entrypoint)
+		if err := writeSeaStub(stub, entrypoint); err != nil {
+			return err
+		}
+		esbuildOpts.EntryPoints = append(esbuildOpts.EntryPoints, stub)
+	}
+	esbuildOpts.Outdir = path.Join(dir, "bundles")
+	esbuildOpts.Outbase = stubDir
+
+	outDir := ExecutablesDir(repo, pkg)
+	return buildAndWatch{
+		Repository: repo,
+		Esbuild:    esbuildOpts,
+		Package:    pkg,
+		Stderr:     opts.stderr,
+		CreateProcess: func() process {
+			return &funcProcess{
+				start: func() error {
+					if err := os.MkdirAll(outDir, 0755); err != nil {
+						return err
+					}
+					for _, name := range names {
+						filename := path.Join(outDir, name)
+						if runtime.GOOS == "windows" {
+							filename += ".exe"
+						}
+						bundle := path.Join(esbuildOpts.Outdir, name+".js")
+						if err := buildSingleExecutable(node, postject, bundle, filename, stderr); err != nil {
+							return fmt.Errorf("building executable %s: %w", name, err)
+						}
+						logEvent(stderr, "executable", logFields{"package": pkg.Name, "executable": name, "path": filename},
+							"built %s", filename)
+					}
+					return nil
+				},
+			}
+		},
+	}.Run()
+}
+
+// writeSeaStub writes a script that calls the main function of an entrypoint,
+// like the executables of built packages.
+func writeSeaStub(filename string, entrypoint string) error {
+	script := fmt.Sprintf(`const { inspect } = require('util');
+process.on('uncaughtException', (exception) => {
+  process.stderr.write('uncaught exception: ' + inspect(exception) + '\n', () => {
+    process.exit(1);
+  });
+});
+process.on('unhandledRejection', (reason, promise) => {
+  process.stderr.write(
+    'unhandled rejection at: ' + inspect(promise) + '\nreason: ' + inspect(reason) + '\n',
+    () => {
+      process.exit(1);
+    },
+  );
+});
+
+const { main } = require(%s);
+if (typeof main === 'function') {
+  void (async () => {
+    const exitCode = await main(...process.argv.slice(2));
+    process.exit(exitCode ?? 0);
+  })();
+}
+`, strconv.Quote(entrypoint))
+	return ioutil.WriteFile(filename, []byte(script), 0644)
+}
+
+// buildSingleExecutable embeds a bundle into a copy of the node binary.
+func buildSingleExecutable(node string, postject string, bundle string, filename string, stderr io.Writer) error {
+	blob := bundle + ".blob"
+	seaConfig, err := json.Marshal(map[string]interface{}{
+		"main":                          bundle,
+		"output":                        blob,
+		"disableExperimentalSEAWarning": true,
+	})
+	if err != nil {
+		return err
+	}
+	seaConfigPath := bundle + ".sea.json"
+	if err := ioutil.WriteFile(seaConfigPath, seaConfig, 0644); err != nil {
+		return err
+	}
+	if err := runTool(stderr, node, "--experimental-sea-config", seaConfigPath); err != nil {
+		return fmt.Errorf("preparing blob: %w", err)
+	}
+
+	if err := copyFile(filename, node, 0755); err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		if err := runTool(stderr, "codesign", "--remove-signature", filename); err != nil {
+			return err
+		}
+	}
+	args := []string{filename, seaResource, blob, "--sentinel-fuse", seaFuse}
+	if runtime.GOOS == "darwin" {
+		args = append(args, "--macho-segment-name", "NODE_SEA")
+	}
+	if err := runTool(stderr, postject, args...); err != nil {
+		return fmt.Errorf("injecting blob: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		if err := runTool(stderr, "codesign", "--sign", "-", filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPostject returns the path of the postject binary installed in
+// node_modules, or else on the PATH.
+func findPostject(repo *Repository) (string, error) {
+	local := filepath.Join(repo.RootDir, "node_modules", ".bin", "postject")
+	if runtime.GOOS == "windows" {
+		local += ".cmd"
+	}
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	if binary, err := exec.LookPath("postject"); err == nil {
+		return binary, nil
+	}
+	return "", errors.New("the executable preset requires postject; add it to dependencies and run `uni install`")
+}
+
+// runTool runs a command, writing its output to stderr.
+func runTool(stderr io.Writer, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return nil
+}
+
+// copyFile copies a file, replacing any existing file at the destination.
+func copyFile(dst string, src string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_ = os.Remove(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
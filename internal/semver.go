@@ -0,0 +1,406 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, as described at https://semver.org.
+type semver struct {
+	Major, Minor, Patch int
+	// Dot-separated prerelease identifiers, if any.
+	Prerelease []string
+	Build      string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = strings.Split(rest[i+1:], ".")
+		rest = rest[:i]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return semver{}, fmt.Errorf("invalid version: %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("invalid version: %q", s)
+		}
+		*nums[i] = n
+	}
+	for _, ident := range v.Prerelease {
+		if ident == "" {
+			return semver{}, fmt.Errorf("invalid version: %q", s)
+		}
+	}
+	return v, nil
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0, or 1 according to semver precedence. Build metadata
+// is ignored.
+func (v semver) Compare(other semver) int {
+	cores := [][2]int{
+		{v.Major, other.Major},
+		{v.Minor, other.Minor},
+		{v.Patch, other.Patch},
+	}
+	for _, core := range cores {
+		if c := compareInts(core[0], core[1]); c != 0 {
+			return c
+		}
+	}
+	// A version without a prerelease has higher precedence.
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		a, b := v.Prerelease[i], other.Prerelease[i]
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInts(an, bn)
+		case aErr == nil:
+			c = -1 // Numeric identifiers have lower precedence.
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(a, b)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.Prerelease), len(other.Prerelease))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Bump returns the next version at the given level, which is one of "major",
+// "minor", "patch", or "prerelease". Like npm, a prerelease bump of a release
+// version increments the patch number, and preid names a prerelease series.
+func (v semver) Bump(level string, preid string) (semver, error) {
+	next := semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	isPrerelease := len(v.Prerelease) > 0
+	switch level {
+	case "major":
+		// Releases the pending major version, if this is a prerelease of one.
+		if !isPrerelease || v.Minor != 0 || v.Patch != 0 {
+			next.Major++
+		}
+		next.Minor = 0
+		next.Patch = 0
+	case "minor":
+		if !isPrerelease || v.Patch != 0 {
+			next.Minor++
+		}
+		next.Patch = 0
+	case "patch":
+		if !isPrerelease {
+			next.Patch++
+		}
+	case "prerelease":
+		if !isPrerelease {
+			next.Patch++
+			next.Prerelease = newPrerelease(preid)
+			break
+		}
+		last := len(v.Prerelease) - 1
+		n, err := strconv.Atoi(v.Prerelease[last])
+		samePreid := preid == "" || (last > 0 && v.Prerelease[0] == preid)
+		if err != nil || !samePreid {
+			next.Prerelease = newPrerelease(preid)
+			break
+		}
+		next.Prerelease = append(append([]string{}, v.Prerelease[:last]...), strconv.Itoa(n+1))
+	default:
+		return semver{}, fmt.Errorf("invalid version bump: %q, expected major, minor, patch, or prerelease", level)
+	}
+	return next, nil
+}
+
+func newPrerelease(preid string) []string {
+	if preid == "" {
+		return []string{"0"}
+	}
+	return []string{preid, "0"}
+}
+
+// semverRange is a set of versions described by npm's range syntax, such as
+// ">=14.17", "^16.13.0", "14.x", or "12 || >=14". Prerelease versions are
+// compared by precedence, without npm's special treatment.
+type semverRange struct {
+	raw string
+	// Versions in the range satisfy every comparator of any of the sets.
+	sets [][]semverComparator
+}
+
+type semverComparator struct {
+	// One of ">=", ">", "<=", "<", or "=".
+	op      string
+	version semver
+}
+
+func (c semverComparator) matches(v semver) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp == 0
+	}
+}
+
+func parseSemverRange(s string) (semverRange, error) {
+	r := semverRange{raw: s}
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.Fields(alternative)
+		var set []semverComparator
+		if len(fields) == 3 && fields[1] == "-" {
+			// Hyphen ranges, inclusive of partial upper bounds.
+			lower, err := expandSemverComparator(">=" + fields[0])
+			if err != nil {
+				return semverRange{}, err
+			}
+			upper, err := expandSemverComparator("<=" + fields[2])
+			if err != nil {
+				return semverRange{}, err
+			}
+			set = append(lower, upper...)
+		} else {
+			for i := 0; i < len(fields); i++ {
+				field := fields[i]
+				// Allow a space between an operator and its version.
+				if strings.Trim(field, "<>=~^") == "" && i+1 < len(fields) {
+					i++
+					field += fields[i]
+				}
+				comparators, err := expandSemverComparator(field)
+				if err != nil {
+					return semverRange{}, fmt.Errorf("invalid version range %q: %w", s, err)
+				}
+				set = append(set, comparators...)
+			}
+		}
+		r.sets = append(r.sets, set)
+	}
+	return r, nil
+}
+
+// expandSemverComparator expands a single comparator, which may use a caret,
+// tilde, or partial version, into primitive comparators.
+func expandSemverComparator(s string) ([]semverComparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			break
+		}
+	}
+	nums, prerelease, err := parsePartialSemver(strings.TrimPrefix(s, op))
+	if err != nil {
+		return nil, err
+	}
+	// The lowest version of the partial version, and the lowest version
+	// above it at the precision given.
+	lower := semver{Prerelease: prerelease}
+	fields := []*int{&lower.Major, &lower.Minor, &lower.Patch}
+	for i, n := range nums {
+		*fields[i] = n
+	}
+	bump := func(level int) semver {
+		v := semver{Major: lower.Major, Minor: lower.Minor, Patch: lower.Patch}
+		switch level {
+		case 0:
+			v = semver{Major: v.Major + 1}
+		case 1:
+			v = semver{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			v.Patch++
+		}
+		return v
+	}
+	all := []semverComparator{{op: ">=", version: semver{}}}
+	switch op {
+	case "", "=":
+		if len(nums) == 0 {
+			return all, nil
+		}
+		if len(nums) == 3 {
+			return []semverComparator{{op: "=", version: lower}}, nil
+		}
+		return []semverComparator{{">=", lower}, {"<", bump(len(nums) - 1)}}, nil
+	case ">=":
+		return []semverComparator{{">=", lower}}, nil
+	case ">":
+		if len(nums) == 0 {
+			return []semverComparator{{"<", semver{}}}, nil
+		}
+		if len(nums) == 3 {
+			return []semverComparator{{">", lower}}, nil
+		}
+		return []semverComparator{{">=", bump(len(nums) - 1)}}, nil
+	case "<=":
+		if len(nums) == 0 {
+			return all, nil
+		}
+		if len(nums) == 3 {
+			return []semverComparator{{"<=", lower}}, nil
+		}
+		return []semverComparator{{"<", bump(len(nums) - 1)}}, nil
+	case "<":
+		return []semverComparator{{"<", lower}}, nil
+	case "~":
+		if len(nums) == 0 {
+			return all, nil
+		}
+		level := 1
+		if len(nums) == 1 {
+			level = 0
+		}
+		return []semverComparator{{">=", lower}, {"<", bump(level)}}, nil
+	default: // "^"
+		if len(nums) == 0 {
+			return all, nil
+		}
+		// Allow changes that do not modify the leftmost nonzero number.
+		level := 0
+		switch {
+		case lower.Major != 0 || len(nums) == 1:
+		case lower.Minor != 0 || len(nums) == 2:
+			level = 1
+		default:
+			level = 2
+		}
+		return []semverComparator{{">=", lower}, {"<", bump(level)}}, nil
+	}
+}
+
+// parsePartialSemver parses a version that may omit trailing numbers or use
+// "x" or "*" wildcards for them, returning the numbers given.
+func parsePartialSemver(s string) ([]int, []string, error) {
+	s = strings.TrimPrefix(s, "v")
+	var prerelease []string
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	var nums []int
+	if s == "" {
+		return nil, nil, nil
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, nil, fmt.Errorf("invalid version: %q", s)
+	}
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid version: %q", s)
+		}
+		nums = append(nums, n)
+	}
+	if len(nums) < 3 {
+		prerelease = nil
+	}
+	return nums, prerelease, nil
+}
+
+func (r semverRange) String() string {
+	return r.raw
+}
+
+// Contains reports whether a version is in the range.
+func (r semverRange) Contains(v semver) bool {
+	for _, set := range r.sets {
+		if setContains(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Min returns the lowest release version in the range, if any.
+func (r semverRange) Min() (semver, bool) {
+	var min semver
+	found := false
+	for _, set := range r.sets {
+		candidate := semver{}
+		for _, c := range set {
+			switch c.op {
+			case ">=", "=":
+				if c.version.Compare(candidate) > 0 {
+					candidate = c.version
+				}
+			case ">":
+				if c.version.Compare(candidate) >= 0 {
+					candidate, _ = c.version.Bump("patch", "")
+				}
+			}
+		}
+		if !setContains(set, candidate) {
+			continue
+		}
+		if !found || candidate.Compare(min) < 0 {
+			min = candidate
+			found = true
+		}
+	}
+	return min, found
+}
+
+func setContains(set []semverComparator, v semver) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
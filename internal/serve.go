@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+type ServeOptions struct {
+	Entrypoint string
+	// Address to listen on, in host:port form.
+	Addr string
+	// Directory of static files to serve. Defaults to the directory containing
+	// the entrypoint.
+	StaticDir string
+}
+
+const DefaultServeAddress = "localhost:3000"
+
+const (
+	serveBundlePath = "/bundle.js"
+	serveStylesPath = "/bundle.css"
+	serveReloadPath = "/__uni/reload"
+)
+
+// Serve bundles a browser entrypoint and serves it over HTTP from memory,
+// rebuilding when source files change. Connected pages are reloaded after
+// each successful rebuild.
+func Serve(repo *Repository, opts ServeOptions) error {
+	staticDir := opts.StaticDir
+	if staticDir == "" {
+		staticDir = filepath.Dir(opts.Entrypoint)
+	}
+
+	srv := &devServer{
+		staticDir: staticDir,
+		static:    http.FileServer(http.Dir(staticDir)),
+		clients:   make(map[chan struct{}]struct{}),
+	}
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	url := fmt.Sprintf("http://%s/", listener.Addr())
+	logEvent(os.Stderr, "serving", logFields{"url": url}, "serving on %s", url)
+	go func() {
+		if err := http.Serve(listener, srv); err != nil {
+			logEvent(os.Stderr, "error", nil, "server error: %v", err)
+		}
+	}()
+
+	return buildAndWatch{
+		Repository: repo,
+		Watch:      true,
+		Esbuild: api.BuildOptions{
+			AbsWorkingDir: repo.RootDir,
+			EntryPoints:   []string{opts.Entrypoint},
+			Outfile:       path.Join(repo.TmpDir, "serve", "bundle.js"),
+			Bundle:        true,
+			Platform:      api.PlatformBrowser,
+			Format:        api.FormatIIFE,
+			Write:         false,
+			LogLevel:      api.LogLevelWarning,
+			Sourcemap:     api.SourceMapInline,
+			Loader:        getLoaders(repo),
+		},
+		OnResult: func(result api.BuildResult) {
+			if len(result.Errors) > 0 {
+				return
+			}
+			// Reset styles when a rebuild no longer imports any stylesheets.
+			var styles []byte
+			for _, file := range result.OutputFiles {
+				switch path.Ext(file.Path) {
+				case ".js":
+					srv.SetBundle(file.Contents)
+				case ".css":
+					styles = file.Contents
+				}
+			}
+			srv.SetStyles(styles)
+		},
+		CreateProcess: func() process {
+			return &reloadProcess{
+				server: srv,
+				done:   make(chan struct{}),
+			}
+		},
+	}.Run()
+}
+
+type devServer struct {
+	staticDir string
+	static    http.Handler
+
+	mx      sync.Mutex
+	bundle  []byte
+	styles  []byte
+	clients map[chan struct{}]struct{}
+}
+
+func (srv *devServer) SetBundle(bundle []byte) {
+	srv.mx.Lock()
+	defer srv.mx.Unlock()
+	srv.bundle = bundle
+}
+
+// SetStyles sets the CSS bundled from imported stylesheets, if any.
+func (srv *devServer) SetStyles(styles []byte) {
+	srv.mx.Lock()
+	defer srv.mx.Unlock()
+	srv.styles = styles
+}
+
+// Reload notifies all connected pages to reload.
+func (srv *devServer) Reload() {
+	srv.mx.Lock()
+	defer srv.mx.Unlock()
+	for client := range srv.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (srv *devServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case serveBundlePath:
+		srv.mx.Lock()
+		bundle := srv.bundle
+		srv.mx.Unlock()
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(bundle)
+	case serveStylesPath:
+		srv.mx.Lock()
+		styles := srv.styles
+		srv.mx.Unlock()
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(styles)
+	case serveReloadPath:
+		srv.serveReloadEvents(w, req)
+	case "/", "/index.html":
+		reloadScript := fmt.Sprintf("<script>new EventSource(%q).onmessage = () => location.reload();</script>\n", serveReloadPath)
+		// Prefer a user-provided index page, falling back to a minimal page.
+		page, err := ioutil.ReadFile(path.Join(srv.staticDir, "index.html"))
+		if err == nil {
+			page = append(page, reloadScript...)
+		} else if os.IsNotExist(err) {
+			page = []byte(fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><link rel="stylesheet" href="%s"></head>
+<body>
+<script src="%s"></script>
+%s</body>
+</html>
+`, serveStylesPath, serveBundlePath, reloadScript))
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(page)
+	default:
+		srv.static.ServeHTTP(w, req)
+	}
+}
+
+func (srv *devServer) serveReloadEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	client := make(chan struct{}, 1)
+	srv.mx.Lock()
+	srv.clients[client] = struct{}{}
+	srv.mx.Unlock()
+	defer func() {
+		srv.mx.Lock()
+		delete(srv.clients, client)
+		srv.mx.Unlock()
+	}()
+
+	for {
+		select {
+		case <-client:
+			fmt.Fprintf(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// reloadProcess stands in for a program process in buildAndWatch. Starting it
+// reloads connected pages, and it runs until killed by a rebuild.
+type reloadProcess struct {
+	server *devServer
+	done   chan struct{}
+}
+
+func (proc *reloadProcess) Start() error {
+	proc.server.Reload()
+	return nil
+}
+
+func (proc *reloadProcess) Kill() error {
+	close(proc.done)
+	return nil
+}
+
+func (proc *reloadProcess) Wait() error {
+	<-proc.done
+	return nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// checkSideEffects warns about source files with side effects when imported,
+// such as top-level calls or imported stylesheets, that are bundled into the
+// given exported entrypoints of a package declaring that it has none. Since
+// bundlers of the package's consumers may then drop imports of the package
+// whose exports are unused, those side effects would be lost.
+//
+// Each entrypoint is bundled as if imported only for its side effects, so
+// that esbuild's tree shaking removes all code without any. Whatever code
+// remains is attributed to the source file it came from. Imports of external
+// modules are assumed to be free of side effects.
+func checkSideEffects(w io.Writer, repo *Repository, pkg *Package, buildOpts api.BuildOptions, entrypoints []string) error {
+	plugins, err := modulePlugins(repo)
+	if err != nil {
+		return err
+	}
+	var messages []api.Message
+	for _, entrypoint := range entrypoints {
+		opts := api.BuildOptions{
+			AbsWorkingDir: repo.RootDir,
+			Stdin: &api.StdinOptions{
+				Contents:   fmt.Sprintf("import %q;", entrypoint),
+				ResolveDir: repo.RootDir,
+			},
+			Outdir:      path.Join(repo.TmpDir, "side-effects"),
+			Bundle:      true,
+			Platform:    buildOpts.Platform,
+			Format:      api.FormatESModule,
+			Target:      buildOpts.Target,
+			Engines:     buildOpts.Engines,
+			LogLevel:    api.LogLevelSilent,
+			External:    buildOpts.External,
+			Loader:      buildOpts.Loader,
+			Define:      buildOpts.Define,
+			JSXFactory:  buildOpts.JSXFactory,
+			JSXFragment: buildOpts.JSXFragment,
+			Plugins:     plugins,
+		}
+		result := api.Build(opts)
+		if len(result.Errors) > 0 {
+			// Reported by the build itself.
+			continue
+		}
+		var files []string
+		for _, output := range result.OutputFiles {
+			files = append(files, sideEffectFiles(string(output.Contents), path.Ext(output.Path) == ".css")...)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		sort.Strings(files)
+		messages = append(messages, api.Message{
+			Text: fmt.Sprintf("%s declares no side effects, but importing %s has side effects from: %s", pkg.Name, relativeToRoot(repo, entrypoint), strings.Join(files, ", ")),
+		})
+	}
+	fprintMessages(w, messages, "warning")
+	return nil
+}
+
+// sideEffectFiles returns the source files of code remaining in a bundle of
+// an entrypoint imported only for its side effects. esbuild precedes the
+// code of each source file with a comment giving its path, and such bundles
+// otherwise contain only imports of external modules and runtime helpers.
+func sideEffectFiles(bundle string, css bool) []string {
+	var files []string
+	current := ""
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(bundle, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case css && strings.HasPrefix(line, "/* ") && strings.HasSuffix(line, " */"):
+			current = strings.TrimSuffix(strings.TrimPrefix(line, "/* "), " */")
+		case !css && strings.HasPrefix(line, "// "):
+			current = strings.TrimPrefix(line, "// ")
+		case line == "" || current == "" || current == "<stdin>" || seen[current]:
+		case !css && strings.HasPrefix(line, "import"):
+		default:
+			seen[current] = true
+			files = append(files, current)
+		}
+	}
+	return files
+}
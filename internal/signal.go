@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// ParseSignal parses a signal name, such as "SIGTERM" or "term".
+func ParseSignal(name string) (syscall.Signal, error) {
+	normalized := strings.ToUpper(name)
+	if !strings.HasPrefix(normalized, "SIG") {
+		normalized = "SIG" + normalized
+	}
+	sig, ok := signalsByName[normalized]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal: %q", name)
+	}
+	return sig, nil
+}
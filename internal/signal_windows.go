@@ -0,0 +1,11 @@
+package internal
+
+import "syscall"
+
+// Windows can only deliver SIGKILL to other processes. Other signals are
+// accepted for configuration portability, but are not deliverable.
+var signalsByName = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
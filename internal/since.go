@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ChangedFiles returns the set of absolute paths of files within the
+// repository that differ from the given git ref, including uncommitted and
+// untracked files.
+func ChangedFiles(repo *Repository, ref string) (map[string]bool, error) {
+	changed := make(map[string]bool)
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", ref, "--"},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		git := exec.Command("git", args...)
+		git.Dir = repo.RootDir
+		var stderr bytes.Buffer
+		git.Stderr = &stderr
+		out, err := git.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if line != "" {
+				changed[path.Join(repo.RootDir, line)] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// AffectedPackages returns the subset of packages that load any file changed
+// since the given git ref. All packages are affected if the config file has
+// changed.
+func AffectedPackages(repo *Repository, packages map[string]*Package, ref string) (map[string]*Package, error) {
+	changed, err := ChangedFiles(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	if anyChanged(changed, repo.ConfigInputs) {
+		return packages, nil
+	}
+	affected := make(map[string]*Package)
+	for name, pkg := range packages {
+		if anyChanged(changed, analyzeInputs(repo, pkg)) {
+			affected[name] = pkg
+		}
+	}
+	return affected, nil
+}
+
+func anyChanged(changed map[string]bool, files []string) bool {
+	for _, file := range files {
+		if changed[file] {
+			return true
+		}
+	}
+	return false
+}
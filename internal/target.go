@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var esTargets = map[string]api.Target{
+	"esnext": api.ESNext,
+	"es5":    api.ES5,
+	"es6":    api.ES2015,
+	"es2015": api.ES2015,
+	"es2016": api.ES2016,
+	"es2017": api.ES2017,
+	"es2018": api.ES2018,
+	"es2019": api.ES2019,
+	"es2020": api.ES2020,
+}
+
+var targetEngines = map[string]api.EngineName{
+	"chrome":  api.EngineChrome,
+	"edge":    api.EngineEdge,
+	"firefox": api.EngineFirefox,
+	"ios":     api.EngineIOS,
+	"node":    api.EngineNode,
+	"safari":  api.EngineSafari,
+}
+
+// ValidateTarget checks the syntax of a target, as accepted by parseTarget.
+func ValidateTarget(s string) error {
+	_, _, err := parseTarget(s)
+	return err
+}
+
+// parseTarget parses a comma-separated list of language versions and engine
+// versions, such as "es2019" or "node12,chrome80", as accepted by esbuild's
+// --target flag. An empty target is ESNext with no engine constraints.
+func parseTarget(s string) (api.Target, []api.Engine, error) {
+	target := api.ESNext
+	var engines []api.Engine
+	if s == "" {
+		return target, engines, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if esTarget, ok := esTargets[part]; ok {
+			target = esTarget
+			continue
+		}
+		i := strings.IndexAny(part, "0123456789")
+		if i <= 0 {
+			return target, nil, fmt.Errorf("invalid target: %q", part)
+		}
+		name, ok := targetEngines[part[:i]]
+		if !ok {
+			return target, nil, fmt.Errorf("unknown target engine: %q", part[:i])
+		}
+		engines = append(engines, api.Engine{
+			Name:    name,
+			Version: part[i:],
+		})
+	}
+	return target, engines, nil
+}
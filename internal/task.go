@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Name of the builtin task that builds a package with uni build. Packages may
+// not define their own task with this name.
+const buildTaskName = "build"
+
+// Task is a shell command associated with a package, run with uni task.
+type Task struct {
+	Package *Package
+	Name    string
+	Run     string
+	// References to tasks that must run first. See resolveTaskReference.
+	DependsOn []string
+	// Glob patterns of files, relative to the repository root, that the task
+	// reads and writes. Tasks with inputs are skipped when their inputs and
+	// outputs are unchanged since they last succeeded.
+	Inputs  []string
+	Outputs []string
+}
+
+func newTask(pkg *Package, name string, cfg TaskConfig) (*Task, error) {
+	if name == "" || strings.ContainsAny(name, "#^") {
+		return nil, fmt.Errorf("invalid task name: %q", name)
+	}
+	if name == buildTaskName {
+		return nil, fmt.Errorf("task %q is reserved for uni build", name)
+	}
+	if cfg.Run == "" {
+		return nil, fmt.Errorf("task %q with no run command", name)
+	}
+	for _, patterns := range [][]string{cfg.Inputs, cfg.Outputs} {
+		for _, pattern := range patterns {
+			if _, err := compileGlob(pattern); err != nil {
+				return nil, fmt.Errorf("task %q with invalid pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+	return &Task{
+		Package:   pkg,
+		Name:      name,
+		Run:       cfg.Run,
+		DependsOn: cfg.DependsOn,
+		Inputs:    cfg.Inputs,
+		Outputs:   cfg.Outputs,
+	}, nil
+}
+
+func (task *Task) String() string {
+	return task.Package.Name + "#" + task.Name
+}
+
+type TaskOptions struct {
+	// Run tasks even if their inputs are unchanged.
+	NoCache bool
+}
+
+// packageTask returns the named task of a package, including the builtin
+// build task, or nil if there is none.
+func packageTask(pkg *Package, name string) *Task {
+	if name == buildTaskName {
+		return &Task{Package: pkg, Name: buildTaskName}
+	}
+	return pkg.Tasks[name]
+}
+
+// RunTasks runs the named task of each of the given packages that defines it,
+// after the tasks each depends on. Each task runs at most once, and running
+// stops at the first failure.
+func RunTasks(repo *Repository, name string, packages map[string]*Package, opts TaskOptions) error {
+	var roots []*Task
+	for _, pkg := range packages {
+		if task := packageTask(pkg, name); task != nil {
+			roots = append(roots, task)
+		}
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no package defines task %q", name)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].String() < roots[j].String()
+	})
+
+	order, err := (&taskPlanner{repo: repo}).Order(roots)
+	if err != nil {
+		return err
+	}
+
+	env, err := commandEnv(repo)
+	if err != nil {
+		return err
+	}
+
+	// Output is labeled when running more than one task.
+	var outputMx sync.Mutex
+	for _, task := range order {
+		var stdout, stderr io.Writer = os.Stdout, os.Stderr
+		var prefixers []*prefixWriter
+		if len(order) > 1 {
+			label := "[" + task.String() + "]"
+			prefixers = []*prefixWriter{
+				newPrefixWriter(&outputMx, os.Stdout, label),
+				newPrefixWriter(&outputMx, os.Stderr, label),
+			}
+			stdout, stderr = prefixers[0], prefixers[1]
+		}
+		err := runTask(repo, task, env, opts, stdout, stderr)
+		for _, prefixer := range prefixers {
+			prefixer.Flush()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", task, err)
+		}
+	}
+	return nil
+}
+
+// taskPlanner orders tasks such that each comes after its dependencies.
+type taskPlanner struct {
+	repo  *Repository
+	graph *PackageGraph
+}
+
+func (planner *taskPlanner) Order(roots []*Task) ([]*Task, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []*Task
+	var stack []string
+	var visit func(task *Task) error
+	visit = func(task *Task) error {
+		key := task.String()
+		switch state[key] {
+		case visited:
+			return nil
+		case visiting:
+			for i, k := range stack {
+				if k == key {
+					return fmt.Errorf("task cycle: %s", strings.Join(append(stack[i:], key), " -> "))
+				}
+			}
+		}
+		state[key] = visiting
+		stack = append(stack, key)
+		for _, ref := range task.DependsOn {
+			deps, err := planner.resolveTaskReference(task.Package, ref)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			for _, dep := range deps {
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[key] = visited
+		order = append(order, task)
+		return nil
+	}
+	for _, root := range roots {
+		if err := visit(root); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// resolveTaskReference returns the tasks referred to by a dependsOn entry of a
+// task of the given package. References are of the form:
+//
+//	task            A task of the same package.
+//	^task           The task of each package that the package depends on and
+//	                that defines it.
+//	package#task    A task of another package.
+func (planner *taskPlanner) resolveTaskReference(pkg *Package, ref string) ([]*Task, error) {
+	switch {
+	case strings.HasPrefix(ref, "^"):
+		name := ref[1:]
+		if planner.graph == nil {
+			var err error
+			planner.graph, err = LoadPackageGraph(planner.repo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var tasks []*Task
+		for _, dependency := range planner.graph.Dependencies[pkg.Name] {
+			if task := packageTask(planner.repo.Packages[dependency], name); task != nil {
+				tasks = append(tasks, task)
+			}
+		}
+		return tasks, nil
+
+	case strings.Contains(ref, "#"):
+		i := strings.LastIndex(ref, "#")
+		other, ok := planner.repo.Packages[ref[:i]]
+		if !ok {
+			return nil, fmt.Errorf("no such package: %q", ref[:i])
+		}
+		task := packageTask(other, ref[i+1:])
+		if task == nil {
+			return nil, fmt.Errorf("no such task: %q", ref)
+		}
+		return []*Task{task}, nil
+
+	default:
+		task := packageTask(pkg, ref)
+		if task == nil {
+			return nil, fmt.Errorf("no such task: %q", ref)
+		}
+		return []*Task{task}, nil
+	}
+}
+
+func runTask(repo *Repository, task *Task, env []string, opts TaskOptions, stdout, stderr io.Writer) error {
+	if task.Name == buildTaskName {
+		return Build(repo, BuildOptions{Package: task.Package, stderr: stderr})
+	}
+
+	var cache *commandCache
+	if len(task.Inputs) > 0 && !opts.NoCache {
+		manifestPath := path.Join(repo.TmpDir, "tasks", stripName(task.Package.Name), task.Name+".json")
+		cache = newCommandCache(repo, manifestPath, task.Run, task.Inputs, task.Outputs)
+		upToDate, err := cache.UpToDate()
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			logEvent(stderr, "up-to-date", logFields{"task": task.String()}, "%s is up to date", task)
+			return nil
+		}
+		// Invalidate first, in case the task fails part way through.
+		if err := cache.Invalidate(); err != nil {
+			return err
+		}
+	}
+
+	logEvent(stderr, "task", logFields{"task": task.String(), "command": task.Run}, "> %s: %s", task, task.Run)
+	cmd := shellCommand(task.Run)
+	cmd.Dir = repo.RootDir
+	cmd.Env = append(append([]string{}, env...),
+		"UNI_PACKAGE="+task.Package.Name,
+		"UNI_TASK="+task.Name,
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if cache != nil {
+		return cache.Save()
+	}
+	return nil
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// WriteTasks prints the tasks of each package, with their dependencies.
+func WriteTasks(w io.Writer, repo *Repository) error {
+	names := make([]string, 0, len(repo.Packages))
+	for name := range repo.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	found := false
+	for _, name := range names {
+		pkg := repo.Packages[name]
+		taskNames := make([]string, 0, len(pkg.Tasks))
+		for taskName := range pkg.Tasks {
+			taskNames = append(taskNames, taskName)
+		}
+		sort.Strings(taskNames)
+		for _, taskName := range taskNames {
+			task := pkg.Tasks[taskName]
+			found = true
+			fmt.Fprintf(w, "%s: %s\n", task, task.Run)
+			if len(task.DependsOn) > 0 {
+				fmt.Fprintf(w, "  depends on: %s\n", strings.Join(task.DependsOn, ", "))
+			}
+		}
+	}
+	if !found {
+		return errors.New("no tasks configured")
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/fsnotify/fsnotify"
+)
+
+type TestOptions struct {
+	// Files or directories to search for tests. Defaults to the repository root.
+	Paths []string
+	// Reruns affected tests when their source files change.
+	Watch bool
+	// If positive, poll for changes at this interval in watch mode.
+	Poll time.Duration
+	// If set, only run test files that load files changed since this git ref.
+	Since string
+}
+
+// Suffix of test module file names.
+const testSuffix = ".test.ts"
+
+// Test modules export functions with names beginning with "test". Each test
+// function is awaited in turn, and a test fails if it throws or rejects.
+//
+// Every test module is bundled and executed in its own node process.
+func Test(repo *Repository, opts TestOptions) error {
+	files, dirs, err := findTests(repo, opts.Paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no test files found")
+	}
+	// All test files found, including those not run, such that test files
+	// created later in watch mode are told apart.
+	known := make(map[string]bool, len(files))
+	for _, file := range files {
+		known[file] = true
+	}
+	if opts.Since != "" {
+		files, err = affectedTests(repo, files, opts.Since)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			logEvent(os.Stderr, "info", nil, "no test files affected since %s", opts.Since)
+			return nil
+		}
+	}
+
+	if err := EnsureTmp(repo); err != nil {
+		return err
+	}
+	dir, err := TempDir(repo, "test")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Map of test file to the source files it depends on.
+	inputs := make(map[string][]string, len(files))
+	var outputMx sync.Mutex
+	runTests := func(files []string) error {
+		run := startSpan(os.Stderr, LogLevelVerbose, "test", "test run", "", logFields{"files": len(files)})
+		defer run.End()
+		failures := 0
+		for _, file := range files {
+			rel, err := filepath.Rel(repo.RootDir, file)
+			if err != nil {
+				rel = file
+			}
+			sp := startSpan(os.Stderr, LogLevelVerbose, "test", "test "+rel, "", logFields{"file": rel})
+			testDir := path.Join(dir, stripName(rel))
+			// Output is labeled when running more than one test file.
+			var stdout, stderr io.Writer = os.Stdout, os.Stderr
+			var prefixers []*prefixWriter
+			if len(files) > 1 {
+				label := "[" + rel + "]"
+				prefixers = []*prefixWriter{
+					newPrefixWriter(&outputMx, os.Stdout, label),
+					newPrefixWriter(&outputMx, os.Stderr, label),
+				}
+				stdout, stderr = prefixers[0], prefixers[1]
+			}
+			inputs[file], err = runTestFile(repo, file, testDir, stdout, stderr)
+			for _, prefixer := range prefixers {
+				prefixer.Flush()
+			}
+			if err == nil {
+				sp.End()
+				logEvent(os.Stdout, "test", logFields{"file": rel, "passed": true}, "PASS %s", rel)
+			} else {
+				failures++
+				sp.Fail(err.Error())
+				sp.End()
+				logEvent(os.Stdout, "test", logFields{"file": rel, "passed": false}, "FAIL %s", rel)
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					logEvent(os.Stderr, "error", nil, "%v", err)
+				}
+			}
+		}
+		if failures > 0 {
+			err := fmt.Errorf("%d of %d test files failed", failures, len(files))
+			run.Fail(err.Error())
+			return err
+		}
+		return nil
+	}
+
+	err = runTests(files)
+	if !opts.Watch {
+		return err
+	}
+	if err != nil {
+		logEvent(os.Stderr, "error", nil, "%v", err)
+	}
+
+	ignored, err := newGlobSet(repo.RootDir, repo.WatchIgnore)
+	if err != nil {
+		return err
+	}
+	// Native watchers observe the whole repository, so that new test files are
+	// found. Polling watchers observe only the directories searched for tests,
+	// whose modification times change when files are created in them.
+	watcher, err := watchRepository(repo, opts.Poll)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	searched := newStringSet()
+	watchDirs := func() {
+		for _, dir := range dirs {
+			if searched.Has(dir) {
+				continue
+			}
+			searched.Add(dir)
+			if opts.Poll <= 0 {
+				continue
+			}
+			if err := watchDir(watcher, dir); err != nil {
+				Warnf("watching %q: %v", dir, err)
+			}
+		}
+	}
+	watchDirs()
+	// isCreation reports whether an event may have created test files.
+	isCreation := func(event fsnotify.Event) bool {
+		if strings.HasPrefix(event.Name, repo.OutDir+string(filepath.Separator)) {
+			return false
+		}
+		if searched.Has(event.Name) {
+			return true
+		}
+		if event.Op&(fsnotify.Create|fsnotify.Rename) == 0 {
+			return false
+		}
+		if strings.HasSuffix(event.Name, testSuffix) {
+			return true
+		}
+		fi, err := os.Stat(event.Name)
+		return err == nil && fi.IsDir()
+	}
+	watchInputs := func() {
+		for _, files := range inputs {
+			for _, file := range files {
+				if ignored.Match(file) {
+					continue
+				}
+				if err := watcher.Add(file); err != nil {
+					Warnf("watching %q: %v", file, err)
+				}
+			}
+		}
+	}
+	watchInputs()
+
+	for {
+		changed := make(map[string]bool)
+		created := false
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				return nil
+			}
+			changed[event.Name] = true
+			created = isCreation(event)
+		case err := <-watcher.Errors():
+			return err
+		}
+		// Absorb extra events until they stop for the debounce period, in case
+		// many files are changing at once.
+	absorb:
+		for {
+			select {
+			case event := <-watcher.Events():
+				changed[event.Name] = true
+				created = created || isCreation(event)
+			case <-time.After(repo.WatchDebounce):
+				break absorb
+			}
+		}
+
+		var affected []string
+		// Test files that are new since the last search are run, and those
+		// that no longer exist are forgotten.
+		if created {
+			found, foundDirs, err := findTests(repo, opts.Paths)
+			if err != nil {
+				logEvent(os.Stderr, "error", nil, "finding tests: %v", err)
+				continue
+			}
+			dirs = foundDirs
+			watchDirs()
+			exists := make(map[string]bool, len(found))
+			for _, file := range found {
+				exists[file] = true
+			}
+			var remaining []string
+			for _, file := range files {
+				if exists[file] {
+					remaining = append(remaining, file)
+				} else {
+					delete(known, file)
+				}
+			}
+			files = remaining
+			for _, file := range found {
+				if !known[file] {
+					known[file] = true
+					files = append(files, file)
+					affected = append(affected, file)
+				}
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			for _, input := range inputs[file] {
+				if changed[input] {
+					affected = append(affected, file)
+					break
+				}
+			}
+		}
+		if len(affected) == 0 {
+			continue
+		}
+		logEvent(os.Stderr, "rerunning", logFields{"files": len(affected)}, "rerunning %d affected test files", len(affected))
+		if err := runTests(affected); err != nil {
+			logEvent(os.Stderr, "error", nil, "%v", err)
+		}
+		watchInputs()
+	}
+}
+
+// affectedTests returns the test files that load any file changed since the
+// given git ref.
+func affectedTests(repo *Repository, files []string, ref string) ([]string, error) {
+	changed, err := ChangedFiles(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	if anyChanged(changed, repo.ConfigInputs) {
+		return files, nil
+	}
+	return testsLoading(repo, files, changed), nil
+}
+
+// testsLoading returns the test files that are, or load, any of the given
+// files.
+func testsLoading(repo *Repository, files []string, loaded map[string]bool) []string {
+	var affected []string
+	for _, file := range files {
+		inputs := analyzeEntrypoints(repo, []string{file}, api.BuildOptions{
+			Platform: api.PlatformNode,
+			External: getExternals(repo),
+		})
+		if loaded[file] || anyChanged(loaded, inputs) {
+			affected = append(affected, file)
+		}
+	}
+	return affected
+}
+
+// runTestFile builds and runs a single test file, returning the absolute paths
+// of the source files that the test depends on.
+func runTestFile(repo *Repository, file string, dir string, stdout, stderr io.Writer) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// See also `script` in Run.
+	script := `require('source-map-support').install();
+
+const { inspect } = require('util');
+process.on('uncaughtException', (exception) => {
+  process.stderr.write('uncaught exception: ' + inspect(exception) + '\n', () => {
+    process.exit(1);
+  });
+});
+process.on('unhandledRejection', (reason, promise) => {
+  process.stderr.write(
+    'unhandled rejection at: ' + inspect(promise) + '\nreason: ' + inspect(reason) + '\n',
+    () => {
+      process.exit(1);
+    },
+  );
+})
+
+const tests = require('./bundle.js');
+void (async () => {
+	let failures = 0;
+	for (const [name, test] of Object.entries(tests)) {
+		if (typeof test !== 'function' || !name.startsWith('test')) {
+			continue;
+		}
+		try {
+			await test();
+			process.stdout.write('  ok   ' + name + '\n');
+		} catch (err) {
+			failures++;
+			process.stdout.write('  FAIL ' + name + '\n' + inspect(err) + '\n');
+		}
+	}
+	process.exit(failures === 0 ? 0 : 1);
+})();
+`
+	scriptPath := path.Join(dir, "script.js")
+	if err := ioutil.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		return nil, err
+	}
+
+	metafilePath := path.Join(dir, "meta.json")
+	_ = os.Remove(metafilePath)
+	esbuildOpts, err := runEsbuildOptions(repo, file, path.Join(dir, "bundle.js"))
+	if err != nil {
+		return nil, err
+	}
+	esbuildOpts.Metafile = metafilePath
+
+	err = buildAndWatch{
+		Repository: repo,
+		Esbuild:    esbuildOpts,
+		Stderr:     stderr,
+		CreateProcess: func() process {
+			node, err := nodeCommand(repo, scriptPath)
+			if err != nil {
+				return &funcProcess{
+					start: func() error {
+						return err
+					},
+				}
+			}
+			node.Stdout = stdout
+			node.Stderr = stderr
+			if err := repo.wrapCommand(node); err != nil {
+				return &funcProcess{
+					start: func() error {
+						return err
+					},
+				}
+			}
+			return newCmdProcess(node, repo.Shutdown)
+		},
+	}.Run()
+
+	inputs := []string{file}
+	if metafile, metaErr := readMetafile(metafilePath); metaErr == nil {
+		inputs = metafile.InputPaths(repo)
+	}
+	return inputs, err
+}
+
+// FindTests returns the absolute paths of test files within the given paths,
+// or within the repository when no paths are given.
+func FindTests(repo *Repository, paths []string) ([]string, error) {
+	files, _, err := findTests(repo, paths)
+	return files, err
+}
+
+// findTests is FindTests, but also returns the directories searched.
+func findTests(repo *Repository, paths []string) (files []string, dirs []string, err error) {
+	if len(paths) == 0 {
+		paths = []string{repo.RootDir}
+	}
+	for _, root := range paths {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				name := fi.Name()
+				if file != root && (name == "node_modules" || strings.HasPrefix(name, ".") || file == repo.OutDir) {
+					return filepath.SkipDir
+				}
+				dirs = append(dirs, file)
+				return nil
+			}
+			if strings.HasSuffix(file, testSuffix) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, dirs, nil
+}
@@ -1,18 +1,91 @@
 package internal
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
 )
 
+// Name of the file in each temporary directory that records which process
+// owns it.
+const tempDirOwnerFile = ".uni-owner"
+
+type tempDirOwner struct {
+	Pid int `json:"pid"`
+}
+
+// Matches the names of directories created by TempDir.
+var tempDirPattern = regexp.MustCompile(`^[a-z]+[0-9]+$`)
+
+var collectTempDirsOnce sync.Once
+
 func EnsureTmp(repo *Repository) error {
-	return os.MkdirAll(repo.TmpDir, 0755)
+	if err := os.MkdirAll(repo.TmpDir, 0755); err != nil {
+		return err
+	}
+	collectTempDirsOnce.Do(func() {
+		collectTempDirs(repo, time.Now())
+	})
+	return nil
 }
 
+// TempDir creates a temporary directory owned by this process. It should be
+// removed when no longer needed, but is otherwise collected once the process
+// has exited.
 func TempDir(repo *Repository, prefix string) (string, error) {
-	return ioutil.TempDir(repo.TmpDir, prefix)
+	dir, err := ioutil.TempDir(repo.TmpDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	if err := writeTempDirOwner(dir, tempDirOwner{Pid: os.Getpid()}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func writeTempDirOwner(dir string, owner tempDirOwner) error {
+	bs, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, tempDirOwnerFile), bs, 0644)
 }
 
 func TempFile(repo *Repository, prefix string) (*os.File, error) {
 	return ioutil.TempFile(repo.TmpDir, "esbuild.meta")
 }
+
+// collectTempDirs removes temporary directories leaked by processes that
+// exited without removing them, such as when killed. A directory is removed if
+// the process that owns it is no longer running, or once it is older than the
+// repository's tmp.maxAge, if its owner is unknown. Directories of running
+// processes are never removed. Failures are ignored, since another process may
+// be collecting at the same time.
+func collectTempDirs(repo *Repository, now time.Time) {
+	entries, err := ioutil.ReadDir(repo.TmpDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !tempDirPattern.MatchString(entry.Name()) {
+			continue
+		}
+		dir := path.Join(repo.TmpDir, entry.Name())
+		expired := repo.TmpMaxAge > 0 && now.Sub(entry.ModTime()) > repo.TmpMaxAge
+		var owner tempDirOwner
+		if bs, err := ioutil.ReadFile(path.Join(dir, tempDirOwnerFile)); err == nil && json.Unmarshal(bs, &owner) == nil && owner.Pid > 0 {
+			if owner.Pid == os.Getpid() || processRunning(owner.Pid) {
+				continue
+			}
+			expired = true
+		}
+		if expired {
+			_ = os.RemoveAll(dir)
+		}
+	}
+}
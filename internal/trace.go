@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// LogLevel controls how much uni reports about its own work.
+type LogLevel int
+
+const (
+	LogLevelNormal LogLevel = iota
+	// Also reports how long each phase takes, such as loading config,
+	// bundling, starting processes, and handling changes in watch mode.
+	LogLevelVerbose
+	// Also reports each file change, and the time spent by uni's esbuild
+	// plugins resolving and loading each file.
+	LogLevelTrace
+)
+
+var logLevel = LogLevelNormal
+
+func SetLogLevel(level LogLevel) {
+	logLevel = level
+}
+
+// When tracing began, which trace file timestamps are relative to.
+var traceStart = time.Now()
+
+var traceMx sync.Mutex
+
+// Path of the Chrome trace file to write, if any, and the events recorded for
+// it. See WriteTraceFile.
+var traceFile string
+var traceEvents []chromeTraceEvent
+
+// Thread IDs of trace events, assigned by category and label, so that spans
+// that may overlap, such as builds of different packages, appear on separate
+// rows.
+var traceThreads = make(map[string]int)
+
+// chromeTraceEvent is an event of the Chrome trace event format, which is
+// viewable with chrome://tracing or https://ui.perfetto.dev.
+type chromeTraceEvent struct {
+	Name     string    `json:"name"`
+	Category string    `json:"cat"`
+	Phase    string    `json:"ph"`
+	Time     int64     `json:"ts"`
+	Duration int64     `json:"dur,omitempty"`
+	Pid      int       `json:"pid"`
+	Tid      int       `json:"tid"`
+	Scope    string    `json:"s,omitempty"`
+	Args     logFields `json:"args,omitempty"`
+}
+
+// SetTraceFile enables recording spans of all levels, to be written to a file
+// by WriteTraceFile.
+func SetTraceFile(filename string) {
+	traceMx.Lock()
+	defer traceMx.Unlock()
+	traceFile = filename
+}
+
+// WriteTraceFile writes the recorded spans to the trace file, if enabled.
+func WriteTraceFile() error {
+	traceMx.Lock()
+	defer traceMx.Unlock()
+	if traceFile == "" {
+		return nil
+	}
+	events := traceEvents
+	if events == nil {
+		events = []chromeTraceEvent{}
+	}
+	bs, err := json.Marshal(struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}{events})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(traceFile, bs, 0644)
+}
+
+func tracing(level LogLevel) bool {
+	if logLevel >= level {
+		return true
+	}
+	if otel != nil && level <= LogLevelVerbose {
+		return true
+	}
+	traceMx.Lock()
+	defer traceMx.Unlock()
+	return traceFile != ""
+}
+
+// traceSpan times a phase of work. See startSpan.
+type traceSpan struct {
+	w        io.Writer
+	level    LogLevel
+	category string
+	name     string
+	label    string
+	fields   logFields
+	start    time.Time
+	failure  string
+}
+
+// startSpan starts timing a phase of work, which is reported by End if the log
+// level is at least the given level, and recorded in the trace file, if any.
+// The label, if any, identifies what the work is for, such as a package, and
+// prefixes the reported message. Spans with the same category and label are
+// shown on the same row of the trace. Returns nil, which may be ended, if the
+// span would not be reported. Spans of at most the verbose level are also
+// exported to OpenTelemetry, if enabled.
+func startSpan(w io.Writer, level LogLevel, category string, name string, label string, fields logFields) *traceSpan {
+	if !tracing(level) {
+		return nil
+	}
+	return &traceSpan{
+		w:        w,
+		level:    level,
+		category: category,
+		name:     name,
+		label:    label,
+		fields:   fields,
+		start:    time.Now(),
+	}
+}
+
+// Fail marks the span as failed, such as a build with errors, when exported.
+func (sp *traceSpan) Fail(message string) {
+	if sp == nil {
+		return
+	}
+	sp.failure = message
+}
+
+func (sp *traceSpan) End() {
+	if sp == nil {
+		return
+	}
+	end := time.Now()
+	elapsed := end.Sub(sp.start)
+	if logLevel >= sp.level {
+		fields := make(logFields, len(sp.fields)+3)
+		for k, v := range sp.fields {
+			fields[k] = v
+		}
+		fields["phase"] = sp.category
+		fields["name"] = sp.name
+		fields["ms"] = float64(elapsed.Microseconds()) / 1000
+		prefix := ""
+		if sp.label != "" {
+			prefix = sp.label + ": "
+		}
+		logEvent(sp.w, "timing", fields, "%s%s took %s", prefix, sp.name, formatElapsed(elapsed))
+	}
+	recordTraceEvent(chromeTraceEvent{
+		Name:     sp.name,
+		Category: sp.category,
+		Phase:    "X",
+		Time:     sp.start.Sub(traceStart).Microseconds(),
+		Duration: elapsed.Microseconds(),
+		Args:     sp.fields,
+	}, sp.label)
+	if sp.level <= LogLevelVerbose {
+		fields := make(logFields, len(sp.fields)+1)
+		for k, v := range sp.fields {
+			fields[k] = v
+		}
+		if sp.label != "" {
+			fields["label"] = sp.label
+		}
+		exportSpan(sp.name, sp.start, end, fields, sp.failure)
+	}
+}
+
+// traceInstant logs an event, such as a file change, with a message only if
+// the log level is at least the given level, and records it in the trace file,
+// if any.
+func traceInstant(w io.Writer, level LogLevel, event string, message string, fields logFields) {
+	if logLevel >= level {
+		logEvent(w, event, fields, "%s", message)
+	} else {
+		logEvent(w, event, fields, "")
+	}
+	recordTraceEvent(chromeTraceEvent{
+		Name:     message,
+		Category: event,
+		Phase:    "i",
+		Time:     time.Since(traceStart).Microseconds(),
+		Scope:    "p",
+		Args:     fields,
+	}, "")
+}
+
+func recordTraceEvent(event chromeTraceEvent, label string) {
+	traceMx.Lock()
+	defer traceMx.Unlock()
+	if traceFile == "" {
+		return
+	}
+	thread := event.Category + "\x00" + label
+	tid, ok := traceThreads[thread]
+	if !ok {
+		tid = len(traceThreads) + 1
+		traceThreads[thread] = tid
+	}
+	event.Pid = os.Getpid()
+	event.Tid = tid
+	traceEvents = append(traceEvents, event)
+}
+
+// formatElapsed formats a duration with a precision suited to its magnitude.
+func formatElapsed(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
+
+// tracePlugins wraps the callbacks of esbuild plugins to time each call, at
+// the trace level. Time spent by esbuild itself is not included.
+func tracePlugins(w io.Writer, label string, plugins []api.Plugin) []api.Plugin {
+	if !tracing(LogLevelTrace) {
+		return plugins
+	}
+	traced := make([]api.Plugin, len(plugins))
+	for i, plugin := range plugins {
+		plugin := plugin
+		traced[i] = api.Plugin{
+			Name: plugin.Name,
+			Setup: func(build api.PluginBuild) {
+				plugin.Setup(&tracedPluginBuild{
+					build:  build,
+					w:      w,
+					label:  label,
+					plugin: plugin.Name,
+				})
+			},
+		}
+	}
+	return traced
+}
+
+type tracedPluginBuild struct {
+	build  api.PluginBuild
+	w      io.Writer
+	label  string
+	plugin string
+}
+
+func (b *tracedPluginBuild) OnResolve(options api.OnResolveOptions, callback func(api.OnResolveArgs) (api.OnResolveResult, error)) {
+	b.build.OnResolve(options, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+		sp := startSpan(b.w, LogLevelTrace, "resolve", "resolve "+args.Path+" ("+b.plugin+")", b.label, logFields{
+			"plugin":   b.plugin,
+			"path":     args.Path,
+			"importer": args.Importer,
+		})
+		defer sp.End()
+		return callback(args)
+	})
+}
+
+func (b *tracedPluginBuild) OnLoad(options api.OnLoadOptions, callback func(api.OnLoadArgs) (api.OnLoadResult, error)) {
+	b.build.OnLoad(options, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+		sp := startSpan(b.w, LogLevelTrace, "load", "load "+args.Path+" ("+b.plugin+")", b.label, logFields{
+			"plugin": b.plugin,
+			"path":   args.Path,
+		})
+		defer sp.End()
+		return callback(args)
+	})
+}
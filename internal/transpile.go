@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+type TranspileOptions struct {
+	Package *Package
+	// Directory to write modules to. Defaults to out/transpile/<package>.
+	OutDir string
+
+	stderr io.Writer
+}
+
+// Extensions of source modules that are transpiled, rather than copied.
+var transpiledExtensions = map[string]bool{
+	".ts":  true,
+	".tsx": true,
+	".js":  true,
+	".jsx": true,
+	".mjs": true,
+	".cjs": true,
+}
+
+// Transpile compiles each source module of a package to its own module,
+// without bundling, such that the output mirrors the structure of the
+// sources. Modules are found by following imports from the package's
+// entrypoints. Imports of modules are rewritten to relative paths of their
+// outputs, including imports of aliases, and imports of the index modules of
+// other packages are rewritten to the names of those packages. Other files
+// that are imported, such as stylesheets or JSON, are copied. Dependencies
+// are left as imports, whether or not they are external when bundled.
+func Transpile(repo *Repository, opts TranspileOptions) error {
+	pkg := opts.Package
+	stderr := opts.stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	outDir := opts.OutDir
+	if outDir == "" {
+		outDir = path.Join(repo.OutDir, "transpile", pkg.Name)
+	}
+
+	if err := runCodegen(repo, stderr); err != nil {
+		return err
+	}
+
+	targetSpec := pkg.Target
+	target, engines, err := parseTarget(targetSpec)
+	if err != nil {
+		return err
+	}
+	if targetSpec == "" && pkg.Platform == PlatformNode {
+		engines = nodeEngines(repo)
+	}
+	sourcemap := SourceMapLinked
+	if pkg.SourceMap != "" {
+		sourcemap = pkg.SourceMap
+	}
+
+	indexOwners := make(map[string]string)
+	for _, other := range repo.Packages {
+		if other.Index != "" && other != pkg {
+			indexOwners[path.Join(repo.RootDir, other.Index)] = other.Name
+		}
+	}
+	outputExt := pkg.Format.Extension()
+	outputPath := func(filename string) string {
+		if ext := path.Ext(filename); transpiledExtensions[ext] {
+			return strings.TrimSuffix(filename, ext) + outputExt
+		}
+		return filename
+	}
+
+	var mx sync.Mutex
+	modules := make(map[string]bool)
+	copied := make(map[string]bool)
+	aliases := sortedAliases(repo)
+
+	// Leaves every import external, rewriting those of source files to the
+	// relative paths of their outputs, and records the source files found.
+	transpilePlugin := api.Plugin{
+		Name: "unirepo:transpile",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{
+				Filter: ".*",
+			}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Importer == "" || args.Namespace != "file" {
+					return api.OnResolveResult{}, nil
+				}
+				var resolved string
+				var ok bool
+				switch {
+				case strings.HasPrefix(args.Path, "./") || strings.HasPrefix(args.Path, "../") || path.IsAbs(args.Path):
+					resolved, ok = resolveFile(filepath.Join(args.ResolveDir, args.Path))
+					if !ok {
+						return api.OnResolveResult{}, fmt.Errorf("could not resolve %q", args.Path)
+					}
+				default:
+					var err error
+					resolved, ok, err = resolveAlias(repo, aliases, args.Path)
+					if err != nil {
+						return api.OnResolveResult{}, err
+					}
+				}
+				if !ok || isNodeModulesPath(resolved) {
+					return api.OnResolveResult{
+						Path:     args.Path,
+						External: true,
+					}, nil
+				}
+				resolved = filepath.ToSlash(resolved)
+				if owner, ok := indexOwners[resolved]; ok {
+					return api.OnResolveResult{
+						Path:     owner,
+						External: true,
+					}, nil
+				}
+
+				mx.Lock()
+				if transpiledExtensions[path.Ext(resolved)] {
+					if _, ok := modules[resolved]; !ok {
+						modules[resolved] = false
+					}
+				} else {
+					copied[resolved] = true
+				}
+				mx.Unlock()
+
+				rel, err := filepath.Rel(filepath.Dir(args.Importer), outputPath(resolved))
+				if err != nil {
+					return api.OnResolveResult{}, err
+				}
+				rel = filepath.ToSlash(rel)
+				if !strings.HasPrefix(rel, "../") {
+					rel = "./" + rel
+				}
+				return api.OnResolveResult{
+					Path:     rel,
+					External: true,
+				}, nil
+			})
+		},
+	}
+
+	plugins := []api.Plugin{transpilePlugin}
+	plugins = append(plugins, repo.Plugins...)
+	scriptPlugins, _, err := jsPlugins(repo)
+	if err != nil {
+		return err
+	}
+	plugins = append(plugins, scriptPlugins...)
+
+	buildOpts := api.BuildOptions{
+		AbsWorkingDir: repo.RootDir,
+		Outdir:        outDir,
+		Bundle:        true,
+		Platform:      pkg.Platform.esbuildPlatform(),
+		Format:        pkg.Format.esbuildFormat(),
+		OutExtensions: map[string]string{".js": outputExt},
+		Target:        target,
+		Engines:       engines,
+		LogLevel:      api.LogLevelSilent,
+		Sourcemap:     sourcemap.esbuildSourceMap(),
+		Plugins:       plugins,
+		Loader:        getLoaders(repo),
+		Define:        mergeDefines(repo, nil),
+		Banner:        pkg.Banner,
+		Footer:        pkg.Footer,
+	}
+	if err := applyJSX(repo, pkg.JSX, &buildOpts); err != nil {
+		return err
+	}
+
+	// Modules are found by transpiling the modules found so far, until no
+	// more are found, and then all of them are transpiled together.
+	for _, entrypoint := range pkg.entrypointPaths() {
+		modules[path.Join(repo.RootDir, entrypoint)] = false
+	}
+	for {
+		var pending []string
+		for module, done := range modules {
+			if !done {
+				pending = append(pending, module)
+				modules[module] = true
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		sort.Strings(pending)
+		discoverOpts := buildOpts
+		discoverOpts.EntryPoints = pending
+		discoverOpts.Outbase = commonDir(pending)
+		// Errors are reported by the final build.
+		api.Build(discoverOpts)
+	}
+
+	var sources []string
+	for module := range modules {
+		sources = append(sources, module)
+	}
+	sort.Strings(sources)
+	var files []string
+	for file := range copied {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	if err := os.RemoveAll(outDir); err != nil {
+		return err
+	}
+	buildOpts.EntryPoints = sources
+	buildOpts.Outbase = commonDir(append(append([]string{}, sources...), files...))
+	buildOpts.Write = true
+	buildOpts.LogLevel = api.LogLevelWarning
+	result := api.Build(buildOpts)
+	if len(result.Errors) > 0 {
+		return ErrBuildFailed
+	}
+	for _, file := range files {
+		rel := strings.TrimPrefix(file, buildOpts.Outbase+"/")
+		dst := path.Join(outDir, rel)
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(dst, file, 0644); err != nil {
+			return err
+		}
+	}
+
+	logEvent(stderr, "transpiled", logFields{
+		"package": pkg.Name,
+		"dir":     relativeToRoot(repo, outDir),
+		"modules": len(sources),
+		"copied":  len(files),
+	}, "transpiled %d modules of %s to %s", len(sources), pkg.Name, relativeToRoot(repo, outDir))
+	return nil
+}
@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UpgradeOptions struct {
+	// Dependencies to upgrade, each a name or name@version. Dependencies given
+	// without versions are upgraded to their latest versions.
+	Dependencies []string
+	// If set, prompts for an outdated dependency to upgrade instead.
+	Interactive bool
+	// Rebuilds the packages that import upgraded dependencies.
+	Build bool
+	// Runs the test files that import upgraded dependencies.
+	Test bool
+}
+
+type dependencyUpgrade struct {
+	name string
+	from string
+	to   string
+}
+
+// Upgrade changes the versions of dependencies in the repository config and
+// reinstalls dependencies. Dependencies upgraded to their latest versions
+// keep the form of their configured versions: exact versions stay exact, and
+// ^ and ~ ranges are kept, starting from the latest version.
+//
+// Configs that are scripts rather than uni.yml are not edited. Instead, the
+// versions to configure are printed, and nothing is installed.
+func Upgrade(w io.Writer, repo *Repository, opts UpgradeOptions) error {
+	specs := opts.Dependencies
+	if opts.Interactive {
+		picked, err := pickOutdatedDependency(w, repo)
+		if err != nil || picked == "" {
+			return err
+		}
+		specs = []string{picked}
+	}
+
+	var upgrades []dependencyUpgrade
+	for _, spec := range specs {
+		name, version := splitDependencySpec(spec)
+		dependency, ok := repo.Dependencies[name]
+		if !ok {
+			return fmt.Errorf("no such dependency: %q", name)
+		}
+		if version == "" {
+			latest, err := fetchLatestVersion(repo, name)
+			if err != nil {
+				return err
+			}
+			version, err = upgradedVersion(dependency.Version, latest)
+			if err != nil {
+				return fmt.Errorf("upgrading %s: %w", name, err)
+			}
+		}
+		if version == dependency.Version {
+			logEvent(w, "info", logFields{"dependency": name, "version": version}, "%s is already at %s", name, version)
+			continue
+		}
+		upgrades = append(upgrades, dependencyUpgrade{
+			name: name,
+			from: dependency.Version,
+			to:   version,
+		})
+	}
+	if len(upgrades) == 0 {
+		return nil
+	}
+
+	if path.Ext(repo.ConfigPath) != ".yml" {
+		fmt.Fprintf(w, "set in dependencies in %s:\n", path.Base(repo.ConfigPath))
+		for _, upgrade := range upgrades {
+			fmt.Fprintf(w, "  %q: %q\n", upgrade.name, upgrade.to)
+		}
+		return nil
+	}
+	original, err := ioutil.ReadFile(repo.ConfigPath)
+	if err != nil {
+		return err
+	}
+	updated := string(original)
+	for _, upgrade := range upgrades {
+		updated, err = setDependencyVersion(updated, upgrade.name, upgrade.to)
+		if err != nil {
+			return fmt.Errorf("%w: set its version in %s by hand", err, path.Base(repo.ConfigPath))
+		}
+	}
+	if err := ioutil.WriteFile(repo.ConfigPath, []byte(updated), 0644); err != nil {
+		return err
+	}
+	// Don't leave behind a config that no longer loads.
+	upgraded, err := LoadRepository(repo.RootDir)
+	if err != nil {
+		_ = ioutil.WriteFile(repo.ConfigPath, original, 0644)
+		return fmt.Errorf("upgrading dependencies: %w", err)
+	}
+	for _, upgrade := range upgrades {
+		logEvent(w, "upgraded", logFields{
+			"dependency": upgrade.name,
+			"from":       upgrade.from,
+			"to":         upgrade.to,
+		}, "upgraded %s from %s to %s", upgrade.name, upgrade.from, upgrade.to)
+	}
+
+	if err := InstallDependencies(upgraded, InstallDependenciesOptions{}); err != nil {
+		return err
+	}
+	if !opts.Build && !opts.Test {
+		return nil
+	}
+
+	// Source files that import upgraded dependencies, which affect the
+	// packages and tests that load them.
+	report, err := AnalyzeDependencies(upgraded)
+	if err != nil {
+		return err
+	}
+	importers := make(map[string]bool)
+	for _, upgrade := range upgrades {
+		for _, file := range report.Importers[upgrade.name] {
+			importers[path.Join(upgraded.RootDir, file)] = true
+		}
+	}
+
+	if opts.Build {
+		affected := make(map[string]*Package)
+		for name, pkg := range upgraded.Packages {
+			if anyChanged(importers, analyzeInputs(upgraded, pkg)) {
+				affected[name] = pkg
+			}
+		}
+		if len(affected) == 0 {
+			logEvent(w, "info", nil, "no packages import upgraded dependencies")
+		} else if err := BuildPackages(upgraded, affected, BuildOptions{Jobs: runtime.NumCPU()}); err != nil {
+			return err
+		}
+	}
+	if opts.Test {
+		tests, err := FindTests(upgraded, nil)
+		if err != nil {
+			return err
+		}
+		tests = testsLoading(upgraded, tests, importers)
+		if len(tests) == 0 {
+			logEvent(w, "info", nil, "no tests import upgraded dependencies")
+		} else if err := Test(upgraded, TestOptions{Paths: tests}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitDependencySpec splits name@version into its name and version, which
+// is empty if not given.
+func splitDependencySpec(spec string) (name string, version string) {
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// upgradedVersion returns the version to configure for a dependency upgraded
+// from its configured version to the latest version. Versions other than
+// exact versions and ^ and ~ ranges, such as tags or URLs, cannot be
+// upgraded automatically.
+func upgradedVersion(configured string, latest string) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(configured, "^") || strings.HasPrefix(configured, "~") {
+		prefix = configured[:1]
+	}
+	current, err := parseSemver(strings.TrimPrefix(configured, prefix))
+	if err != nil {
+		return "", fmt.Errorf("configured version %q is not a version or a ^ or ~ range; give the version to upgrade to, as in <dependency>@<version>", configured)
+	}
+	next, err := parseSemver(latest)
+	if err != nil {
+		return "", err
+	}
+	if next.Compare(current) <= 0 {
+		return configured, nil
+	}
+	return prefix + latest, nil
+}
+
+var (
+	dependenciesKeyPattern = regexp.MustCompile(`^dependencies:\s*(#.*)?$`)
+	// Matches an entry of a block mapping, capturing its indentation, key,
+	// separator, value, and any trailing comment.
+	mappingEntryPattern = regexp.MustCompile(`^(\s+)("(?:[^"\\]|\\.)*"|'[^']*'|[^\s"'#][^:#]*?)(\s*:\s*)("(?:[^"\\]|\\.)*"|'[^']*'|[^\s#](?:[^#]*[^\s#])?)(\s*(?:#.*)?)$`)
+	// Matches versions that need no quotes as YAML values.
+	plainVersionPattern = regexp.MustCompile(`^[0-9A-Za-z^~][0-9A-Za-z.+^~-]*$`)
+)
+
+// setDependencyVersion replaces the version of a dependency in the text of a
+// uni.yml file, preserving comments and the quoting of the version. Only
+// dependencies configured in a block mapping can be found.
+func setDependencyVersion(text string, name string, version string) (string, error) {
+	lines := strings.Split(text, "\n")
+	start := -1
+	for i, line := range lines {
+		if dependenciesKeyPattern.MatchString(line) {
+			start = i
+			break
+		}
+	}
+	if start >= 0 {
+		indent := ""
+		for i := start + 1; i < len(lines); i++ {
+			line := lines[i]
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			match := mappingEntryPattern.FindStringSubmatch(line)
+			if match == nil {
+				if !indentPattern.MatchString(line) {
+					break
+				}
+				continue
+			}
+			if indent == "" {
+				indent = match[1]
+			}
+			if match[1] != indent {
+				continue
+			}
+			key := match[2]
+			switch key[0] {
+			case '"':
+				key, _ = strconv.Unquote(key)
+			case '\'':
+				key = key[1 : len(key)-1]
+			}
+			if key != name {
+				continue
+			}
+			value := version
+			switch {
+			case strings.HasPrefix(match[4], "'") && !strings.Contains(version, "'"):
+				value = "'" + version + "'"
+			case strings.HasPrefix(match[4], `"`) || !plainVersionPattern.MatchString(version):
+				value = strconv.Quote(version)
+			}
+			lines[i] = match[1] + match[2] + match[3] + value + match[5]
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+	return "", fmt.Errorf("could not find %s in dependencies", name)
+}
+
+// fetchLatestVersion returns the version of a package tagged latest in the
+// registry.
+func fetchLatestVersion(repo *Repository, name string) (string, error) {
+	registry := strings.TrimSuffix(repo.Registry, "/")
+	req, err := http.NewRequest("GET", registry+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+	// Abbreviated metadata, which omits the details of each version.
+	req.Header.Set("Accept", "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s from registry: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s from registry: %s", name, resp.Status)
+	}
+	var metadata struct {
+		DistTags map[string]string `json:"dist-tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("fetching %s from registry: %w", name, err)
+	}
+	latest := metadata.DistTags["latest"]
+	if latest == "" {
+		return "", fmt.Errorf("%s has no latest version in registry", name)
+	}
+	return latest, nil
+}
+
+// Maximum number of concurrent requests to the registry.
+const registryConcurrency = 8
+
+// pickOutdatedDependency fetches the latest version of every dependency and
+// prompts for one of those that are outdated, returning its name, or the
+// empty string if none are outdated. Dependencies are outdated if their
+// installed versions, or configured versions if not installed, precede their
+// latest versions.
+func pickOutdatedDependency(w io.Writer, repo *Repository) (string, error) {
+	if !CanPick() {
+		return "", errors.New("--interactive requires a terminal")
+	}
+	names := DependencyNames(repo)
+	latest := make([]string, len(names))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, registryConcurrency)
+	for i, name := range names {
+		i, name := i, name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			version, err := fetchLatestVersion(repo, name)
+			if err != nil {
+				Warnf("%v", err)
+				return
+			}
+			latest[i] = version
+		}()
+	}
+	wg.Wait()
+
+	var items []PickerItem
+	for i, name := range names {
+		next, err := parseSemver(latest[i])
+		if err != nil {
+			continue
+		}
+		current := installedVersion(repo, name)
+		if current == "" {
+			current = strings.TrimLeft(repo.Dependencies[name].Version, "^~")
+		}
+		version, err := parseSemver(current)
+		if err != nil || next.Compare(version) <= 0 {
+			continue
+		}
+		items = append(items, PickerItem{
+			Label:  name,
+			Detail: current + " -> " + latest[i],
+			Value:  name,
+		})
+	}
+	if len(items) == 0 {
+		logEvent(w, "info", nil, "all dependencies are up to date")
+		return "", nil
+	}
+	return Pick("dependency", items)
+}
+
+// installedVersion returns the version of a package installed at the top of
+// node_modules, or the empty string if it is not installed.
+func installedVersion(repo *Repository, name string) string {
+	var metadata struct {
+		Version string `json:"version"`
+	}
+	if err := ReadJSON(path.Join(repo.RootDir, "node_modules", name, "package.json"), &metadata); err != nil {
+		if !os.IsNotExist(err) {
+			Warnf("reading version of %s: %v", name, err)
+		}
+		return ""
+	}
+	return metadata.Version
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceMapUpload configures where `uni build --upload-sourcemaps` sends
+// source maps. At most one destination may be set.
+type SourceMapUpload struct {
+	Sentry   *SentryUpload
+	Endpoint *EndpointUpload
+}
+
+type SentryUpload struct {
+	// Base URL of the Sentry server.
+	URL          string
+	Organization string
+	Project      string
+	// Name of the environment variable holding the auth token.
+	TokenEnv string
+}
+
+// EndpointUpload posts each file as a multipart form with "package",
+// "version", "name", and "file" fields.
+type EndpointUpload struct {
+	URL string
+	// Name of the environment variable holding a bearer token, if any.
+	TokenEnv string
+}
+
+const (
+	DefaultSentryURL      = "https://sentry.io/"
+	DefaultSentryTokenEnv = "SENTRY_AUTH_TOKEN"
+)
+
+// sourceMapUploadHook uploads source maps and the files they map to.
+type sourceMapUploadHook struct {
+	upload SourceMapUpload
+}
+
+func (hook *sourceMapUploadHook) Name() string {
+	return "uploading source maps"
+}
+
+func (hook *sourceMapUploadHook) Run(build *buildHookContext) error {
+	if build.Version == "" {
+		return errors.New("version is required to tag uploaded source maps")
+	}
+	files, err := sourceMapFiles(build.PackageDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(build.Stderr, "%s has no source maps to upload\n", build.Package.Name)
+		return nil
+	}
+	switch {
+	case hook.upload.Sentry != nil:
+		return uploadToSentry(hook.upload.Sentry, build, files)
+	case hook.upload.Endpoint != nil:
+		return uploadToEndpoint(hook.upload.Endpoint, build, files)
+	default:
+		return errors.New("no upload destination configured")
+	}
+}
+
+// sourceMapFiles returns paths, relative to dir, of all source maps and the
+// JavaScript files they belong to.
+func sourceMapFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(file, ".map") {
+			return err
+		}
+		for _, name := range []string{file, strings.TrimSuffix(file, ".map")} {
+			if _, err := os.Stat(name); err != nil {
+				continue
+			}
+			rel, err := filepath.Rel(dir, name)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+func uploadToSentry(cfg *SentryUpload, build *buildHookContext, files []string) error {
+	token := os.Getenv(cfg.TokenEnv)
+	if token == "" {
+		return fmt.Errorf("%s is not set", cfg.TokenEnv)
+	}
+	release := build.Package.Name + "@" + build.Version
+	base := strings.TrimSuffix(cfg.URL, "/") + "/api/0/organizations/" + url.PathEscape(cfg.Organization) + "/releases/"
+
+	body, err := json.Marshal(map[string]interface{}{
+		"version":  release,
+		"projects": []string{cfg.Project},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", base, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Releases and files that already exist conflict, such as when a build is
+	// uploaded again.
+	if err := doUploadRequest(req, token); err != nil && !isConflict(err) {
+		return fmt.Errorf("creating release %s: %w", release, err)
+	}
+
+	filesURL := base + url.PathEscape(release) + "/files/"
+	for _, file := range files {
+		// Sentry matches files by URL, with ~ standing for any origin.
+		err := postFile(filesURL, token, filepath.Join(build.PackageDir, filepath.FromSlash(file)), map[string]string{
+			"name": "~/" + file,
+		})
+		if err != nil && !isConflict(err) {
+			return fmt.Errorf("uploading %s: %w", file, err)
+		}
+	}
+	fmt.Fprintf(build.Stderr, "uploaded %d files to Sentry release %s\n", len(files), release)
+	return nil
+}
+
+func uploadToEndpoint(cfg *EndpointUpload, build *buildHookContext, files []string) error {
+	var token string
+	if cfg.TokenEnv != "" {
+		token = os.Getenv(cfg.TokenEnv)
+		if token == "" {
+			return fmt.Errorf("%s is not set", cfg.TokenEnv)
+		}
+	}
+	for _, file := range files {
+		err := postFile(cfg.URL, token, filepath.Join(build.PackageDir, filepath.FromSlash(file)), map[string]string{
+			"package": build.Package.Name,
+			"version": build.Version,
+			"name":    file,
+		})
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", file, err)
+		}
+	}
+	fmt.Fprintf(build.Stderr, "uploaded %d files to %s\n", len(files), cfg.URL)
+	return nil
+}
+
+// postFile posts a multipart form with the given fields and a "file" field
+// containing the file's contents.
+func postFile(url string, token string, filename string, fields map[string]string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return doUploadRequest(req, token)
+}
+
+func doUploadRequest(req *http.Request, token string) error {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &uploadStatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+	return nil
+}
+
+// uploadStatusError is an unsuccessful response to an upload request.
+type uploadStatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (err *uploadStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Status, err.Message)
+}
+
+// isConflict reports whether an upload failed because what it uploads
+// already exists.
+func isConflict(err error) bool {
+	var statusErr *uploadStatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// PackageVersion returns the current version of a package. This is the
+// version configured in uni.yml, if any, or else the greatest version among
+// git tags of the form <package-name>@<version>. Returns an empty string if
+// neither is present.
+func PackageVersion(repo *Repository, pkg *Package) (string, error) {
+	if pkg.Version != "" {
+		return pkg.Version, nil
+	}
+	v, ok, err := taggedVersion(repo, pkg)
+	if err != nil || !ok {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+// taggedVersion finds the greatest version that a package has been tagged
+// with in git.
+func taggedVersion(repo *Repository, pkg *Package) (v semver, ok bool, err error) {
+	prefix := pkg.Name + "@"
+	git := exec.Command("git", "tag", "--list", prefix+"*")
+	git.Dir = repo.RootDir
+	var stderr bytes.Buffer
+	git.Stderr = &stderr
+	out, err := git.Output()
+	if err != nil {
+		// Not being in a git repository is not an error.
+		if _, isExit := err.(*exec.ExitError); isExit {
+			return semver{}, false, nil
+		}
+		return semver{}, false, fmt.Errorf("listing git tags: %w", err)
+	}
+	for _, tag := range strings.Fields(string(out)) {
+		tagged, err := parseSemver(strings.TrimPrefix(tag, prefix))
+		if err != nil {
+			continue
+		}
+		if !ok || tagged.Compare(v) > 0 {
+			v = tagged
+			ok = true
+		}
+	}
+	return v, ok, nil
+}
+
+type BumpOptions struct {
+	// One of major, minor, patch, or prerelease.
+	Level string
+	// Identifier of prerelease versions, such as "beta".
+	Preid string
+	// Print the next version without tagging it.
+	NoTag bool
+	// Report the tag that would be created without creating it.
+	DryRun bool
+}
+
+// Bump computes the next version of a package from its latest tagged version
+// and tags HEAD with it. Packages without any tagged version start at 0.0.0.
+func Bump(repo *Repository, pkg *Package, opts BumpOptions) (string, error) {
+	if pkg.Version != "" {
+		return "", fmt.Errorf("version of %s is configured in %s, edit it there instead", pkg.Name, path.Base(repo.ConfigPath))
+	}
+	current, _, err := taggedVersion(repo, pkg)
+	if err != nil {
+		return "", err
+	}
+	next, err := current.Bump(opts.Level, opts.Preid)
+	if err != nil {
+		return "", err
+	}
+	version := next.String()
+	if opts.DryRun {
+		logEvent(os.Stderr, "plan", logFields{"package": pkg.Name, "version": version, "current": current.String()},
+			"would tag HEAD with %s@%s, after %s", pkg.Name, version, current)
+	}
+	if opts.NoTag || opts.DryRun {
+		return version, nil
+	}
+	git := exec.Command("git", "tag", pkg.Name+"@"+version)
+	git.Dir = repo.RootDir
+	git.Stdout = os.Stdout
+	git.Stderr = os.Stderr
+	if err := git.Run(); err != nil {
+		return "", fmt.Errorf("tagging: %w", err)
+	}
+	return version, nil
+}
+
+var (
+	uniVersionOnce sync.Once
+	uniVersionID   string
+)
+
+// uniVersion identifies the running build of uni: its module version, along
+// with a hash of its executable for development builds, which may differ
+// without their versions differing.
+func uniVersion() string {
+	uniVersionOnce.Do(func() {
+		uniVersionID = "(devel)"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+			uniVersionID = info.Main.Version
+		}
+		if uniVersionID != "(devel)" && !strings.HasSuffix(uniVersionID, "+dirty") {
+			return
+		}
+		if exe, err := os.Executable(); err == nil {
+			if hash, err := hashFile(exe); err == nil {
+				uniVersionID += " " + hash
+			}
+		}
+	})
+	return uniVersionID
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// checkDependencyVersions reports version skew in a package's build, which
+// would otherwise go unnoticed until the package is installed elsewhere:
+//
+//   - packages bundled in more than one version, such as when a dependency
+//     has its own copy of another dependency in a nested node_modules;
+//   - dependencies, bundled or external, whose installed versions are not in
+//     the ranges configured for them, as dependencies or as peer dependencies
+//     of the package.
+//
+// Bundled packages are reported with the chain of imports from an entrypoint
+// that led to them. Mismatches are warnings, or errors if strict is set.
+// Dependencies configured with versions that are not semver ranges, such as
+// tags or URLs, are not checked.
+func checkDependencyVersions(w io.Writer, repo *Repository, pkg *Package, metafilePath string, externals []string, strict bool) error {
+	metafile, err := readMetafile(metafilePath)
+	if err != nil {
+		return fmt.Errorf("reading metafile: %w", err)
+	}
+
+	// Map of each input to an input that imports it, for import chains.
+	importers := make(map[string]string)
+	for _, input := range sortedMetafileInputs(metafile) {
+		for _, imported := range metafile.Inputs[input].Imports {
+			if _, ok := importers[imported.Path]; !ok {
+				importers[imported.Path] = input
+			}
+		}
+	}
+	chain := func(input string) string {
+		files := []string{input}
+		seen := map[string]bool{input: true}
+		for file := importers[input]; file != "" && !seen[file]; file = importers[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+		return strings.Join(files, " -> ")
+	}
+
+	// Versions of each bundled package, by the directory it is installed in,
+	// with the first input loaded from that directory.
+	type bundledCopy struct {
+		dir     string
+		version string
+		input   string
+	}
+	bundled := make(map[string][]*bundledCopy)
+	copies := make(map[string]*bundledCopy)
+	for _, input := range sortedMetafileInputs(metafile) {
+		dir := nodeModulesPackageDir(input)
+		if dir == "" || copies[dir] != nil {
+			continue
+		}
+		copy := &bundledCopy{dir: dir, input: input}
+		var metadata struct {
+			Version string `json:"version"`
+		}
+		if err := ReadJSON(path.Join(repo.RootDir, dir, "package.json"), &metadata); err == nil {
+			copy.version = metadata.Version
+		}
+		copies[dir] = copy
+		name := nodeModulesPackageName(input)
+		bundled[name] = append(bundled[name], copy)
+	}
+
+	var messages []api.Message
+	names := make([]string, 0, len(bundled))
+	for name := range bundled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		versions := make(map[string]bool)
+		for _, copy := range bundled[name] {
+			versions[copy.version] = true
+		}
+		if len(versions) < 2 {
+			continue
+		}
+		var text strings.Builder
+		fmt.Fprintf(&text, "%s bundles %d versions of %s:", pkg.Name, len(versions), name)
+		for _, copy := range bundled[name] {
+			fmt.Fprintf(&text, "\n    %s from %s, imported by %s", copy.version, copy.dir, chain(copy.input))
+		}
+		messages = append(messages, api.Message{Text: text.String()})
+	}
+
+	// Installed versions of the dependencies of the build, which are the
+	// copies at the top of node_modules.
+	installed := make(map[string]string)
+	via := make(map[string]string)
+	for name, copies := range bundled {
+		for _, copy := range copies {
+			if copy.dir == path.Join("node_modules", name) {
+				installed[name] = copy.version
+				via[name] = ", imported by " + chain(copy.input)
+			}
+		}
+	}
+	for _, name := range externals {
+		var metadata struct {
+			Version string `json:"version"`
+		}
+		if err := ReadJSON(path.Join(repo.RootDir, "node_modules", name, "package.json"), &metadata); err == nil {
+			installed[name] = metadata.Version
+		}
+	}
+	names = names[:0]
+	for name := range installed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		version, err := parseSemver(installed[name])
+		if err != nil {
+			continue
+		}
+		var ranges []string
+		if dependency, ok := repo.Dependencies[name]; ok {
+			ranges = append(ranges, dependency.Version)
+		}
+		if peer, ok := pkg.PeerDependencies[name]; ok {
+			ranges = append(ranges, peer.Version)
+		}
+		for _, raw := range ranges {
+			r, err := parseSemverRange(raw)
+			if err != nil || r.Contains(version) {
+				continue
+			}
+			messages = append(messages, api.Message{
+				Text: fmt.Sprintf("%s requires %s %s, but %s is installed%s", pkg.Name, name, raw, installed[name], via[name]),
+			})
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	kind := "warning"
+	if strict {
+		kind = "error"
+	}
+	fprintMessages(w, messages, kind)
+	if strict {
+		return errors.New("dependency version conflicts found")
+	}
+	return nil
+}
+
+func sortedMetafileInputs(metafile *Metafile) []string {
+	inputs := make([]string, 0, len(metafile.Inputs))
+	for input := range metafile.Inputs {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	return inputs
+}
+
+// nodeModulesPackageDir returns the directory of the package containing a
+// file in the innermost node_modules directory of its path, or the empty
+// string if it is not in node_modules.
+func nodeModulesPackageDir(filename string) string {
+	filename = filepath.ToSlash(filename)
+	const marker = "node_modules/"
+	i := strings.LastIndex(filename, marker)
+	if i < 0 {
+		return ""
+	}
+	return filename[:i+len(marker)] + packageNameOf(filename[i+len(marker):])
+}
@@ -3,11 +3,14 @@ package internal
 import (
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/evanw/esbuild/pkg/api"
@@ -16,12 +19,87 @@ import (
 )
 
 type buildAndWatch struct {
-	Repository    *Repository
-	Package       *Package
-	Esbuild       api.BuildOptions // XXX smaller option set.
-	Types         bool
-	Watch         bool
+	Repository *Repository
+	Package    *Package
+	Esbuild    api.BuildOptions // XXX smaller option set.
+	// Additional builds to run alongside the main build, such as alternate
+	// module formats. Each runs its own plugins, followed by those of the main
+	// build, and shares watching and error handling with the main build.
+	ExtraEsbuild []api.BuildOptions
+	Types        bool
+	Watch        bool
+	// Type check in the background after each build. Diagnostics are reported
+	// without delaying process start.
+	TypeCheck     bool
 	CreateProcess func() process
+	// Processes run from the output of the build, each started, stopped, and
+	// restarted independently, instead of the single process made by
+	// CreateProcess. Without Watch, the first to exit stops the others.
+	Programs []buildProgram
+	// Files that the process made by CreateProcess runs. If set, the process is
+	// only restarted after a change if the contents of these files change.
+	Outputs []string
+	// Start replacements of running processes and wait for them to be ready
+	// before stopping the processes they replace. Processes must implement
+	// prestarter.
+	Prestart bool
+	// If set, processes that exit with an error in watch mode are restarted
+	// automatically after a delay, rather than waiting for a change.
+	CrashRestart *CrashRestartPolicy
+	// If positive, poll for changes at this interval instead of relying on
+	// filesystem notifications.
+	Poll time.Duration
+	// Glob patterns of paths to ignore in watch mode, in addition to those
+	// configured for the repository.
+	WatchIgnore []string
+	// Closing stops watching and kills the running process.
+	Stop <-chan struct{}
+	// Receiving forces a rebuild and restart, even if no files have changed.
+	Restart <-chan struct{}
+	// Like Restart, but messages of the rebuild are written to the request's
+	// writer, and the number of build errors is sent as a reply, such that
+	// callers may wait for a current build.
+	Sync <-chan syncRequest
+	// Files that are not build inputs, but still trigger a restart when they
+	// change in watch mode. They need not exist.
+	WatchFiles []string
+	// Called before the initial build and each rebuild, such as to generate
+	// code. If it fails, the build is treated as failed.
+	BeforeBuild func() error
+	// Plugins that supply the contents of loaded files. These run after
+	// plugins that only observe loads, such as for watching.
+	LoadPlugins []api.Plugin
+	// Workers bundled alongside the main build as extra builds. Workers that
+	// builds discover are bundled before the build completes, after which the
+	// other builds are rebuilt to refer to their bundles.
+	Workers *workerSet
+	// Called with the result of the main build after each build or rebuild,
+	// once the extra builds are done.
+	OnResult func(result api.BuildResult)
+	// Where to write diagnostics and lifecycle messages. If set, esbuild's own
+	// logging is replaced with equivalent output written here. Defaults to
+	// os.Stderr.
+	Stderr io.Writer
+}
+
+// syncRequest asks for an immediate rebuild. See buildAndWatch.Sync.
+type syncRequest struct {
+	Stderr io.Writer
+	Reply  chan<- int
+}
+
+// buildProgram is one of several processes run from the output of a shared
+// build. After a rebuild, a running program is restarted only if its outputs
+// changed, or if a restart is forced.
+type buildProgram struct {
+	// Paths of output files that the program loads.
+	Outputs       []string
+	CreateProcess func() process
+	// Where to write lifecycle messages. Defaults to the build's Stderr.
+	Stderr io.Writer
+	// Indexes of earlier programs that must be ready before this program is
+	// started.
+	DependsOn []int
 }
 
 type process interface {
@@ -30,6 +108,27 @@ type process interface {
 	Kill() error
 }
 
+// reloader is a process that can load rebuilt outputs while it runs, rather
+// than being restarted.
+type reloader interface {
+	Reload() error
+}
+
+// readier is a process that can tell when it is ready after starting.
+type readier interface {
+	// WaitReady waits until the process is ready, returning false if it
+	// cannot tell.
+	WaitReady() (bool, error)
+}
+
+// prestarter is a process that waits after starting until it is activated,
+// so that it can be started before the process it replaces is stopped.
+type prestarter interface {
+	process
+	Ready() <-chan error
+	Activate() error
+}
+
 func (opts buildAndWatch) Run() error {
 	if opts.Watch && opts.Types {
 		return errors.New("cannot build types with watch")
@@ -37,16 +136,83 @@ func (opts buildAndWatch) Run() error {
 
 	repo := opts.Repository
 
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	// Messages of synchronous rebuilds are redirected to their requesters.
+	syncStderr := &switchWriter{w: stderr}
+	if opts.Sync != nil {
+		stderr = syncStderr
+	}
+	// Messages are written by esbuild itself, unless redirected or formatted.
+	reportMessages := opts.Stderr != nil || logSettingsOf(stderr).Format == LogFormatJSON
+	report := func(result api.BuildResult) api.BuildResult {
+		if reportMessages {
+			fprintMessages(stderr, result.Warnings, "warning")
+			fprintMessages(stderr, result.Errors, "error")
+		}
+		fields := logFields{
+			"errors":   len(result.Errors),
+			"warnings": len(result.Warnings),
+		}
+		if opts.Package != nil {
+			fields["package"] = opts.Package.Name
+		}
+		logEvent(stderr, "built", fields, "")
+		return result
+	}
+
+	label := ""
+	var labelFields logFields
+	if opts.Package != nil {
+		label = opts.Package.Name
+		labelFields = logFields{"package": label}
+	}
+	// build runs a build, which is timed in verbose mode.
+	build := func(name string, f func() api.BuildResult) api.BuildResult {
+		sp := startSpan(stderr, LogLevelVerbose, "build", name, label, labelFields)
+		result := f()
+		if len(result.Errors) > 0 {
+			sp.Fail(result.Errors[0].Text)
+		}
+		sp.End()
+		return report(result)
+	}
+
 	plugins := append([]api.Plugin{}, opts.Esbuild.Plugins...)
+	if boundaries := boundariesPlugin(repo); boundaries != nil {
+		plugins = append(plugins, *boundaries)
+	}
+	if aliases := aliasesPlugin(repo); aliases != nil {
+		plugins = append(plugins, *aliases)
+	}
 
-	var watcher *fsnotify.Watcher
+	var watcher fileWatcher
+	var ignored *globSet
+	inputs := newStringSet()
+	// Directories of inputs, in which creating or removing files may change
+	// module resolution.
+	inputDirs := newStringSet()
+	hashes := newFileHashes()
+	// Matches inputs and outputs of code generators.
+	var generated *globSet
 	if opts.Watch {
 		var err error
-		watcher, err = fsnotify.NewWatcher()
+		ignored, err = newGlobSet(repo.RootDir, append(append([]string{}, repo.WatchIgnore...), opts.WatchIgnore...))
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		// Native watchers observe whole directories, which uses far fewer watch
+		// handles than watching each file and also notices new files that may
+		// resolve a previously failed import. Polling watchers observe only
+		// loaded files.
+		watcher, err = watchRepository(repo, opts.Poll)
+		if err != nil {
+			return err
 		}
 		defer watcher.Close()
+		watchDirs := opts.Poll <= 0
 
 		watchPlugin := api.Plugin{
 			Name: "unirepo:watch",
@@ -54,7 +220,18 @@ func (opts buildAndWatch) Run() error {
 				build.OnLoad(api.OnLoadOptions{
 					Filter: ".*",
 				}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
-					err := watcher.Add(args.Path)
+					if args.Namespace != "file" || ignored.Match(args.Path) {
+						return api.OnLoadResult{}, nil
+					}
+					inputs.Add(args.Path)
+					inputDirs.Add(filepath.Dir(args.Path))
+					hashes.Record(args.Path)
+					var err error
+					if watchDirs {
+						err = watchDir(watcher, filepath.Dir(args.Path))
+					} else {
+						err = watcher.Add(args.Path)
+					}
 					return api.OnLoadResult{}, err
 				})
 			},
@@ -62,22 +239,206 @@ func (opts buildAndWatch) Run() error {
 		plugins = append(plugins, watchPlugin)
 	}
 
+	// Must come last, since these load files that other plugins only observe.
+	plugins = append(plugins, opts.LoadPlugins...)
+	plugins = append(plugins, repo.Plugins...)
+	scriptPlugins, _, err := jsPlugins(repo)
+	if err != nil {
+		return err
+	}
+	plugins = append(plugins, scriptPlugins...)
+	plugins = append(plugins, cssModulesPlugin(repo))
+
 	esbuildOpts := opts.Esbuild
-	esbuildOpts.Plugins = plugins
+	esbuildOpts.Plugins = tracePlugins(stderr, label, plugins)
 	esbuildOpts.Incremental = opts.Watch
+	if reportMessages {
+		esbuildOpts.LogLevel = api.LogLevelSilent
+	}
+
+	configureExtra := func(extra api.BuildOptions) api.BuildOptions {
+		extra.Plugins = tracePlugins(stderr, label, append(append([]api.Plugin{}, extra.Plugins...), plugins...))
+		extra.Incremental = opts.Watch
+		extra.LogLevel = esbuildOpts.LogLevel
+		return extra
+	}
+	extraOpts := make([]api.BuildOptions, len(opts.ExtraEsbuild))
+	for i, extra := range opts.ExtraEsbuild {
+		extraOpts[i] = configureExtra(extra)
+	}
 
 	if opts.Watch {
+		for _, file := range opts.WatchFiles {
+			inputs.Add(file)
+			inputDirs.Add(filepath.Dir(file))
+			var err error
+			if opts.Poll <= 0 {
+				err = watchDir(watcher, filepath.Dir(file))
+			} else {
+				err = watcher.Add(file)
+			}
+			if err != nil {
+				return fmt.Errorf("watching %q: %w", file, err)
+			}
+		}
+		var err error
+		generated, err = newGlobSet(repo.RootDir, codegenPatterns(repo))
+		if err != nil {
+			return err
+		}
+		if opts.Poll > 0 {
+			files, err := globFiles(repo, codegenPatterns(repo))
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				if err := watcher.Add(file); err != nil {
+					return fmt.Errorf("watching %q: %w", file, err)
+				}
+			}
+		}
 		for _, entrypoint := range esbuildOpts.EntryPoints {
 			if !filepath.IsAbs(entrypoint) {
 				entrypoint = filepath.Join(repo.RootDir, entrypoint)
 			}
+			inputs.Add(entrypoint)
+			inputDirs.Add(filepath.Dir(entrypoint))
 			if err := watcher.Add(entrypoint); err != nil {
 				return fmt.Errorf("watching %q: %w", entrypoint, err)
 			}
 		}
 	}
 
-	result := api.Build(esbuildOpts)
+	// Code generators run before the initial build, and before rebuilds after
+	// their files change or if they last failed.
+	var codegenMx sync.Mutex
+	codegenPending := true
+	setCodegenPending := func(pending bool) bool {
+		codegenMx.Lock()
+		defer codegenMx.Unlock()
+		prev := codegenPending
+		codegenPending = pending
+		return prev
+	}
+
+	var beforeErr error
+	beforeBuild := func() {
+		beforeErr = nil
+		if setCodegenPending(false) {
+			beforeErr = runCodegen(repo, stderr)
+			if beforeErr != nil {
+				setCodegenPending(true)
+			} else if opts.Watch {
+				// Avoid rebuilding again when generated files are rewritten with
+				// unchanged content.
+				if outputs, err := codegenOutputs(repo); err == nil {
+					for _, output := range outputs {
+						hashes.Record(output)
+					}
+				}
+			}
+		}
+		if beforeErr == nil && opts.BeforeBuild != nil {
+			beforeErr = opts.BeforeBuild()
+		}
+		if beforeErr != nil {
+			logEvent(stderr, "error", nil, "%v", beforeErr)
+		}
+	}
+
+	beforeBuild()
+	if beforeErr != nil && !opts.Watch {
+		return beforeErr
+	}
+	var workersErr error
+	// takeWorkers adds builds of workers that are not yet bundled, reporting
+	// whether there were any.
+	takeWorkers := func() bool {
+		if opts.Workers == nil {
+			return false
+		}
+		builds, err := opts.Workers.Take()
+		if err != nil {
+			workersErr = err
+			logEvent(stderr, "error", nil, "%v", err)
+			return false
+		}
+		for _, extra := range builds {
+			extraOpts = append(extraOpts, configureExtra(extra))
+		}
+		return len(builds) > 0
+	}
+	takeWorkers()
+	result := build("esbuild build", func() api.BuildResult {
+		return api.Build(esbuildOpts)
+	})
+	var extraResults []api.BuildResult
+	// buildExtras runs the extra builds that have not yet run.
+	buildExtras := func() {
+		for len(extraResults) < len(extraOpts) {
+			extra := extraOpts[len(extraResults)]
+			extraResults = append(extraResults, build("esbuild build", func() api.BuildResult {
+				return api.Build(extra)
+			}))
+		}
+	}
+	// bundleWorkers builds the workers discovered by the last builds, and then
+	// rebuilds the others, whose references to the workers now refer to their
+	// bundles. Builds that are not incremental are run again instead.
+	bundleWorkers := func() {
+		buildExtras()
+		for takeWorkers() {
+			built := len(extraResults)
+			buildExtras()
+			if result.Rebuild != nil {
+				result = build("esbuild rebuild", result.Rebuild)
+			} else {
+				result = build("esbuild build", func() api.BuildResult {
+					return api.Build(esbuildOpts)
+				})
+			}
+			for i := 0; i < built; i++ {
+				if extraResults[i].Rebuild != nil {
+					extraResults[i] = build("esbuild rebuild", extraResults[i].Rebuild)
+				} else {
+					extra := extraOpts[i]
+					extraResults[i] = build("esbuild build", func() api.BuildResult {
+						return api.Build(extra)
+					})
+				}
+			}
+		}
+	}
+	bundleWorkers()
+	if opts.Watch {
+		watchMetrics.setWatchedFiles(label, inputs.Len())
+	}
+	if opts.OnResult != nil {
+		opts.OnResult(result)
+	}
+	buildErrors := func() int {
+		n := len(result.Errors)
+		for _, extraResult := range extraResults {
+			n += len(extraResult.Errors)
+		}
+		if beforeErr != nil {
+			n++
+		}
+		if workersErr != nil {
+			n++
+		}
+		return n
+	}
+	// Whether the last build failed, which the watcher reads concurrently.
+	var failing int32
+	setFailing := func() {
+		var n int32
+		if buildErrors() > 0 {
+			n = 1
+		}
+		atomic.StoreInt32(&failing, n)
+	}
+	setFailing()
 
 	if opts.Types && opts.Package.Index != "" {
 		args := []string{
@@ -88,98 +449,618 @@ func (opts buildAndWatch) Run() error {
 			args = append(args, "--external-imports="+external)
 		}
 		args = append(args,
-			"--out-file", path.Join(repo.OutDir, "dist", opts.Package.Name, "index.d.ts"),
+			"--out-file", path.Join(repo.OutDir, "dist", opts.Package.Name, typesFileName),
 			opts.Package.Index,
 		)
 		cmd := exec.Command(
 			path.Join(repo.RootDir, "node_modules", ".bin", "dts-bundle-generator"),
 			args...,
 		)
-		cmd.Stdout = os.Stderr // Intentional redirect.
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = stderr // Intentional redirect.
+		cmd.Stderr = stderr
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("bundling type declarations: %w", err)
 		}
 	}
 
+	var checker *backgroundChecker
+	if opts.TypeCheck {
+		checker = &backgroundChecker{repo: repo}
+		checker.Request()
+	}
+
 	g := new(errgroup.Group)
 
 	abort := make(chan struct{})
 	restart := make(chan struct{}, 1)
 
-	g.Go(func() error {
-		if len(result.Errors) > 0 {
+	// rebuildAll rebuilds everything, without stopping any process.
+	rebuildAll := func() {
+		logEvent(stderr, "rebuilding", nil, "")
+		sp := startSpan(stderr, LogLevelVerbose, "watch", "rebuild", label, labelFields)
+		defer sp.End()
+		start := time.Now()
+		beforeBuild()
+		workersErr = nil
+		result = build("esbuild rebuild", result.Rebuild)
+		for i, extraResult := range extraResults {
+			extraResults[i] = build("esbuild rebuild", extraResult.Rebuild)
+		}
+		bundleWorkers()
+		watchMetrics.recordRebuild(label, time.Since(start), buildErrors() > 0)
+		setFailing()
+		watchMetrics.setWatchedFiles(label, inputs.Len())
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+		if checker != nil {
+			checker.Request()
+		}
+	}
+
+	// rebuild kills the running process and rebuilds everything.
+	rebuild := func(proc process) {
+		if err := proc.Kill(); err != nil {
+			logEvent(stderr, "error", nil, "could not kill: %v", err)
+		}
+		rebuildAll()
+	}
+
+	// absorbRestarts waits for restarts to stop for the debounce period, in
+	// case many files are changing at once.
+	absorbRestarts := func() {
+		sp := startSpan(stderr, LogLevelVerbose, "watch", "debounce changes", label, labelFields)
+		defer sp.End()
+		for {
+			delay := time.After(repo.WatchDebounce)
+			select {
+			case <-restart:
+			case <-delay:
+				return
+			}
+		}
+	}
+
+	// processHash hashes the files that a process depends on, such that it
+	// needs restarting if the hash changes: its outputs, and files such as env
+	// files that affect it without being built.
+	processHash := func(outputs []string) string {
+		files := append(append([]string{}, outputs...), opts.WatchFiles...)
+		if opts.Workers != nil {
+			files = append(files, opts.Workers.Paths()...)
+		}
+		return hashFiles(files)
+	}
+
+	// reloadable reports whether a process may be reloaded rather than
+	// restarted. Files read only at startup, such as env files, must not have
+	// changed since it started.
+	reloadable := func(proc process, watchHash string) bool {
+		_, ok := proc.(reloader)
+		return ok && hashFiles(opts.WatchFiles) == watchHash
+	}
+
+	// reload reloads a process, reporting whether it succeeded.
+	reload := func(w io.Writer, proc process) bool {
+		if err := proc.(reloader).Reload(); err != nil {
+			logEvent(w, "error", nil, "could not reload: %v", err)
+			return false
+		}
+		logEvent(w, "reloaded", nil, "reloaded")
+		return true
+	}
+
+	// prestart starts a replacement process while the process it replaces
+	// keeps running, and waits for the replacement to be ready. Returns a
+	// channel that receives the result of the replacement, or false if it
+	// failed, in which case it is killed.
+	prestart := func(w io.Writer, proc process) (<-chan error, bool) {
+		if err := proc.Start(); err != nil {
+			var startErr *StartError
+			if errors.As(err, &startErr) {
+				err = startErr.Err
+			}
+			logEvent(w, "start-failed", nil, "could not start replacement: %v", err)
+			return nil, false
+		}
+		logStarted(w, proc)
+		done := make(chan error, 1)
+		go func() {
+			done <- proc.Wait()
+		}()
+		select {
+		case err := <-proc.(prestarter).Ready():
+			if err == nil {
+				logEvent(w, "replacement-ready", nil, "replacement ready, stopping previous process")
+				return done, true
+			}
+			logEvent(w, "not-ready", nil, "replacement %v; keeping previous process", err)
+		case <-abort:
+		case <-opts.Stop:
+		}
+		if err := proc.Kill(); err != nil {
+			logEvent(w, "error", nil, "could not kill: %v", err)
+		}
+		return nil, false
+	}
+
+	// activate lets a started process run, if it waits to be activated.
+	activate := func(w io.Writer, proc process) {
+		if p, ok := proc.(prestarter); ok {
+			if err := p.Activate(); err != nil {
+				logEvent(w, "error", nil, "could not activate: %v", err)
+			}
+		}
+	}
+
+	// awaitReady reports when a started process becomes ready, if it can
+	// tell. The returned channel is closed once the process is ready or never
+	// will be.
+	awaitReady := func(w io.Writer, proc process, started time.Time) <-chan struct{} {
+		ready := make(chan struct{})
+		r, ok := proc.(readier)
+		if !ok {
+			close(ready)
+			return ready
+		}
+		go func() {
+			defer close(ready)
+			probed, err := r.WaitReady()
+			switch {
+			case !probed:
+			case err != nil:
+				logEvent(w, "not-ready", nil, "%v", err)
+			default:
+				ms := time.Since(started).Milliseconds()
+				logEvent(w, "ready", logFields{"ms": ms}, "ready in %dms", ms)
+			}
+		}()
+		return ready
+	}
+
+	// scheduleCrashRestart decides whether a process that exited with err
+	// should be restarted automatically, reporting the decision. Returns a
+	// channel that receives when it is time to restart, or nil.
+	scheduleCrashRestart := func(w io.Writer, crashes *crashTracker, err error, started time.Time) <-chan time.Time {
+		delay, ok := crashes.crashed(err, started)
+		if !ok {
+			if crashes.policy != nil && err != nil {
+				logEvent(w, "crash-restart-exhausted", logFields{"attempts": crashes.crashes}, "crashed %d times in a row, waiting for changes", crashes.crashes+1)
+			}
+			return nil
+		}
+		fields := logFields{
+			"attempt": crashes.crashes,
+			"ms":      delay.Milliseconds(),
+		}
+		logEvent(w, "crash-restart", fields, "restarting in %v (attempt %d of %d)", delay, crashes.crashes, crashes.policy.MaxRetries)
+		return time.After(delay)
+	}
+
+	runProcess := func() error {
+		if buildErrors() > 0 {
 			if !opts.Watch {
-				return fmt.Errorf("build error")
+				return ErrBuildFailed
 			}
 		}
 
 		waitForChange := false
+		crashes := &crashTracker{policy: opts.CrashRestart}
+		// Receives when a crashed process is due to be restarted.
+		var crashRestart <-chan time.Time
+		// A replacement that was started and became ready while the process it
+		// replaced was running, along with its result and hashes.
+		var next process
+		var nextDone <-chan error
+		var nextOutputHash, nextWatchHash string
+		// Whether a process has been started, such that starting another is
+		// a restart.
+		startedBefore := false
 		for {
-			proc := opts.CreateProcess()
-			done := make(chan error, 1)
+			var proc process
+			var done <-chan error
+			running := false
+			outputHash := ""
+			watchHash := ""
+			started := time.Now()
 
-			buildOK := len(result.Errors) == 0
-			shouldStart := buildOK && !waitForChange
-			if shouldStart {
-				if err := proc.Start(); err != nil {
+			if next != nil {
+				proc, done, outputHash, watchHash = next, nextDone, nextOutputHash, nextWatchHash
+				next = nil
+				running = true
+				watchMetrics.recordRestart(label)
+				activate(stderr, proc)
+				awaitReady(stderr, proc, started)
+			} else {
+				proc = opts.CreateProcess()
+				buildOK := buildErrors() == 0
+				shouldStart := buildOK && !waitForChange
+				if shouldStart {
+					outputHash = processHash(opts.Outputs)
+					watchHash = hashFiles(opts.WatchFiles)
+					if err := proc.Start(); err != nil {
+						if !opts.Watch {
+							return err
+						}
+						logEvent(stderr, "start-failed", nil, "%v", err)
+						waitForChange = true
+					} else {
+						logStarted(stderr, proc)
+						if startedBefore {
+							watchMetrics.recordRestart(label)
+						}
+						startedBefore = true
+						running = true
+						waited := make(chan error, 1)
+						done = waited
+						go func() {
+							waited <- proc.Wait()
+						}()
+						activate(stderr, proc)
+						awaitReady(stderr, proc, started)
+					}
+				}
+			}
+		wait:
+			for {
+				select {
+				case <-abort:
+					if err := proc.Kill(); err != nil {
+						logEvent(stderr, "error", nil, "could not kill: %v", err)
+					}
+					return nil
+				case <-opts.Stop:
+					if err := proc.Kill(); err != nil {
+						logEvent(stderr, "error", nil, "could not kill: %v", err)
+					}
+					return nil
+				case <-restart:
+					absorbRestarts()
+					crashes.reset()
+					crashRestart = nil
+					if !running || len(opts.Outputs) == 0 {
+						rebuild(proc)
+						waitForChange = false
+						break wait
+					}
+					// Keep the process running if its output is unchanged, such as
+					// when only comments changed.
+					rebuildAll()
+					if buildErrors() == 0 && processHash(opts.Outputs) == outputHash {
+						logEvent(stderr, "unchanged", nil, "output unchanged, not restarting")
+						continue
+					}
+					if reloadable(proc, watchHash) {
+						if buildErrors() > 0 {
+							// Keep running the last successful build.
+							continue
+						}
+						if reload(stderr, proc) {
+							outputHash = processHash(opts.Outputs)
+							continue
+						}
+					}
+					if opts.Prestart {
+						if buildErrors() > 0 {
+							// Keep running the last successful build.
+							continue
+						}
+						replacement := opts.CreateProcess()
+						hash, watchHash := processHash(opts.Outputs), hashFiles(opts.WatchFiles)
+						replacementDone, ok := prestart(stderr, replacement)
+						if !ok {
+							continue
+						}
+						if err := proc.Kill(); err != nil {
+							logEvent(stderr, "error", nil, "could not kill: %v", err)
+						}
+						next, nextDone, nextOutputHash, nextWatchHash = replacement, replacementDone, hash, watchHash
+						waitForChange = false
+						break wait
+					}
+					if err := proc.Kill(); err != nil {
+						logEvent(stderr, "error", nil, "could not kill: %v", err)
+					}
+					waitForChange = false
+					break wait
+				case <-opts.Restart:
+					logEvent(stderr, "restarting", nil, "restarting")
+					crashes.reset()
+					crashRestart = nil
+					rebuild(proc)
+					waitForChange = false
+					break wait
+				case req := <-opts.Sync:
+					syncStderr.Set(req.Stderr)
+					crashes.reset()
+					crashRestart = nil
+					rebuild(proc)
+					syncStderr.Set(opts.Stderr)
+					waitForChange = false
+					req.Reply <- buildErrors()
+					break wait
+				case err := <-done:
 					if !opts.Watch {
 						return err
 					}
-					fmt.Fprintf(os.Stderr, "could not start: %v\n", err)
+					logExited(stderr, err)
+					crashRestart = scheduleCrashRestart(stderr, crashes, err, started)
 					waitForChange = true
-				} else {
-					go func() {
-						done <- proc.Wait()
-					}()
+					break wait
+				case <-crashRestart:
+					crashRestart = nil
+					waitForChange = false
+					break wait
+				}
+			}
+		}
+	}
+
+	runPrograms := func() error {
+		if buildErrors() > 0 && !opts.Watch {
+			return ErrBuildFailed
+		}
+
+		type programExit struct {
+			index int
+			proc  process
+			err   error
+		}
+		finished := make(chan struct{})
+		defer close(finished)
+		exits := make(chan programExit)
+		// Receives the indexes of crashed programs that are due to be
+		// restarted, along with how many times they had been started.
+		type crashRestartRequest struct {
+			index  int
+			starts int
+		}
+		crashRestarts := make(chan crashRestartRequest)
+		procs := make([]process, len(opts.Programs))
+		// Number of times each program has been started, and when it was last
+		// started.
+		starts := make([]int, len(opts.Programs))
+		startTimes := make([]time.Time, len(opts.Programs))
+		crashes := make([]*crashTracker, len(opts.Programs))
+		for i := range crashes {
+			crashes[i] = &crashTracker{policy: opts.CrashRestart}
+		}
+		// Hashes of each running program's outputs and watched files when it
+		// was started or last reloaded.
+		outputHashes := make([]string, len(opts.Programs))
+		watchHashes := make([]string, len(opts.Programs))
+		// Closed once each program's current process is ready or never will be.
+		readies := make([]<-chan struct{}, len(opts.Programs))
+
+		programStderr := func(i int) io.Writer {
+			if opts.Programs[i].Stderr != nil {
+				return opts.Programs[i].Stderr
+			}
+			return stderr
+		}
+		kill := func(i int) {
+			if procs[i] == nil {
+				return
+			}
+			if err := procs[i].Kill(); err != nil {
+				logEvent(programStderr(i), "error", nil, "could not kill: %v", err)
+			}
+			procs[i] = nil
+		}
+		killAll := func() {
+			for i := range procs {
+				kill(i)
+			}
+		}
+		// start starts a program, replacing it if it is running.
+		start := func(i int) error {
+			proc := opts.Programs[i].CreateProcess()
+			hash := processHash(opts.Programs[i].Outputs)
+			watchHash := hashFiles(opts.WatchFiles)
+			var done <-chan error
+			var started time.Time
+			if procs[i] != nil && opts.Prestart {
+				var ok bool
+				done, ok = prestart(programStderr(i), proc)
+				if !ok {
+					// Keep the running process.
+					return nil
+				}
+				kill(i)
+				started = time.Now()
+			} else {
+				kill(i)
+				started = time.Now()
+				if err := proc.Start(); err != nil {
+					if !opts.Watch {
+						return err
+					}
+					logEvent(programStderr(i), "start-failed", nil, "%v", err)
+					return nil
+				}
+				logStarted(programStderr(i), proc)
+				waited := make(chan error, 1)
+				done = waited
+				go func() {
+					waited <- proc.Wait()
+				}()
+			}
+			procs[i] = proc
+			if starts[i] > 0 {
+				watchMetrics.recordRestart(label)
+			}
+			starts[i]++
+			startTimes[i] = started
+			outputHashes[i] = hash
+			watchHashes[i] = watchHash
+			activate(programStderr(i), proc)
+			readies[i] = awaitReady(programStderr(i), proc, started)
+			go func() {
+				err := <-done
+				select {
+				case exits <- programExit{index: i, proc: proc, err: err}:
+				case <-finished:
 				}
+			}()
+			return nil
+		}
+		// startAll starts programs that are not running, and restarts those
+		// whose outputs have changed, unless the build failed.
+		startAll := func(force bool) error {
+			if buildErrors() > 0 {
+				return nil
+			}
+			for _, tracker := range crashes {
+				tracker.reset()
 			}
+			for i, program := range opts.Programs {
+				if procs[i] == nil {
+					// Wait for dependencies that are starting to be ready.
+					for _, j := range program.DependsOn {
+						if readies[j] == nil {
+							continue
+						}
+						select {
+						case <-readies[j]:
+						case <-abort:
+							return nil
+						case <-opts.Stop:
+							return nil
+						}
+					}
+				}
+				if procs[i] != nil && !force {
+					hash := processHash(program.Outputs)
+					if outputHashes[i] == hash {
+						continue
+					}
+					if reloadable(procs[i], watchHashes[i]) && reload(programStderr(i), procs[i]) {
+						outputHashes[i] = hash
+						continue
+					}
+				}
+				if err := start(i); err != nil {
+					killAll()
+					return err
+				}
+			}
+			return nil
+		}
+
+		if err := startAll(true); err != nil {
+			return err
+		}
+		for {
 			select {
 			case <-abort:
-				if err := proc.Kill(); err != nil {
-					fmt.Fprintf(os.Stderr, "could not kill: %v\n", err)
-				}
+				killAll()
+				return nil
+			case <-opts.Stop:
+				killAll()
 				return nil
 			case <-restart:
-			loop:
-				for {
-					// Absorb extra restarts for a little while in case many files are changing at once.
-					delay := time.After(50 * time.Millisecond)
-					select {
-					case <-restart:
-					case <-delay:
-						break loop
-					}
-				}
-				if err := proc.Kill(); err != nil {
-					fmt.Fprintf(os.Stderr, "could not kill: %v\n", err)
-				}
-				result = result.Rebuild()
-				waitForChange = false
-			case err := <-done:
-				if !opts.Watch {
+				absorbRestarts()
+				rebuildAll()
+				if err := startAll(false); err != nil {
 					return err
 				}
-				if err == nil {
-					fmt.Fprintf(os.Stderr, "process finished\n")
-				} else {
-					fmt.Fprintf(os.Stderr, "process failure: %v\n", err)
+			case <-opts.Restart:
+				logEvent(stderr, "restarting", nil, "restarting")
+				rebuildAll()
+				if err := startAll(true); err != nil {
+					return err
+				}
+			case req := <-opts.Sync:
+				syncStderr.Set(req.Stderr)
+				rebuildAll()
+				syncStderr.Set(opts.Stderr)
+				if err := startAll(false); err != nil {
+					return err
+				}
+				req.Reply <- buildErrors()
+			case exit := <-exits:
+				if procs[exit.index] != exit.proc {
+					// Killed in order to restart.
+					continue
+				}
+				procs[exit.index] = nil
+				if !opts.Watch {
+					killAll()
+					return exit.err
+				}
+				i := exit.index
+				logExited(programStderr(i), exit.err)
+				if after := scheduleCrashRestart(programStderr(i), crashes[i], exit.err, startTimes[i]); after != nil {
+					req := crashRestartRequest{index: i, starts: starts[i]}
+					go func() {
+						select {
+						case <-after:
+							select {
+							case crashRestarts <- req:
+							case <-finished:
+							}
+						case <-finished:
+						}
+					}()
+				}
+			case req := <-crashRestarts:
+				// Skip programs that have been started since, such as due to
+				// changes.
+				if procs[req.index] != nil || starts[req.index] != req.starts || buildErrors() > 0 {
+					continue
+				}
+				if err := start(req.index); err != nil {
+					return err
 				}
-				waitForChange = true
 			}
 		}
-	})
+	}
+
+	if len(opts.Programs) > 0 {
+		g.Go(runPrograms)
+	} else {
+		g.Go(runProcess)
+	}
 
 	if opts.Watch {
 		g.Go(func() error {
 			for {
 				select {
-				case _, ok := <-watcher.Events:
+				case <-opts.Stop:
+					return nil
+				case event, ok := <-watcher.Events():
 					if !ok {
 						return nil
 					}
+					if event.Op == fsnotify.Chmod || ignored.Match(event.Name) {
+						continue
+					}
+					if generated.Match(event.Name) {
+						if event.Op == fsnotify.Write && !hashes.Changed(event.Name) {
+							continue
+						}
+						setCodegenPending(true)
+						traceInstant(stderr, LogLevelTrace, "changed", "changed "+event.Name, logFields{"path": event.Name})
+						restart <- struct{}{}
+						continue
+					}
+					// Writes only matter to files that were loaded, and only if
+					// they change content, such as when regenerated by a hook.
+					// Creating, removing, or renaming files may change module
+					// resolution, but only beside loaded files, unless the last
+					// build failed, such as on an import that a new file may
+					// resolve. The watcher is shared with other builds, so most
+					// events are for files of other packages.
+					if event.Op == fsnotify.Write {
+						if !inputs.Has(event.Name) || !hashes.Changed(event.Name) {
+							continue
+						}
+					} else if !inputs.Has(event.Name) && !inputDirs.Has(filepath.Dir(event.Name)) && atomic.LoadInt32(&failing) == 0 {
+						continue
+					}
+					traceInstant(stderr, LogLevelTrace, "changed", "changed "+event.Name, logFields{"path": event.Name})
 					restart <- struct{}{}
-				case err, ok := <-watcher.Errors:
+				case err, ok := <-watcher.Errors():
 					if !ok {
 						close(abort)
 						return err
@@ -191,3 +1072,82 @@ func (opts buildAndWatch) Run() error {
 
 	return g.Wait()
 }
+
+func logStarted(w io.Writer, proc process) {
+	switch p := proc.(type) {
+	case *hotProcess:
+		proc = p.cmdProcess
+	case *prestartProcess:
+		proc = p.cmdProcess
+	}
+	if cmdProc, ok := proc.(*cmdProcess); ok {
+		logEvent(w, "started", logFields{"pid": cmdProc.cmd.Process.Pid}, "")
+	}
+}
+
+func logExited(w io.Writer, err error) {
+	if err == nil {
+		logEvent(w, "exited", logFields{"code": 0}, "process finished")
+		return
+	}
+	fields := logFields{"error": err.Error()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		fields["code"] = exitErr.ExitCode()
+	}
+	logEvent(w, "exited", fields, "process failure: %v", err)
+}
+
+// switchWriter is a writer whose destination may be changed concurrently with
+// writes.
+type switchWriter struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+func (sw *switchWriter) Set(w io.Writer) {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+	sw.w = w
+}
+
+// logSettings returns the settings of the current destination.
+func (sw *switchWriter) logSettings() logSettings {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+	return logSettingsOf(sw.w)
+}
+
+func (sw *switchWriter) Write(p []byte) (int, error) {
+	sw.mx.Lock()
+	defer sw.mx.Unlock()
+	return sw.w.Write(p)
+}
+
+// hashFiles returns a combined hash of the contents of files. Missing files
+// hash as empty.
+func hashFiles(filenames []string) string {
+	var hashes []string
+	for _, filename := range filenames {
+		hash, _ := hashFile(filename)
+		hashes = append(hashes, hash)
+	}
+	return strings.Join(hashes, ",")
+}
+
+// modulePlugins returns the plugins that resolve and load modules as they are
+// for builds, for bundling modules outside of buildAndWatch.
+func modulePlugins(repo *Repository) ([]api.Plugin, error) {
+	var plugins []api.Plugin
+	if aliases := aliasesPlugin(repo); aliases != nil {
+		plugins = append(plugins, *aliases)
+	}
+	plugins = append(plugins, repo.Plugins...)
+	scriptPlugins, _, err := jsPlugins(repo)
+	if err != nil {
+		return nil, err
+	}
+	plugins = append(plugins, scriptPlugins...)
+	plugins = append(plugins, cssModulesPlugin(repo))
+	return plugins, nil
+}
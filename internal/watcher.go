@@ -0,0 +1,453 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileWatcher reports changes to a set of files.
+type fileWatcher interface {
+	Add(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// newFileWatcher returns a watcher backed by native filesystem notifications,
+// or a polling watcher if pollInterval is positive. Polling works on
+// filesystems without notification support, such as NFS and some container
+// bind mounts.
+func newFileWatcher(pollInterval time.Duration) (fileWatcher, error) {
+	if pollInterval > 0 {
+		return newPollWatcher(pollInterval), nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &notifyWatcher{watcher}, nil
+}
+
+type notifyWatcher struct {
+	watcher *fsnotify.Watcher
+}
+
+func (w *notifyWatcher) Add(name string) error         { return w.watcher.Add(name) }
+func (w *notifyWatcher) Events() <-chan fsnotify.Event { return w.watcher.Events }
+func (w *notifyWatcher) Errors() <-chan error          { return w.watcher.Errors }
+func (w *notifyWatcher) Close() error                  { return w.watcher.Close() }
+
+type pollWatcher struct {
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+
+	mx    sync.Mutex
+	files map[string]pollState
+}
+
+type pollState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		files:  make(map[string]pollState),
+	}
+	go w.loop(interval)
+	return w
+}
+
+func statPollState(name string) (pollState, error) {
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return pollState{}, nil
+	}
+	if err != nil {
+		return pollState{}, err
+	}
+	return pollState{
+		exists:  true,
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}, nil
+}
+
+func (w *pollWatcher) Add(name string) error {
+	state, err := statPollState(name)
+	if err != nil {
+		return err
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if _, exists := w.files[name]; !exists {
+		w.files[name] = state
+	}
+	return nil
+}
+
+func (w *pollWatcher) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		for _, event := range w.poll() {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll compares the current state of all files to their last known state.
+func (w *pollWatcher) poll() []fsnotify.Event {
+	w.mx.Lock()
+	names := make([]string, 0, len(w.files))
+	for name := range w.files {
+		names = append(names, name)
+	}
+	w.mx.Unlock()
+
+	var events []fsnotify.Event
+	for _, name := range names {
+		state, err := statPollState(name)
+		if err != nil {
+			continue
+		}
+		w.mx.Lock()
+		prev := w.files[name]
+		w.files[name] = state
+		w.mx.Unlock()
+
+		var op fsnotify.Op
+		switch {
+		case prev.exists && !state.exists:
+			op = fsnotify.Remove
+		case !prev.exists && state.exists:
+			op = fsnotify.Create
+		case state.exists && (!state.modTime.Equal(prev.modTime) || state.size != prev.size):
+			op = fsnotify.Write
+		default:
+			continue
+		}
+		events = append(events, fsnotify.Event{Name: name, Op: op})
+	}
+	return events
+}
+
+func (w *pollWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *pollWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+// watchDir adds a directory to a watcher, which then reports changes to any
+// of the directory's immediate entries.
+func watchDir(watcher fileWatcher, dir string) error {
+	return watcher.Add(dir)
+}
+
+// watchTree recursively adds all directories under root that are not ignored.
+func watchTree(watcher fileWatcher, root string, ignored *globSet) error {
+	return filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if name != root && (ignored.Match(name) || ignored.Match(name+"/")) {
+			return filepath.SkipDir
+		}
+		return watchDir(watcher, name)
+	})
+}
+
+// Watchers shared by the builds of this process. See watchRepository.
+var (
+	sharedWatchersMx sync.Mutex
+	sharedWatchers   = make(map[sharedWatcherKey]*sharedWatcher)
+)
+
+type sharedWatcherKey struct {
+	root string
+	poll time.Duration
+}
+
+// watchRepository returns a watcher of a repository that shares a single
+// underlying watcher with the other builds of this process, so that watching
+// many packages uses one set of watch handles. Native watchers observe every
+// directory that is not ignored, including those created later. Polling
+// watchers observe only the files that are added. Every subscriber receives
+// every event, and must filter them by its own inputs. Closing the returned
+// watcher unsubscribes it, and the last subscriber to close closes the
+// underlying watcher.
+func watchRepository(repo *Repository, poll time.Duration) (fileWatcher, error) {
+	sharedWatchersMx.Lock()
+	defer sharedWatchersMx.Unlock()
+	key := sharedWatcherKey{root: repo.RootDir, poll: poll}
+	shared, ok := sharedWatchers[key]
+	if !ok {
+		var err error
+		shared, err = newSharedWatcher(repo, poll)
+		if err != nil {
+			return nil, err
+		}
+		shared.key = key
+		sharedWatchers[key] = shared
+	}
+	return shared.subscribe(), nil
+}
+
+type sharedWatcher struct {
+	key     sharedWatcherKey
+	watcher fileWatcher
+	ignored *globSet
+	// Whether directories are watched, rather than individual files.
+	watchDirs bool
+	done      chan struct{}
+
+	mx   sync.Mutex
+	subs map[*watchSubscription]struct{}
+}
+
+func newSharedWatcher(repo *Repository, poll time.Duration) (*sharedWatcher, error) {
+	ignored, err := newGlobSet(repo.RootDir, repo.WatchIgnore)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := newFileWatcher(poll)
+	if err != nil {
+		return nil, err
+	}
+	shared := &sharedWatcher{
+		watcher:   watcher,
+		ignored:   ignored,
+		watchDirs: poll <= 0,
+		done:      make(chan struct{}),
+		subs:      make(map[*watchSubscription]struct{}),
+	}
+	if shared.watchDirs {
+		if err := watchTree(watcher, repo.RootDir, ignored); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("watching %q: %w", repo.RootDir, err)
+		}
+	}
+	go shared.loop()
+	return shared, nil
+}
+
+func (shared *sharedWatcher) loop() {
+	for {
+		select {
+		case <-shared.done:
+			return
+		case event, ok := <-shared.watcher.Events():
+			if !ok {
+				return
+			}
+			if shared.watchDirs && event.Op&fsnotify.Create != 0 && !shared.ignored.Match(event.Name) {
+				if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+					if err := watchTree(shared.watcher, event.Name, shared.ignored); err != nil {
+						Warnf("watching %q: %v", event.Name, err)
+					}
+				}
+			}
+			shared.mx.Lock()
+			for sub := range shared.subs {
+				sub.deliver(event)
+			}
+			shared.mx.Unlock()
+		case err, ok := <-shared.watcher.Errors():
+			if !ok {
+				return
+			}
+			shared.mx.Lock()
+			for sub := range shared.subs {
+				select {
+				case sub.errors <- err:
+				default:
+				}
+			}
+			shared.mx.Unlock()
+		}
+	}
+}
+
+func (shared *sharedWatcher) subscribe() *watchSubscription {
+	sub := &watchSubscription{
+		shared: shared,
+		events: make(chan fsnotify.Event),
+		errors: make(chan error, 1),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	shared.mx.Lock()
+	shared.subs[sub] = struct{}{}
+	shared.mx.Unlock()
+	go sub.pump()
+	return sub
+}
+
+func (shared *sharedWatcher) unsubscribe(sub *watchSubscription) {
+	sharedWatchersMx.Lock()
+	defer sharedWatchersMx.Unlock()
+	shared.mx.Lock()
+	delete(shared.subs, sub)
+	empty := len(shared.subs) == 0
+	shared.mx.Unlock()
+	if empty {
+		delete(sharedWatchers, shared.key)
+		close(shared.done)
+		shared.watcher.Close()
+	}
+}
+
+// watchSubscription is a subscriber's view of a shared watcher. Events are
+// queued, so that a subscriber that is busy rebuilding does not hold up the
+// others.
+type watchSubscription struct {
+	shared *sharedWatcher
+	events chan fsnotify.Event
+	errors chan error
+	ready  chan struct{}
+	done   chan struct{}
+
+	mx    sync.Mutex
+	queue []fsnotify.Event
+
+	closeOnce sync.Once
+}
+
+func (sub *watchSubscription) Add(name string) error         { return sub.shared.watcher.Add(name) }
+func (sub *watchSubscription) Events() <-chan fsnotify.Event { return sub.events }
+func (sub *watchSubscription) Errors() <-chan error          { return sub.errors }
+
+func (sub *watchSubscription) Close() error {
+	sub.closeOnce.Do(func() {
+		sub.shared.unsubscribe(sub)
+		close(sub.done)
+	})
+	return nil
+}
+
+func (sub *watchSubscription) deliver(event fsnotify.Event) {
+	sub.mx.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.mx.Unlock()
+	select {
+	case sub.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pump sends queued events until closed, and then closes the events channel.
+func (sub *watchSubscription) pump() {
+	defer close(sub.events)
+	for {
+		sub.mx.Lock()
+		if len(sub.queue) == 0 {
+			sub.mx.Unlock()
+			select {
+			case <-sub.ready:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		event := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mx.Unlock()
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// stringSet is a set of strings that is safe for concurrent use.
+type stringSet struct {
+	mx    sync.Mutex
+	items map[string]struct{}
+}
+
+func newStringSet() *stringSet {
+	return &stringSet{
+		items: make(map[string]struct{}),
+	}
+}
+
+func (set *stringSet) Add(item string) {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	set.items[item] = struct{}{}
+}
+
+func (set *stringSet) Len() int {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	return len(set.items)
+}
+
+func (set *stringSet) Has(item string) bool {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	_, ok := set.items[item]
+	return ok
+}
+
+// fileHashes records content hashes of files, so that writes which do not
+// change a file's content can be ignored. Safe for concurrent use.
+type fileHashes struct {
+	mx     sync.Mutex
+	hashes map[string]string
+}
+
+func newFileHashes() *fileHashes {
+	return &fileHashes{
+		hashes: make(map[string]string),
+	}
+}
+
+// Record saves the current hash of a file.
+func (fh *fileHashes) Record(filename string) {
+	fh.Changed(filename)
+}
+
+// Changed reports whether a file's content differs from when it was last
+// recorded, and records its current hash. Unreadable files are considered
+// changed.
+func (fh *fileHashes) Changed(filename string) bool {
+	hash, err := hashFile(filename)
+	fh.mx.Lock()
+	defer fh.mx.Unlock()
+	if err != nil {
+		delete(fh.hashes, filename)
+		return true
+	}
+	prev, ok := fh.hashes[filename]
+	fh.hashes[filename] = hash
+	return !ok || prev != hash
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Matches references to sibling modules in the form recommended for workers,
+// such as `new Worker(new URL('./worker.ts', import.meta.url))`.
+var workerURLPattern = regexp.MustCompile(`new\s+URL\(\s*(['"])(\.\.?/[^'"]+)['"]\s*,\s*import\.meta\.url\s*\)`)
+
+// findWorkers returns the absolute paths of modules referenced by
+// `new URL(<relative path>, import.meta.url)` in the source files loaded by
+// the given entrypoints or by workers in turn, along with the paths of all of
+// those files. Builds discover workers as they load files instead. See
+// workerSet.
+func findWorkers(repo *Repository, entrypoints []string, opts api.BuildOptions) (workers []string, inputs []string) {
+	seen := make(map[string]bool)
+	queue := entrypoints
+	for len(queue) > 0 {
+		loaded := analyzeEntrypoints(repo, queue, opts)
+		inputs = append(inputs, loaded...)
+		queue = nil
+		for _, input := range loaded {
+			if isNodeModulesPath(input) {
+				continue
+			}
+			for _, worker := range workerReferences(input) {
+				if !seen[worker] {
+					seen[worker] = true
+					workers = append(workers, worker)
+					queue = append(queue, worker)
+				}
+			}
+		}
+	}
+	return workers, inputs
+}
+
+// workerReferences returns the paths of existing modules that a source file
+// references by URL.
+func workerReferences(filename string) []string {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	var workers []string
+	for _, match := range workerURLPattern.FindAllStringSubmatch(string(bs), -1) {
+		if worker, ok := resolveFile(filepath.Join(filepath.Dir(filename), match[2])); ok {
+			workers = append(workers, worker)
+		}
+	}
+	return workers
+}
+
+// workerSet is the set of workers of a build, each bundled separately into
+// dir with the given extension. Besides those added explicitly, workers are
+// discovered as the build loads files that reference them, and are bundled by
+// the next build. See buildAndWatch.Workers.
+type workerSet struct {
+	dir string
+	ext string
+	// Returns the options to bundle a worker to the named file in dir.
+	options func(worker string, output string) api.BuildOptions
+
+	mx sync.Mutex
+	// Map of the paths of workers to the names of their bundles.
+	outputs map[string]string
+	// Map of the names of output files to the workers they belong to, or to
+	// the empty string if reserved for other outputs.
+	owners map[string]string
+	// Workers that are not yet bundled.
+	pending []string
+}
+
+func newWorkerSet(dir string, ext string, reserved []string, options func(worker string, output string) api.BuildOptions) *workerSet {
+	set := &workerSet{
+		dir:     dir,
+		ext:     ext,
+		options: options,
+		outputs: make(map[string]string),
+		owners:  make(map[string]string),
+	}
+	for _, name := range reserved {
+		set.owners[name] = ""
+	}
+	return set
+}
+
+// Add adds a worker to be bundled by the next build.
+func (set *workerSet) Add(worker string) {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	set.add(worker)
+}
+
+func (set *workerSet) add(worker string) {
+	if _, ok := set.outputs[worker]; ok {
+		return
+	}
+	for _, pending := range set.pending {
+		if pending == worker {
+			return
+		}
+	}
+	set.pending = append(set.pending, worker)
+}
+
+// Output returns the name of the bundle of a worker, if it has been taken.
+func (set *workerSet) Output(worker string) (string, bool) {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	output, ok := set.outputs[worker]
+	if !ok {
+		set.add(worker)
+	}
+	return output, ok
+}
+
+// Take assigns bundle names to the pending workers, and returns the options to
+// bundle them.
+func (set *workerSet) Take() ([]api.BuildOptions, error) {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	var builds []api.BuildOptions
+	for _, worker := range set.pending {
+		name := strings.TrimSuffix(filepath.Base(worker), filepath.Ext(worker)) + set.ext
+		if owner, taken := set.owners[name]; taken {
+			set.pending = nil
+			if owner == "" {
+				return nil, fmt.Errorf("worker %s conflicts with output file %s", worker, name)
+			}
+			return nil, fmt.Errorf("workers %s and %s have the same output name %s", owner, worker, name)
+		}
+		set.owners[name] = worker
+		set.outputs[worker] = name
+		builds = append(builds, set.options(worker, name))
+	}
+	set.pending = nil
+	return builds, nil
+}
+
+// Outputs returns a map of the paths of bundled workers to the names of their
+// bundles.
+func (set *workerSet) Outputs() map[string]string {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	outputs := make(map[string]string, len(set.outputs))
+	for worker, name := range set.outputs {
+		outputs[worker] = name
+	}
+	return outputs
+}
+
+// Paths returns the paths of the bundles of workers, sorted.
+func (set *workerSet) Paths() []string {
+	set.mx.Lock()
+	defer set.mx.Unlock()
+	paths := make([]string, 0, len(set.outputs))
+	for _, name := range set.outputs {
+		paths = append(paths, filepath.Join(set.dir, name))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// workersPlugin rewrites worker URLs to refer to worker bundles instead of
+// their sources. For CommonJS output, where import.meta.url is unavailable,
+// the URL is derived from __dirname instead. References to workers that are
+// not yet bundled are left as they are, and the workers are added to the set,
+// so that the next build bundles them.
+func workersPlugin(set *workerSet, format api.Format) api.Plugin {
+	return api.Plugin{
+		Name: "unirepo:workers",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(api.OnLoadOptions{
+				Filter:    `\.[jt]sx?$`,
+				Namespace: "file",
+			}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				if isNodeModulesPath(args.Path) {
+					return api.OnLoadResult{}, nil
+				}
+				bs, err := ioutil.ReadFile(args.Path)
+				if err != nil {
+					// Let esbuild report unreadable files.
+					return api.OnLoadResult{}, nil
+				}
+				if !bytes.Contains(bs, []byte("import.meta.url")) {
+					return api.OnLoadResult{}, nil
+				}
+				dir := filepath.Dir(args.Path)
+				changed := false
+				contents := workerURLPattern.ReplaceAllStringFunc(string(bs), func(match string) string {
+					rel := workerURLPattern.FindStringSubmatch(match)[2]
+					worker, ok := resolveFile(filepath.Join(dir, rel))
+					if !ok {
+						return match
+					}
+					output, ok := set.Output(worker)
+					if !ok {
+						return match
+					}
+					changed = true
+					if format == api.FormatCommonJS {
+						return fmt.Sprintf(`require("url").pathToFileURL(require("path").join(__dirname, %s))`, strconv.Quote(output))
+					}
+					return fmt.Sprintf(`new URL(%s, import.meta.url)`, strconv.Quote("./"+output))
+				})
+				if !changed {
+					return api.OnLoadResult{}, nil
+				}
+				return api.OnLoadResult{
+					Contents:   &contents,
+					ResolveDir: dir,
+					Loader:     loaderForExtension(filepath.Ext(args.Path)),
+				}, nil
+			})
+		},
+	}
+}
+
+func loaderForExtension(ext string) api.Loader {
+	switch ext {
+	case ".ts":
+		return api.LoaderTS
+	case ".tsx":
+		return api.LoaderTSX
+	case ".jsx":
+		return api.LoaderJSX
+	default:
+		return api.LoaderJS
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Candidate entrypoints of workspace packages without a "source" field in
+// their package.json, relative to the package directory, in order of
+// precedence.
+var workspaceIndexCandidates = []string{
+	"src/index.ts",
+	"src/index.tsx",
+	"index.ts",
+	"index.tsx",
+	"src/index.js",
+	"index.js",
+}
+
+// workspaceManifest is the subset of a workspace package's package.json that
+// configures the package.
+type workspaceManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Private     bool   `json:"private"`
+	// Scoped packages are public only if configured for public access.
+	PublishConfig struct {
+		Access string `json:"access"`
+	} `json:"publishConfig"`
+	// Path of the package's index module, by the convention of other bundlers.
+	Source string `json:"source"`
+}
+
+// workspaces is the result of discovering packages from workspace globs.
+type workspaces struct {
+	// Globs configured in the root package.json, if any, rather than in
+	// pnpm-workspace.yaml.
+	PackageJSONGlobs []string
+	// Map of names of packages that are not already configured to their
+	// configuration.
+	Packages map[string]PackageConfig
+	// Names of all workspace packages, including those already configured.
+	Names []string
+	// Map of names of all workspace packages to their directories, relative
+	// to the repository root.
+	Dirs map[string]string
+	// Files that packages were discovered from.
+	Manifests []string
+}
+
+// discoverWorkspaces finds packages matched by the workspaces globs of the
+// root package.json or pnpm-workspace.yaml, configuring each from its own
+// package.json. Packages that are already configured are skipped.
+func discoverWorkspaces(rootDir string, configured map[string]PackageConfig) (*workspaces, error) {
+	ws := &workspaces{
+		Packages: make(map[string]PackageConfig),
+		Dirs:     make(map[string]string),
+	}
+	var globs []string
+
+	rootManifest := path.Join(rootDir, "package.json")
+	var root struct {
+		Workspaces interface{} `json:"workspaces"`
+	}
+	if err := ReadJSON(rootManifest, &root); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	switch w := root.Workspaces.(type) {
+	case nil:
+	case []interface{}:
+		globs = append(globs, stringsOf(w)...)
+	case map[string]interface{}:
+		// Yarn's form, which may also configure hoisting.
+		if packages, ok := w["packages"].([]interface{}); ok {
+			globs = append(globs, stringsOf(packages)...)
+		}
+	default:
+		return nil, errors.New("package.json has invalid workspaces")
+	}
+	if len(globs) > 0 {
+		ws.PackageJSONGlobs = globs
+		ws.Manifests = append(ws.Manifests, rootManifest)
+	}
+
+	pnpmWorkspace := path.Join(rootDir, "pnpm-workspace.yaml")
+	bs, err := ioutil.ReadFile(pnpmWorkspace)
+	if err == nil {
+		var pnpm struct {
+			Packages []string `yaml:"packages"`
+		}
+		if err := yaml.Unmarshal(bs, &pnpm); err != nil {
+			return nil, fmt.Errorf("reading pnpm-workspace.yaml: %w", err)
+		}
+		globs = append(globs, pnpm.Packages...)
+		ws.Manifests = append(ws.Manifests, pnpmWorkspace)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(globs) == 0 {
+		return nil, errors.New("no workspaces found in package.json or pnpm-workspace.yaml")
+	}
+	dirs, err := matchWorkspaceDirs(rootDir, globs)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		manifestPath := path.Join(rootDir, dir, "package.json")
+		var manifest workspaceManifest
+		if err := ReadJSON(manifestPath, &manifest); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", manifestPath, err)
+		}
+		if manifest.Name == "" {
+			return nil, fmt.Errorf("workspace %s has no name in package.json", dir)
+		}
+		ws.Manifests = append(ws.Manifests, manifestPath)
+		ws.Names = append(ws.Names, manifest.Name)
+		ws.Dirs[manifest.Name] = dir
+		if _, ok := configured[manifest.Name]; ok {
+			continue
+		}
+		if _, ok := ws.Packages[manifest.Name]; ok {
+			return nil, fmt.Errorf("workspace %s has the same name as another workspace: %q", dir, manifest.Name)
+		}
+		index := manifest.Source
+		if index == "" {
+			for _, candidate := range workspaceIndexCandidates {
+				if _, err := os.Stat(path.Join(rootDir, dir, candidate)); err == nil {
+					index = candidate
+					break
+				}
+			}
+		}
+		if index == "" {
+			return nil, fmt.Errorf("workspace %s has no source field in package.json or index module", dir)
+		}
+		ws.Packages[manifest.Name] = PackageConfig{
+			Public:      !manifest.Private && (manifest.PublishConfig.Access == "public" || !strings.HasPrefix(manifest.Name, "@")),
+			Description: manifest.Description,
+			Index:       path.Join(dir, index),
+			Version:     manifest.Version,
+		}
+	}
+	return ws, nil
+}
+
+// matchWorkspaceDirs returns the sorted paths, relative to rootDir, of the
+// directories with a package.json that are matched by globs. Globs prefixed
+// by "!" exclude directories.
+func matchWorkspaceDirs(rootDir string, globs []string) ([]string, error) {
+	var include, exclude []string
+	for _, glob := range globs {
+		glob = strings.TrimSuffix(strings.TrimPrefix(glob, "./"), "/")
+		if strings.HasPrefix(glob, "!") {
+			exclude = append(exclude, strings.TrimPrefix(strings.TrimPrefix(glob, "!"), "./"))
+		} else {
+			include = append(include, glob)
+		}
+	}
+	included, err := newGlobSet(rootDir, include)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := newGlobSet(rootDir, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	err = filepath.Walk(rootDir, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if filename == rootDir {
+			return nil
+		}
+		name := fi.Name()
+		if name == "node_modules" || strings.HasPrefix(name, ".") || filename == path.Join(rootDir, "out") {
+			return filepath.SkipDir
+		}
+		if !included.Match(filename) || excluded.Match(filename) {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(filename, "package.json")); err == nil {
+			rel, err := filepath.Rel(rootDir, filename)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+func stringsOf(values []interface{}) []string {
+	var strs []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,139 @@
+// Package uni lets Go programs, such as a platform team's own tooling, build
+// and run the packages of a uni repository without running the uni CLI.
+//
+// Builds and runs may be extended with esbuild plugins and command wrappers
+// set on the Repository:
+//
+//	repo, err := uni.LoadRepository(".")
+//	if err != nil {
+//		return err
+//	}
+//	repo.Plugins = append(repo.Plugins, myPlugin)
+//	repo.WrapCommand = func(cmd *exec.Cmd) error {
+//		cmd.Env = append(cmd.Env, "TRACING=1")
+//		return nil
+//	}
+//	return uni.Build(repo, nil, uni.DefaultBuildOptions())
+//
+// Options have the same meaning as the corresponding flags of the CLI, and
+// output is logged to stderr in the same way.
+package uni
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/deref/uni/internal"
+)
+
+// Repository is a loaded uni.yml and the packages it configures. Its Plugins
+// and WrapCommand fields extend every build and run.
+type Repository = internal.Repository
+
+type Package = internal.Package
+
+type BuildOptions = internal.BuildOptions
+
+type RunOptions = internal.RunOptions
+
+type TestOptions = internal.TestOptions
+
+type ExecOptions = internal.ExecOptions
+
+type ServeOptions = internal.ServeOptions
+
+type RunTarget = internal.RunTarget
+
+// Option values, which are parsed from the names accepted by the CLI.
+type (
+	BuildPreset = internal.BuildPreset
+	SourceMap   = internal.SourceMap
+	Runtime     = internal.Runtime
+)
+
+var (
+	ParseBuildPreset = internal.ParseBuildPreset
+	ParseSourceMap   = internal.ParseSourceMap
+	ParseRuntime     = internal.ParseRuntime
+)
+
+// ErrBuildFailed is returned when a build has errors, which have already
+// been logged.
+var ErrBuildFailed = internal.ErrBuildFailed
+
+// LoadRepository loads the configuration of the repository containing dir.
+func LoadRepository(dir string) (*Repository, error) {
+	return internal.LoadRepository(dir)
+}
+
+// DefaultBuildOptions returns the options of `uni build` without flags.
+func DefaultBuildOptions() BuildOptions {
+	return BuildOptions{
+		Jobs: runtime.NumCPU(),
+	}
+}
+
+// Build builds the named packages into the dist directory, along with the
+// packages they depend on, or all packages if none are named.
+func Build(repo *Repository, names []string, opts BuildOptions) error {
+	packages := repo.Packages
+	if len(names) > 0 {
+		packages = make(map[string]*Package, len(names))
+		for _, name := range names {
+			pkg, ok := repo.Packages[name]
+			if !ok {
+				return fmt.Errorf("no such package: %q", name)
+			}
+			packages[name] = pkg
+		}
+	}
+	return internal.BuildPackages(repo, packages, opts)
+}
+
+// DefaultRunOptions returns the options of `uni run` without flags, which
+// stop and restart programs as configured for the repository.
+func DefaultRunOptions(repo *Repository) RunOptions {
+	return RunOptions{
+		Shutdown:     repo.Shutdown,
+		Restart:      repo.Restart,
+		CrashRestart: repo.CrashRestart,
+		PortConflict: repo.PortConflict,
+	}
+}
+
+// Run bundles and runs a program, given by the absolute path of its
+// entrypoint, or configured run targets. Unless watching, it returns once the
+// program exits. See ExitCode.
+func Run(repo *Repository, opts RunOptions) error {
+	return internal.Run(repo, opts)
+}
+
+// ResolveRunTargets looks up run targets and groups of targets by name.
+func ResolveRunTargets(repo *Repository, names []string) ([]*RunTarget, error) {
+	return internal.ResolveRunTargets(repo, names)
+}
+
+// Test runs test files, each bundled and run as a program.
+func Test(repo *Repository, opts TestOptions) error {
+	return internal.Test(repo, opts)
+}
+
+// Exec runs a command with a built package resolvable, as `uni exec` does.
+func Exec(repo *Repository, opts ExecOptions) error {
+	return internal.Exec(repo, opts)
+}
+
+// Serve bundles a browser entrypoint and serves it with live reload.
+func Serve(repo *Repository, opts ServeOptions) error {
+	return internal.Serve(repo, opts)
+}
+
+// ExitCode returns the exit status that `uni run` would exit with, given an
+// error returned by Run: that of the program if it failed, or else one that
+// describes how uni failed.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return internal.RunExitCode(err)
+}
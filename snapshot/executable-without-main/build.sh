@@ -0,0 +1,8 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+uni clean
+uni build
+
+./out/dist/script/script world
@@ -0,0 +1,14 @@
+var __defProp = Object.defineProperty;
+var __markAsModule = (target) => __defProp(target, "__esModule", {value: true});
+var __export = (target, all) => {
+  for (var name in all)
+    __defProp(target, name, {get: all[name], enumerable: true});
+};
+
+// index.ts
+__markAsModule(exports);
+__export(exports, {
+  greeting: () => greeting
+});
+var greeting = "hello";
+//# sourceMappingURL=index.js.map
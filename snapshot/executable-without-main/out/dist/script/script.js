@@ -0,0 +1,6 @@
+// index.ts
+var greeting = "hello";
+
+// script.ts
+console.log(greeting, process.argv.slice(2).join(" "));
+//# sourceMappingURL=script.js.map
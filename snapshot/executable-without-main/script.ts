@@ -0,0 +1,4 @@
+import { greeting } from './index';
+
+// Runs when loaded, rather than exporting a main function.
+console.log(greeting, process.argv.slice(2).join(' '));
@@ -0,0 +1,9 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+(
+  set +e
+  uni build --preset executable
+  echo "exit code expected=1 actual=$?"
+)
@@ -0,0 +1,3 @@
+export const main = () => {
+  console.log('hello');
+};
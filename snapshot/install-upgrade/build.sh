@@ -0,0 +1,31 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+# Installs with a stand-in for npm. See bin/npm.
+export PATH="$PWD/bin:$PATH"
+
+# Restore the config, which uni upgrade edits.
+cat > uni.yml <<'YAML'
+dependencies:
+  left-pad: ^1.1.0
+  is-odd: "3.0.0" # Pinned.
+YAML
+rm -rf node_modules package-lock.json
+
+(
+  set +e
+  uni install --frozen
+  echo "exit code expected=1 actual=$?"
+)
+uni install
+uni install --frozen
+
+uni upgrade left-pad@^1.3.0 is-odd@3.0.1
+uni upgrade left-pad@^1.3.0
+
+(
+  set +e
+  uni upgrade right-pad@1.0.0
+  echo "exit code expected=1 actual=$?"
+)
@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+uni clean
+
+# Published to out/run/<hash>, which is printed.
+uni run --build-only --runtime deno ./index.ts
+
+(
+  set +e
+  uni run --build-only --runtime bogus ./index.ts
+  echo "exit code expected=125 actual=$?"
+)
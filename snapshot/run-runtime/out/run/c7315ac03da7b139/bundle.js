@@ -0,0 +1,14 @@
+import { createRequire as __uniCreateRequire } from "node:module";
+import { fileURLToPath as __uniFileURLToPath } from "node:url";
+import { dirname as __uniDirname } from "node:path";
+const require = __uniCreateRequire(import.meta.url);
+const __filename = __uniFileURLToPath(import.meta.url);
+const __dirname = __uniDirname(__filename);
+// index.ts
+var main = (name) => {
+  console.log(`hello, ${name ?? "world"}`);
+};
+export {
+  main
+};
+//# sourceMappingURL=bundle.js.map
@@ -0,0 +1,9 @@
+import { main } from "./bundle.js";
+
+if (typeof main === 'function') {
+	const exitCode = await main(...Deno.args);
+	Deno.exit(exitCode ?? 0);
+} else {
+	console.error('error: ' + "index.ts" + ' does not export a main function');
+	Deno.exit(1);
+}
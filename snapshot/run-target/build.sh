@@ -0,0 +1,15 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+uni clean
+
+# Each build is published to out/run/<hash>, which is printed.
+uni run --build-only ./index.ts
+uni run --build-only --target esnext ./index.ts
+
+(
+  set +e
+  uni run --build-only --target bogus ./index.ts
+  echo "exit code expected=125 actual=$?"
+)
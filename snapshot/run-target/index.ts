@@ -0,0 +1,3 @@
+export const main = (name?: string) => {
+  console.log(`hello, ${name ?? 'world'}`);
+};
@@ -15,7 +15,7 @@ process.on('unhandledRejection', (reason, promise) => {
   );
 })
 
-const { main } = require('./bundle.js');
+const { main } = require("./bundle.js");
 if (typeof main === 'function') {
 	const args = process.argv.slice(2);
 	void (async () => {
@@ -23,7 +23,7 @@ if (typeof main === 'function') {
 		process.exit(exitCode ?? 0);
 	})();
 } else {
-	process.stderr.write('error: /current/working/path/snapshot/running/exit.ts does not export a main function\n', () => {
+	process.stderr.write('error: ' + "index.ts" + ' does not export a main function\n', () => {
 		process.exit(1);
 	});
 }
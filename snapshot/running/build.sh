@@ -15,5 +15,5 @@ uni clean
   echo "exit code expected=5 actual=$?"
 )
 
-tmppath=$(uni run --build-only ./exit.ts 5)
-mv "$tmppath" ./out/tmp/run001
+# Published to out/run/<hash>, which is printed.
+uni run --build-only ./exit.ts 5
@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+
+set -euo pipefail
+
+addr=localhost:3917
+
+uni serve --addr "$addr" ./index.ts 2>/dev/null &
+trap 'kill $!' EXIT
+
+# Wait for the initial build to be served.
+for i in $(seq 50); do
+  if curl -sf "http://$addr/bundle.js" | grep -q hello; then
+    break
+  fi
+  sleep 0.1
+done
+
+curl -sf "http://$addr/bundle.css"
@@ -0,0 +1,22 @@
+var __defProp = Object.defineProperty;
+var __markAsModule = (target) => __defProp(target, "__esModule", {value: true});
+var __export = (target, all) => {
+  for (var name in all)
+    __defProp(target, name, {get: all[name], enumerable: true});
+};
+
+// packages/app/index.ts
+__markAsModule(exports);
+__export(exports, {
+  app: () => app
+});
+
+// packages/foo/src/index.ts
+var foo = "foo";
+
+// packages/foo/utils.ts
+var util = "util";
+
+// packages/app/index.ts
+var app = `${foo} ${util}`;
+//# sourceMappingURL=index.js.map
@@ -0,0 +1,4 @@
+import { foo } from '@scope/foo';
+import { util } from '@scope/foo/utils';
+
+export const app = `${foo} ${util}`;
@@ -30,10 +30,9 @@ for snapshot in $snapshots; do
     fi
 
     deterministic stdout
-    script=$(find . -name script.js)
-    if [[ -n $script ]]; then
-      deterministic $(find . -name script.js)
-    fi
+    for script in $(find . -name script.js); do
+      deterministic $script
+    done
   )
 done
 